@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/database"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/handlers"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/go-playground/validator/v10"
+)
+
+const usage = `usage: server-iot [flags] [command]
+
+commands:
+  server                                        run the http and mqtt server, this is the default
+  migrate up | down N | down all | status       manage the schema migrations
+  user create --username U --email E [--password P] [--admin]
+                                                create an active user, a password is generated when it is not set
+  user activate USERNAME                        activate a user without the email link
+  user reset-password [--password P] USERNAME   set a new password and revoke every session of the user
+  sensor purge --before TIME [--sensor ID]      delete channels older than TIME, RFC 3339 or epoch milliseconds
+  rollup backfill [--sensor ID] [--from TIME] [--to TIME]
+                                                recompute the hourly and daily rollup of the existing channels
+  seed [--mock]                                 create the admin and user account from the config, --mock also
+                                                create the mock hardware, node, sensor and channel`
+
+// generatedPasswordLength is the number of random bytes of a password generated by the cli
+const generatedPasswordLength = 12
+
+// parseCommandFlag parse the flags of a subcommand, flags can be written before or after the positional arguments
+func parseCommandFlag(flagSet *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		err := flagSet.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		if flagSet.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flagSet.Arg(0))
+		args = flagSet.Args()[1:]
+	}
+}
+
+func newUserRepository() (repositories.UserRepository, error) {
+	dialer, err := dependencies.NewMailDialer(configs.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	userRepository, err := repositories.NewUserRepository(dialer)
+	return &userRepository, err
+}
+
+func generatePassword(password string) (string, bool, error) {
+	if password != "" {
+		return password, false, nil
+	}
+	password, err := helper.GenerateSecureToken(generatedPasswordLength)
+	return password, true, err
+}
+
+// RunUserCommand run the user subcommand, args is every argument after "user"
+func RunUserCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	ctx := context.Background()
+	db, err := database.GetConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	userRepository, err := newUserRepository()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		flagSet := flag.NewFlagSet("user create", flag.ContinueOnError)
+		username := flagSet.String("username", "", "username of the new user")
+		email := flagSet.String("email", "", "email of the new user")
+		password := flagSet.String("password", "", "password of the new user, generated when empty")
+		isAdmin := flagSet.Bool("admin", false, "create the user as an admin")
+		_, err := parseCommandFlag(flagSet, args[1:])
+		if err != nil {
+			return err
+		}
+
+		newPassword, generated, err := generatePassword(*password)
+		if err != nil {
+			return err
+		}
+		payload := entities.UserCreate{
+			Email:    *email,
+			Username: *username,
+			Password: newPassword,
+		}
+		myValidator := dependencies.NewValidator(validator.New())
+		err = myValidator.ValidateStruct(&payload)
+		if err != nil {
+			return errors.New(strings.TrimSpace(err.Error()))
+		}
+
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		_, err = userRepository.GetByUsername(ctx, tx, payload.Username)
+		if err == nil {
+			return fmt.Errorf("username %s is already used", payload.Username)
+		}
+		_, err = userRepository.GetByEmail(ctx, tx, payload.Email)
+		if err == nil {
+			return fmt.Errorf("email %s is already used", payload.Email)
+		}
+
+		user, err := userRepository.Create(ctx, tx, payload)
+		if err != nil {
+			return err
+		}
+		err = userRepository.UpdateStatus(ctx, tx, user.IdUser, true)
+		if err != nil {
+			return err
+		}
+		if *isAdmin {
+			err = userRepository.UpdateIsAdmin(ctx, tx, user.IdUser, true)
+			if err != nil {
+				return err
+			}
+		}
+		err = tx.Commit(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Created user %s with id %d\n", user.Username, user.IdUser)
+		if generated {
+			fmt.Printf("Password: %s\n", newPassword)
+		}
+	case "activate":
+		positional, err := parseCommandFlag(flag.NewFlagSet("user activate", flag.ContinueOnError), args[1:])
+		if err != nil {
+			return err
+		}
+		if len(positional) != 1 {
+			return errors.New(usage)
+		}
+
+		user, err := userRepository.GetByUsername(ctx, db, positional[0])
+		if err != nil {
+			return err
+		}
+		err = userRepository.UpdateStatus(ctx, db, user.IdUser, true)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Activated user %s\n", user.Username)
+	case "reset-password":
+		flagSet := flag.NewFlagSet("user reset-password", flag.ContinueOnError)
+		password := flagSet.String("password", "", "new password, generated when empty")
+		positional, err := parseCommandFlag(flagSet, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(positional) != 1 {
+			return errors.New(usage)
+		}
+
+		newPassword, generated, err := generatePassword(*password)
+		if err != nil {
+			return err
+		}
+		if len(newPassword) > 72 {
+			return errors.New("password must be at most 72 characters")
+		}
+		refreshTokenRepository, err := repositories.NewRefreshTokenRepository()
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		user, err := userRepository.GetByUsername(ctx, tx, positional[0])
+		if err != nil {
+			return err
+		}
+		err = userRepository.UpdatePassword(ctx, tx, user.IdUser, newPassword)
+		if err != nil {
+			return err
+		}
+		err = refreshTokenRepository.RevokeAllByUser(ctx, tx, user.IdUser)
+		if err != nil {
+			return err
+		}
+		err = tx.Commit(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Reset password of user %s\n", user.Username)
+		if generated {
+			fmt.Printf("Password: %s\n", newPassword)
+		}
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
+
+// RunSensorCommand run the sensor subcommand, args is every argument after "sensor"
+func RunSensorCommand(args []string) error {
+	if len(args) == 0 || args[0] != "purge" {
+		return errors.New(usage)
+	}
+
+	flagSet := flag.NewFlagSet("sensor purge", flag.ContinueOnError)
+	before := flagSet.String("before", "", "delete channels older than this time")
+	sensor := flagSet.String("sensor", "", "only delete the channels of this sensor id")
+	_, err := parseCommandFlag(flagSet, args[1:])
+	if err != nil {
+		return err
+	}
+	if *before == "" {
+		return errors.New("--before is required")
+	}
+	beforeTime, err := entities.ParseChannelTime(*before)
+	if err != nil {
+		return err
+	}
+
+	var idSensor *int
+	if *sensor != "" {
+		id, err := strconv.Atoi(*sensor)
+		if err != nil || id <= 0 {
+			return errors.New("--sensor must be a valid positive integer")
+		}
+		idSensor = &id
+	}
+
+	ctx := context.Background()
+	db, err := database.GetConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	channelRepository, err := repositories.NewChannelRepository()
+	if err != nil {
+		return err
+	}
+
+	if idSensor != nil {
+		sensorRepository, err := repositories.NewSensorRepository()
+		if err != nil {
+			return err
+		}
+		_, err = sensorRepository.GetById(ctx, db, *idSensor)
+		if err != nil {
+			return err
+		}
+	}
+
+	count, err := channelRepository.DeleteBefore(ctx, db, beforeTime.Time, idSensor)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %d channel\n", count)
+	return nil
+}
+
+// parseOptionalTime parse a channel time flag, an empty value is nil
+func parseOptionalTime(name string, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := entities.ParseChannelTime(value)
+	if err != nil {
+		return nil, fmt.Errorf("--%s %v", name, err)
+	}
+	return &parsed.Time, nil
+}
+
+// RunRollupCommand run the rollup subcommand, args is every argument after "rollup"
+func RunRollupCommand(args []string) error {
+	if len(args) == 0 || args[0] != "backfill" {
+		return errors.New(usage)
+	}
+
+	flagSet := flag.NewFlagSet("rollup backfill", flag.ContinueOnError)
+	sensor := flagSet.Int("sensor", 0, "only recompute the rollup of this sensor id")
+	from := flagSet.String("from", "", "only recompute the channels from this time")
+	to := flagSet.String("to", "", "only recompute the channels until this time")
+	_, err := parseCommandFlag(flagSet, args[1:])
+	if err != nil {
+		return err
+	}
+
+	var idSensor *int
+	if *sensor < 0 {
+		return errors.New("--sensor must be a valid positive integer")
+	} else if *sensor > 0 {
+		idSensor = sensor
+	}
+	fromTime, err := parseOptionalTime("from", *from)
+	if err != nil {
+		return err
+	}
+	toTime, err := parseOptionalTime("to", *to)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rollupRepository, err := repositories.NewRollupRepository()
+	if err != nil {
+		return err
+	}
+	rollupHandler, err := handlers.NewRollupHandler(db, &rollupRepository)
+	if err != nil {
+		return err
+	}
+
+	count, err := rollupHandler.Backfill(context.Background(), idSensor, fromTime, toTime)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Recomputed %d hour of rollup\n", count)
+	return nil
+}
+
+// RunSeedCommand run the seed subcommand, args is every argument after "seed"
+func RunSeedCommand(args []string) error {
+	flagSet := flag.NewFlagSet("seed", flag.ContinueOnError)
+	mock := flagSet.Bool("mock", false, "also create the mock hardware, node, sensor and channel, only use it on an empty database")
+	positional, err := parseCommandFlag(flagSet, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return errors.New(usage)
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = database.Seed(context.Background(), db, *mock)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Seed success")
+	return nil
+}
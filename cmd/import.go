@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/dafaath/iot-server/internal/handlers"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+)
+
+// ImportChannelCsv import the csv file at path as the user with the given username and print the report,
+// it return false when a row is rejected
+func ImportChannelCsv(db helper.Querier, handler *handlers.ChannelHandler, userRepository repositories.UserRepository, path string, username string, dryRun bool) (bool, error) {
+	ctx := context.Background()
+	user, err := userRepository.GetByUsername(ctx, db, username)
+	if err != nil {
+		return false, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	report, err := handler.ImportCsv(ctx, file, user, dryRun)
+	if err != nil {
+		return false, err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(report)
+	if err != nil {
+		return false, err
+	}
+	return report.Rejected == 0, nil
+}
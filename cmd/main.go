@@ -1,110 +1,128 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/dafaath/iot-server/configs"
-	"github.com/dafaath/iot-server/internal/database"
-	"github.com/dafaath/iot-server/internal/dependencies"
-	"github.com/dafaath/iot-server/internal/handlers"
 	"github.com/dafaath/iot-server/internal/helper"
-	"github.com/dafaath/iot-server/internal/middlewares"
-	"github.com/dafaath/iot-server/internal/repositories"
-	"github.com/go-playground/validator/v10"
-	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/recover"
-	"github.com/gofiber/template/handlebars"
-	// "github.com/goccy/go-json"
 )
 
-var createDatabaseMode bool
+var importCsvPath string
+var importUsername string
+var importDryRun bool
 
 func init() {
-	flag.BoolVar(&createDatabaseMode, "create-db", false, "If set to true, this will drop the current table, create the table and create initial user. Then exit program")
+	flag.StringVar(&importCsvPath, "import-csv", "", "Import the channels in this csv file for the sensors of the user set with -import-user. Then exit program")
+	flag.StringVar(&importUsername, "import-user", "", "Username of the user who own the sensors of the imported csv")
+	flag.BoolVar(&importDryRun, "dry-run", false, "If set to true, -import-csv only validate the file without inserting anything")
 }
 
-// Declare all dependencies and run server
 func main() {
 	// Parse flag
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		fmt.Fprintln(flag.CommandLine.Output(), "\nflags:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	if createDatabaseMode {
-		database.DropTable()
-		database.CreateTableAndMockData()
-		os.Exit(0)
+	var err error
+	args := []string{}
+	if flag.NArg() > 1 {
+		args = flag.Args()[1:]
 	}
+	switch flag.Arg(0) {
+	case "", "server":
+		runServer()
+	case "migrate":
+		err = RunMigrateCommand(args)
+	case "user":
+		err = RunUserCommand(args)
+	case "sensor":
+		err = RunSensorCommand(args)
+	case "rollup":
+		err = RunRollupCommand(args)
+	case "seed":
+		err = RunSeedCommand(args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
-	engine := handlebars.New("./internal/views", ".hbs")
-
-	app := fiber.New(
-		fiber.Config{
-			// Override default error handler
-			Views:        engine,
-			ErrorHandler: helper.FiberErrorHandler,
-			// JSONEncoder:  json.Marshal,
-			// JSONDecoder:  json.Unmarshal,
-		},
-	)
-	app.Static("/static", "./internal/public")
-
-	// BEGIN Other dependencies declaration
+// Declare all dependencies and run server
+func runServer() {
 	config := configs.GetConfig()
-	validate := validator.New()
-	db, err := database.GetConnection()
-	helper.PanicIfError(err)
-	myValidator := dependencies.NewValidator(validate)
-	dialer, err := dependencies.NewMailDialer(config)
+	if importCsvPath != "" && importUsername == "" {
+		log.Fatal("-import-user is required with -import-csv")
+	}
+	server, err := NewServer(".")
 	helper.PanicIfError(err)
-	// END
 
-	// BEGIN Middleware
-	app.Use(recover.New(recover.Config{
-		EnableStackTrace: true,
-	}))
-	authenticationMiddleware := middlewares.NewAuthenticationMiddleware(&myValidator)
-	// END
+	if importCsvPath != "" {
+		success, err := ImportChannelCsv(server.Db, &server.ChannelHandler, server.Repositories.User, importCsvPath, importUsername, importDryRun)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !success {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-	// BEGIN Repositories declaration
-	userRepository, err := repositories.NewUserRepository(dialer)
-	helper.PanicIfError(err)
-	hardwareRepository, err := repositories.NewHardwareRepository()
-	helper.PanicIfError(err)
-	nodeRepository, err := repositories.NewNodeRepository()
-	helper.PanicIfError(err)
-	sensorRepository, err := repositories.NewSensorRepository()
-	helper.PanicIfError(err)
-	channelRepository, err := repositories.NewChannelRepository()
-	helper.PanicIfError(err)
-	// END
+	go server.WebhookHandler.StartDispatcher(context.Background())
+	go server.NodeHandler.StartStatusMonitor(context.Background())
+	go server.PartitionHandler.StartMaintenance(context.Background())
+	go server.RollupHandler.StartWorker(context.Background())
+	if config.Stream.PostgresNotify {
+		go server.ChannelHub.Listen(context.Background())
+	}
 
-	// BEGIN Handlers declaration
-	userHandler, err := handlers.NewUserHandler(db, &userRepository, &myValidator)
-	helper.PanicIfError(err)
-	hardwareHandler, err := handlers.NewHardwareHandler(db, &hardwareRepository, &nodeRepository, &sensorRepository, &myValidator)
-	helper.PanicIfError(err)
-	nodeHandler, err := handlers.NewNodeHandler(db, &nodeRepository, &hardwareRepository, &sensorRepository, &myValidator)
-	helper.PanicIfError(err)
-	sensorHandler, err := handlers.NewSensorHandler(db, &sensorRepository, &hardwareRepository, &nodeRepository, &myValidator)
-	helper.PanicIfError(err)
-	channelHandler, err := handlers.NewChannelHandler(db, &channelRepository, &sensorRepository, &myValidator)
-	helper.PanicIfError(err)
-	// END
+	if config.Mqtt.Enabled {
+		_, err = StartMqttServer(config, &server.MqttHandler)
+		helper.PanicIfError(err)
+		log.Printf("MQTT listener started on %s:%d", config.Mqtt.Host, config.Mqtt.Port)
+	}
 
-	// BEGIN Routes declaration
-	router, err := NewRouter(app, &authenticationMiddleware)
-	helper.PanicIfError(err)
-	router.CreateHealthCheckRoute()
-	router.CreateUserRoute(&userHandler)
-	router.CreateHardwareRoute(&hardwareHandler)
-	router.CreateNodeRoute(&nodeHandler)
-	router.CreateSensorRoute(&sensorHandler)
-	router.CreateChannelRoute(&channelHandler)
-	// END
+	// Stop accepting request on interrupt, then write what is left in the write buffer
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+		log.Println("Shutting down server")
+		err := server.App.Shutdown()
+		if err != nil {
+			log.Printf("Failed to shut down server, %v", err)
+		}
+	}()
 
-	// Initialize default config
+	err = server.App.Listen(fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port))
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	log.Fatal(app.Listen(fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port)))
+	ctx, cancel := context.WithTimeout(context.Background(), config.Ingest.ShutdownTimeout)
+	defer cancel()
+	if server.ChannelWriter != nil {
+		err = server.ChannelWriter.Close(ctx)
+		if err != nil {
+			log.Printf("Failed to write every queued channel, %v", err)
+		}
+	}
+	// Closed after the writer because writing the queue notify the listeners
+	if server.ChannelListenerQueue != nil {
+		err = server.ChannelListenerQueue.Close(ctx)
+		if err != nil {
+			log.Printf("Failed to notify the listeners of every queued channel, %v", err)
+		}
+	}
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/database"
+)
+
+const migrateUsage = "usage: migrate up | migrate down N | migrate down all | migrate status"
+
+// RunMigrateCommand run the migrate subcommand, args is every argument after "migrate"
+func RunMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	ctx := context.Background()
+	db, err := database.GetConnection()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := database.MigrateUp(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, migration := range applied {
+			fmt.Printf("Applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+	case "down":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		n, err := strconv.Atoi(args[1])
+		if args[1] == "all" {
+			// Every migration is reverted, which drop every table
+			n, err = math.MaxInt, nil
+		}
+		if err != nil || n <= 0 {
+			return fmt.Errorf("N must be a positive integer, %s", migrateUsage)
+		}
+
+		reverted, err := database.MigrateDown(ctx, db, n)
+		if err != nil {
+			return err
+		}
+		for _, migration := range reverted {
+			fmt.Printf("Reverted %d_%s\n", migration.Version, migration.Name)
+		}
+		if len(reverted) == 0 {
+			fmt.Println("There is no applied migration")
+		}
+	case "status":
+		statuses, err := database.GetMigrationStatus(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(writer, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return writer.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/handlers"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// StartMqttServer start the embedded MQTT broker in the background, publish is handled by the mqtt handler
+func StartMqttServer(config *configs.Config, handler *handlers.MqttHandler) (*mqtt.Server, error) {
+	server := mqtt.New(nil)
+	handler.SetServer(server)
+	err := server.AddHook(handler, nil)
+	if err != nil {
+		return server, err
+	}
+
+	tcp := listeners.NewTCP("tcp", fmt.Sprintf("%s:%d", config.Mqtt.Host, config.Mqtt.Port), nil)
+	err = server.AddListener(tcp)
+	if err != nil {
+		return server, err
+	}
+
+	err = server.Serve()
+	if err != nil {
+		return server, err
+	}
+
+	return server, nil
+}
@@ -7,14 +7,16 @@ import (
 )
 
 type Router struct {
-	app            *fiber.App
-	authMiddleware *middlewares.AuthenticationMiddleware
+	app                  *fiber.App
+	authMiddleware       *middlewares.AuthenticationMiddleware
+	deviceAuthMiddleware *middlewares.DeviceAuthenticationMiddleware
 }
 
-func NewRouter(app *fiber.App, authMiddleware *middlewares.AuthenticationMiddleware) (Router, error) {
+func NewRouter(app *fiber.App, authMiddleware *middlewares.AuthenticationMiddleware, deviceAuthMiddleware *middlewares.DeviceAuthenticationMiddleware) (Router, error) {
 	return Router{
-		app:            app,
-		authMiddleware: authMiddleware,
+		app:                  app,
+		authMiddleware:       authMiddleware,
+		deviceAuthMiddleware: deviceAuthMiddleware,
 	}, nil
 }
 
@@ -37,8 +39,12 @@ func (r *Router) CreateUserRoute(handler *handlers.UserHandler) {
 	userRouter.Get("/signup", handler.RegisterPage)
 	userRouter.Post("/login", handler.Login)
 	userRouter.Get("/login", handler.LoginPage)
+	userRouter.Post("/refresh", handler.Refresh)
+	userRouter.Post("/logout", handler.Logout)
 	userRouter.Post("/forget-password", handler.ForgotPassword)
 	userRouter.Get("/forget-password", handler.ForgotPasswordPage)
+	userRouter.Post("/reset-password", handler.ResetPassword)
+	userRouter.Get("/reset-password", handler.ResetPasswordPage)
 	userRouter.Get("/activation", handler.Activation)
 	userRouter.Get("/", r.authMiddleware.ValidateAdmin, handler.GetAll)
 	userRouter.Get("/:id", r.authMiddleware.ValidateAdmin, handler.GetOne)
@@ -46,6 +52,19 @@ func (r *Router) CreateUserRoute(handler *handlers.UserHandler) {
 	userRouter.Delete("/:id", r.authMiddleware.ValidateUserSameAsUrlIdOrAdmin, handler.Delete)
 }
 
+func (r *Router) CreateOrganizationRoute(handler *handlers.OrganizationHandler) {
+	organizationRouter := r.app.Group("/organization")
+	organizationRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	organizationRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	organizationRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
+	organizationRouter.Put("/:id", r.authMiddleware.ValidateUser, handler.Update)
+	organizationRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
+	organizationRouter.Get("/:id/member", r.authMiddleware.ValidateUser, handler.GetMembers)
+	organizationRouter.Post("/:id/member", r.authMiddleware.ValidateUser, handler.AddMember)
+	organizationRouter.Put("/:id/member/:idUser", r.authMiddleware.ValidateUser, handler.UpdateMember)
+	organizationRouter.Delete("/:id/member/:idUser", r.authMiddleware.ValidateUser, handler.RemoveMember)
+}
+
 func (r *Router) CreateHardwareRoute(handler *handlers.HardwareHandler) {
 	hardwareRouter := r.app.Group("/hardware")
 	hardwareRouter.Get("/create", r.authMiddleware.ValidateUser, handler.CreateForm)
@@ -64,16 +83,45 @@ func (r *Router) CreateNodeRoute(handler *handlers.NodeHandler) {
 	nodeRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
 	nodeRouter.Get("/:id/edit", r.authMiddleware.ValidateUser, handler.UpdateForm)
 	nodeRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
+	nodeRouter.Get("/:id/export", r.authMiddleware.ValidateUser, handler.Export)
+	nodeRouter.Post("/:id/heartbeat", r.deviceAuthMiddleware.ValidateUserOrDevice, handler.Heartbeat)
 	nodeRouter.Put("/:id", r.authMiddleware.ValidateUser, handler.Update)
 	nodeRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
 }
 
+func (r *Router) CreateNodeKeyRoute(handler *handlers.NodeKeyHandler) {
+	nodeKeyRouter := r.app.Group("/node/:id/key")
+	nodeKeyRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	nodeKeyRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	nodeKeyRouter.Post("/rotate", r.authMiddleware.ValidateUser, handler.Rotate)
+	nodeKeyRouter.Delete("/:idKey", r.authMiddleware.ValidateUser, handler.Revoke)
+}
+
+func (r *Router) CreateNodeCommandRoute(handler *handlers.NodeCommandHandler) {
+	nodeCommandRouter := r.app.Group("/node/:id/commands")
+	nodeCommandRouter.Get("/", r.deviceAuthMiddleware.ValidateUserOrDevice, handler.GetAll)
+	nodeCommandRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	nodeCommandRouter.Post("/:idCommand/ack", r.deviceAuthMiddleware.ValidateUserOrDevice, handler.Ack)
+}
+
+func (r *Router) CreateNodeShadowRoute(handler *handlers.NodeShadowHandler) {
+	nodeShadowRouter := r.app.Group("/node/:id/shadow")
+	nodeShadowRouter.Get("/", r.deviceAuthMiddleware.ValidateUserOrDevice, handler.GetById)
+	nodeShadowRouter.Get("/delta", r.deviceAuthMiddleware.ValidateUserOrDevice, handler.GetDelta)
+	nodeShadowRouter.Put("/desired", r.authMiddleware.ValidateUser, handler.UpdateDesired)
+	nodeShadowRouter.Put("/reported", r.deviceAuthMiddleware.ValidateUserOrDevice, handler.Report)
+}
+
 func (r *Router) CreateSensorRoute(handler *handlers.SensorHandler) {
 	sensorRouter := r.app.Group("/sensor")
 	sensorRouter.Get("/create", r.authMiddleware.ValidateUser, handler.CreateForm)
 	sensorRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
 	sensorRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
 	sensorRouter.Get("/:id/edit", r.authMiddleware.ValidateUser, handler.UpdateForm)
+	sensorRouter.Get("/:id/channel", r.authMiddleware.ValidateUser, handler.GetChannel)
+	sensorRouter.Get("/:id/aggregate", r.authMiddleware.ValidateUser, handler.GetAggregate)
+	sensorRouter.Get("/:id/stream", r.authMiddleware.ValidateUser, handler.Stream)
+	sensorRouter.Get("/:id/export", r.authMiddleware.ValidateUser, handler.Export)
 	sensorRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
 	sensorRouter.Put("/:id", r.authMiddleware.ValidateUser, handler.Update)
 	sensorRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
@@ -81,5 +129,49 @@ func (r *Router) CreateSensorRoute(handler *handlers.SensorHandler) {
 
 func (r *Router) CreateChannelRoute(handler *handlers.ChannelHandler) {
 	channelRouter := r.app.Group("/channel")
-	channelRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	channelRouter.Post("/", r.deviceAuthMiddleware.ValidateUserOrDevice, handler.Create)
+	channelRouter.Post("/batch", r.deviceAuthMiddleware.ValidateUserOrDevice, handler.CreateBatch)
+	channelRouter.Post("/import", r.authMiddleware.ValidateUser, handler.Import)
+	channelRouter.Get("/writer", r.authMiddleware.ValidateAdmin, handler.GetWriterStats)
+}
+
+func (r *Router) CreateAlertRoute(handler *handlers.AlertHandler) {
+	alertRouter := r.app.Group("/alert")
+	alertRouter.Get("/create", r.authMiddleware.ValidateUser, handler.CreateForm)
+	alertRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	alertRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	alertRouter.Get("/:id/edit", r.authMiddleware.ValidateUser, handler.UpdateForm)
+	alertRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
+	alertRouter.Put("/:id", r.authMiddleware.ValidateUser, handler.Update)
+	alertRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
+}
+
+func (r *Router) CreateWebhookRoute(handler *handlers.WebhookHandler) {
+	webhookRouter := r.app.Group("/webhook")
+	webhookRouter.Get("/create", r.authMiddleware.ValidateUser, handler.CreateForm)
+	webhookRouter.Post("/", r.authMiddleware.ValidateUser, handler.Create)
+	webhookRouter.Get("/", r.authMiddleware.ValidateUser, handler.GetAll)
+	webhookRouter.Get("/:id/edit", r.authMiddleware.ValidateUser, handler.UpdateForm)
+	webhookRouter.Get("/:id", r.authMiddleware.ValidateUser, handler.GetById)
+	webhookRouter.Put("/:id", r.authMiddleware.ValidateUser, handler.Update)
+	webhookRouter.Delete("/:id", r.authMiddleware.ValidateUser, handler.Delete)
+}
+
+func (r *Router) CreateFirmwareRoute(handler *handlers.FirmwareHandler) {
+	firmwareRouter := r.app.Group("/firmware")
+	firmwareRouter.Get("/create", r.authMiddleware.ValidateAdmin, handler.CreateForm)
+	firmwareRouter.Post("/", r.authMiddleware.ValidateAdmin, handler.Create)
+	firmwareRouter.Get("/", r.authMiddleware.ValidateAdmin, handler.GetAll)
+	firmwareRouter.Get("/:id/edit", r.authMiddleware.ValidateAdmin, handler.UpdateForm)
+	firmwareRouter.Get("/:id/download", handler.Download)
+	firmwareRouter.Get("/:id", r.authMiddleware.ValidateAdmin, handler.GetById)
+	firmwareRouter.Put("/:id", r.authMiddleware.ValidateAdmin, handler.Update)
+	firmwareRouter.Delete("/:id", r.authMiddleware.ValidateAdmin, handler.Delete)
+	r.app.Get("/node/:id/firmware", r.deviceAuthMiddleware.ValidateUserOrDevice, handler.Check)
+}
+
+func (r *Router) CreatePartitionRoute(handler *handlers.PartitionHandler) {
+	partitionRouter := r.app.Group("/partition")
+	partitionRouter.Get("/", r.authMiddleware.ValidateAdmin, handler.GetAll)
+	partitionRouter.Put("/retention/:id", r.authMiddleware.ValidateAdmin, handler.UpdateUserRetention)
 }
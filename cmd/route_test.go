@@ -0,0 +1,1136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/middlewares"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// testServer is the whole server running on the memory database, every test get a new one so they don't share data
+type testServer struct {
+	t      *testing.T
+	server *Server
+}
+
+type testResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// newTestServer create the server, configure can change the ingest config. Every field changed here and the
+// ingest config are restored when the test end, one by one because the stream of a previous test may still
+// be reading the rest of the config.
+func newTestServer(t *testing.T, configure ...func(config *configs.Config)) *testServer {
+	t.Helper()
+	config := configs.GetConfig()
+	previousIngest := config.Ingest
+	previousDriver := config.Database.Driver
+	previousMqttEnabled := config.Mqtt.Enabled
+	previousBcryptCost := config.Password.BcryptCost
+	previousStorageDir := config.Firmware.StorageDir
+	t.Cleanup(func() {
+		config.Ingest = previousIngest
+		config.Database.Driver = previousDriver
+		config.Mqtt.Enabled = previousMqttEnabled
+		config.Password.BcryptCost = previousBcryptCost
+		config.Firmware.StorageDir = previousStorageDir
+	})
+	config.Database.Driver = "memory"
+	config.Ingest.Mode = "sync"
+	// Run the channel listeners on the request so their effect is visible right after the response
+	config.Ingest.ListenerQueueSize = 0
+	config.Mqtt.Enabled = false
+	// The lowest cost, hashing with the default one make the tests slow
+	config.Password.BcryptCost = 4
+	config.Firmware.StorageDir = t.TempDir()
+	for _, configure := range configure {
+		configure(config)
+	}
+
+	server, err := NewServer("..")
+	if err != nil {
+		t.Fatalf("Failed to create server, %v", err)
+	}
+	return &testServer{t: t, server: server}
+}
+
+// request send body as json, unless it is already an io.Reader, with the bearer token when token is set
+func (s *testServer) request(method string, target string, token string, body interface{}, headers ...string) testResponse {
+	s.t.Helper()
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = body
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatalf("Failed to marshal body, %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, target, reader)
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	res, err := s.server.App.Test(req, -1)
+	if err != nil {
+		s.t.Fatalf("%s %s failed, %v", method, target, err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		s.t.Fatalf("Failed to read response of %s %s, %v", method, target, err)
+	}
+	return testResponse{status: res.StatusCode, header: res.Header, body: data}
+}
+
+// expect fail the test when the status is not the expected one and decode the body to out when out is set
+func (s *testServer) expect(res testResponse, status int, out interface{}) {
+	s.t.Helper()
+	if res.status != status {
+		s.t.Fatalf("Expected status %d, got %d: %s", status, res.status, res.body)
+	}
+	if out != nil {
+		err := json.Unmarshal(res.body, out)
+		if err != nil {
+			s.t.Fatalf("Failed to decode %s, %v", res.body, err)
+		}
+	}
+}
+
+func (s *testServer) login(username string, password string) entities.UserToken {
+	s.t.Helper()
+	token := entities.UserToken{}
+	s.expect(s.request("POST", "/user/login", "", entities.UserLogin{Username: username, Password: password}), 200, &token)
+	return token
+}
+
+func (s *testServer) adminToken() string {
+	config := configs.GetConfig()
+	return s.login(config.Account.AdminUsername, config.Account.AdminPassword).AccessToken
+}
+
+func (s *testServer) userToken() string {
+	config := configs.GetConfig()
+	return s.login(config.Account.UserUsername, config.Account.UserPassword).AccessToken
+}
+
+// lastMailToken get the token in the link of the last email sent to the address
+func (s *testServer) lastMailToken(to string) string {
+	s.t.Helper()
+	userRepository, ok := s.server.Repositories.User.(*repositories.MemoryUserRepository)
+	if !ok {
+		s.t.Fatal("User repository is not the memory repository")
+	}
+
+	mails := userRepository.SentMails()
+	for i := len(mails) - 1; i >= 0; i-- {
+		if mails[i].To != to {
+			continue
+		}
+		match := regexp.MustCompile(`token=([\w-]+)`).FindStringSubmatch(mails[i].Body)
+		if match == nil {
+			s.t.Fatalf("No token in the email %s", mails[i].Body)
+		}
+		return match[1]
+	}
+	s.t.Fatalf("No email sent to %s", to)
+	return ""
+}
+
+// createUser sign up and activate a new user then log in with it
+func (s *testServer) createUser(username string) string {
+	s.t.Helper()
+	email := username + "@example.com"
+	s.expect(s.request("POST", "/user/signup", "", entities.UserCreate{Email: email, Username: username, Password: "password"}), 201, nil)
+	s.expect(s.request("GET", "/user/activation?token="+s.lastMailToken(email), "", nil), 200, nil)
+	return s.login(username, "password").AccessToken
+}
+
+func (s *testServer) createHardware(token string, name string, hardwareType string) int {
+	s.t.Helper()
+	s.expect(s.request("POST", "/hardware", token, entities.HardwareCreate{Name: name, Type: hardwareType, Description: name}), 201, nil)
+
+	hardwares := map[string][]entities.Hardware{}
+	s.expect(s.request("GET", "/hardware", token, nil), 200, &hardwares)
+	for _, hardware := range append(hardwares["node"], hardwares["sensor"]...) {
+		if hardware.Name == name {
+			return hardware.IdHardware
+		}
+	}
+	s.t.Fatalf("Hardware %s not found after create", name)
+	return 0
+}
+
+func (s *testServer) createNode(token string, name string, idHardware int) int {
+	s.t.Helper()
+	s.expect(s.request("POST", "/node", token, entities.NodeCreate{Name: name, Location: "Bogor", IdHardware: idHardware}), 201, nil)
+
+	nodes := []entities.Node{}
+	s.expect(s.request("GET", "/node", token, nil), 200, &nodes)
+	for _, node := range nodes {
+		if node.Name == name {
+			return node.IdNode
+		}
+	}
+	s.t.Fatalf("Node %s not found after create", name)
+	return 0
+}
+
+func (s *testServer) createSensor(token string, name string, idNode int, idHardware int) int {
+	s.t.Helper()
+	s.expect(s.request("POST", "/sensor", token, entities.SensorCreate{Name: name, Unit: "celcius", IdNode: idNode, IdHardware: idHardware}), 201, nil)
+
+	sensors := []entities.Sensor{}
+	s.expect(s.request("GET", "/sensor", token, nil), 200, &sensors)
+	for _, sensor := range sensors {
+		if sensor.Name == name {
+			return sensor.IdSensor
+		}
+	}
+	s.t.Fatalf("Sensor %s not found after create", name)
+	return 0
+}
+
+// createDevice create a node with one sensor for the user of the token
+func (s *testServer) createDevice(token string) (idNode int, idSensor int) {
+	s.t.Helper()
+	nodeHardware := s.createHardware(token, "ESP32", "microcontroller unit")
+	sensorHardware := s.createHardware(token, "DHT22", "sensor")
+	idNode = s.createNode(token, "Greenhouse", nodeHardware)
+	idSensor = s.createSensor(token, "Temperature", idNode, sensorHardware)
+	return idNode, idSensor
+}
+
+func (s *testServer) createNodeKey(token string, idNode int) entities.NodeKeyCreated {
+	s.t.Helper()
+	nodeKey := entities.NodeKeyCreated{}
+	s.expect(s.request("POST", fmt.Sprintf("/node/%d/key", idNode), token, nil), 201, &nodeKey)
+	return nodeKey
+}
+
+func (s *testServer) sendChannel(token string, idSensor int, value float64, channelTime time.Time) {
+	s.t.Helper()
+	payload := map[string]interface{}{"value": value, "id_sensor": idSensor, "time": channelTime.Format(time.RFC3339)}
+	s.expect(s.request("POST", "/channel", token, payload), 201, nil)
+}
+
+func TestHealthCheckRoute(t *testing.T) {
+	s := newTestServer(t)
+
+	res := s.request("GET", "/", "", nil)
+	s.expect(res, 200, nil)
+	if string(res.body) != "Server OK" {
+		t.Fatalf("Expected Server OK, got %s", res.body)
+	}
+
+	res = s.request("GET", "/", "", nil, "Accept", "text/html")
+	s.expect(res, 200, nil)
+	if !strings.Contains(res.header.Get("Content-Type"), "text/html") {
+		t.Fatalf("Expected html page, got %s", res.header.Get("Content-Type"))
+	}
+}
+
+func TestUserRoute(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, page := range []string{"/user/signup", "/user/login", "/user/forget-password", "/user/reset-password?token=abc"} {
+		s.expect(s.request("GET", page, "", nil), 200, nil)
+	}
+
+	// Sign up and activation
+	payload := entities.UserCreate{Email: "researcher@example.com", Username: "researcher", Password: "password"}
+	s.expect(s.request("POST", "/user/signup", "", payload), 201, nil)
+	s.expect(s.request("POST", "/user/signup", "", payload), 409, nil)
+	s.expect(s.request("POST", "/user/signup", "", entities.UserCreate{Username: "invalid"}), 400, nil)
+	s.expect(s.request("POST", "/user/login", "", entities.UserLogin{Username: "researcher", Password: "password"}), 400, nil)
+	activationToken := s.lastMailToken(payload.Email)
+	s.expect(s.request("GET", "/user/activation?token="+activationToken, "", nil), 200, nil)
+	s.expect(s.request("GET", "/user/activation?token="+activationToken, "", nil), 400, nil)
+
+	// Login, refresh and logout
+	s.expect(s.request("POST", "/user/login", "", entities.UserLogin{Username: "researcher", Password: "wrong"}), 401, nil)
+	token := s.login("researcher", "password")
+	if token.AccessToken == "" || token.RefreshToken == "" {
+		t.Fatalf("Expected access and refresh token, got %+v", token)
+	}
+	refreshed := entities.UserToken{}
+	s.expect(s.request("POST", "/user/refresh", "", entities.UserRefreshToken{RefreshToken: token.RefreshToken}), 200, &refreshed)
+	// The refresh token can only be used once
+	s.expect(s.request("POST", "/user/refresh", "", entities.UserRefreshToken{RefreshToken: token.RefreshToken}), 401, nil)
+	token = s.login("researcher", "password")
+	s.expect(s.request("POST", "/user/logout", "", entities.UserRefreshToken{RefreshToken: token.RefreshToken}), 200, nil)
+	s.expect(s.request("POST", "/user/refresh", "", entities.UserRefreshToken{RefreshToken: token.RefreshToken}), 401, nil)
+
+	// Token signed before access token expire don't have an exp claim and must not be accepted anymore
+	legacyToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"idUser": 1, "email": payload.Email, "username": "researcher", "status": true, "isAdmin": false,
+	}).SignedString([]byte(configs.GetConfig().JWT.SecretKey))
+	if err != nil {
+		t.Fatalf("Failed to sign token, %v", err)
+	}
+	s.expect(s.request("GET", "/node", legacyToken, nil), 401, nil)
+
+	// Forgot and reset password
+	s.expect(s.request("POST", "/user/forget-password", "", entities.UserForgotPassword{Username: "researcher", Email: "other@example.com"}), 400, nil)
+	s.expect(s.request("POST", "/user/forget-password", "", entities.UserForgotPassword{Username: "researcher", Email: payload.Email}), 200, nil)
+	resetToken := s.lastMailToken(payload.Email)
+	s.expect(s.request("POST", "/user/reset-password", "", entities.UserResetPassword{Token: resetToken, NewPassword: "new-password"}), 200, nil)
+	s.expect(s.request("POST", "/user/reset-password", "", entities.UserResetPassword{Token: resetToken, NewPassword: "again"}), 400, nil)
+	token = s.login("researcher", "new-password")
+
+	// Admin only routes
+	s.expect(s.request("GET", "/user", "", nil), 401, nil)
+	s.expect(s.request("GET", "/user", token.AccessToken, nil), 403, nil)
+	adminToken := s.adminToken()
+	users := []entities.UserRead{}
+	s.expect(s.request("GET", "/user", adminToken, nil), 200, &users)
+	if len(users) != 3 {
+		t.Fatalf("Expected 3 users, got %d", len(users))
+	}
+	researcher := users[2]
+	user := entities.UserRead{}
+	s.expect(s.request("GET", fmt.Sprintf("/user/%d", researcher.IdUser), adminToken, nil), 200, &user)
+	if user.Username != "researcher" {
+		t.Fatalf("Expected researcher, got %s", user.Username)
+	}
+	s.expect(s.request("GET", "/user/100", adminToken, nil), 404, nil)
+
+	// Update and delete own account
+	update := entities.UserUpdatePassword{OldPassword: "wrong", NewPassword: "changed"}
+	s.expect(s.request("PUT", fmt.Sprintf("/user/%d", researcher.IdUser), token.AccessToken, update), 401, nil)
+	update.OldPassword = "new-password"
+	s.expect(s.request("PUT", fmt.Sprintf("/user/%d", researcher.IdUser), token.AccessToken, update), 200, nil)
+	s.login("researcher", "changed")
+	s.expect(s.request("DELETE", fmt.Sprintf("/user/%d", users[0].IdUser), token.AccessToken, nil), 403, nil)
+	s.expect(s.request("DELETE", fmt.Sprintf("/user/%d", researcher.IdUser), token.AccessToken, nil), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/user/%d", researcher.IdUser), adminToken, nil), 404, nil)
+}
+
+func TestOrganizationRoute(t *testing.T) {
+	s := newTestServer(t)
+	token := s.userToken()
+	editorToken := s.createUser("editor")
+	viewerToken := s.createUser("viewer")
+	outsiderToken := s.createUser("outsider")
+
+	s.expect(s.request("POST", "/organization", token, entities.OrganizationCreate{}), 400, nil)
+	organization := entities.Organization{}
+	s.expect(s.request("POST", "/organization", token, entities.OrganizationCreate{Name: "Lab"}), 201, &organization)
+	organizationUrl := fmt.Sprintf("/organization/%d", organization.IdOrganization)
+
+	// The personal organization is listed even before the user create a node
+	organizations := []entities.OrganizationWithRole{}
+	s.expect(s.request("GET", "/organization", token, nil), 200, &organizations)
+	if len(organizations) != 2 {
+		t.Fatalf("Expected the personal organization and Lab, got %+v", organizations)
+	}
+	for _, listed := range organizations {
+		if listed.Role != entities.OrganizationRoleOwner {
+			t.Fatalf("Expected user to own %+v", listed)
+		}
+		if listed.IdPersonalUser != nil {
+			s.expect(s.request("DELETE", fmt.Sprintf("/organization/%d", listed.IdOrganization), token, nil), 400, nil)
+		}
+	}
+	s.expect(s.request("GET", "/organization", token, nil, "Accept", "text/html"), 200, nil)
+
+	s.expect(s.request("POST", organizationUrl+"/member", token, entities.OrganizationMemberCreate{Username: "editor", Role: "admin"}), 400, nil)
+	s.expect(s.request("POST", organizationUrl+"/member", token, entities.OrganizationMemberCreate{Username: "nobody", Role: entities.OrganizationRoleViewer}), 404, nil)
+	s.expect(s.request("POST", organizationUrl+"/member", token, entities.OrganizationMemberCreate{Username: "editor", Role: entities.OrganizationRoleEditor}), 201, nil)
+	s.expect(s.request("POST", organizationUrl+"/member", token, entities.OrganizationMemberCreate{Username: "viewer", Role: entities.OrganizationRoleViewer}), 201, nil)
+	s.expect(s.request("POST", organizationUrl+"/member", token, entities.OrganizationMemberCreate{Username: "viewer", Role: entities.OrganizationRoleEditor}), 400, nil)
+	s.expect(s.request("POST", organizationUrl+"/member", editorToken, entities.OrganizationMemberCreate{Username: "outsider", Role: entities.OrganizationRoleViewer}), 403, nil)
+
+	members := []entities.OrganizationMember{}
+	s.expect(s.request("GET", organizationUrl+"/member", viewerToken, nil), 200, &members)
+	if len(members) != 3 {
+		t.Fatalf("Expected 3 members, got %+v", members)
+	}
+	idUser := map[string]int{}
+	for _, member := range members {
+		idUser[member.Username] = member.IdUser
+	}
+	s.expect(s.request("GET", organizationUrl, outsiderToken, nil), 403, nil)
+
+	// Node in the organization is shared by every member according to their role
+	nodeHardware := s.createHardware(token, "ESP32", "microcontroller unit")
+	sensorHardware := s.createHardware(token, "DHT22", "sensor")
+	s.expect(s.request("POST", "/node", outsiderToken, entities.NodeCreate{Name: "Intruder", Location: "Bogor", IdHardware: nodeHardware, IdOrganization: organization.IdOrganization}), 403, nil)
+	s.expect(s.request("POST", "/node", viewerToken, entities.NodeCreate{Name: "Intruder", Location: "Bogor", IdHardware: nodeHardware, IdOrganization: organization.IdOrganization}), 403, nil)
+	s.expect(s.request("POST", "/node", editorToken, entities.NodeCreate{Name: "Greenhouse", Location: "Bogor", IdHardware: nodeHardware, IdOrganization: organization.IdOrganization}), 201, nil)
+	organizationWithMemberAndNode := entities.OrganizationWithMemberAndNode{}
+	s.expect(s.request("GET", organizationUrl, viewerToken, nil), 200, &organizationWithMemberAndNode)
+	if len(organizationWithMemberAndNode.Node) != 1 || len(organizationWithMemberAndNode.Member) != 3 || organizationWithMemberAndNode.Role != entities.OrganizationRoleViewer {
+		t.Fatalf("Unexpected organization %+v", organizationWithMemberAndNode)
+	}
+	s.expect(s.request("GET", organizationUrl, token, nil, "Accept", "text/html"), 200, nil)
+	idNode := organizationWithMemberAndNode.Node[0].IdNode
+	nodeUrl := fmt.Sprintf("/node/%d", idNode)
+
+	nodes := []entities.Node{}
+	s.expect(s.request("GET", "/node", viewerToken, nil), 200, &nodes)
+	if len(nodes) != 1 || nodes[0].IdNode != idNode {
+		t.Fatalf("Expected viewer to see the organization node, got %+v", nodes)
+	}
+	s.expect(s.request("GET", nodeUrl, viewerToken, nil), 200, nil)
+	s.expect(s.request("GET", nodeUrl, outsiderToken, nil), 403, nil)
+	s.expect(s.request("PUT", nodeUrl, viewerToken, entities.NodeUpdate{Location: "Jakarta"}), 403, nil)
+	s.expect(s.request("PUT", nodeUrl, token, entities.NodeUpdate{Location: "Jakarta"}), 200, nil)
+
+	s.expect(s.request("POST", "/sensor", viewerToken, entities.SensorCreate{Name: "Humidity", Unit: "percent", IdNode: idNode, IdHardware: sensorHardware}), 403, nil)
+	idSensor := s.createSensor(editorToken, "Temperature", idNode, sensorHardware)
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d", idSensor), viewerToken, nil), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d", idSensor), outsiderToken, nil), 403, nil)
+
+	// Every member get the channel on their webhook, not only the user who created the node
+	deliveryCount := func(token string, idWebhook int) int {
+		t.Helper()
+		webhookWithDelivery := entities.WebhookWithDelivery{}
+		s.expect(s.request("GET", fmt.Sprintf("/webhook/%d", idWebhook), token, nil), 200, &webhookWithDelivery)
+		return len(webhookWithDelivery.Deliveries)
+	}
+	viewerWebhook := entities.WebhookCreated{}
+	s.expect(s.request("POST", "/webhook", viewerToken, entities.WebhookCreate{Url: "https://example.com/viewer", EventTypes: []string{entities.WebhookEventChannelCreated}}), 201, &viewerWebhook)
+	editorWebhook := entities.WebhookCreated{}
+	s.expect(s.request("POST", "/webhook", editorToken, entities.WebhookCreate{Url: "https://example.com/editor", EventTypes: []string{entities.WebhookEventChannelCreated}}), 201, &editorWebhook)
+	outsiderWebhook := entities.WebhookCreated{}
+	s.expect(s.request("POST", "/webhook", outsiderToken, entities.WebhookCreate{Url: "https://example.com/outsider", EventTypes: []string{entities.WebhookEventChannelCreated}}), 201, &outsiderWebhook)
+
+	s.sendChannel(token, idSensor, 25.5, time.Now().UTC())
+	if count := deliveryCount(viewerToken, viewerWebhook.IdWebhook); count != 1 {
+		t.Fatalf("Expected viewer webhook to get the channel, got %d delivery", count)
+	}
+	if count := deliveryCount(editorToken, editorWebhook.IdWebhook); count != 1 {
+		t.Fatalf("Expected editor webhook to get the channel, got %d delivery", count)
+	}
+	if count := deliveryCount(outsiderToken, outsiderWebhook.IdWebhook); count != 0 {
+		t.Fatalf("Expected outsider webhook to get nothing, got %d delivery", count)
+	}
+	payload := map[string]interface{}{"value": 26.5, "id_sensor": idSensor}
+	s.expect(s.request("POST", "/channel", viewerToken, payload), 403, nil)
+
+	// Promoting the viewer let them write to the node
+	s.expect(s.request("PUT", fmt.Sprintf("%s/member/%d", organizationUrl, idUser["viewer"]), editorToken, entities.OrganizationMemberUpdate{Role: entities.OrganizationRoleEditor}), 403, nil)
+	s.expect(s.request("PUT", fmt.Sprintf("%s/member/%d", organizationUrl, idUser["viewer"]), token, entities.OrganizationMemberUpdate{Role: entities.OrganizationRoleEditor}), 200, nil)
+	s.expect(s.request("POST", "/channel", viewerToken, payload), 201, nil)
+	if count := deliveryCount(viewerToken, viewerWebhook.IdWebhook); count != 2 {
+		t.Fatalf("Expected viewer webhook to get 2 channel, got %d delivery", count)
+	}
+	s.expect(s.request("PUT", nodeUrl, viewerToken, entities.NodeUpdate{Name: "Nursery"}), 200, nil)
+
+	// The last owner can't step down or leave
+	s.expect(s.request("PUT", fmt.Sprintf("%s/member/%d", organizationUrl, idUser["user"]), token, entities.OrganizationMemberUpdate{Role: entities.OrganizationRoleEditor}), 400, nil)
+	s.expect(s.request("DELETE", fmt.Sprintf("%s/member/%d", organizationUrl, idUser["user"]), token, nil), 400, nil)
+	s.expect(s.request("DELETE", fmt.Sprintf("%s/member/%d", organizationUrl, idUser["editor"]), viewerToken, nil), 403, nil)
+	s.expect(s.request("PUT", fmt.Sprintf("%s/member/%d", organizationUrl, idUser["editor"]), token, entities.OrganizationMemberUpdate{Role: entities.OrganizationRoleOwner}), 200, nil)
+	s.expect(s.request("DELETE", fmt.Sprintf("%s/member/%d", organizationUrl, idUser["user"]), token, nil), 200, nil)
+	s.expect(s.request("GET", nodeUrl, token, nil), 403, nil)
+
+	// A member can leave, and lose the access to the node
+	s.expect(s.request("DELETE", fmt.Sprintf("%s/member/%d", organizationUrl, idUser["viewer"]), viewerToken, nil), 200, nil)
+	s.expect(s.request("GET", nodeUrl, viewerToken, nil), 403, nil)
+
+	// and their webhook stop getting the channel of the organization
+	s.sendChannel(editorToken, idSensor, 27.5, time.Now().UTC())
+	if count := deliveryCount(editorToken, editorWebhook.IdWebhook); count != 3 {
+		t.Fatalf("Expected editor webhook to get 3 channel, got %d delivery", count)
+	}
+	if count := deliveryCount(viewerToken, viewerWebhook.IdWebhook); count != 2 {
+		t.Fatalf("Expected the webhook of a removed member to get nothing, got %d delivery", count)
+	}
+
+	s.expect(s.request("PUT", organizationUrl, editorToken, entities.OrganizationUpdate{Name: "Greenhouse Lab"}), 200, nil)
+	s.expect(s.request("DELETE", organizationUrl, editorToken, nil), 400, nil)
+	s.expect(s.request("DELETE", nodeUrl, editorToken, nil), 200, nil)
+	s.expect(s.request("DELETE", organizationUrl, viewerToken, nil), 403, nil)
+	s.expect(s.request("DELETE", organizationUrl, editorToken, nil), 200, nil)
+	s.expect(s.request("GET", organizationUrl, editorToken, nil), 404, nil)
+}
+
+func TestHardwareRoute(t *testing.T) {
+	s := newTestServer(t)
+	token := s.userToken()
+
+	s.expect(s.request("GET", "/hardware/create", token, nil), 200, nil)
+	s.expect(s.request("POST", "/hardware", token, entities.HardwareCreate{Name: "Robot", Type: "robot", Description: "Robot"}), 400, nil)
+	s.expect(s.request("POST", "/hardware", "", entities.HardwareCreate{Name: "ESP32", Type: "microcontroller unit", Description: "ESP32"}), 401, nil)
+	nodeHardware := s.createHardware(token, "ESP32", "microcontroller unit")
+	sensorHardware := s.createHardware(token, "DHT22", "sensor")
+	s.createNode(token, "Greenhouse", nodeHardware)
+
+	hardwareWithNode := entities.HardwareWithNode{}
+	s.expect(s.request("GET", fmt.Sprintf("/hardware/%d", nodeHardware), token, nil), 200, &hardwareWithNode)
+	if len(hardwareWithNode.Nodes) != 1 {
+		t.Fatalf("Expected 1 node on the hardware, got %d", len(hardwareWithNode.Nodes))
+	}
+	hardwareWithSensor := entities.HardwareWithSensor{}
+	s.expect(s.request("GET", fmt.Sprintf("/hardware/%d", sensorHardware), token, nil), 200, &hardwareWithSensor)
+	s.expect(s.request("GET", fmt.Sprintf("/hardware/%d/edit", nodeHardware), token, nil), 200, nil)
+	s.expect(s.request("GET", "/hardware", token, nil, "Accept", "text/html"), 200, nil)
+
+	s.expect(s.request("PUT", fmt.Sprintf("/hardware/%d", sensorHardware), token, entities.HardwareUpdate{Name: "DHT11", Type: "sensor"}), 200, nil)
+	hardware := entities.Hardware{}
+	s.expect(s.request("GET", fmt.Sprintf("/hardware/%d", sensorHardware), token, nil), 200, &hardware)
+	if hardware.Name != "DHT11" || hardware.Description != "DHT22" {
+		t.Fatalf("Expected only the name to be updated, got %+v", hardware)
+	}
+
+	s.expect(s.request("DELETE", fmt.Sprintf("/hardware/%d", sensorHardware), token, nil), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/hardware/%d", sensorHardware), token, nil), 404, nil)
+	s.expect(s.request("GET", "/hardware/abc", token, nil), 400, nil)
+}
+
+func TestNodeRoute(t *testing.T) {
+	s := newTestServer(t)
+	token := s.userToken()
+	otherToken := s.createUser("other")
+	idNode, idSensor := s.createDevice(token)
+
+	s.expect(s.request("GET", "/node/create", token, nil), 200, nil)
+	s.expect(s.request("POST", "/node", token, entities.NodeCreate{Name: "Field", Location: "Bogor"}), 400, nil)
+	s.expect(s.request("GET", "/node", token, nil, "Accept", "text/html"), 200, nil)
+
+	node := entities.NodeWithHardwareAndSensors{}
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d", idNode), token, nil), 200, &node)
+	if node.Hardware.Name != "ESP32" || len(node.Sensor) != 1 || node.Status != entities.NodeStatusOffline {
+		t.Fatalf("Unexpected node %+v", node)
+	}
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d", idNode), otherToken, nil), 403, nil)
+	otherNodes := []entities.Node{}
+	s.expect(s.request("GET", "/node", otherToken, nil), 200, &otherNodes)
+	if len(otherNodes) != 0 {
+		t.Fatalf("Expected other user to see no node, got %d", len(otherNodes))
+	}
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d/edit", idNode), token, nil), 200, nil)
+
+	s.expect(s.request("PUT", fmt.Sprintf("/node/%d", idNode), otherToken, entities.NodeUpdate{Name: "Stolen"}), 403, nil)
+	s.expect(s.request("PUT", fmt.Sprintf("/node/%d", idNode), token, entities.NodeUpdate{Location: "Jakarta"}), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d", idNode), token, nil), 200, &node)
+	if node.Name != "Greenhouse" || node.Location != "Jakarta" {
+		t.Fatalf("Expected only the location to be updated, got %+v", node.Node)
+	}
+
+	// Heartbeat with the user token and with the device key
+	heartbeat := entities.Node{}
+	s.expect(s.request("POST", fmt.Sprintf("/node/%d/heartbeat", idNode), token, nil), 200, &heartbeat)
+	if heartbeat.Status != entities.NodeStatusOnline || heartbeat.LastSeen == nil {
+		t.Fatalf("Expected node to be online after heartbeat, got %+v", heartbeat)
+	}
+	nodeKey := s.createNodeKey(token, idNode)
+	s.expect(s.request("POST", fmt.Sprintf("/node/%d/heartbeat", idNode), "", nil, middlewares.DeviceKeyHeader, nodeKey.Key), 200, nil)
+	s.expect(s.request("POST", fmt.Sprintf("/node/%d/heartbeat", idNode), otherToken, nil), 403, nil)
+
+	// Export
+	now := time.Now().UTC().Truncate(time.Second)
+	s.sendChannel(token, idSensor, 25.5, now.Add(-time.Minute))
+	s.sendChannel(token, idSensor, 26.5, now)
+	res := s.request("GET", fmt.Sprintf("/node/%d/export", idNode), token, nil)
+	s.expect(res, 200, nil)
+	lines := strings.Split(strings.TrimSpace(string(res.body)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "25.5") || !strings.Contains(lines[2], "26.5") {
+		t.Fatalf("Expected csv header and 2 channels ordered by time, got %s", res.body)
+	}
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d/export", idNode), otherToken, nil), 403, nil)
+
+	s.expect(s.request("DELETE", fmt.Sprintf("/node/%d", idNode), otherToken, nil), 403, nil)
+	s.expect(s.request("DELETE", fmt.Sprintf("/node/%d", idNode), token, nil), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d", idNode), token, nil), 404, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d", idSensor), token, nil), 404, nil)
+}
+
+func TestNodeKeyRoute(t *testing.T) {
+	s := newTestServer(t)
+	token := s.userToken()
+	otherToken := s.createUser("other")
+	idNode, _ := s.createDevice(token)
+
+	s.expect(s.request("POST", fmt.Sprintf("/node/%d/key", idNode), otherToken, nil), 403, nil)
+	nodeKey := s.createNodeKey(token, idNode)
+	if nodeKey.Key == "" || !strings.HasPrefix(nodeKey.Key, nodeKey.Prefix) {
+		t.Fatalf("Expected the key to start with its prefix, got %+v", nodeKey)
+	}
+
+	nodeKeys := []entities.NodeKey{}
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d/key", idNode), token, nil), 200, &nodeKeys)
+	if len(nodeKeys) != 1 {
+		t.Fatalf("Expected 1 key, got %d", len(nodeKeys))
+	}
+	if strings.Contains(string(s.request("GET", fmt.Sprintf("/node/%d/key", idNode), token, nil).body), nodeKey.Key) {
+		t.Fatal("The key should only be shown once")
+	}
+
+	// Rotate revoke the previous key
+	rotated := entities.NodeKeyCreated{}
+	s.expect(s.request("POST", fmt.Sprintf("/node/%d/key/rotate", idNode), token, nil), 201, &rotated)
+	s.expect(s.request("POST", fmt.Sprintf("/node/%d/heartbeat", idNode), "", nil, middlewares.DeviceKeyHeader, nodeKey.Key), 401, nil)
+	s.expect(s.request("POST", fmt.Sprintf("/node/%d/heartbeat", idNode), "", nil, middlewares.DeviceKeyHeader, rotated.Key), 200, nil)
+
+	s.expect(s.request("DELETE", fmt.Sprintf("/node/%d/key/%d", idNode, rotated.IdNodeKey), otherToken, nil), 403, nil)
+	s.expect(s.request("DELETE", fmt.Sprintf("/node/%d/key/%d", idNode, rotated.IdNodeKey), token, nil), 200, nil)
+	s.expect(s.request("POST", fmt.Sprintf("/node/%d/heartbeat", idNode), "", nil, middlewares.DeviceKeyHeader, rotated.Key), 401, nil)
+}
+
+func TestNodeCommandRoute(t *testing.T) {
+	s := newTestServer(t)
+	token := s.userToken()
+	otherToken := s.createUser("other")
+	idNode, _ := s.createDevice(token)
+	nodeKey := s.createNodeKey(token, idNode)
+
+	payload := map[string]interface{}{"name": "reboot", "args": map[string]int{"delay": 5}}
+	s.expect(s.request("POST", fmt.Sprintf("/node/%d/commands", idNode), otherToken, payload), 403, nil)
+	command := entities.NodeCommand{}
+	s.expect(s.request("POST", fmt.Sprintf("/node/%d/commands", idNode), token, payload), 201, &command)
+	if command.Status != entities.NodeCommandPending {
+		t.Fatalf("Expected pending command, got %s", command.Status)
+	}
+
+	// The device receive the pending command
+	commands := []entities.NodeCommand{}
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d/commands", idNode), "", nil, middlewares.DeviceKeyHeader, nodeKey.Key), 200, &commands)
+	if len(commands) != 1 || commands[0].IdNodeCommand != command.IdNodeCommand {
+		t.Fatalf("Expected the created command, got %+v", commands)
+	}
+
+	ack := map[string]interface{}{"status": "acked", "result": map[string]bool{"ok": true}}
+	s.expect(s.request("POST", fmt.Sprintf("/node/%d/commands/%d/ack", idNode, command.IdNodeCommand), "", ack, middlewares.DeviceKeyHeader, nodeKey.Key), 200, &command)
+	if command.Status != entities.NodeCommandAcked || string(command.Result) != `{"ok":true}` {
+		t.Fatalf("Expected acked command with result, got %+v", command)
+	}
+	s.expect(s.request("POST", fmt.Sprintf("/node/%d/commands/%d/ack", idNode+1, command.IdNodeCommand), "", ack, middlewares.DeviceKeyHeader, nodeKey.Key), 403, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d/commands", idNode), token, nil), 200, &commands)
+}
+
+func TestNodeShadowRoute(t *testing.T) {
+	s := newTestServer(t)
+	token := s.userToken()
+	otherToken := s.createUser("other")
+	idNode, _ := s.createDevice(token)
+	nodeKey := s.createNodeKey(token, idNode)
+
+	shadow := entities.NodeShadow{}
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d/shadow", idNode), token, nil), 200, &shadow)
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d/shadow", idNode), otherToken, nil), 403, nil)
+
+	desired := map[string]interface{}{"desired": map[string]interface{}{"led": true, "interval": 10}}
+	s.expect(s.request("PUT", fmt.Sprintf("/node/%d/shadow/desired", idNode), "", desired, middlewares.DeviceKeyHeader, nodeKey.Key), 401, nil)
+	s.expect(s.request("PUT", fmt.Sprintf("/node/%d/shadow/desired", idNode), token, desired), 200, &shadow)
+
+	delta := entities.NodeShadowDelta{}
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d/shadow/delta", idNode), "", nil, middlewares.DeviceKeyHeader, nodeKey.Key), 200, &delta)
+	if delta.InSync || !strings.Contains(string(delta.Delta), "led") {
+		t.Fatalf("Expected led in the delta, got %s", delta.Delta)
+	}
+
+	reported := map[string]interface{}{"reported": map[string]interface{}{"led": true, "interval": 10}, "version": shadow.Version}
+	s.expect(s.request("PUT", fmt.Sprintf("/node/%d/shadow/reported", idNode), "", reported, middlewares.DeviceKeyHeader, nodeKey.Key), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d/shadow/delta", idNode), token, nil), 200, &delta)
+	if !delta.InSync {
+		t.Fatalf("Expected shadow to be in sync, got %s", delta.Delta)
+	}
+}
+
+func TestSensorRoute(t *testing.T) {
+	s := newTestServer(t)
+	token := s.userToken()
+	otherToken := s.createUser("other")
+	idNode, idSensor := s.createDevice(token)
+	var nodeHardware int
+	node := entities.Node{}
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d", idNode), token, nil), 200, &node)
+	nodeHardware = node.IdHardware
+
+	s.expect(s.request("GET", "/sensor/create", token, nil), 200, nil)
+	s.expect(s.request("POST", "/sensor", token, entities.SensorCreate{Name: "Humidity", Unit: "%", IdNode: idNode, IdHardware: nodeHardware}), 400, nil)
+	s.expect(s.request("POST", "/sensor", otherToken, entities.SensorCreate{Name: "Humidity", Unit: "%", IdNode: idNode, IdHardware: nodeHardware + 1}), 403, nil)
+	s.expect(s.request("GET", "/sensor", token, nil, "Accept", "text/html"), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d/edit", idSensor), token, nil), 200, nil)
+
+	start := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Hour)
+	for i, value := range []float64{20, 22, 30} {
+		s.sendChannel(token, idSensor, value, start.Add(time.Duration(i)*30*time.Minute))
+	}
+
+	sensor := entities.SensorWithChannel{}
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d", idSensor), token, nil), 200, &sensor)
+	if sensor.Name != "Temperature" || len(sensor.Channel) != 3 {
+		t.Fatalf("Expected sensor with 3 channels, got %+v", sensor)
+	}
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d", idSensor), otherToken, nil), 403, nil)
+
+	// Channel page
+	page := entities.ChannelPage{}
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d/channel?limit=2", idSensor), token, nil), 200, &page)
+	if len(page.Channel) != 2 || page.NextCursor == "" {
+		t.Fatalf("Expected 2 channels and a cursor, got %+v", page)
+	}
+	nextPage := entities.ChannelPage{}
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d/channel?limit=2&cursor=%s", idSensor, url.QueryEscape(page.NextCursor)), token, nil), 200, &nextPage)
+	// Newest first by default
+	if len(nextPage.Channel) != 1 || nextPage.Channel[0].Value != 20 || nextPage.NextCursor != "" {
+		t.Fatalf("Expected the oldest channel, got %+v", nextPage)
+	}
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d/channel?order=random", idSensor), token, nil), 400, nil)
+
+	// Aggregate
+	aggregate := entities.SensorWithAggregate{}
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d/aggregate?bucket=1h&function=avg&function=count", idSensor), token, nil), 200, &aggregate)
+	if len(aggregate.Aggregate) != 2 {
+		t.Fatalf("Expected 2 hourly buckets, got %+v", aggregate.Aggregate)
+	}
+	if *aggregate.Aggregate[0].Values["avg"] != 21 || *aggregate.Aggregate[0].Values["count"] != 2 || *aggregate.Aggregate[1].Values["avg"] != 30 {
+		t.Fatalf("Unexpected aggregate %+v", aggregate.Aggregate)
+	}
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d/aggregate?function=avg", idSensor), token, nil), 400, nil)
+
+	// Export
+	res := s.request("GET", fmt.Sprintf("/sensor/%d/export?format=jsonl", idSensor), token, nil)
+	s.expect(res, 200, nil)
+	lines := strings.Split(strings.TrimSpace(string(res.body)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 json lines, got %s", res.body)
+	}
+	export := entities.ChannelExport{}
+	err := json.Unmarshal([]byte(lines[0]), &export)
+	if err != nil || export.SensorName != "Temperature" || export.Value != 20 {
+		t.Fatalf("Unexpected export line %s, %v", lines[0], err)
+	}
+
+	// Stream never end, so reaching the timeout means the checks passed and the stream is open
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d/stream", idSensor), otherToken, nil), 403, nil)
+	streamRes, err := s.server.App.Test(httptest.NewRequest("GET", fmt.Sprintf("/sensor/%d/stream", idSensor), nil), 200)
+	if err != nil {
+		t.Fatalf("Expected unauthorized stream to be rejected, got %v", err)
+	}
+	streamRes.Body.Close()
+	if streamRes.StatusCode != 401 {
+		t.Fatalf("Expected unauthorized stream to be rejected with 401, got %d", streamRes.StatusCode)
+	}
+	req := httptest.NewRequest("GET", fmt.Sprintf("/sensor/%d/stream", idSensor), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	_, err = s.server.App.Test(req, 200)
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("Expected stream to stay open, got %v", err)
+	}
+
+	s.expect(s.request("PUT", fmt.Sprintf("/sensor/%d", idSensor), otherToken, entities.SensorUpdate{Name: "Stolen"}), 403, nil)
+	s.expect(s.request("PUT", fmt.Sprintf("/sensor/%d", idSensor), token, entities.SensorUpdate{Unit: "fahrenheit"}), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d", idSensor), token, nil), 200, &sensor)
+	if sensor.Name != "Temperature" || sensor.Unit != "fahrenheit" {
+		t.Fatalf("Expected only the unit to be updated, got %+v", sensor.Sensor)
+	}
+
+	s.expect(s.request("DELETE", fmt.Sprintf("/sensor/%d", idSensor), otherToken, nil), 403, nil)
+	s.expect(s.request("DELETE", fmt.Sprintf("/sensor/%d", idSensor), token, nil), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d", idSensor), token, nil), 404, nil)
+}
+
+func TestChannelRoute(t *testing.T) {
+	s := newTestServer(t)
+	token := s.userToken()
+	otherToken := s.createUser("other")
+	idNode, idSensor := s.createDevice(token)
+	nodeKey := s.createNodeKey(token, idNode)
+
+	// Single channel
+	s.expect(s.request("POST", "/channel", token, map[string]interface{}{"value": 1.5, "id_sensor": idSensor}), 201, nil)
+	s.expect(s.request("POST", "/channel", "", map[string]interface{}{"value": 2.5, "id_sensor": idSensor}, middlewares.DeviceKeyHeader, nodeKey.Key), 201, nil)
+	s.expect(s.request("POST", "/channel", otherToken, map[string]interface{}{"value": 1.5, "id_sensor": idSensor}), 403, nil)
+	s.expect(s.request("POST", "/channel", token, map[string]interface{}{"value": 1.5, "id_sensor": 100}), 404, nil)
+	future := time.Now().UTC().Add(time.Hour).Format(time.RFC3339)
+	s.expect(s.request("POST", "/channel", token, map[string]interface{}{"value": 1.5, "id_sensor": idSensor, "time": future}), 400, nil)
+	s.expect(s.request("POST", "/channel", token, map[string]interface{}{"value": 1.5, "id_sensor": idSensor, "time": time.Now().UnixMilli()}), 201, nil)
+	s.expect(s.request("POST", "/channel", token, map[string]interface{}{"value": 1.5, "id_sensor": idSensor, "time": time.Now().Unix()}), 400, nil)
+
+	// Batch, the accepted channels are inserted even when others are rejected
+	report := entities.ChannelBatchReport{}
+	batch := map[string]interface{}{"channels": []map[string]interface{}{{"value": 3, "id_sensor": idSensor}, {"value": 4, "id_sensor": 100}}}
+	s.expect(s.request("POST", "/channel/batch", token, batch), 201, &report)
+	if report.Accepted != 1 || report.Rejected != 1 || report.Results[1].Status != entities.ChannelBatchRejected {
+		t.Fatalf("Expected 1 accepted and 1 rejected channel, got %+v", report)
+	}
+	batch = map[string]interface{}{"channels": []map[string]interface{}{{"value": 3, "id_sensor": idSensor}}}
+	s.expect(s.request("POST", "/channel/batch", otherToken, batch), 400, &report)
+	batch = map[string]interface{}{"channels": []map[string]interface{}{{"value": 3, "id_sensor": idSensor}, {"value": 4, "id_sensor": idSensor}}}
+	s.expect(s.request("POST", "/channel/batch", "", batch, middlewares.DeviceKeyHeader, nodeKey.Key), 201, &report)
+	if report.Accepted != 2 {
+		t.Fatalf("Expected 2 accepted channel, got %+v", report)
+	}
+
+	// Import
+	csv := fmt.Sprintf("time,value,id_sensor\n2023-01-01T00:00:00Z,10,%d\n2023-01-01T00:01:00Z,11,%d\n", idSensor, idSensor)
+	importReport := entities.ChannelImportReport{}
+	s.expect(s.request("POST", "/channel/import?dry_run=true", token, strings.NewReader(csv)), 200, &importReport)
+	if importReport.Accepted != 2 || importReport.Inserted != 0 {
+		t.Fatalf("Expected dry run to insert nothing, got %+v", importReport)
+	}
+	s.expect(s.request("POST", "/channel/import", otherToken, strings.NewReader(csv)), 400, &importReport)
+	if importReport.Rejected != 2 {
+		t.Fatalf("Expected every row of another user's sensor to be rejected, got %+v", importReport)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "channel.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(csv))
+	writer.Close()
+	s.expect(s.request("POST", "/channel/import", token, body, "Content-Type", writer.FormDataContentType()), 201, &importReport)
+	if importReport.Inserted != 2 {
+		t.Fatalf("Expected 2 inserted channel, got %+v", importReport)
+	}
+
+	page := entities.ChannelPage{}
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d/channel", idSensor), token, nil), 200, &page)
+	if len(page.Channel) != 8 {
+		t.Fatalf("Expected 8 channels, got %d", len(page.Channel))
+	}
+
+	// Writer stats
+	s.expect(s.request("GET", "/channel/writer", token, nil), 403, nil)
+	stats := map[string]interface{}{}
+	s.expect(s.request("GET", "/channel/writer", s.adminToken(), nil), 200, &stats)
+	if stats["mode"] != "sync" {
+		t.Fatalf("Expected sync mode, got %v", stats)
+	}
+}
+
+func TestChannelWriter(t *testing.T) {
+	s := newTestServer(t, func(config *configs.Config) {
+		config.Ingest.Mode = "async"
+		// Only written on close so the sensor can be deleted while its channel is queued
+		config.Ingest.FlushInterval = time.Hour
+		config.Ingest.ListenerQueueSize = 10
+	})
+	token := s.userToken()
+	idNode, idSensor := s.createDevice(token)
+	deletedSensor := s.createSensor(token, "Humidity", idNode, s.createHardware(token, "DHT11", "sensor"))
+
+	for _, id := range []int{idSensor, deletedSensor, idSensor} {
+		s.expect(s.request("POST", "/channel", token, map[string]interface{}{"value": 1, "id_sensor": id}), 202, nil)
+	}
+	s.expect(s.request("DELETE", fmt.Sprintf("/sensor/%d", deletedSensor), token, nil), 200, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.server.ChannelWriter.Close(ctx)
+	if err != nil {
+		t.Fatalf("Failed to close the writer, %v", err)
+	}
+	err = s.server.ChannelListenerQueue.Close(ctx)
+	if err != nil {
+		t.Fatalf("Failed to close the listener queue, %v", err)
+	}
+
+	// Only the channel of the deleted sensor is dropped, the rest of the batch is written
+	stats := s.server.ChannelWriter.Stats()
+	if stats.Written != 2 || stats.Failed != 1 {
+		t.Fatalf("Expected 2 written and 1 failed channel, got %+v", stats)
+	}
+	page := entities.ChannelPage{}
+	s.expect(s.request("GET", fmt.Sprintf("/sensor/%d/channel", idSensor), token, nil), 200, &page)
+	if len(page.Channel) != 2 {
+		t.Fatalf("Expected 2 channels, got %d", len(page.Channel))
+	}
+
+	// The listeners are notified in the background
+	node := entities.NodeWithHardwareAndSensors{}
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d", idNode), token, nil), 200, &node)
+	if node.LastSeen == nil {
+		t.Fatalf("Expected the node last seen time to be set by the channel, got %+v", node.Node)
+	}
+}
+
+func TestMqttSessionTakeover(t *testing.T) {
+	s := newTestServer(t)
+	token := s.userToken()
+	idNode, idSensor := s.createDevice(token)
+	nodeKey := s.createNodeKey(token, idNode)
+	handler := &s.server.MqttHandler
+	connect := packets.Packet{Connect: packets.ConnectParams{Password: []byte(nodeKey.Key)}}
+	publish := packets.Packet{TopicName: fmt.Sprintf("node/%d/sensor/%d", idNode, idSensor), Payload: []byte("21.5")}
+
+	// The new connection take over the session, then the old one is disconnected
+	oldClient := &mqtt.Client{ID: "greenhouse"}
+	newClient := &mqtt.Client{ID: "greenhouse"}
+	if !handler.OnConnectAuthenticate(oldClient, connect) || !handler.OnConnectAuthenticate(newClient, connect) {
+		t.Fatal("Expected the device key to authenticate")
+	}
+	handler.OnDisconnect(oldClient, nil, false)
+
+	_, err := handler.OnPublish(newClient, publish)
+	if err != nil {
+		t.Fatalf("Expected the new client to stay authenticated, %v", err)
+	}
+	_, err = handler.OnPublish(oldClient, publish)
+	if err == nil {
+		t.Fatal("Expected the disconnected client to be rejected")
+	}
+}
+
+func TestAlertRoute(t *testing.T) {
+	s := newTestServer(t)
+	token := s.userToken()
+	otherToken := s.createUser("other")
+	_, idSensor := s.createDevice(token)
+
+	upper := 30.0
+	payload := entities.AlertRuleCreate{IdSensor: idSensor, Name: "Too hot", Condition: "above", UpperThreshold: &upper}
+	s.expect(s.request("GET", "/alert/create", token, nil), 200, nil)
+	s.expect(s.request("POST", "/alert", token, entities.AlertRuleCreate{IdSensor: idSensor, Name: "Too hot", Condition: "above"}), 400, nil)
+	s.expect(s.request("POST", "/alert", otherToken, payload), 403, nil)
+	s.expect(s.request("POST", "/alert", token, payload), 201, nil)
+
+	alertRules := []entities.AlertRule{}
+	s.expect(s.request("GET", "/alert", token, nil), 200, &alertRules)
+	if len(alertRules) != 1 || alertRules[0].State != entities.AlertStateOk {
+		t.Fatalf("Expected 1 alert rule in ok state, got %+v", alertRules)
+	}
+	idAlertRule := alertRules[0].IdAlertRule
+	s.expect(s.request("GET", "/alert", token, nil, "Accept", "text/html"), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/alert/%d/edit", idAlertRule), token, nil), 200, nil)
+
+	// A channel above the threshold fire the alert
+	s.sendChannel(token, idSensor, 35, time.Now().UTC())
+	alertRule := entities.AlertRuleWithEvent{}
+	s.expect(s.request("GET", fmt.Sprintf("/alert/%d", idAlertRule), token, nil), 200, &alertRule)
+	if alertRule.State != entities.AlertStateFiring || len(alertRule.Events) != 1 || alertRule.Events[0].Kind != entities.AlertEventFiring {
+		t.Fatalf("Expected the alert to fire, got %+v", alertRule)
+	}
+	s.expect(s.request("GET", fmt.Sprintf("/alert/%d", idAlertRule), otherToken, nil), 403, nil)
+
+	enabled := false
+	s.expect(s.request("PUT", fmt.Sprintf("/alert/%d", idAlertRule), otherToken, entities.AlertRuleUpdate{Enabled: &enabled}), 403, nil)
+	s.expect(s.request("PUT", fmt.Sprintf("/alert/%d", idAlertRule), token, entities.AlertRuleUpdate{Name: "Very hot", Enabled: &enabled}), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/alert/%d", idAlertRule), token, nil), 200, &alertRule)
+	if alertRule.Name != "Very hot" || alertRule.Enabled {
+		t.Fatalf("Expected the alert to be renamed and disabled, got %+v", alertRule.AlertRule)
+	}
+
+	s.expect(s.request("DELETE", fmt.Sprintf("/alert/%d", idAlertRule), otherToken, nil), 403, nil)
+	s.expect(s.request("DELETE", fmt.Sprintf("/alert/%d", idAlertRule), token, nil), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/alert/%d", idAlertRule), token, nil), 404, nil)
+}
+
+func TestWebhookRoute(t *testing.T) {
+	s := newTestServer(t)
+	token := s.userToken()
+	otherToken := s.createUser("other")
+
+	s.expect(s.request("GET", "/webhook/create", token, nil), 200, nil)
+	s.expect(s.request("POST", "/webhook", token, entities.WebhookCreate{Url: "not an url", EventTypes: []string{"node.created"}}), 400, nil)
+	s.expect(s.request("POST", "/webhook", token, entities.WebhookCreate{Url: "https://example.com/hook", EventTypes: []string{"node.exploded"}}), 400, nil)
+	webhook := entities.WebhookCreated{}
+	s.expect(s.request("POST", "/webhook", token, entities.WebhookCreate{Url: "https://example.com/hook", EventTypes: []string{"node.created"}}), 201, &webhook)
+	if webhook.Secret == "" || !webhook.Enabled {
+		t.Fatalf("Expected an enabled webhook with a secret, got %+v", webhook)
+	}
+
+	// Creating a node queue a delivery for the webhook before responding, it is sent in the background
+	nodeHardware := s.createHardware(token, "ESP32", "microcontroller unit")
+	s.createNode(token, "Greenhouse", nodeHardware)
+	webhookWithDelivery := entities.WebhookWithDelivery{}
+	s.expect(s.request("GET", fmt.Sprintf("/webhook/%d", webhook.IdWebhook), token, nil), 200, &webhookWithDelivery)
+	if len(webhookWithDelivery.Deliveries) != 1 || webhookWithDelivery.Deliveries[0].EventType != "node.created" {
+		t.Fatalf("Expected a node.created delivery, got %+v", webhookWithDelivery.Deliveries)
+	}
+	s.expect(s.request("GET", fmt.Sprintf("/webhook/%d", webhook.IdWebhook), otherToken, nil), 403, nil)
+
+	webhooks := []entities.Webhook{}
+	s.expect(s.request("GET", "/webhook", otherToken, nil), 200, &webhooks)
+	if len(webhooks) != 0 {
+		t.Fatalf("Expected other user to see no webhook, got %d", len(webhooks))
+	}
+	s.expect(s.request("GET", "/webhook", token, nil, "Accept", "text/html"), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/webhook/%d/edit", webhook.IdWebhook), token, nil), 200, nil)
+
+	enabled := false
+	s.expect(s.request("PUT", fmt.Sprintf("/webhook/%d", webhook.IdWebhook), otherToken, entities.WebhookUpdate{Enabled: &enabled}), 403, nil)
+	s.expect(s.request("PUT", fmt.Sprintf("/webhook/%d", webhook.IdWebhook), token, entities.WebhookUpdate{Enabled: &enabled}), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/webhook/%d", webhook.IdWebhook), token, nil), 200, &webhookWithDelivery)
+	if webhookWithDelivery.Enabled || webhookWithDelivery.Url != "https://example.com/hook" {
+		t.Fatalf("Expected only the webhook to be disabled, got %+v", webhookWithDelivery.Webhook)
+	}
+
+	s.expect(s.request("DELETE", fmt.Sprintf("/webhook/%d", webhook.IdWebhook), otherToken, nil), 403, nil)
+	s.expect(s.request("DELETE", fmt.Sprintf("/webhook/%d", webhook.IdWebhook), token, nil), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/webhook/%d", webhook.IdWebhook), token, nil), 404, nil)
+
+	// A webhook can't target the server own network
+	for _, privateUrl := range []string{"http://127.0.0.1:8080/hook", "http://169.254.169.254/latest/meta-data", "http://10.0.0.1/hook", "http://localhost/hook", "http://[::1]/hook"} {
+		s.expect(s.request("POST", "/webhook", token, entities.WebhookCreate{Url: privateUrl, EventTypes: []string{"node.created"}}), 400, nil)
+	}
+
+	// The address is checked again when it is dialed, for a host name that resolve to a private address
+	received := make(chan struct{}, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer target.Close()
+	privateWebhook, err := s.server.Repositories.Webhook.Create(context.Background(), s.server.Db, webhook.IdUser, &entities.WebhookCreate{Url: target.URL, EventTypes: []string{"node.created"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.createNode(token, "Nursery", nodeHardware)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.server.WebhookHandler.StartDispatcher(ctx)
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		s.expect(s.request("GET", fmt.Sprintf("/webhook/%d", privateWebhook.IdWebhook), token, nil), 200, &webhookWithDelivery)
+		if webhookWithDelivery.Deliveries[0].LastError != nil || time.Now().After(deadline) {
+			break
+		}
+	}
+	lastError := webhookWithDelivery.Deliveries[0].LastError
+	if lastError == nil || !strings.Contains(*lastError, "private") {
+		t.Fatalf("Expected the delivery to a private address to fail, got %+v", webhookWithDelivery.Deliveries[0])
+	}
+	select {
+	case <-received:
+		t.Fatal("Expected the private address to not receive the webhook")
+	default:
+	}
+}
+
+func TestFirmwareRoute(t *testing.T) {
+	s := newTestServer(t)
+	token := s.userToken()
+	adminToken := s.adminToken()
+	idNode, _ := s.createDevice(token)
+	nodeKey := s.createNodeKey(token, idNode)
+	node := entities.Node{}
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d", idNode), token, nil), 200, &node)
+
+	upload := func(token string, version string, content []byte) testResponse {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("id_hardware", fmt.Sprint(node.IdHardware))
+		writer.WriteField("version", version)
+		writer.WriteField("description", "Firmware "+version)
+		part, err := writer.CreateFormFile("file", "firmware.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(content)
+		writer.Close()
+		return s.request("POST", "/firmware", token, body, "Content-Type", writer.FormDataContentType())
+	}
+
+	s.expect(s.request("GET", "/firmware/create", token, nil), 403, nil)
+	s.expect(s.request("GET", "/firmware/create", adminToken, nil), 200, nil)
+	s.expect(upload(token, "1.0.0", []byte("firmware 1.0.0")), 403, nil)
+	s.expect(upload(adminToken, "latest", []byte("firmware latest")), 400, nil)
+	firmware := entities.Firmware{}
+	s.expect(upload(adminToken, "1.0.0", []byte("firmware 1.0.0")), 201, &firmware)
+	if firmware.Size != int64(len("firmware 1.0.0")) || firmware.Checksum == "" || firmware.RolloutPercentage != 100 {
+		t.Fatalf("Unexpected firmware %+v", firmware)
+	}
+	s.expect(upload(adminToken, "1.0.0", []byte("firmware 1.0.0")), 400, nil)
+
+	firmwares := []entities.Firmware{}
+	s.expect(s.request("GET", "/firmware", adminToken, nil), 200, &firmwares)
+	if len(firmwares) != 1 {
+		t.Fatalf("Expected 1 firmware, got %d", len(firmwares))
+	}
+	s.expect(s.request("GET", "/firmware", adminToken, nil, "Accept", "text/html"), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/firmware/%d", firmware.IdFirmware), adminToken, nil), 200, &firmware)
+	s.expect(s.request("GET", fmt.Sprintf("/firmware/%d/edit", firmware.IdFirmware), adminToken, nil), 200, nil)
+
+	// Check and download from the node
+	check := entities.FirmwareCheck{}
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d/firmware?version=1.0.0", idNode), "", nil, middlewares.DeviceKeyHeader, nodeKey.Key), 200, &check)
+	if check.UpdateAvailable {
+		t.Fatalf("Expected no update for the same version, got %+v", check)
+	}
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d/firmware?version=0.9.0", idNode), "", nil, middlewares.DeviceKeyHeader, nodeKey.Key), 200, &check)
+	if !check.UpdateAvailable || check.DownloadUrl == "" {
+		t.Fatalf("Expected an update with a download url, got %+v", check)
+	}
+	downloadUrl, err := url.Parse(check.DownloadUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := s.request("GET", downloadUrl.RequestURI(), "", nil)
+	s.expect(res, 200, nil)
+	if string(res.body) != "firmware 1.0.0" || res.header.Get("X-Checksum-Sha256") != firmware.Checksum {
+		t.Fatalf("Unexpected download %s", res.body)
+	}
+	s.expect(s.request("GET", fmt.Sprintf("/firmware/%d/download?expires=%d&signature=00", firmware.IdFirmware, time.Now().Add(time.Hour).Unix()), "", nil), 403, nil)
+
+	// The node is not in the rollout anymore
+	rolloutPercentage := 0
+	s.expect(s.request("PUT", fmt.Sprintf("/firmware/%d", firmware.IdFirmware), token, entities.FirmwareUpdate{RolloutPercentage: &rolloutPercentage}), 403, nil)
+	s.expect(s.request("PUT", fmt.Sprintf("/firmware/%d", firmware.IdFirmware), adminToken, entities.FirmwareUpdate{RolloutPercentage: &rolloutPercentage}), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/node/%d/firmware?version=0.9.0", idNode), token, nil), 200, &check)
+	if check.UpdateAvailable {
+		t.Fatalf("Expected no update outside of the rollout, got %+v", check)
+	}
+
+	s.expect(s.request("DELETE", fmt.Sprintf("/firmware/%d", firmware.IdFirmware), adminToken, nil), 200, nil)
+	s.expect(s.request("GET", fmt.Sprintf("/firmware/%d", firmware.IdFirmware), adminToken, nil), 404, nil)
+
+	// Only the firmware upload accept a body larger than the default limit
+	large := bytes.Repeat([]byte{0}, fiber.DefaultBodyLimit+1)
+	s.expect(upload(adminToken, "2.0.0", large), 201, &firmware)
+	if firmware.Size != int64(len(large)) {
+		t.Fatalf("Expected the whole binary to be saved, got %+v", firmware)
+	}
+	s.expect(s.request("POST", "/channel", token, bytes.NewReader(large)), 413, nil)
+}
+
+func TestPartitionRoute(t *testing.T) {
+	s := newTestServer(t)
+	token := s.userToken()
+	adminToken := s.adminToken()
+	_, idSensor := s.createDevice(token)
+	s.sendChannel(token, idSensor, 1, time.Now().UTC())
+
+	s.expect(s.request("GET", "/partition", token, nil), 403, nil)
+	report := entities.ChannelPartitionReport{}
+	s.expect(s.request("GET", "/partition", adminToken, nil), 200, &report)
+	if report.TotalRows != 1 {
+		t.Fatalf("Expected 1 channel row, got %+v", report)
+	}
+	s.expect(s.request("GET", "/partition", adminToken, nil, "Accept", "text/html"), 200, nil)
+
+	users := []entities.UserRead{}
+	s.expect(s.request("GET", "/user", adminToken, nil), 200, &users)
+	retentionDays := 30
+	s.expect(s.request("PUT", fmt.Sprintf("/partition/retention/%d", users[1].IdUser), token, entities.UserRetentionUpdate{RetentionDays: &retentionDays}), 403, nil)
+	s.expect(s.request("PUT", fmt.Sprintf("/partition/retention/%d", users[1].IdUser), adminToken, entities.UserRetentionUpdate{RetentionDays: &retentionDays}), 200, nil)
+	retentionDays = 0
+	s.expect(s.request("PUT", fmt.Sprintf("/partition/retention/%d", users[1].IdUser), adminToken, entities.UserRetentionUpdate{RetentionDays: &retentionDays}), 400, nil)
+	s.expect(s.request("PUT", "/partition/retention/100", adminToken, entities.UserRetentionUpdate{}), 404, nil)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/database"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/handlers"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/middlewares"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/template/handlebars"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"gopkg.in/gomail.v2"
+	// "github.com/goccy/go-json"
+)
+
+// Server is the fiber app with every dependency that is needed after the routes are declared
+type Server struct {
+	App           *fiber.App
+	Db            helper.Querier
+	Repositories  repositories.Repositories
+	ChannelHub    *dependencies.ChannelHub
+	ChannelWriter *dependencies.ChannelWriter
+	// Nil when the listeners run on the request
+	ChannelListenerQueue *handlers.ChannelListenerQueue
+
+	ChannelHandler   handlers.ChannelHandler
+	NodeHandler      handlers.NodeHandler
+	WebhookHandler   handlers.WebhookHandler
+	PartitionHandler handlers.PartitionHandler
+	RollupHandler    handlers.RollupHandler
+	MqttHandler      handlers.MqttHandler
+}
+
+// NewServer declare every dependency and route, rootDir is the directory containing the internal folder
+// with the views and static files. The database driver in the config choose between postgres and memory.
+func NewServer(rootDir string) (server *Server, err error) {
+	config := configs.GetConfig()
+	engine := handlebars.New(path.Join(rootDir, "internal", "views"), ".hbs")
+
+	app := fiber.New(
+		fiber.Config{
+			// Override default error handler
+			Views:        engine,
+			ErrorHandler: helper.FiberErrorHandler,
+			// The body size is limited per route by the body limit middleware
+			StreamRequestBody:            true,
+			DisablePreParseMultipartForm: true,
+			// JSONEncoder:  json.Marshal,
+			// JSONDecoder:  json.Unmarshal,
+		},
+	)
+	app.Static("/static", path.Join(rootDir, "internal", "public"))
+	server = &Server{App: app}
+
+	// BEGIN Database and repositories declaration
+	var pool *pgxpool.Pool
+	switch config.Database.Driver {
+	case "memory":
+		log.Println("Using the memory database, every data is lost when the server stop")
+		// There is no other server instance to share the reading with
+		config.Stream.PostgresNotify = false
+		server.Db = repositories.NewMemoryDatabase()
+		server.Repositories, err = repositories.NewMemoryRepositories()
+		if err != nil {
+			return server, err
+		}
+		err = seedMemoryAccount(context.Background(), server.Db, server.Repositories.User)
+		if err != nil {
+			return server, err
+		}
+	case "", "postgres":
+		pool, err = database.GetConnection()
+		if err != nil {
+			return server, err
+		}
+		server.Db = pool
+		var dialer *gomail.Dialer
+		dialer, err = dependencies.NewMailDialer(config)
+		if err != nil {
+			return server, err
+		}
+		server.Repositories, err = repositories.NewPostgresRepositories(dialer)
+		if err != nil {
+			return server, err
+		}
+	default:
+		return server, fmt.Errorf("unknown database driver %s, it should be postgres or memory", config.Database.Driver)
+	}
+	db := server.Db
+	repository := server.Repositories
+	// END
+
+	// BEGIN Other dependencies declaration
+	validate := validator.New()
+	myValidator := dependencies.NewValidator(validate)
+	server.ChannelHub = dependencies.NewChannelHub(pool)
+	// END
+
+	// BEGIN Middleware
+	app.Use(recover.New(recover.Config{
+		EnableStackTrace: true,
+	}))
+	bodyLimitMiddleware := middlewares.NewBodyLimitMiddleware(fiber.DefaultBodyLimit, map[string]int{
+		"POST /firmware": config.Firmware.MaxSize,
+	})
+	app.Use(bodyLimitMiddleware.Limit)
+	authenticationMiddleware := middlewares.NewAuthenticationMiddleware(&myValidator)
+	// END
+
+	// BEGIN Handlers declaration
+	server.WebhookHandler, err = handlers.NewWebhookHandler(db, repository.Webhook, repository.Sensor, &myValidator)
+	helper.PanicIfError(err)
+	userHandler, err := handlers.NewUserHandler(db, repository.User, repository.RefreshToken, repository.PasswordReset, &myValidator)
+	helper.PanicIfError(err)
+	organizationHandler, err := handlers.NewOrganizationHandler(db, repository.Organization, repository.Node, repository.User, &myValidator)
+	helper.PanicIfError(err)
+	hardwareHandler, err := handlers.NewHardwareHandler(db, repository.Hardware, repository.Node, repository.Sensor, &server.WebhookHandler, &myValidator)
+	helper.PanicIfError(err)
+	server.NodeHandler, err = handlers.NewNodeHandler(db, repository.Node, repository.Hardware, repository.Sensor, repository.Channel, repository.NodeCommand, repository.NodeShadow, repository.User, repository.Organization, &server.WebhookHandler, &myValidator)
+	helper.PanicIfError(err)
+	sensorHandler, err := handlers.NewSensorHandler(db, repository.Sensor, repository.Hardware, repository.Node, repository.Channel, repository.Organization, &server.WebhookHandler, server.ChannelHub, &myValidator)
+	helper.PanicIfError(err)
+	alertHandler, err := handlers.NewAlertHandler(db, repository.Alert, repository.Sensor, repository.User, repository.Organization, &server.WebhookHandler, &myValidator)
+	helper.PanicIfError(err)
+	server.RollupHandler, err = handlers.NewRollupHandler(db, repository.Rollup)
+	helper.PanicIfError(err)
+	// The node last seen time, the alert evaluation and the channel webhook don't need to finish before the response
+	backgroundListeners := []handlers.ChannelListener{&server.NodeHandler, &alertHandler, &server.WebhookHandler}
+	if config.Ingest.ListenerQueueSize > 0 {
+		server.ChannelListenerQueue = handlers.NewChannelListenerQueue(config.Ingest.ListenerQueueSize, backgroundListeners)
+		server.ChannelListenerQueue.Start()
+		backgroundListeners = []handlers.ChannelListener{server.ChannelListenerQueue}
+	}
+	channelListeners := append([]handlers.ChannelListener{server.ChannelHub}, backgroundListeners...)
+	server.ChannelHandler, err = handlers.NewChannelHandler(db, repository.Channel, repository.Sensor, repository.Rollup, repository.Organization, &myValidator, channelListeners)
+	helper.PanicIfError(err)
+	if config.Ingest.Mode == "async" {
+		server.ChannelWriter = dependencies.NewChannelWriter(server.ChannelHandler.WriteChannels, repositories.IsConstraintError, config.Ingest.QueueSize, config.Ingest.BatchSize, config.Ingest.FlushInterval, config.Ingest.MaxRetry, config.Ingest.RetryBaseDelay)
+		server.ChannelWriter.Start()
+		server.ChannelHandler.SetWriter(server.ChannelWriter)
+	}
+	nodeKeyHandler, err := handlers.NewNodeKeyHandler(db, repository.NodeKey, repository.Node, repository.Organization, &myValidator)
+	helper.PanicIfError(err)
+	server.MqttHandler, err = handlers.NewMqttHandler(db, repository.Channel, repository.Sensor, repository.Node, repository.NodeKey, repository.Organization, channelListeners)
+	helper.PanicIfError(err)
+	nodeCommandHandler, err := handlers.NewNodeCommandHandler(db, repository.NodeCommand, repository.Node, repository.Organization, &server.MqttHandler, &myValidator)
+	helper.PanicIfError(err)
+	nodeShadowHandler, err := handlers.NewNodeShadowHandler(db, repository.NodeShadow, repository.Node, repository.Organization, &server.MqttHandler, &myValidator)
+	helper.PanicIfError(err)
+	firmwareHandler, err := handlers.NewFirmwareHandler(db, repository.Firmware, repository.Hardware, repository.Node, repository.Organization, &myValidator)
+	helper.PanicIfError(err)
+	server.PartitionHandler, err = handlers.NewPartitionHandler(db, repository.Partition, repository.User, &myValidator)
+	helper.PanicIfError(err)
+	// END
+
+	// BEGIN Routes declaration
+	deviceAuthenticationMiddleware := middlewares.NewDeviceAuthenticationMiddleware(db, repository.NodeKey, &authenticationMiddleware)
+	router, err := NewRouter(app, &authenticationMiddleware, &deviceAuthenticationMiddleware)
+	helper.PanicIfError(err)
+	router.CreateHealthCheckRoute()
+	router.CreateUserRoute(&userHandler)
+	router.CreateOrganizationRoute(&organizationHandler)
+	router.CreateHardwareRoute(&hardwareHandler)
+	router.CreateNodeRoute(&server.NodeHandler)
+	router.CreateNodeKeyRoute(&nodeKeyHandler)
+	router.CreateNodeCommandRoute(&nodeCommandHandler)
+	router.CreateNodeShadowRoute(&nodeShadowHandler)
+	router.CreateSensorRoute(&sensorHandler)
+	router.CreateChannelRoute(&server.ChannelHandler)
+	router.CreateAlertRoute(&alertHandler)
+	router.CreateWebhookRoute(&server.WebhookHandler)
+	router.CreateFirmwareRoute(&firmwareHandler)
+	router.CreatePartitionRoute(&server.PartitionHandler)
+	// END
+
+	return server, nil
+}
+
+// seedMemoryAccount create the active admin and user account from the config, the same as the seed command
+// on postgres, so the memory database can be used right away
+func seedMemoryAccount(ctx context.Context, db helper.Querier, userRepository repositories.UserRepository) error {
+	config := configs.GetConfig()
+	accounts := []struct {
+		payload entities.UserCreate
+		isAdmin bool
+	}{
+		{entities.UserCreate{Username: config.Account.AdminUsername, Email: config.Account.AdminEmail, Password: config.Account.AdminPassword}, true},
+		{entities.UserCreate{Username: config.Account.UserUsername, Email: config.Account.UserEmail, Password: config.Account.UserPassword}, false},
+	}
+
+	for _, account := range accounts {
+		user, err := userRepository.Create(ctx, db, account.payload)
+		if err != nil {
+			return err
+		}
+		err = userRepository.UpdateStatus(ctx, db, user.IdUser, true)
+		if err != nil {
+			return err
+		}
+		err = userRepository.UpdateIsAdmin(ctx, db, user.IdUser, account.isAdmin)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
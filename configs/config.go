@@ -2,10 +2,13 @@ package configs
 
 import (
 	"bytes"
+	"errors"
+	"io/fs"
 	"log"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	_ "embed"
 
@@ -18,7 +21,14 @@ type Config struct {
 		Host string `json:"host"`
 		Port int    `json:"port"`
 	} `json:"server"`
+	Mqtt struct {
+		Enabled bool   `json:"enabled"`
+		Host    string `json:"host"`
+		Port    int    `json:"port"`
+	} `json:"mqtt"`
 	Database struct {
+		// postgres or memory, memory keep everything in the process and lose it on restart, used for test and demo
+		Driver   string `json:"driver"`
 		Username string `json:"username"`
 		Password string `json:"password"`
 		Host     string `json:"host"`
@@ -26,8 +36,14 @@ type Config struct {
 		Name     string `json:"name"`
 	} `json:"database"`
 	JWT struct {
-		SecretKey string `json:"secretKey"`
+		SecretKey       string        `json:"secretKey"`
+		AccessTokenTTL  time.Duration `json:"accessTokenTTL"`
+		RefreshTokenTTL time.Duration `json:"refreshTokenTTL"`
 	} `json:"jwt"`
+	Password struct {
+		// Bcrypt work factor, between 4 and 31, existing hash is upgraded on the next login when this change
+		BcryptCost int `json:"bcryptCost"`
+	} `json:"password"`
 	Mail struct {
 		SMTPHost               string `json:"smtpHost"`
 		SMTPPort               int    `json:"smtpPort"`
@@ -35,6 +51,85 @@ type Config struct {
 		AuthenticationMail     string `json:"authenticationMail"`
 		AuthenticationPassword string `json:"authenticationPassword"`
 	} `json:"mail"`
+	Channel struct {
+		// Reject reading with device time later than received time plus this drift
+		MaxFutureDrift time.Duration `json:"maxFutureDrift"`
+		// Reject reading with device time older than this, zero means no limit
+		MaxPastAge time.Duration `json:"maxPastAge"`
+	} `json:"channel"`
+	Ingest struct {
+		// sync insert a channel before responding to POST /channel, async queue it in the write buffer
+		// and respond 202 before it is stored
+		Mode string `json:"mode"`
+		// Maximum number of queued channel, new channel are rejected with 429 when the queue is full
+		QueueSize int `json:"queueSize"`
+		// A batch is written when it reach BatchSize channel or FlushInterval after its first channel
+		BatchSize     int           `json:"batchSize"`
+		FlushInterval time.Duration `json:"flushInterval"`
+		// A batch that fail with a transient error, like a lost database connection, is retried up to MaxRetry
+		// times after RetryBaseDelay, doubled on every attempt. A batch rejected by a constraint is written one
+		// channel at a time instead so only the invalid channels are dropped
+		MaxRetry       int           `json:"maxRetry"`
+		RetryBaseDelay time.Duration `json:"retryBaseDelay"`
+		// Maximum number of stored channel batch waiting for the node last seen time, the alert evaluation and the
+		// rollup, which run in the background. A new channel wait when it is full. Zero run them on the request
+		ListenerQueueSize int `json:"listenerQueueSize"`
+		// How long the server wait for the queues to be written on shutdown
+		ShutdownTimeout time.Duration `json:"shutdownTimeout"`
+	} `json:"ingest"`
+	Command struct {
+		// A node command expire when it is not acknowledged after this duration, unless expires_in is set
+		DefaultTTL time.Duration `json:"defaultTTL"`
+	} `json:"command"`
+	Heartbeat struct {
+		// A node is offline when nothing is received for this duration, unless the node set its own offline_timeout
+		OfflineTimeout time.Duration `json:"offlineTimeout"`
+		// How often node status is checked to send the offline and online email
+		CheckInterval time.Duration `json:"checkInterval"`
+	} `json:"heartbeat"`
+	Firmware struct {
+		// Directory where uploaded firmware binary is saved
+		StorageDir string `json:"storageDir"`
+		// Maximum firmware size in byte, other routes keep the default 4 MiB request body limit
+		MaxSize int `json:"maxSize"`
+		// How long a signed download url stay valid
+		DownloadURLTTL time.Duration `json:"downloadURLTTL"`
+	} `json:"firmware"`
+	Partition struct {
+		// Length of a channel partition, either day, week or month
+		Interval string `json:"interval"`
+		// Number of upcoming partition created in advance
+		Premake int `json:"premake"`
+		// Partition entirely older than this are dropped, zero means keep forever
+		Retention time.Duration `json:"retention"`
+		// Detach expired partition into a standalone table instead of dropping it, so it can be archived
+		DetachOnly bool `json:"detachOnly"`
+		// How often partition are created and retention is applied
+		CheckInterval time.Duration `json:"checkInterval"`
+	} `json:"partition"`
+	Rollup struct {
+		// How often the worker recompute the hour that received new channel
+		Interval time.Duration `json:"interval"`
+		// Number of pending hour recomputed in a single transaction
+		BatchSize int `json:"batchSize"`
+	} `json:"rollup"`
+	Stream struct {
+		// Share new reading between server instance with postgres LISTEN/NOTIFY instead of in memory only
+		PostgresNotify bool `json:"postgresNotify"`
+		// Interval of the keep alive comment sent on an idle stream
+		KeepAliveInterval time.Duration `json:"keepAliveInterval"`
+	} `json:"stream"`
+	Webhook struct {
+		// How often the dispatcher look for due delivery when it is not woken up by a new event
+		PollInterval time.Duration `json:"pollInterval"`
+		// Timeout of a single delivery request
+		Timeout time.Duration `json:"timeout"`
+		// A failed delivery is retried after RetryBaseDelay, doubled on every attempt, until MaxAttempt
+		RetryBaseDelay time.Duration `json:"retryBaseDelay"`
+		MaxAttempt     int           `json:"maxAttempt"`
+		// Allow webhook to loopback, private and link local address, only for local development
+		AllowPrivateAddress bool `json:"allowPrivateAddress"`
+	} `json:"webhook"`
 	Account struct {
 		AdminUsername string `json:"adminUsername"`
 		AdminEmail    string `json:"adminEmail"`
@@ -42,6 +137,8 @@ type Config struct {
 		UserUsername  string `json:"userUsername"`
 		UserEmail     string `json:"userEmail"`
 		UserPassword  string `json:"userPassword"`
+		// How long a forgot password link can be used
+		PasswordResetTTL time.Duration `json:"passwordResetTTL"`
 	} `json:"account"`
 }
 
@@ -58,8 +155,9 @@ func init() {
 	configSettings := viper.New()
 
 	env_path := path.Join(working_directory, ".env")
+	// The .env file is optional, the environment variables can be set directly
 	err = godotenv.Load(env_path)
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		log.Fatalf("Error getting reading env, %s", err.Error())
 	}
 
@@ -2,8 +2,6 @@ package database
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"io/ioutil"
 	"log"
 	"path/filepath"
@@ -13,36 +11,23 @@ import (
 	"github.com/dafaath/iot-server/internal/helper"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type SQLType int
 
 const (
-	TABLE SQLType = iota
-	DROP
-	ADMIN
+	ADMIN SQLType = iota
 	HARDWARE
 	NODE
 	SENSOR
 	CHANNEL
 )
 
-func hashPassword(ctx context.Context, password string) (hashedPassword string, err error) {
-	hasher := sha256.New()
-	hasher.Write([]byte(password))
-	passwordHashBytes := hasher.Sum(nil)
-	passwordHashString := hex.EncodeToString(passwordHashBytes)
-	return passwordHashString, nil
-}
-
 func openSqlFile(sqlType SQLType) string {
 	var path string
 	sqlFolderPath := filepath.Join("internal", "database", "sql")
 	switch sqlType {
-	case TABLE:
-		path = filepath.Join(sqlFolderPath, "table.sql")
-	case DROP:
-		path = filepath.Join(sqlFolderPath, "drop.sql")
 	case HARDWARE:
 		path = filepath.Join(sqlFolderPath, "hardware.sql")
 	case NODE:
@@ -64,7 +49,7 @@ func openSqlFile(sqlType SQLType) string {
 
 func createAdminData(tx pgx.Tx, config *configs.Config) error {
 	log.Println("Creating admin data")
-	hashedPassword, err := hashPassword(context.Background(), config.Account.AdminPassword)
+	hashedPassword, err := helper.HashPassword(config.Account.AdminPassword)
 	if err != nil {
 		return err
 	}
@@ -85,7 +70,7 @@ func createAdminData(tx pgx.Tx, config *configs.Config) error {
 
 func createUserData(tx pgx.Tx, config *configs.Config) error {
 	log.Println("Creating user data")
-	hashedPassword, err := hashPassword(context.Background(), config.Account.UserPassword)
+	hashedPassword, err := helper.HashPassword(config.Account.UserPassword)
 	if err != nil {
 		return err
 	}
@@ -111,6 +96,19 @@ func createHardware(tx pgx.Tx) error {
 	return err
 }
 
+// createPersonalOrganization create the personal organization of every user that doesn't have one yet,
+// the mock node use the organization id of its user so it must run in user id order
+func createPersonalOrganization(tx pgx.Tx) error {
+	log.Println("Creating personal organization")
+	_, err := tx.Exec(context.Background(), `INSERT INTO organization (name, id_personal_user) SELECT username, id_user FROM user_person ORDER BY id_user ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.Background(), `INSERT INTO organization_member (id_organization, id_user, role) SELECT id_organization, id_personal_user, 'owner' FROM organization WHERE id_personal_user IS NOT NULL ON CONFLICT DO NOTHING`)
+	return err
+}
+
 func createNode(tx pgx.Tx) error {
 	log.Println("Creating node")
 	sqlStatement := openSqlFile(NODE)
@@ -129,22 +127,18 @@ func createChannel(tx pgx.Tx) error {
 	log.Println("Creating channel")
 	sqlStatement := openSqlFile(CHANNEL)
 	_, err := tx.Exec(context.Background(), sqlStatement)
-	return err
-}
-
-func createMockData(tx pgx.Tx, config *configs.Config) error {
-	log.Println("Creating mock data")
-	err := createAdminData(tx, config)
 	if err != nil {
 		return err
 	}
 
-	err = createUserData(tx, config)
-	if err != nil {
-		return err
-	}
+	// The rollup worker summarize the mock channel when the server start
+	_, err = tx.Exec(context.Background(), `INSERT INTO channel_rollup_pending (id_sensor, bucket) SELECT DISTINCT id_sensor, date_trunc('hour', time) FROM channel ON CONFLICT DO NOTHING`)
+	return err
+}
 
-	err = createHardware(tx)
+// createMockDevice create the hardware, node, sensor and channel of the mock data
+func createMockDevice(tx pgx.Tx) error {
+	err := createHardware(tx)
 	if err != nil {
 		return err
 	}
@@ -165,56 +159,34 @@ func createMockData(tx pgx.Tx, config *configs.Config) error {
 	return nil
 }
 
-func createTable(tx pgx.Tx) error {
-	log.Println("Creating table")
-	sqlStatement := openSqlFile(TABLE)
-	_, err := tx.Exec(context.Background(), sqlStatement)
-	return err
-}
-
-func DropTable() {
-	db, err := GetConnection()
-	helper.PanicIfError(err)
-
-	log.Println("Drop table")
-	sqlStatement := openSqlFile(DROP)
-	_, err = db.Exec(context.Background(), sqlStatement)
-	log.Println("Finish drop table")
-
-	helper.PanicIfError(err)
-}
-
-func CreateTableAndMockData() {
-	db, err := GetConnection()
-	helper.PanicIfError(err)
+// Seed create the admin and user account from the config without dropping anything,
+// mock also create the hardware, node, sensor and channel of the mock data
+func Seed(ctx context.Context, db *pgxpool.Pool, mock bool) error {
 	config := configs.GetConfig()
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
 
-	tx, err := db.Begin(context.Background())
-	helper.PanicIfError(err)
-
-	err = createTable(tx)
-	helper.PanicIfError(err)
-
-	err = createMockData(tx, config)
-	helper.PanicIfError(err)
+	err = createAdminData(tx, config)
+	if err != nil {
+		return err
+	}
+	err = createUserData(tx, config)
+	if err != nil {
+		return err
+	}
+	err = createPersonalOrganization(tx)
+	if err != nil {
+		return err
+	}
 
-	defer func() {
-		recoverErr := recover()
-		if recoverErr != nil {
-			log.Println("[ERROR]", recoverErr)
-			errorRollback := tx.Rollback(context.Background())
-			if errorRollback != nil {
-				log.Println("Error in rollback", errorRollback)
-			} else {
-				log.Println("Rollback success")
-			}
-		} else {
-			errorCommit := tx.Commit(context.Background())
-			if errorCommit != nil {
-				log.Println("Error in commit", errorCommit)
-			} else {
-				log.Println("Commit success")
-			}
+	if mock {
+		err = createMockDevice(tx)
+		if err != nil {
+			return err
 		}
-	}()
+	}
+	return tx.Commit(ctx)
 }
@@ -0,0 +1,250 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Migration are embedded in the binary, each version need a {version}_{name}.up.sql
+// and a {version}_{name}.down.sql file in the migrations folder
+//
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Arbitrary key for pg_advisory_lock so two process never migrate at the same time
+const migrationLockKey = 7_160_912
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	// AppliedAt is nil when the migration is still pending
+	AppliedAt *time.Time
+}
+
+// LoadMigrations read the embedded migrations sorted by version
+func LoadMigrations() ([]Migration, error) {
+	files, err := fs.ReadDir(migrationFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrationByVersion := map[int]*Migration{}
+	for _, file := range files {
+		match := migrationFileName.FindStringSubmatch(file.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s, it must be {version}_{name}.up.sql or {version}_{name}.down.sql", file.Name())
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		content, err := migrationFS.ReadFile("migrations/" + file.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := migrationByVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			migrationByVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(migrationByVersion))
+	for _, migration := range migrationByVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// withMigrationLock run handle on a single connection holding the migration lock,
+// the schema_migrations table is created when it doesn't exist
+func withMigrationLock(ctx context.Context, db *pgxpool.Pool, handle func(conn *pgxpool.Conn) error) error {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey)
+	if err != nil {
+		return err
+	}
+	defer conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc')
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return handle(conn)
+}
+
+func getAppliedMigration(ctx context.Context, conn *pgxpool.Conn) (map[int]time.Time, error) {
+	applied := map[int]time.Time{}
+	rows, err := conn.Query(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return applied, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return applied, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runMigration execute the sql and record the change in schema_migrations in the same transaction
+func runMigration(ctx context.Context, conn *pgxpool.Conn, sqlStatement string, record func(tx pgx.Tx) error) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, sqlStatement)
+	if err != nil {
+		return err
+	}
+
+	err = record(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// MigrateUp apply every pending migration in order, each one in its own transaction
+func MigrateUp(ctx context.Context, db *pgxpool.Pool) (applied []Migration, err error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	err = withMigrationLock(ctx, db, func(conn *pgxpool.Conn) error {
+		appliedAt, err := getAppliedMigration(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			if _, ok := appliedAt[migration.Version]; ok {
+				continue
+			}
+
+			log.Printf("[MIGRATION] Applying %d_%s", migration.Version, migration.Name)
+			err := runMigration(ctx, conn, migration.Up, func(tx pgx.Tx) error {
+				_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s failed, %w", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown revert the last n applied migration, newest first
+func MigrateDown(ctx context.Context, db *pgxpool.Pool, n int) (reverted []Migration, err error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	err = withMigrationLock(ctx, db, func(conn *pgxpool.Conn) error {
+		appliedAt, err := getAppliedMigration(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(reverted) < n; i-- {
+			migration := migrations[i]
+			if _, ok := appliedAt[migration.Version]; !ok {
+				continue
+			}
+
+			log.Printf("[MIGRATION] Reverting %d_%s", migration.Version, migration.Name)
+			err := runMigration(ctx, conn, migration.Down, func(tx pgx.Tx) error {
+				_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version=$1`, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("revert migration %d_%s failed, %w", migration.Version, migration.Name, err)
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// GetMigrationStatus list every embedded migration with the time it was applied
+func GetMigrationStatus(ctx context.Context, db *pgxpool.Pool) (statuses []MigrationStatus, err error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	err = withMigrationLock(ctx, db, func(conn *pgxpool.Conn) error {
+		appliedAt, err := getAppliedMigration(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			status := MigrationStatus{Migration: migration}
+			if t, ok := appliedAt[migration.Version]; ok {
+				status.AppliedAt = &t
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
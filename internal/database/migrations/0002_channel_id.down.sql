@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS channel_id_sensor_time_idx;
+ALTER TABLE channel DROP COLUMN IF EXISTS received_at;
+ALTER TABLE channel DROP COLUMN IF EXISTS id_channel;
@@ -0,0 +1,7 @@
+-- Channel from before the migration get an id and use their own time as the received time
+ALTER TABLE channel ADD COLUMN IF NOT EXISTS id_channel BIGSERIAL PRIMARY KEY;
+ALTER TABLE channel ADD COLUMN IF NOT EXISTS received_at TIMESTAMP;
+UPDATE channel SET received_at = COALESCE(time, NOW() AT TIME ZONE 'utc') WHERE received_at IS NULL;
+ALTER TABLE channel ALTER COLUMN received_at SET DEFAULT (NOW() AT TIME ZONE 'utc');
+ALTER TABLE channel ALTER COLUMN received_at SET NOT NULL;
+CREATE INDEX IF NOT EXISTS channel_id_sensor_time_idx ON channel (id_sensor, time);
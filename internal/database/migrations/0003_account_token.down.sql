@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "password_reset" CASCADE;
+DROP TABLE IF EXISTS "refresh_token" CASCADE;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS refresh_token (
+  id_refresh_token SERIAL PRIMARY KEY, 
+  id_user INTEGER NOT NULL, 
+  token_hash VARCHAR (255) NOT NULL UNIQUE, 
+  expires_at TIMESTAMP NOT NULL, 
+  created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  revoked_at TIMESTAMP, 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS password_reset (
+  id_password_reset SERIAL PRIMARY KEY, 
+  id_user INTEGER NOT NULL, 
+  token_hash VARCHAR (255) NOT NULL UNIQUE, 
+  expires_at TIMESTAMP NOT NULL, 
+  created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  used_at TIMESTAMP, 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS "firmware" CASCADE;
+DROP TABLE IF EXISTS "node_shadow" CASCADE;
+DROP TABLE IF EXISTS "node_command" CASCADE;
+DROP TABLE IF EXISTS "node_key" CASCADE;
+ALTER TABLE node DROP COLUMN IF EXISTS notified_status;
+ALTER TABLE node DROP COLUMN IF EXISTS notify_offline;
+ALTER TABLE node DROP COLUMN IF EXISTS offline_timeout;
+ALTER TABLE node DROP COLUMN IF EXISTS last_seen;
@@ -0,0 +1,52 @@
+ALTER TABLE node ADD COLUMN IF NOT EXISTS last_seen TIMESTAMP;
+ALTER TABLE node ADD COLUMN IF NOT EXISTS offline_timeout INTEGER;
+ALTER TABLE node ADD COLUMN IF NOT EXISTS notify_offline BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE node ADD COLUMN IF NOT EXISTS notified_status VARCHAR (16);
+CREATE TABLE IF NOT EXISTS node_key (
+  id_node_key SERIAL PRIMARY KEY, 
+  id_node INTEGER NOT NULL, 
+  prefix VARCHAR (255) NOT NULL, 
+  key_hash VARCHAR (255) NOT NULL UNIQUE, 
+  created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  revoked_at TIMESTAMP, 
+  FOREIGN KEY (id_node) REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS node_command (
+  id_node_command SERIAL PRIMARY KEY, 
+  id_node INTEGER NOT NULL, 
+  name VARCHAR (255) NOT NULL, 
+  args JSONB NOT NULL DEFAULT '{}', 
+  status VARCHAR (16) NOT NULL DEFAULT 'pending', 
+  result JSONB, 
+  created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  expires_at TIMESTAMP NOT NULL, 
+  delivered_at TIMESTAMP, 
+  acked_at TIMESTAMP, 
+  FOREIGN KEY (id_node) REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS node_command_id_node_status_idx ON node_command (id_node, status);
+CREATE TABLE IF NOT EXISTS node_shadow (
+  id_node INTEGER PRIMARY KEY, 
+  desired JSONB NOT NULL DEFAULT '{}', 
+  reported JSONB NOT NULL DEFAULT '{}', 
+  version INTEGER NOT NULL DEFAULT 0, 
+  reported_version INTEGER NOT NULL DEFAULT 0, 
+  desired_updated_at TIMESTAMP, 
+  reported_at TIMESTAMP, 
+  FOREIGN KEY (id_node) REFERENCES node (id_node) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS firmware (
+  id_firmware SERIAL PRIMARY KEY, 
+  id_hardware INTEGER NOT NULL, 
+  version VARCHAR (64) NOT NULL, 
+  description TEXT NOT NULL DEFAULT '', 
+  file_name VARCHAR (255) NOT NULL, 
+  file_path VARCHAR (1024) NOT NULL, 
+  size BIGINT NOT NULL, 
+  checksum VARCHAR (64) NOT NULL, 
+  rollout_percentage INTEGER NOT NULL DEFAULT 100, 
+  rollout_nodes INTEGER[] NOT NULL DEFAULT '{}', 
+  created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  UNIQUE (id_hardware, version), 
+  FOREIGN KEY (id_hardware) REFERENCES hardware (id_hardware) ON UPDATE CASCADE ON DELETE CASCADE
+);
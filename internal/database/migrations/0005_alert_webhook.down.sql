@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS "webhook_delivery" CASCADE;
+DROP TABLE IF EXISTS "webhook" CASCADE;
+DROP TABLE IF EXISTS "alert_event" CASCADE;
+DROP TABLE IF EXISTS "alert_rule" CASCADE;
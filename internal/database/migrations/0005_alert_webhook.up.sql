@@ -0,0 +1,52 @@
+CREATE TABLE IF NOT EXISTS alert_rule (
+  id_alert_rule SERIAL PRIMARY KEY, 
+  id_sensor INTEGER NOT NULL, 
+  name VARCHAR (255) NOT NULL, 
+  condition VARCHAR (16) NOT NULL, 
+  lower_threshold FLOAT, 
+  upper_threshold FLOAT, 
+  consecutive INTEGER NOT NULL DEFAULT 1, 
+  duration_second INTEGER NOT NULL DEFAULT 0, 
+  enabled BOOLEAN NOT NULL DEFAULT TRUE, 
+  state VARCHAR (16) NOT NULL DEFAULT 'ok', 
+  breach_count INTEGER NOT NULL DEFAULT 0, 
+  breach_since TIMESTAMP, 
+  created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS alert_event (
+  id_alert_event SERIAL PRIMARY KEY, 
+  id_alert_rule INTEGER NOT NULL, 
+  kind VARCHAR (16) NOT NULL, 
+  value FLOAT NOT NULL, 
+  time TIMESTAMP NOT NULL, 
+  created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  FOREIGN KEY (id_alert_rule) REFERENCES alert_rule (id_alert_rule) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS alert_event_id_alert_rule_time_idx ON alert_event (id_alert_rule, time);
+CREATE TABLE IF NOT EXISTS webhook (
+  id_webhook SERIAL PRIMARY KEY, 
+  id_user INTEGER NOT NULL, 
+  url VARCHAR (2048) NOT NULL, 
+  secret VARCHAR (255) NOT NULL, 
+  event_types VARCHAR (64)[] NOT NULL, 
+  enabled BOOLEAN NOT NULL DEFAULT TRUE, 
+  created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+  id_webhook_delivery BIGSERIAL PRIMARY KEY, 
+  id_webhook INTEGER NOT NULL, 
+  event_type VARCHAR (64) NOT NULL, 
+  payload TEXT NOT NULL, 
+  status VARCHAR (16) NOT NULL DEFAULT 'pending', 
+  attempt INTEGER NOT NULL DEFAULT 0, 
+  next_attempt_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  last_status_code INTEGER, 
+  last_error TEXT, 
+  created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  delivered_at TIMESTAMP, 
+  FOREIGN KEY (id_webhook) REFERENCES webhook (id_webhook) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS webhook_delivery_pending_idx ON webhook_delivery (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_delivery_id_webhook_idx ON webhook_delivery (id_webhook, id_webhook_delivery);
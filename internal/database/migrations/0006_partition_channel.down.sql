@@ -0,0 +1,18 @@
+ALTER TABLE user_person DROP COLUMN IF EXISTS retention_days;
+ALTER TABLE channel RENAME TO channel_partitioned;
+ALTER INDEX channel_pkey RENAME TO channel_partitioned_pkey;
+ALTER INDEX channel_id_sensor_time_idx RENAME TO channel_partitioned_id_sensor_time_idx;
+ALTER SEQUENCE channel_id_channel_seq OWNED BY NONE;
+CREATE TABLE channel (
+  id_channel BIGINT PRIMARY KEY DEFAULT nextval('channel_id_channel_seq'), 
+  time TIMESTAMP, 
+  value FLOAT NOT NULL, 
+  id_sensor INTEGER NOT NULL, 
+  received_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX channel_id_sensor_time_idx ON channel (id_sensor, time);
+INSERT INTO channel (id_channel, time, value, id_sensor, received_at) 
+SELECT id_channel, time, value, id_sensor, received_at FROM channel_partitioned;
+DROP TABLE channel_partitioned;
+ALTER SEQUENCE channel_id_channel_seq OWNED BY channel.id_channel;
@@ -0,0 +1,34 @@
+ALTER TABLE channel RENAME TO channel_legacy;
+ALTER INDEX channel_pkey RENAME TO channel_legacy_pkey;
+DROP INDEX IF EXISTS channel_id_sensor_time_idx;
+ALTER SEQUENCE channel_id_channel_seq OWNED BY NONE;
+CREATE TABLE channel (
+  id_channel BIGINT NOT NULL DEFAULT nextval('channel_id_channel_seq'), 
+  time TIMESTAMP NOT NULL, 
+  value FLOAT NOT NULL, 
+  id_sensor INTEGER NOT NULL, 
+  received_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  PRIMARY KEY (id_channel, time), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+) PARTITION BY RANGE (time);
+CREATE INDEX channel_id_sensor_time_idx ON channel (id_sensor, time);
+-- Catch reading outside every partition, such as an imported reading older than the first partition
+CREATE TABLE channel_default PARTITION OF channel DEFAULT;
+-- Monthly partition from the oldest reading until 3 month ahead, the server create the next one
+DO $$
+DECLARE
+  partition_start TIMESTAMP;
+  partition_end TIMESTAMP;
+BEGIN
+  SELECT date_trunc('month', COALESCE(MIN(COALESCE(time, received_at)), NOW() AT TIME ZONE 'utc')) INTO partition_start FROM channel_legacy;
+  WHILE partition_start < date_trunc('month', NOW() AT TIME ZONE 'utc') + INTERVAL '3 month' LOOP
+    partition_end := partition_start + INTERVAL '1 month';
+    EXECUTE format('CREATE TABLE %I PARTITION OF channel FOR VALUES FROM (%L) TO (%L)', 'channel_p' || to_char(partition_start, 'YYYYMMDD'), partition_start, partition_end);
+    partition_start := partition_end;
+  END LOOP;
+END $$;
+INSERT INTO channel (id_channel, time, value, id_sensor, received_at) 
+SELECT id_channel, COALESCE(time, received_at), value, id_sensor, received_at FROM channel_legacy;
+DROP TABLE channel_legacy;
+ALTER SEQUENCE channel_id_channel_seq OWNED BY channel.id_channel;
+ALTER TABLE user_person ADD COLUMN IF NOT EXISTS retention_days INTEGER;
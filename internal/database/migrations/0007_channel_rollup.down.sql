@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "channel_rollup_pending" CASCADE;
+DROP TABLE IF EXISTS "channel_rollup_day" CASCADE;
+DROP TABLE IF EXISTS "channel_rollup_hour" CASCADE;
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS channel_rollup_hour (
+  id_sensor INTEGER NOT NULL, 
+  bucket TIMESTAMP NOT NULL, 
+  count BIGINT NOT NULL, 
+  min FLOAT NOT NULL, 
+  max FLOAT NOT NULL, 
+  sum FLOAT NOT NULL, 
+  PRIMARY KEY (id_sensor, bucket), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS channel_rollup_day (
+  id_sensor INTEGER NOT NULL, 
+  bucket TIMESTAMP NOT NULL, 
+  count BIGINT NOT NULL, 
+  min FLOAT NOT NULL, 
+  max FLOAT NOT NULL, 
+  sum FLOAT NOT NULL, 
+  PRIMARY KEY (id_sensor, bucket), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+-- Hour bucket that received new channel and must be recomputed by the rollup worker
+CREATE TABLE IF NOT EXISTS channel_rollup_pending (
+  id_sensor INTEGER NOT NULL, 
+  bucket TIMESTAMP NOT NULL, 
+  PRIMARY KEY (id_sensor, bucket), 
+  FOREIGN KEY (id_sensor) REFERENCES sensor (id_sensor) ON UPDATE CASCADE ON DELETE CASCADE
+);
+-- Every existing channel is summarized on the first run of the worker
+INSERT INTO channel_rollup_pending (id_sensor, bucket) 
+SELECT DISTINCT id_sensor, date_trunc('hour', time) FROM channel 
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+ALTER TABLE node DROP COLUMN IF EXISTS id_organization;
+DROP TABLE IF EXISTS "organization_member" CASCADE;
+DROP TABLE IF EXISTS "organization" CASCADE;
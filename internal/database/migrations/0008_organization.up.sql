@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS organization (
+  id_organization SERIAL PRIMARY KEY, 
+  name VARCHAR (255) NOT NULL, 
+  id_personal_user INTEGER UNIQUE, 
+  created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  FOREIGN KEY (id_personal_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS organization_member (
+  id_organization INTEGER NOT NULL, 
+  id_user INTEGER NOT NULL, 
+  role VARCHAR (255) NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')), 
+  created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'utc'), 
+  PRIMARY KEY (id_organization, id_user), 
+  FOREIGN KEY (id_organization) REFERENCES organization (id_organization) ON UPDATE CASCADE ON DELETE CASCADE, 
+  FOREIGN KEY (id_user) REFERENCES user_person (id_user) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS organization_member_id_user_idx ON organization_member (id_user);
+-- Every existing user get a personal organization that own the node they already have
+INSERT INTO organization (name, id_personal_user) 
+SELECT username, id_user FROM user_person ORDER BY id_user 
+ON CONFLICT DO NOTHING;
+INSERT INTO organization_member (id_organization, id_user, role) 
+SELECT id_organization, id_personal_user, 'owner' FROM organization WHERE id_personal_user IS NOT NULL 
+ON CONFLICT DO NOTHING;
+ALTER TABLE node ADD COLUMN IF NOT EXISTS id_organization INTEGER REFERENCES organization (id_organization) ON UPDATE CASCADE ON DELETE CASCADE;
+UPDATE node SET id_organization=organization.id_organization 
+FROM organization WHERE organization.id_personal_user=node.id_user AND node.id_organization IS NULL;
+ALTER TABLE node ALTER COLUMN id_organization SET NOT NULL;
+CREATE INDEX IF NOT EXISTS node_id_organization_idx ON node (id_organization);
@@ -0,0 +1,163 @@
+package dependencies
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// channelNotifyName is the postgres notification channel used to share new reading between server
+const channelNotifyName = "channel_created"
+
+// channelNotifyChunk keep every notification payload under the 8000 bytes limit of postgres
+const channelNotifyChunk = 50
+
+// channelSubscriberBuffer is the number of pending batch a subscriber can have before new batch are dropped
+const channelSubscriberBuffer = 64
+
+// ChannelSubscription receive the new channels of a single sensor
+type ChannelSubscription struct {
+	C        chan []entities.Channel
+	idSensor int
+}
+
+// ChannelHub is an in process pub/sub of new channels used for live streaming. When postgres notify
+// is enabled the channels are published through LISTEN/NOTIFY so every server instance receive them.
+type ChannelHub struct {
+	db          *pgxpool.Pool
+	mutex       sync.RWMutex
+	subscribers map[int]map[*ChannelSubscription]struct{}
+}
+
+func NewChannelHub(db *pgxpool.Pool) *ChannelHub {
+	return &ChannelHub{
+		db:          db,
+		subscribers: map[int]map[*ChannelSubscription]struct{}{},
+	}
+}
+
+func (h *ChannelHub) Subscribe(idSensor int) *ChannelSubscription {
+	subscription := &ChannelSubscription{
+		C:        make(chan []entities.Channel, channelSubscriberBuffer),
+		idSensor: idSensor,
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.subscribers[idSensor] == nil {
+		h.subscribers[idSensor] = map[*ChannelSubscription]struct{}{}
+	}
+	h.subscribers[idSensor][subscription] = struct{}{}
+	return subscription
+}
+
+func (h *ChannelHub) Unsubscribe(subscription *ChannelSubscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.subscribers[subscription.idSensor], subscription)
+	if len(h.subscribers[subscription.idSensor]) == 0 {
+		delete(h.subscribers, subscription.idSensor)
+	}
+}
+
+// publishLocal send the channels to the subscribers of this server, a slow subscriber miss
+// the batch instead of blocking the ingest
+func (h *ChannelHub) publishLocal(channels []entities.Channel) {
+	channelBySensor := map[int][]entities.Channel{}
+	for _, channel := range channels {
+		channelBySensor[channel.IdSensor] = append(channelBySensor[channel.IdSensor], channel)
+	}
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for idSensor, sensorChannels := range channelBySensor {
+		for subscription := range h.subscribers[idSensor] {
+			select {
+			case subscription.C <- sensorChannels:
+			default:
+			}
+		}
+	}
+}
+
+// OnChannelCreated publish the new channels to the stream subscribers
+func (h *ChannelHub) OnChannelCreated(ctx context.Context, channels []entities.Channel) {
+	config := configs.GetConfig()
+	if !config.Stream.PostgresNotify {
+		h.publishLocal(channels)
+		return
+	}
+
+	for start := 0; start < len(channels); start += channelNotifyChunk {
+		end := start + channelNotifyChunk
+		if end > len(channels) {
+			end = len(channels)
+		}
+
+		payload, err := json.Marshal(channels[start:end])
+		if err != nil {
+			log.Printf("[STREAM] Failed to encode channel, %v", err)
+			return
+		}
+
+		_, err = h.db.Exec(ctx, "SELECT pg_notify($1, $2)", channelNotifyName, string(payload))
+		if err != nil {
+			log.Printf("[STREAM] Failed to notify channel, %v", err)
+			return
+		}
+	}
+}
+
+// Listen receive the channels notified by every server and publish them locally until the context is done,
+// it is only needed when postgres notify is enabled
+func (h *ChannelHub) Listen(ctx context.Context) {
+	for {
+		err := h.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.Printf("[STREAM] Postgres listener stopped, reconnecting, %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (h *ChannelHub) listen(ctx context.Context) error {
+	poolConn, err := h.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The connection keep listening so it is taken out of the pool instead of being reused
+	conn := poolConn.Hijack()
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "LISTEN "+channelNotifyName)
+	if err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		channels := []entities.Channel{}
+		err = json.Unmarshal([]byte(notification.Payload), &channels)
+		if err != nil {
+			log.Printf("[STREAM] Failed to decode notified channel, %v", err)
+			continue
+		}
+		h.publishLocal(channels)
+	}
+}
@@ -0,0 +1,201 @@
+package dependencies
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+)
+
+var (
+	ErrChannelWriterFull   = errors.New("channel write queue is full")
+	ErrChannelWriterClosed = errors.New("channel writer is closed")
+)
+
+// ChannelWriterFlush store a batch of channels, it is called by a single goroutine
+type ChannelWriterFlush func(ctx context.Context, channels []entities.Channel) error
+
+// ChannelWriterPermanentError report whether a flush error come from the channels themselves, like a reference to
+// a deleted sensor, so the batch is not retried but written one channel at a time to drop only the invalid ones
+type ChannelWriterPermanentError func(err error) bool
+
+type ChannelWriterStats struct {
+	QueueDepth    int    `json:"queue_depth"`
+	QueueCapacity int    `json:"queue_capacity"`
+	Accepted      uint64 `json:"accepted"`
+	Rejected      uint64 `json:"rejected"`
+	Written       uint64 `json:"written"`
+	Failed        uint64 `json:"failed"`
+	Batches       uint64 `json:"batches"`
+	// Duration of the last flush in millisecond
+	LastFlushMs int64 `json:"last_flush_ms"`
+}
+
+// ChannelWriter is an in process write buffer. Channels are queued and written in batch when
+// batchSize channels are waiting or flushInterval has passed since the first one was queued.
+// A batch that fail with a transient error is retried up to maxRetry times, waiting retryBaseDelay doubled
+// on every attempt.
+type ChannelWriter struct {
+	flush          ChannelWriterFlush
+	isPermanent    ChannelWriterPermanentError
+	queue          chan entities.Channel
+	batchSize      int
+	flushInterval  time.Duration
+	maxRetry       int
+	retryBaseDelay time.Duration
+	// mutex make the capacity check and the send of a whole request atomic, and guard closed
+	mutex  sync.Mutex
+	closed bool
+	done   chan struct{}
+
+	accepted    atomic.Uint64
+	rejected    atomic.Uint64
+	written     atomic.Uint64
+	failed      atomic.Uint64
+	batches     atomic.Uint64
+	lastFlushMs atomic.Int64
+}
+
+func NewChannelWriter(flush ChannelWriterFlush, isPermanent ChannelWriterPermanentError, queueSize int, batchSize int, flushInterval time.Duration, maxRetry int, retryBaseDelay time.Duration) *ChannelWriter {
+	return &ChannelWriter{
+		flush:          flush,
+		isPermanent:    isPermanent,
+		queue:          make(chan entities.Channel, queueSize),
+		batchSize:      batchSize,
+		flushInterval:  flushInterval,
+		maxRetry:       maxRetry,
+		retryBaseDelay: retryBaseDelay,
+		done:           make(chan struct{}),
+	}
+}
+
+// Enqueue queue every channel or none of them, ErrChannelWriterFull is returned when there is not enough room
+func (w *ChannelWriter) Enqueue(channels []entities.Channel) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return ErrChannelWriterClosed
+	}
+	if len(w.queue)+len(channels) > cap(w.queue) {
+		w.rejected.Add(uint64(len(channels)))
+		return ErrChannelWriterFull
+	}
+
+	// The writer only remove from the queue, so every send succeed without blocking
+	for _, channel := range channels {
+		w.queue <- channel
+	}
+	w.accepted.Add(uint64(len(channels)))
+	return nil
+}
+
+// Start write the queued channels in the background until Close is called
+func (w *ChannelWriter) Start() {
+	go w.run()
+}
+
+func (w *ChannelWriter) run() {
+	defer close(w.done)
+	batch := make([]entities.Channel, 0, w.batchSize)
+	timer := time.NewTimer(w.flushInterval)
+	timer.Stop()
+
+	write := func() {
+		timer.Stop()
+		if len(batch) == 0 {
+			return
+		}
+
+		start := time.Now()
+		err := w.flushWithRetry(batch)
+		if err != nil && w.isPermanent(err) && len(batch) > 1 {
+			// One invalid channel fail the whole batch, so write them one by one and drop only the invalid ones
+			log.Printf("[WRITER] Failed to write %d channel, writing them one by one, %v", len(batch), err)
+			for i := range batch {
+				w.count(batch[i:i+1], w.flushWithRetry(batch[i:i+1]))
+			}
+		} else {
+			w.count(batch, err)
+		}
+		w.lastFlushMs.Store(time.Since(start).Milliseconds())
+		w.batches.Add(1)
+		batch = make([]entities.Channel, 0, w.batchSize)
+	}
+
+	for {
+		select {
+		case channel, ok := <-w.queue:
+			if !ok {
+				write()
+				return
+			}
+
+			batch = append(batch, channel)
+			if len(batch) == 1 {
+				timer.Reset(w.flushInterval)
+			}
+			if len(batch) >= w.batchSize {
+				write()
+			}
+		case <-timer.C:
+			write()
+		}
+	}
+}
+
+// flushWithRetry flush the channels, retrying with exponential backoff as long as the error is not permanent
+func (w *ChannelWriter) flushWithRetry(channels []entities.Channel) error {
+	for attempt := 0; ; attempt++ {
+		err := w.flush(context.Background(), channels)
+		if err == nil || w.isPermanent(err) || attempt >= w.maxRetry {
+			return err
+		}
+
+		delay := w.retryBaseDelay * time.Duration(1<<attempt)
+		log.Printf("[WRITER] Failed to write %d channel, retrying in %s, %v", len(channels), delay, err)
+		time.Sleep(delay)
+	}
+}
+
+func (w *ChannelWriter) count(channels []entities.Channel, err error) {
+	if err != nil {
+		w.failed.Add(uint64(len(channels)))
+		log.Printf("[WRITER] Dropped %d channel, %v", len(channels), err)
+		return
+	}
+	w.written.Add(uint64(len(channels)))
+}
+
+// Close stop accepting channel and wait until every queued channel is written or the context is done
+func (w *ChannelWriter) Close(ctx context.Context) error {
+	w.mutex.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mutex.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *ChannelWriter) Stats() ChannelWriterStats {
+	return ChannelWriterStats{
+		QueueDepth:    len(w.queue),
+		QueueCapacity: cap(w.queue),
+		Accepted:      w.accepted.Load(),
+		Rejected:      w.rejected.Load(),
+		Written:       w.written.Load(),
+		Failed:        w.failed.Load(),
+		Batches:       w.batches.Load(),
+		LastFlushMs:   w.lastFlushMs.Load(),
+	}
+}
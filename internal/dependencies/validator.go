@@ -3,6 +3,7 @@ package dependencies
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"reflect"
 	"strconv"
 	"strings"
@@ -17,9 +18,20 @@ type Validator struct {
 }
 
 func NewValidator(validate *validator.Validate) Validator {
+	// http_url is not built in the validator version used here
+	validate.RegisterValidation("http_url", validateHttpUrl)
 	return Validator{Validate: validate}
 }
 
+// validateHttpUrl check that the field is an absolute http or https url
+func validateHttpUrl(fl validator.FieldLevel) bool {
+	parsed, err := url.Parse(fl.Field().String())
+	if err != nil {
+		return false
+	}
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
 func (v *Validator) formaFieldErrorMessage(fe validator.FieldError) string {
 	var sb strings.Builder
 
@@ -52,6 +64,12 @@ func (v *Validator) validateStruct(payload interface{}) error {
 	}
 }
 
+// ValidateStruct validate an already parsed payload, used when a body contains
+// several items that need to be checked one by one
+func (v *Validator) ValidateStruct(payload interface{}) error {
+	return v.validateStruct(payload)
+}
+
 func (v *Validator) validateParse(c *fiber.Ctx, payload interface{}) error {
 	err := v.validateStruct(payload)
 	if err != nil {
@@ -123,3 +141,14 @@ func (v *Validator) GetAuthentication(c *fiber.Ctx) (entities.UserRead, error) {
 
 	return user, nil
 }
+
+// GetDevice get the node authenticated with an API key, ok is false when the request is sent by a user
+func (v *Validator) GetDevice(c *fiber.Ctx) (device entities.Device, ok bool) {
+	potentialDevice := c.Locals("currentDevice")
+	if potentialDevice == nil {
+		return device, false
+	}
+
+	device, ok = potentialDevice.(entities.Device)
+	return device, ok
+}
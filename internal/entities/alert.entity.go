@@ -0,0 +1,167 @@
+package entities
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	AlertConditionAbove   = "above"
+	AlertConditionBelow   = "below"
+	AlertConditionOutside = "outside"
+)
+
+// State of an alert rule, a notification is only sent when the state change
+const (
+	AlertStateOk     = "ok"
+	AlertStateFiring = "firing"
+)
+
+// Kind of an alert event
+const (
+	AlertEventFiring   = "firing"
+	AlertEventResolved = "resolved"
+)
+
+type AlertRule struct {
+	IdAlertRule int `json:"id_alert_rule"`
+	AlertRuleCreate
+	Enabled bool   `json:"enabled"`
+	State   string `json:"state"`
+	// Number of consecutive reading that breach the threshold and the time of the first one
+	BreachCount int        `json:"breach_count"`
+	BreachSince *time.Time `json:"breach_since"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type AlertRuleCreate struct {
+	IdSensor       int      `json:"id_sensor" validate:"required"`
+	Name           string   `json:"name" validate:"required"`
+	Condition      string   `json:"condition" validate:"required,oneof=above below outside"`
+	LowerThreshold *float64 `json:"lower_threshold"`
+	UpperThreshold *float64 `json:"upper_threshold"`
+	// The alert fire after this many consecutive reading breach the threshold
+	Consecutive int `json:"consecutive" validate:"omitempty,min=1"`
+	// The alert fire after the threshold has been breached for this many seconds
+	DurationSecond int `json:"duration_second" validate:"omitempty,min=0"`
+}
+
+func (a *AlertRuleCreate) SetDefault() {
+	if a.Consecutive == 0 {
+		a.Consecutive = 1
+	}
+}
+
+// CheckThreshold check that the threshold needed by the condition is set
+func (a *AlertRuleCreate) CheckThreshold() error {
+	switch a.Condition {
+	case AlertConditionAbove:
+		if a.UpperThreshold == nil {
+			return errors.New("upper_threshold is required for condition above")
+		}
+	case AlertConditionBelow:
+		if a.LowerThreshold == nil {
+			return errors.New("lower_threshold is required for condition below")
+		}
+	case AlertConditionOutside:
+		if a.LowerThreshold == nil || a.UpperThreshold == nil {
+			return errors.New("lower_threshold and upper_threshold are required for condition outside")
+		}
+		if *a.LowerThreshold >= *a.UpperThreshold {
+			return errors.New("lower_threshold must be less than upper_threshold")
+		}
+	}
+	return nil
+}
+
+// IsBreached check if the value breach the threshold of the rule
+func (a *AlertRuleCreate) IsBreached(value float64) bool {
+	switch a.Condition {
+	case AlertConditionAbove:
+		return value > *a.UpperThreshold
+	case AlertConditionBelow:
+		return value < *a.LowerThreshold
+	case AlertConditionOutside:
+		return value < *a.LowerThreshold || value > *a.UpperThreshold
+	}
+	return false
+}
+
+type AlertRuleUpdate struct {
+	Name           string   `json:"name"`
+	Condition      string   `json:"condition" validate:"omitempty,oneof=above below outside"`
+	LowerThreshold *float64 `json:"lower_threshold"`
+	UpperThreshold *float64 `json:"upper_threshold"`
+	Consecutive    int      `json:"consecutive" validate:"omitempty,min=1"`
+	DurationSecond *int     `json:"duration_second" validate:"omitempty,min=0"`
+	Enabled        *bool    `json:"enabled"`
+}
+
+func (au *AlertRuleUpdate) ChangeSettedFieldOnly(alertRule *AlertRule) {
+	if au.Name == "" {
+		au.Name = alertRule.Name
+	}
+
+	if au.Condition == "" {
+		au.Condition = alertRule.Condition
+	}
+
+	if au.LowerThreshold == nil {
+		au.LowerThreshold = alertRule.LowerThreshold
+	}
+
+	if au.UpperThreshold == nil {
+		au.UpperThreshold = alertRule.UpperThreshold
+	}
+
+	if au.Consecutive == 0 {
+		au.Consecutive = alertRule.Consecutive
+	}
+
+	if au.DurationSecond == nil {
+		au.DurationSecond = &alertRule.DurationSecond
+	}
+
+	if au.Enabled == nil {
+		au.Enabled = &alertRule.Enabled
+	}
+}
+
+func (au *AlertRuleUpdate) ToCreate(idSensor int) AlertRuleCreate {
+	return AlertRuleCreate{
+		IdSensor:       idSensor,
+		Name:           au.Name,
+		Condition:      au.Condition,
+		LowerThreshold: au.LowerThreshold,
+		UpperThreshold: au.UpperThreshold,
+		Consecutive:    au.Consecutive,
+		DurationSecond: *au.DurationSecond,
+	}
+}
+
+type AlertEvent struct {
+	IdAlertEvent int       `json:"id_alert_event"`
+	IdAlertRule  int       `json:"id_alert_rule"`
+	Kind         string    `json:"kind"`
+	Value        float64   `json:"value"`
+	Time         time.Time `json:"time"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type AlertRuleWithEvent struct {
+	AlertRule
+	Events []AlertEvent `json:"events"`
+}
+
+// AlertNotification is an alert state change that need to be sent to every member of the organization
+// that own the sensor, the user is the member who receive it
+type AlertNotification struct {
+	AlertRule
+	Event          AlertEvent
+	SensorName     string
+	Unit           string
+	IdOrganization int
+	IdUser         int
+	Username       string
+	Email          string
+}
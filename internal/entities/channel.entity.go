@@ -1,13 +1,222 @@
 package entities
 
-import "time"
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
 
 type Channel struct {
-	Time time.Time `json:"time" validate:"required"`
+	IdChannel  int64     `json:"id_channel"`
+	Time       time.Time `json:"time" validate:"required"`
+	ReceivedAt time.Time `json:"received_at"`
 	ChannelCreate
 }
 
 type ChannelCreate struct {
 	Value    float64 `json:"value" validate:"required"`
 	IdSensor int     `json:"id_sensor" validate:"required"`
+	// Time is the optional time the reading was taken on the device.
+	// When it is not set the time the server receive the reading is used.
+	Time *ChannelTime `json:"time,omitempty"`
+}
+
+// ChannelTime is a time sent by a device, it can be written either
+// as an RFC 3339 string or as epoch milliseconds
+type ChannelTime struct {
+	time.Time
+}
+
+// minEpochMillis is 1973-03-03, a smaller integer is most likely epoch seconds sent by mistake
+const minEpochMillis = 100_000_000_000
+
+func ParseChannelTime(value string) (ChannelTime, error) {
+	epochMillis, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		if epochMillis < minEpochMillis {
+			return ChannelTime{}, fmt.Errorf("time %d is before 1973 as epoch milliseconds, epoch seconds must be multiplied by 1000", epochMillis)
+		}
+		return ChannelTime{time.UnixMilli(epochMillis).UTC()}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return ChannelTime{}, fmt.Errorf("time %q must be an RFC 3339 string or epoch milliseconds", value)
+	}
+	return ChannelTime{t.UTC()}, nil
+}
+
+func (ct *ChannelTime) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	var value string
+	if len(data) > 0 && data[0] == '"' {
+		err := json.Unmarshal(data, &value)
+		if err != nil {
+			return err
+		}
+	} else {
+		value = string(data)
+	}
+
+	parsed, err := ParseChannelTime(value)
+	if err != nil {
+		return err
+	}
+	*ct = parsed
+	return nil
+}
+
+func (ct *ChannelTime) UnmarshalText(data []byte) error {
+	parsed, err := ParseChannelTime(string(data))
+	if err != nil {
+		return err
+	}
+	*ct = parsed
+	return nil
+}
+
+const ChannelQueryDefaultLimit = 1000
+
+// ChannelQuery filter and paginate the channel of a sensor. Channel are
+// returned newest first with ChannelQueryDefaultLimit item per page by default.
+type ChannelQuery struct {
+	From   *ChannelTime `query:"from"`
+	To     *ChannelTime `query:"to"`
+	Limit  int          `query:"limit" validate:"omitempty,min=1,max=10000"`
+	Order  string       `query:"order" validate:"omitempty,oneof=asc desc"`
+	Cursor string       `query:"cursor"`
+}
+
+func (cq *ChannelQuery) SetDefault() {
+	if cq.Limit == 0 {
+		cq.Limit = ChannelQueryDefaultLimit
+	}
+
+	if cq.Order == "" {
+		cq.Order = "desc"
+	}
+}
+
+type ChannelPage struct {
+	Channel    []Channel `json:"channel"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+const ChannelAggregateMaxBucket = 10000
+
+// ChannelAggregateQuery group the channel of a sensor into time bucket
+// and compute every function in Functions for each bucket
+type ChannelAggregateQuery struct {
+	Bucket     string       `query:"bucket" validate:"required"`
+	Functions  []string     `query:"function" validate:"required,min=1,dive,oneof=min max avg sum count first last stddev percentile"`
+	Percentile float64      `query:"percentile" validate:"omitempty,gt=0,lt=1"`
+	From       *ChannelTime `query:"from"`
+	To         *ChannelTime `query:"to"`
+}
+
+func (caq *ChannelAggregateQuery) SetDefault() {
+	if caq.Percentile == 0 {
+		caq.Percentile = 0.5
+	}
+}
+
+type ChannelAggregate struct {
+	Time   time.Time           `json:"time"`
+	Values map[string]*float64 `json:"values"`
+}
+
+// ChannelSpan summarize the channel of a sensor inside a time range
+type ChannelSpan struct {
+	Count int64      `json:"count"`
+	From  *time.Time `json:"from"`
+	To    *time.Time `json:"to"`
+}
+
+type ChannelBatchCreate struct {
+	Channels []ChannelCreate `json:"channels" validate:"required,min=1,max=1000"`
+}
+
+const (
+	ChannelBatchAccepted = "accepted"
+	ChannelBatchRejected = "rejected"
+)
+
+type ChannelBatchResult struct {
+	Index    int    `json:"index"`
+	IdSensor int    `json:"id_sensor"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type ChannelBatchReport struct {
+	Accepted int                  `json:"accepted"`
+	Rejected int                  `json:"rejected"`
+	Results  []ChannelBatchResult `json:"results"`
+}
+
+type ChannelImportQuery struct {
+	// Only validate the file without inserting anything
+	DryRun bool `query:"dry_run"`
+}
+
+type ChannelImportError struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// ChannelImportReport is the result of a csv import, nothing is inserted when any row is rejected
+type ChannelImportReport struct {
+	DryRun   bool  `json:"dry_run"`
+	Total    int   `json:"total"`
+	Accepted int   `json:"accepted"`
+	Rejected int   `json:"rejected"`
+	Inserted int64 `json:"inserted"`
+	// Only the first ChannelImportMaxError error are reported
+	Errors []ChannelImportError `json:"errors"`
+}
+
+const ChannelImportMaxError = 1000
+
+// ChannelExportQuery select the channel to export, format is csv by default
+type ChannelExportQuery struct {
+	Format string       `query:"format" validate:"omitempty,oneof=csv jsonl"`
+	From   *ChannelTime `query:"from"`
+	To     *ChannelTime `query:"to"`
+}
+
+func (ceq *ChannelExportQuery) SetDefault() {
+	if ceq.Format == "" {
+		ceq.Format = "csv"
+	}
+}
+
+// ChannelExport is one exported channel together with its sensor so several sensors can be exported in one file
+type ChannelExport struct {
+	IdChannel  int64     `json:"id_channel"`
+	Time       time.Time `json:"time"`
+	ReceivedAt time.Time `json:"received_at"`
+	IdSensor   int       `json:"id_sensor"`
+	SensorName string    `json:"sensor_name"`
+	Unit       string    `json:"unit"`
+	Value      float64   `json:"value"`
+}
+
+// ChannelExportHeader is the csv header matching ChannelExport.CsvRecord
+var ChannelExportHeader = []string{"id_channel", "time", "received_at", "id_sensor", "sensor_name", "unit", "value"}
+
+func (ce *ChannelExport) CsvRecord() []string {
+	return []string{
+		strconv.FormatInt(ce.IdChannel, 10),
+		ce.Time.Format(time.RFC3339Nano),
+		ce.ReceivedAt.Format(time.RFC3339Nano),
+		strconv.Itoa(ce.IdSensor),
+		ce.SensorName,
+		ce.Unit,
+		strconv.FormatFloat(ce.Value, 'f', -1, 64),
+	}
 }
@@ -0,0 +1,95 @@
+package entities
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Firmware struct {
+	IdFirmware  int    `json:"id_firmware"`
+	IdHardware  int    `json:"id_hardware"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	FileName    string `json:"file_name"`
+	// Location of the binary on the server disk
+	FilePath string `json:"-"`
+	Size     int64  `json:"size"`
+	// Hex encoded sha256 of the binary
+	Checksum          string    `json:"checksum"`
+	RolloutPercentage int       `json:"rollout_percentage"`
+	RolloutNodes      []int     `json:"rollout_nodes"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// IsRolledOutTo check if the node should get this firmware, a node in rollout_nodes always get it,
+// other node get it when its bucket is within the rollout percentage. The bucket depend on the firmware
+// so a different set of node is the first to get every new release.
+func (f *Firmware) IsRolledOutTo(idNode int) bool {
+	for _, rolloutNode := range f.RolloutNodes {
+		if rolloutNode == idNode {
+			return true
+		}
+	}
+
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d:%d", f.IdFirmware, idNode)))
+	bucket := binary.BigEndian.Uint32(hash[:4]) % 100
+	return int(bucket) < f.RolloutPercentage
+}
+
+// RolloutNodesText is used to show the rollout node on the firmware page
+func (f Firmware) RolloutNodesText() string {
+	ids := make([]string, 0, len(f.RolloutNodes))
+	for _, id := range f.RolloutNodes {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	return strings.Join(ids, ",")
+}
+
+// FirmwareCreate is sent as multipart form together with the binary on the file field
+type FirmwareCreate struct {
+	IdHardware  int    `json:"id_hardware" form:"id_hardware" validate:"required"`
+	Version     string `json:"version" form:"version" validate:"required,max=64,semver"`
+	Description string `json:"description" form:"description"`
+	// Default to 100 when empty
+	RolloutPercentage *int `json:"rollout_percentage" form:"rollout_percentage" validate:"omitempty,min=0,max=100"`
+	// Comma separated node id, e.g. 1,2,3
+	RolloutNodes string `json:"rollout_nodes" form:"rollout_nodes"`
+}
+
+type FirmwareUpdate struct {
+	Description       string `json:"description"`
+	RolloutPercentage *int   `json:"rollout_percentage" validate:"omitempty,min=0,max=100"`
+	// Empty list remove every node, null keep the current list
+	RolloutNodes []int `json:"rollout_nodes"`
+}
+
+func (fu *FirmwareUpdate) ChangeSettedFieldOnly(firmware *Firmware) {
+	if fu.Description == "" {
+		fu.Description = firmware.Description
+	}
+
+	if fu.RolloutPercentage == nil {
+		fu.RolloutPercentage = &firmware.RolloutPercentage
+	}
+
+	if fu.RolloutNodes == nil {
+		fu.RolloutNodes = firmware.RolloutNodes
+	}
+}
+
+type FirmwareCheckQuery struct {
+	// Version currently running on the node
+	Version string `query:"version" validate:"required,semver"`
+}
+
+type FirmwareCheck struct {
+	UpdateAvailable bool      `json:"update_available"`
+	Firmware        *Firmware `json:"firmware"`
+	// Signed url to download the firmware without any authentication header, valid until download_expires_at
+	DownloadUrl       string     `json:"download_url,omitempty"`
+	DownloadExpiresAt *time.Time `json:"download_expires_at,omitempty"`
+}
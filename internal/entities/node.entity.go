@@ -1,20 +1,70 @@
 package entities
 
+import "time"
+
+// Status of a node based on when it was last heard from
+const (
+	NodeStatusOnline  = "online"
+	NodeStatusStale   = "stale"
+	NodeStatusOffline = "offline"
+)
+
 type Node struct {
 	IdNode int `json:"id_node" validate:"required"`
 	NodeCreate
-	IdUser int `json:"id_user" validate:"required"`
+	// User who created the node, access is given by the membership of the node organization
+	IdUser   int        `json:"id_user" validate:"required"`
+	LastSeen *time.Time `json:"last_seen"`
+	Status   string     `json:"status"`
+}
+
+// SetStatus set the status from the last seen time, the node is stale after half of the offline timeout
+// without any message and offline after the whole timeout
+func (n *Node) SetStatus(now time.Time, defaultOfflineTimeout time.Duration) {
+	n.Status = NodeStatus(n.LastSeen, n.GetOfflineTimeout(defaultOfflineTimeout), now)
+}
+
+func (n *Node) GetOfflineTimeout(defaultOfflineTimeout time.Duration) time.Duration {
+	if n.OfflineTimeout == nil {
+		return defaultOfflineTimeout
+	}
+
+	return time.Duration(*n.OfflineTimeout) * time.Second
+}
+
+func NodeStatus(lastSeen *time.Time, offlineTimeout time.Duration, now time.Time) string {
+	if lastSeen == nil {
+		return NodeStatusOffline
+	}
+
+	age := now.Sub(*lastSeen)
+	switch {
+	case age > offlineTimeout:
+		return NodeStatusOffline
+	case age > offlineTimeout/2:
+		return NodeStatusStale
+	default:
+		return NodeStatusOnline
+	}
 }
 
 type NodeCreate struct {
 	Name       string `json:"name" validate:"required"`
 	Location   string `json:"location" validate:"required"`
 	IdHardware int    `json:"id_hardware" validate:"required"`
+	// Organization that own the node, empty use the personal organization of the user
+	IdOrganization int `json:"id_organization" validate:"omitempty,min=1"`
+	// Second without any message before the node is offline, empty use the server default
+	OfflineTimeout *int `json:"offline_timeout" validate:"omitempty,min=1"`
+	// Email the owner when the node goes offline and when it comes back
+	NotifyOffline bool `json:"notify_offline"`
 }
 
 type NodeUpdate struct {
-	Name     string `json:"name"`
-	Location string `json:"location"`
+	Name           string `json:"name"`
+	Location       string `json:"location"`
+	OfflineTimeout *int   `json:"offline_timeout" validate:"omitempty,min=1"`
+	NotifyOffline  *bool  `json:"notify_offline"`
 }
 
 func (hu *NodeUpdate) ChangeSettedFieldOnly(node *Node) {
@@ -25,6 +75,14 @@ func (hu *NodeUpdate) ChangeSettedFieldOnly(node *Node) {
 	if hu.Location == "" {
 		hu.Location = node.Location
 	}
+
+	if hu.OfflineTimeout == nil {
+		hu.OfflineTimeout = node.OfflineTimeout
+	}
+
+	if hu.NotifyOffline == nil {
+		hu.NotifyOffline = &node.NotifyOffline
+	}
 }
 
 type NodeWithHardwareAndSensors struct {
@@ -32,3 +90,11 @@ type NodeWithHardwareAndSensors struct {
 	Hardware Hardware `json:"hardware"`
 	Sensor   []Sensor `json:"sensor"`
 }
+
+// NodeStatusNotification is sent to the owner when a node with notify_offline goes offline or comes back online
+type NodeStatusNotification struct {
+	Node
+	// The member of the node organization who receive the notification
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
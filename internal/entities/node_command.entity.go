@@ -0,0 +1,50 @@
+package entities
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Status of a node command
+const (
+	NodeCommandPending   = "pending"
+	NodeCommandDelivered = "delivered"
+	NodeCommandAcked     = "acked"
+	NodeCommandFailed    = "failed"
+	NodeCommandExpired   = "expired"
+)
+
+type NodeCommand struct {
+	IdNodeCommand int             `json:"id_node_command"`
+	IdNode        int             `json:"id_node"`
+	Name          string          `json:"name"`
+	Args          json.RawMessage `json:"args"`
+	Status        string          `json:"status"`
+	Result        json.RawMessage `json:"result"`
+	CreatedAt     time.Time       `json:"created_at"`
+	ExpiresAt     time.Time       `json:"expires_at"`
+	DeliveredAt   *time.Time      `json:"delivered_at"`
+	AckedAt       *time.Time      `json:"acked_at"`
+}
+
+// ArgsText is used to show the args on the node detail page
+func (n NodeCommand) ArgsText() string {
+	return string(n.Args)
+}
+
+// ResultText is used to show the result on the node detail page
+func (n NodeCommand) ResultText() string {
+	return string(n.Result)
+}
+
+type NodeCommandCreate struct {
+	Name string          `json:"name" validate:"required,max=255"`
+	Args json.RawMessage `json:"args"`
+	// The command expire when the node doesn't acknowledge it after this many seconds
+	ExpiresIn int `json:"expires_in" validate:"omitempty,min=1"`
+}
+
+type NodeCommandAck struct {
+	Status string          `json:"status" validate:"required,oneof=acked failed"`
+	Result json.RawMessage `json:"result"`
+}
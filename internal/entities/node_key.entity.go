@@ -0,0 +1,27 @@
+package entities
+
+import "time"
+
+// NodeKeyPrefix is the start of every node API key, used to tell it apart from a JWT
+const NodeKeyPrefix = "iotk_"
+
+type NodeKey struct {
+	IdNodeKey int        `json:"id_node_key"`
+	IdNode    int        `json:"id_node"`
+	Prefix    string     `json:"prefix"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// NodeKeyCreated contain the plain API key, it is only shown once when the key is created
+type NodeKeyCreated struct {
+	NodeKey
+	Key string `json:"key"`
+}
+
+// Device is a node that is authenticated using its API key
+type Device struct {
+	IdNodeKey int `json:"id_node_key"`
+	IdNode    int `json:"id_node"`
+	IdUser    int `json:"id_user"`
+}
@@ -0,0 +1,61 @@
+package entities
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// NodeShadow is the configuration document of a node, the user set the desired state
+// and the node report the state it has applied
+type NodeShadow struct {
+	IdNode   int             `json:"id_node"`
+	Desired  json.RawMessage `json:"desired"`
+	Reported json.RawMessage `json:"reported"`
+	// Increased every time the desired state change
+	Version int `json:"version"`
+	// The desired version the node has applied on its last report
+	ReportedVersion  int        `json:"reported_version"`
+	DesiredUpdatedAt *time.Time `json:"desired_updated_at"`
+	ReportedAt       *time.Time `json:"reported_at"`
+}
+
+// DesiredText is used to show the desired state on the node detail page
+func (n NodeShadow) DesiredText() string {
+	return string(n.Desired)
+}
+
+// ReportedText is used to show the reported state on the node detail page
+func (n NodeShadow) ReportedText() string {
+	return string(n.Reported)
+}
+
+type NodeShadowWithDelta struct {
+	NodeShadow
+	// Every desired key that the node has not reported yet
+	Delta  json.RawMessage `json:"delta"`
+	InSync bool            `json:"in_sync"`
+}
+
+// DeltaText is used to show the delta on the node detail page
+func (n NodeShadowWithDelta) DeltaText() string {
+	return string(n.Delta)
+}
+
+type NodeShadowDelta struct {
+	IdNode  int             `json:"id_node"`
+	Version int             `json:"version"`
+	Delta   json.RawMessage `json:"delta"`
+	InSync  bool            `json:"in_sync"`
+}
+
+// NodeShadowDesiredUpdate is merged to the desired state as a json merge patch, a null value remove the key
+type NodeShadowDesiredUpdate struct {
+	Desired json.RawMessage `json:"desired" validate:"required"`
+}
+
+// NodeShadowReport is merged to the reported state as a json merge patch, a null value remove the key
+type NodeShadowReport struct {
+	Reported json.RawMessage `json:"reported" validate:"required"`
+	// The desired version that the node has applied
+	Version int `json:"version" validate:"min=0"`
+}
@@ -0,0 +1,87 @@
+package entities
+
+import "time"
+
+// Role of a member in an organization, from the most to the least privileged
+const (
+	OrganizationRoleOwner  = "owner"
+	OrganizationRoleEditor = "editor"
+	OrganizationRoleViewer = "viewer"
+)
+
+// organizationRoleRank is higher for a more privileged role
+var organizationRoleRank = map[string]int{
+	OrganizationRoleViewer: 1,
+	OrganizationRoleEditor: 2,
+	OrganizationRoleOwner:  3,
+}
+
+// OrganizationRoleAtLeast check that the role is the minimum role or a more privileged one,
+// an empty role means the user is not a member
+func OrganizationRoleAtLeast(role string, minimumRole string) bool {
+	return role != "" && organizationRoleRank[role] >= organizationRoleRank[minimumRole]
+}
+
+// OrganizationRolesAtLeast return every role that is the minimum role or a more privileged one
+func OrganizationRolesAtLeast(minimumRole string) []string {
+	roles := []string{}
+	for _, role := range []string{OrganizationRoleOwner, OrganizationRoleEditor, OrganizationRoleViewer} {
+		if OrganizationRoleAtLeast(role, minimumRole) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+type Organization struct {
+	IdOrganization int    `json:"id_organization"`
+	Name           string `json:"name"`
+	// Every user has a personal organization that own the node created without an organization,
+	// it is deleted together with the user
+	IdPersonalUser *int      `json:"id_personal_user"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// OrganizationWithRole is an organization together with the role of the current user in it
+type OrganizationWithRole struct {
+	Organization
+	Role string `json:"role"`
+}
+
+type OrganizationCreate struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type OrganizationUpdate struct {
+	Name string `json:"name"`
+}
+
+func (ou *OrganizationUpdate) ChangeSettedFieldOnly(organization *Organization) {
+	if ou.Name == "" {
+		ou.Name = organization.Name
+	}
+}
+
+type OrganizationMember struct {
+	IdOrganization int       `json:"id_organization"`
+	IdUser         int       `json:"id_user"`
+	Username       string    `json:"username"`
+	Email          string    `json:"email"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type OrganizationMemberCreate struct {
+	Username string `json:"username" validate:"required"`
+	Role     string `json:"role" validate:"required,oneof=owner editor viewer"`
+}
+
+type OrganizationMemberUpdate struct {
+	Role string `json:"role" validate:"required,oneof=owner editor viewer"`
+}
+
+type OrganizationWithMemberAndNode struct {
+	OrganizationWithRole
+	Member []OrganizationMember `json:"member"`
+	Node   []Node               `json:"node"`
+}
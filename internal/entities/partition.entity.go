@@ -0,0 +1,87 @@
+package entities
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	PartitionIntervalDay   = "day"
+	PartitionIntervalWeek  = "week"
+	PartitionIntervalMonth = "month"
+)
+
+// ChannelPartition is a partition of the channel table, From and To are nil for the default partition
+type ChannelPartition struct {
+	Name      string     `json:"name"`
+	From      *time.Time `json:"from"`
+	To        *time.Time `json:"to"`
+	IsDefault bool       `json:"is_default"`
+	// Estimated row count, it is only updated by vacuum and analyze
+	Rows int64 `json:"rows"`
+	// Size of the table and its index in byte
+	Size int64 `json:"size"`
+}
+
+func (cp ChannelPartition) SizeText() string {
+	return FormatByteSize(cp.Size)
+}
+
+type ChannelPartitionReport struct {
+	Partitions []ChannelPartition `json:"partitions"`
+	TotalRows  int64              `json:"total_rows"`
+	TotalSize  int64              `json:"total_size"`
+}
+
+func (cpr ChannelPartitionReport) TotalSizeText() string {
+	return FormatByteSize(cpr.TotalSize)
+}
+
+type UserRetentionUpdate struct {
+	// Channel of the user older than this are deleted, null to only use the global retention
+	RetentionDays *int `json:"retention_days" validate:"omitempty,min=1"`
+}
+
+// FormatByteSize format a size in byte with a binary unit such as 1.5 MiB
+func FormatByteSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// TruncatePartitionTime return the start of the partition containing t, week start on monday
+// and an unknown interval is treated as a month
+func TruncatePartitionTime(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch interval {
+	case PartitionIntervalDay:
+		return day
+	case PartitionIntervalWeek:
+		weekday := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -weekday)
+	default:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// NextPartitionTime return the start of the partition after the one containing t
+func NextPartitionTime(t time.Time, interval string) time.Time {
+	start := TruncatePartitionTime(t, interval)
+	switch interval {
+	case PartitionIntervalDay:
+		return start.AddDate(0, 0, 1)
+	case PartitionIntervalWeek:
+		return start.AddDate(0, 0, 7)
+	default:
+		return start.AddDate(0, 1, 0)
+	}
+}
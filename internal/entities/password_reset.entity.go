@@ -0,0 +1,11 @@
+package entities
+
+import "time"
+
+type PasswordReset struct {
+	IdPasswordReset int        `json:"id_password_reset"`
+	IdUser          int        `json:"id_user"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UsedAt          *time.Time `json:"used_at"`
+}
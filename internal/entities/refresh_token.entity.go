@@ -0,0 +1,11 @@
+package entities
+
+import "time"
+
+type RefreshToken struct {
+	IdRefreshToken int        `json:"id_refresh_token"`
+	IdUser         int        `json:"id_user"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+}
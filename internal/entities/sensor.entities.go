@@ -29,5 +29,19 @@ func (su *SensorUpdate) ChangeSettedFieldOnly(sensor *Sensor) {
 
 type SensorWithChannel struct {
 	Sensor
-	Channel []Channel `json:"channel"`
+	Channel    []Channel `json:"channel"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+type SensorWithAggregate struct {
+	Sensor
+	Bucket    string             `json:"bucket"`
+	Aggregate []ChannelAggregate `json:"aggregate"`
+}
+
+type SensorOwner struct {
+	IdSensor       int `json:"id_sensor"`
+	IdNode         int `json:"id_node"`
+	IdUser         int `json:"id_user"`
+	IdOrganization int `json:"id_organization"`
 }
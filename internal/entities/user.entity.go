@@ -13,7 +13,8 @@ type User struct {
 type UserCreate struct {
 	Email    string `json:"email" validate:"required,email"`
 	Username string `json:"username" validate:"required"`
-	Password string `json:"password" validate:"required"`
+	// bcrypt only use the first 72 bytes of the password
+	Password string `json:"password" validate:"required,max=72"`
 }
 
 type UserRead struct {
@@ -35,11 +36,27 @@ type UserForgotPassword struct {
 	Email    string `json:"email" validate:"required,email"`
 }
 
+type UserResetPassword struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,max=72"`
+}
+
 type UserUpdatePassword struct {
 	OldPassword string `json:"old_password" validate:"required"`
-	NewPassword string `json:"new_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,max=72"`
 }
 
 type UserValidate struct {
 	Token string `query:"token" validate:"required"`
 }
+
+type UserToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type UserRefreshToken struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
@@ -0,0 +1,103 @@
+package entities
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	WebhookEventChannelCreated  = "channel.created"
+	WebhookEventSensorCreated   = "sensor.created"
+	WebhookEventSensorUpdated   = "sensor.updated"
+	WebhookEventSensorDeleted   = "sensor.deleted"
+	WebhookEventNodeCreated     = "node.created"
+	WebhookEventNodeUpdated     = "node.updated"
+	WebhookEventNodeDeleted     = "node.deleted"
+	WebhookEventHardwareCreated = "hardware.created"
+	WebhookEventHardwareUpdated = "hardware.updated"
+	WebhookEventHardwareDeleted = "hardware.deleted"
+	WebhookEventAlertFired      = "alert.fired"
+	WebhookEventAlertResolved   = "alert.resolved"
+)
+
+// Status of a webhook delivery
+const (
+	WebhookDeliveryPending = "pending"
+	WebhookDeliverySuccess = "success"
+	WebhookDeliveryFailed  = "failed"
+)
+
+type Webhook struct {
+	IdWebhook  int       `json:"id_webhook"`
+	IdUser     int       `json:"id_user"`
+	Url        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Enabled    bool      `json:"enabled"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type WebhookCreate struct {
+	Url        string   `json:"url" validate:"required,http_url"`
+	EventTypes []string `json:"event_types" validate:"required,min=1,dive,oneof=channel.created sensor.created sensor.updated sensor.deleted node.created node.updated node.deleted hardware.created hardware.updated hardware.deleted alert.fired alert.resolved"`
+	// Secret used to sign the payload, a random one is generated when it is empty
+	Secret string `json:"secret" validate:"omitempty,min=16"`
+}
+
+// WebhookCreated is only returned once after the webhook is created because it contains the secret
+type WebhookCreated struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+type WebhookUpdate struct {
+	Url        string   `json:"url" validate:"omitempty,http_url"`
+	EventTypes []string `json:"event_types" validate:"omitempty,min=1,dive,oneof=channel.created sensor.created sensor.updated sensor.deleted node.created node.updated node.deleted hardware.created hardware.updated hardware.deleted alert.fired alert.resolved"`
+	Enabled    *bool    `json:"enabled"`
+}
+
+func (wu *WebhookUpdate) ChangeSettedFieldOnly(webhook *Webhook) {
+	if wu.Url == "" {
+		wu.Url = webhook.Url
+	}
+
+	if len(wu.EventTypes) == 0 {
+		wu.EventTypes = webhook.EventTypes
+	}
+
+	if wu.Enabled == nil {
+		wu.Enabled = &webhook.Enabled
+	}
+}
+
+type WebhookDelivery struct {
+	IdWebhookDelivery int64      `json:"id_webhook_delivery"`
+	IdWebhook         int        `json:"id_webhook"`
+	EventType         string     `json:"event_type"`
+	Status            string     `json:"status"`
+	Attempt           int        `json:"attempt"`
+	NextAttemptAt     time.Time  `json:"next_attempt_at"`
+	LastStatusCode    *int       `json:"last_status_code"`
+	LastError         *string    `json:"last_error"`
+	CreatedAt         time.Time  `json:"created_at"`
+	DeliveredAt       *time.Time `json:"delivered_at"`
+}
+
+// WebhookDeliveryJob is a due delivery claimed by the dispatcher together with its target
+type WebhookDeliveryJob struct {
+	WebhookDelivery
+	Url     string
+	Secret  string
+	Payload []byte
+}
+
+type WebhookWithDelivery struct {
+	Webhook
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// WebhookPayload is the body posted to the webhook url
+type WebhookPayload struct {
+	Event string          `json:"event"`
+	Time  time.Time       `json:"time"`
+	Data  json.RawMessage `json:"data"`
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+)
+
+type AlertHandler struct {
+	db                     helper.Querier
+	repository             repositories.AlertRepository
+	sensorRepository       repositories.SensorRepository
+	userRepository         repositories.UserRepository
+	organizationRepository repositories.OrganizationRepository
+	webhookHandler         *WebhookHandler
+	validator              *dependencies.Validator
+}
+
+func NewAlertHandler(db helper.Querier, alertRepository repositories.AlertRepository, sensorRepository repositories.SensorRepository, userRepository repositories.UserRepository, organizationRepository repositories.OrganizationRepository, webhookHandler *WebhookHandler, validator *dependencies.Validator) (AlertHandler, error) {
+	return AlertHandler{
+		db:                     db,
+		repository:             alertRepository,
+		sensorRepository:       sensorRepository,
+		userRepository:         userRepository,
+		organizationRepository: organizationRepository,
+		webhookHandler:         webhookHandler,
+		validator:              validator,
+	}, nil
+}
+
+// checkCanAccessSensor make sure the current user has at least the minimum role in the sensor organization
+func (h *AlertHandler) checkCanAccessSensor(ctx context.Context, c *fiber.Ctx, idSensor int, minimumRole string) error {
+	sensorOwner, err := h.sensorRepository.GetSensorOwnerById(ctx, h.db, idSensor)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	return checkOrganizationRole(ctx, h.db, h.organizationRepository, &currentUser, sensorOwner.IdOrganization, minimumRole, "You can't access alert of a sensor of another organization")
+}
+
+// getAccessibleAlertRule get the alert rule from the url id and check that the current user has at least
+// the minimum role in the organization of its sensor
+func (h *AlertHandler) getAccessibleAlertRule(ctx context.Context, c *fiber.Ctx, minimumRole string) (alertRule entities.AlertRule, err error) {
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return alertRule, err
+	}
+
+	alertRule, err = h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return alertRule, err
+	}
+
+	err = h.checkCanAccessSensor(ctx, c, alertRule.IdSensor, minimumRole)
+	if err != nil {
+		return alertRule, err
+	}
+
+	return alertRule, nil
+}
+
+func (h *AlertHandler) CreateForm(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	sensors, err := h.sensorRepository.GetAll(ctx, h.db, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Render("alert_form", fiber.Map{
+		"title":    "Create Alert",
+		"sensors":  sensors,
+		"idSensor": c.QueryInt("id_sensor", 0),
+	}, "layouts/main")
+}
+
+func (h *AlertHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.AlertRuleCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = h.checkCanAccessSensor(ctx, c, bodyPayload.IdSensor, entities.OrganizationRoleEditor)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.repository.Create(ctx, h.db, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).SendString("Success add new alert rule")
+}
+
+func (h *AlertHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	alertRules, err := h.repository.GetAll(ctx, h.db, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("alert", fiber.Map{
+			"title":  "Alert",
+			"alerts": alertRules,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(alertRules)
+	}
+}
+
+func (h *AlertHandler) GetById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	alertRule, err := h.getAccessibleAlertRule(ctx, c, entities.OrganizationRoleViewer)
+	if err != nil {
+		return err
+	}
+
+	events, err := h.repository.GetEvents(ctx, h.db, alertRule.IdAlertRule)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("alert_detail", fiber.Map{
+			"title":  "Alert Detail",
+			"alert":  alertRule,
+			"events": events,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(entities.AlertRuleWithEvent{
+			AlertRule: alertRule,
+			Events:    events,
+		})
+	}
+}
+
+func (h *AlertHandler) UpdateForm(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	alertRule, err := h.getAccessibleAlertRule(ctx, c, entities.OrganizationRoleEditor)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	sensors, err := h.sensorRepository.GetAll(ctx, h.db, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Render("alert_form", fiber.Map{
+		"title":    "Update Alert",
+		"alert":    alertRule,
+		"sensors":  sensors,
+		"idSensor": alertRule.IdSensor,
+		"edit":     true,
+	}, "layouts/main")
+}
+
+func (h *AlertHandler) Update(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.AlertRuleUpdate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	alertRule, err := h.getAccessibleAlertRule(ctx, c, entities.OrganizationRoleEditor)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Update(ctx, h.db, &alertRule, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit alert rule")
+}
+
+func (h *AlertHandler) Delete(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	alertRule, err := h.getAccessibleAlertRule(ctx, c, entities.OrganizationRoleEditor)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, h.db, alertRule.IdAlertRule)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete alert rule, id: %d", alertRule.IdAlertRule))
+}
+
+// OnChannelCreated evaluate the alert rules of the new channels and notify the members of the organization that
+// own the sensor by email and webhook when an alert start firing or is resolved, failing is only logged so it
+// never reject the reading
+func (h *AlertHandler) OnChannelCreated(ctx context.Context, channels []entities.Channel) {
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		log.Printf("[ALERT] Failed to evaluate alert rule, %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	notifications, err := h.repository.Evaluate(ctx, tx, channels)
+	if err != nil {
+		log.Printf("[ALERT] Failed to evaluate alert rule, %v", err)
+		return
+	}
+
+	// The webhook event is queued with the alert event so it is not lost when the server stop
+	for _, notification := range notifications {
+		eventType := entities.WebhookEventAlertFired
+		if notification.Event.Kind == entities.AlertEventResolved {
+			eventType = entities.WebhookEventAlertResolved
+		}
+		err = h.webhookHandler.PublishForOrganization(ctx, tx, notification.IdOrganization, eventType, entities.AlertRuleWithEvent{
+			AlertRule: notification.AlertRule,
+			Events:    []entities.AlertEvent{notification.Event},
+		})
+		if err != nil {
+			log.Printf("[ALERT] Failed to queue %s event of alert %d, %v", eventType, notification.IdAlertRule, err)
+			return
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("[ALERT] Failed to evaluate alert rule, %v", err)
+		return
+	}
+	if len(notifications) > 0 {
+		h.webhookHandler.Wake()
+	}
+
+	for _, notification := range notifications {
+		members, err := h.organizationRepository.GetMembers(ctx, h.db, notification.IdOrganization)
+		if err != nil {
+			log.Printf("[ALERT] Failed to get the member to notify of alert %d, %v", notification.IdAlertRule, err)
+			continue
+		}
+		for _, member := range members {
+			notification.IdUser = member.IdUser
+			notification.Username = member.Username
+			notification.Email = member.Email
+			go func(notification entities.AlertNotification) {
+				err := h.userRepository.SendEmailAlert(context.Background(), notification)
+				if err != nil {
+					log.Printf("[ALERT] Failed to send alert %d email to %s, %v", notification.IdAlertRule, notification.Email, err)
+				}
+			}(notification)
+		}
+	}
+}
@@ -1,103 +1,674 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/dafaath/iot-server/configs"
 	"github.com/dafaath/iot-server/internal/dependencies"
 	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
 	"github.com/dafaath/iot-server/internal/repositories"
 	"github.com/gofiber/fiber/v2"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// ChannelListener is notified after new channels are stored, whether they come from HTTP or MQTT
+type ChannelListener interface {
+	OnChannelCreated(ctx context.Context, channels []entities.Channel)
+}
+
+func notifyChannelCreated(ctx context.Context, listeners []ChannelListener, channels []entities.Channel) {
+	for _, listener := range listeners {
+		listener.OnChannelCreated(ctx, channels)
+	}
+}
+
+// ChannelListenerQueue notify its listeners from a single background worker so slow listeners, like the alert
+// evaluation, don't delay the response. The queue is bounded and a new channel wait for room when the worker
+// is behind, so the listeners still see the channels in the order they are stored.
+type ChannelListenerQueue struct {
+	listeners []ChannelListener
+	queue     chan []entities.Channel
+	// mutex guard closed, a send hold the read lock so the queue is not closed during the send
+	mutex  sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+func NewChannelListenerQueue(queueSize int, listeners []ChannelListener) *ChannelListenerQueue {
+	return &ChannelListenerQueue{
+		listeners: listeners,
+		queue:     make(chan []entities.Channel, queueSize),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start notify the listeners in the background until Close is called
+func (q *ChannelListenerQueue) Start() {
+	go func() {
+		defer close(q.done)
+		for channels := range q.queue {
+			notifyChannelCreated(context.Background(), q.listeners, channels)
+		}
+	}()
+}
+
+// OnChannelCreated queue the channels for the listeners, after Close the listeners are notified right away
+func (q *ChannelListenerQueue) OnChannelCreated(ctx context.Context, channels []entities.Channel) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	if q.closed {
+		notifyChannelCreated(ctx, q.listeners, channels)
+		return
+	}
+	q.queue <- channels
+}
+
+// Close wait until the listeners are notified of every queued channel or the context is done
+func (q *ChannelListenerQueue) Close(ctx context.Context) error {
+	q.mutex.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mutex.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// exportChannel stream the channel of the sensors as a csv or json lines attachment named fileName,
+// the rows are written while they are read from the database
+func exportChannel(c *fiber.Ctx, db helper.Querier, channelRepository repositories.ChannelRepository, sensorIds []int, query *entities.ChannelExportQuery, fileName string) {
+	query.SetDefault()
+	if query.Format == "jsonl" {
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	} else {
+		c.Set(fiber.HeaderContentType, "text/csv")
+	}
+	c.Attachment(fileName + "." + query.Format)
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx := context.Background()
+		csvWriter := csv.NewWriter(w)
+		if query.Format == "csv" {
+			csvWriter.Write(entities.ChannelExportHeader)
+		}
+
+		count := 0
+		err := channelRepository.Export(ctx, db, sensorIds, query, func(channel entities.ChannelExport) error {
+			if query.Format == "csv" {
+				csvWriter.Write(channel.CsvRecord())
+			} else {
+				data, err := json.Marshal(channel)
+				if err != nil {
+					return err
+				}
+				w.Write(data)
+				w.WriteByte('\n')
+			}
+
+			count++
+			if count%1000 != 0 {
+				return nil
+			}
+			// Flush fail once the client is gone, which stop the export
+			csvWriter.Flush()
+			return w.Flush()
+		})
+		if err != nil {
+			// The status is already sent, the file will be truncated
+			log.Printf("[EXPORT] Failed to export %s, %v", fileName, err)
+			return
+		}
+
+		csvWriter.Flush()
+		w.Flush()
+	})
+}
+
 type ChannelHandler struct {
-	db               *pgxpool.Pool
-	repository       *repositories.ChannelRepository
-	sensorRepository *repositories.SensorRepository
-	validator        *dependencies.Validator
+	db                     helper.Querier
+	repository             repositories.ChannelRepository
+	sensorRepository       repositories.SensorRepository
+	rollupRepository       repositories.RollupRepository
+	organizationRepository repositories.OrganizationRepository
+	validator              *dependencies.Validator
+	listeners              []ChannelListener
+	// writer is only set when the ingest mode is async
+	writer *dependencies.ChannelWriter
 }
 
-func NewChannelHandler(db *pgxpool.Pool, channelRepository *repositories.ChannelRepository, sensorRepository *repositories.SensorRepository, validator *dependencies.Validator) (ChannelHandler, error) {
+func NewChannelHandler(db helper.Querier, channelRepository repositories.ChannelRepository, sensorRepository repositories.SensorRepository, rollupRepository repositories.RollupRepository, organizationRepository repositories.OrganizationRepository, validator *dependencies.Validator, listeners []ChannelListener) (ChannelHandler, error) {
 	return ChannelHandler{
-		db:               db,
-		repository:       channelRepository,
-		sensorRepository: sensorRepository,
-		validator:        validator,
+		db:                     db,
+		repository:             channelRepository,
+		sensorRepository:       sensorRepository,
+		rollupRepository:       rollupRepository,
+		organizationRepository: organizationRepository,
+		validator:              validator,
+		listeners:              listeners,
 	}, nil
 }
 
+// SetWriter make Create queue the channel in the write buffer instead of inserting it directly
+func (h *ChannelHandler) SetWriter(writer *dependencies.ChannelWriter) {
+	h.writer = writer
+}
+
+// WriteChannels store a batch of channels from the write buffer and notify the listeners
+func (h *ChannelHandler) WriteChannels(ctx context.Context, channels []entities.Channel) error {
+	_, err := h.repository.CreateBatch(ctx, h.db, channels)
+	if err != nil {
+		return err
+	}
+	notifyChannelCreated(ctx, h.listeners, channels)
+	return nil
+}
+
 func (h *ChannelHandler) CreateForm(c *fiber.Ctx) (err error) {
 	idSensor := c.QueryInt("id_sensor", 0)
 	return c.Render("channel_form", fiber.Map{"title": "Create Channel", "idSensor": idSensor}, "layouts/main")
 }
 
+// canSendChannel check that the user or the device who send the request can send channel to the sensor.
+// A device can only send channel to sensor on its own node and a user must be an editor of the sensor organization.
+func (h *ChannelHandler) canSendChannel(ctx context.Context, c *fiber.Ctx, sensorOwner entities.SensorOwner) error {
+	device, isDevice := h.validator.GetDevice(c)
+	if isDevice {
+		if device.IdNode != sensorOwner.IdNode {
+			return fiber.NewError(fiber.StatusForbidden, "Device key can only send channel to sensor on its own node")
+		}
+		return nil
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	return checkOrganizationMember(ctx, h.db, h.organizationRepository, currentUser.IdUser, sensorOwner.IdOrganization, entities.OrganizationRoleEditor, "You can't send channel to a sensor of another organization")
+}
+
 func (h *ChannelHandler) Create(c *fiber.Ctx) (err error) {
 	ctx := context.Background()
 	bodyPayload := entities.ChannelCreate{}
 
-	parseChannel := make(chan error)
-	go func() {
-		err = h.validator.ParseBody(c, &bodyPayload)
-		parseChannel <- err
-	}()
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
 
-	// Get current user async
-	type currentUserResult struct {
-		res entities.UserRead
-		err error
+	sensorOwner, err := h.sensorRepository.GetSensorOwnerById(ctx, h.db, bodyPayload.IdSensor)
+	if err != nil {
+		return err
 	}
-	currentUserChannel := make(chan currentUserResult)
-	go func() {
-		currentUser, err := h.validator.GetAuthentication(c)
-		currentUserChannel <- currentUserResult{
-			res: currentUser,
-			err: err,
+
+	err = h.canSendChannel(ctx, c, sensorOwner)
+	if err != nil {
+		return err
+	}
+
+	if h.writer != nil {
+		channel, err := h.repository.NewChannel(&bodyPayload, time.Now().UTC())
+		if err != nil {
+			return err
 		}
-	}()
 
-	// Wait for parsing and get parsing error
-	err = <-parseChannel
+		err = h.writer.Enqueue([]entities.Channel{channel})
+		if err == dependencies.ErrChannelWriterFull {
+			c.Set(fiber.HeaderRetryAfter, "1")
+			return fiber.NewError(fiber.StatusTooManyRequests, "Too many channel waiting to be written, try again later")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+		}
+		return c.Status(fiber.StatusAccepted).SendString("Channel queued")
+	}
+
+	channel, err := h.repository.Create(ctx, h.db, &bodyPayload)
 	if err != nil {
 		return err
 	}
+	notifyChannelCreated(ctx, h.listeners, []entities.Channel{channel})
+
+	return c.Status(fiber.StatusCreated).SendString("Add new channel")
 
-	// Get sensor owner Id async
-	type sensorOwnerIdResult struct {
-		res int
-		err error
+}
+
+func (h *ChannelHandler) CreateBatch(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.ChannelBatchCreate{}
+
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
 	}
-	sensorOwnerIdChannel := make(chan sensorOwnerIdResult)
-	go func() {
-		sensorOwnerId, err := h.sensorRepository.GetIdUserWhoOwnSensorById(ctx, h.db, bodyPayload.IdSensor)
-		sensorOwnerIdChannel <- sensorOwnerIdResult{
-			res: sensorOwnerId,
-			err: err,
+
+	// Check ownership only once for every distinct sensor in the batch
+	sensorIds := []int{}
+	seenSensor := map[int]bool{}
+	for _, item := range bodyPayload.Channels {
+		if !seenSensor[item.IdSensor] {
+			seenSensor[item.IdSensor] = true
+			sensorIds = append(sensorIds, item.IdSensor)
 		}
-	}()
+	}
 
-	currentUserRes := <-currentUserChannel
-	currentUser := currentUserRes.res
-	err = currentUserRes.err
+	sensorOwners, err := h.sensorRepository.GetSensorOwnerByIds(ctx, h.db, sensorIds)
 	if err != nil {
 		return err
 	}
+	sensorErrors := map[int]error{}
+	for idSensor, owner := range sensorOwners {
+		sensorErrors[idSensor] = h.canSendChannel(ctx, c, owner)
+	}
+
+	receivedAt := time.Now().UTC()
+	report := entities.ChannelBatchReport{
+		Results: make([]entities.ChannelBatchResult, 0, len(bodyPayload.Channels)),
+	}
+	channels := make([]entities.Channel, 0, len(bodyPayload.Channels))
+	for i, item := range bodyPayload.Channels {
+		result := entities.ChannelBatchResult{
+			Index:    i,
+			IdSensor: item.IdSensor,
+			Status:   entities.ChannelBatchAccepted,
+		}
+
+		_, sensorExist := sensorOwners[item.IdSensor]
+		err := h.validator.ValidateStruct(&item)
+		if err != nil {
+			result.Status = entities.ChannelBatchRejected
+			result.Reason = strings.TrimSpace(err.Error())
+		} else if !sensorExist {
+			result.Status = entities.ChannelBatchRejected
+			result.Reason = fmt.Sprintf("Sensor with id %d not found", item.IdSensor)
+		} else if err := sensorErrors[item.IdSensor]; err != nil {
+			result.Status = entities.ChannelBatchRejected
+			result.Reason = err.Error()
+		} else if channel, err := h.repository.NewChannel(&item, receivedAt); err != nil {
+			result.Status = entities.ChannelBatchRejected
+			result.Reason = err.Error()
+		} else {
+			channels = append(channels, channel)
+		}
 
-	sensorOwnerIdRes := <-sensorOwnerIdChannel
-	sensorOwnerId := sensorOwnerIdRes.res
-	err = sensorOwnerIdRes.err
+		if result.Status == entities.ChannelBatchAccepted {
+			report.Accepted++
+		} else {
+			report.Rejected++
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	if len(channels) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(report)
+	}
+
+	tx, err := h.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	if currentUser.IdUser != sensorOwnerId {
-		return fiber.NewError(fiber.StatusForbidden, "You can't send channel to another user's sensor")
+	_, err = h.repository.CreateBatch(ctx, tx, channels)
+	if err != nil {
+		return err
 	}
 
-	_, err = h.repository.Create(ctx, h.db, &bodyPayload)
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
+	notifyChannelCreated(ctx, h.listeners, channels)
 
-	return c.Status(fiber.StatusCreated).SendString("Add new channel")
+	return c.Status(fiber.StatusCreated).JSON(report)
+}
+
+// channelImport read and validate the rows of a channel csv. It is used as the source of CopyFrom so
+// the file is inserted while it is read, invalid rows are skipped and recorded in the report.
+type channelImport struct {
+	ctx                    context.Context
+	db                     helper.Querier
+	sensorRepository       repositories.SensorRepository
+	organizationRepository repositories.OrganizationRepository
+	reader                 *csv.Reader
+	currentUser            entities.UserRead
+	// Index of each column in the header, -1 when the column is missing
+	timeColumn       int
+	valueColumn      int
+	idSensorColumn   int
+	sensorNameColumn int
+	ownedSensor      map[int]bool
+	sensorByName     map[string][]int
+	// Reason why a sensor outside the organizations where the user is an editor can't be used
+	forbiddenSensor map[int]string
+	// Oldest and newest accepted time of every sensor, used to mark the rollup to recompute
+	sensorRange map[int][2]time.Time
+	receivedAt  time.Time
+	report      *entities.ChannelImportReport
+	values      []interface{}
+	err         error
+}
+
+func newChannelImport(ctx context.Context, db helper.Querier, sensorRepository repositories.SensorRepository, organizationRepository repositories.OrganizationRepository, reader io.Reader, currentUser entities.UserRead, report *entities.ChannelImportReport) (*channelImport, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil, fiber.NewError(400, "CSV file is empty")
+	}
+	if err != nil {
+		return nil, fiber.NewError(400, fmt.Sprintf("Invalid CSV header, %v", err))
+	}
+
+	ci := channelImport{
+		ctx:                    ctx,
+		db:                     db,
+		sensorRepository:       sensorRepository,
+		organizationRepository: organizationRepository,
+		reader:                 csvReader,
+		currentUser:            currentUser,
+		timeColumn:             -1,
+		valueColumn:            -1,
+		idSensorColumn:         -1,
+		sensorNameColumn:       -1,
+		ownedSensor:            map[int]bool{},
+		sensorByName:           map[string][]int{},
+		forbiddenSensor:        map[int]string{},
+		sensorRange:            map[int][2]time.Time{},
+		receivedAt:             time.Now().UTC(),
+		report:                 report,
+	}
+	for i, column := range header {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case "time", "timestamp":
+			ci.timeColumn = i
+		case "value":
+			ci.valueColumn = i
+		case "id_sensor", "sensor_id":
+			ci.idSensorColumn = i
+		case "sensor_name", "sensor":
+			ci.sensorNameColumn = i
+		}
+	}
+	if ci.timeColumn == -1 || ci.valueColumn == -1 || (ci.idSensorColumn == -1 && ci.sensorNameColumn == -1) {
+		return nil, fiber.NewError(400, "CSV header must contain time, value and either id_sensor or sensor_name column")
+	}
+
+	sensors, err := sensorRepository.GetUserSensor(ctx, db, currentUser.IdUser, entities.OrganizationRolesAtLeast(entities.OrganizationRoleEditor))
+	if err != nil {
+		return nil, err
+	}
+	for _, sensor := range sensors {
+		ci.ownedSensor[sensor.IdSensor] = true
+		ci.sensorByName[sensor.Name] = append(ci.sensorByName[sensor.Name], sensor.IdSensor)
+	}
+
+	return &ci, nil
+}
+
+func (ci *channelImport) reject(line int, reason string) {
+	ci.report.Rejected++
+	if len(ci.report.Errors) < entities.ChannelImportMaxError {
+		ci.report.Errors = append(ci.report.Errors, entities.ChannelImportError{Line: line, Reason: reason})
+	}
+}
+
+func (ci *channelImport) column(record []string, index int) string {
+	if index == -1 || index >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[index])
+}
+
+// sensorId resolve the sensor of a row, the id column is used before the name column when both are set
+func (ci *channelImport) sensorId(record []string) (int, error) {
+	idText := ci.column(record, ci.idSensorColumn)
+	if idText == "" {
+		name := ci.column(record, ci.sensorNameColumn)
+		if name == "" {
+			return 0, fiber.NewError(400, "id_sensor or sensor_name is required")
+		}
+
+		ids := ci.sensorByName[name]
+		if len(ids) == 0 {
+			return 0, fiber.NewError(404, fmt.Sprintf("Sensor with name %q not found", name))
+		}
+		if len(ids) > 1 {
+			return 0, fiber.NewError(400, fmt.Sprintf("Sensor name %q is used by %d of your sensors, use id_sensor instead", name, len(ids)))
+		}
+		return ids[0], nil
+	}
+
+	id, err := strconv.Atoi(idText)
+	if err != nil || id <= 0 {
+		return 0, fiber.NewError(400, fmt.Sprintf("id_sensor %q must be a valid positive integer", idText))
+	}
+	if ci.ownedSensor[id] {
+		return id, nil
+	}
+
+	reason, checked := ci.forbiddenSensor[id]
+	if !checked {
+		sensorOwner, err := ci.sensorRepository.GetSensorOwnerById(ci.ctx, ci.db, id)
+		if err == nil {
+			err = checkOrganizationMember(ci.ctx, ci.db, ci.organizationRepository, ci.currentUser.IdUser, sensorOwner.IdOrganization, entities.OrganizationRoleEditor, "You can't send channel to a sensor of another organization")
+		}
+		if err != nil {
+			var fiberErr *fiber.Error
+			if !errors.As(err, &fiberErr) {
+				return 0, err
+			}
+			reason = fiberErr.Message
+		}
+		ci.forbiddenSensor[id] = reason
+	}
+	return 0, fiber.NewError(403, reason)
+}
+
+func (ci *channelImport) parse(record []string) (values []interface{}, err error) {
+	idSensor, err := ci.sensorId(record)
+	if err != nil {
+		return nil, err
+	}
+
+	timeText := ci.column(record, ci.timeColumn)
+	if timeText == "" {
+		return nil, fiber.NewError(400, "time is required")
+	}
+	channelTime, err := entities.ParseChannelTime(timeText)
+	if err != nil {
+		return nil, fiber.NewError(400, err.Error())
+	}
+	maxFutureDrift := configs.GetConfig().Channel.MaxFutureDrift
+	if channelTime.After(ci.receivedAt.Add(maxFutureDrift)) {
+		return nil, fiber.NewError(400, fmt.Sprintf("Channel time %s is too far in the future, max drift is %s", channelTime.Format(time.RFC3339), maxFutureDrift))
+	}
+
+	valueText := ci.column(record, ci.valueColumn)
+	value, err := strconv.ParseFloat(valueText, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fiber.NewError(400, fmt.Sprintf("value %q must be a finite number", valueText))
+	}
+
+	timeRange, ok := ci.sensorRange[idSensor]
+	if !ok || channelTime.Before(timeRange[0]) {
+		timeRange[0] = channelTime.Time
+	}
+	if !ok || channelTime.After(timeRange[1]) {
+		timeRange[1] = channelTime.Time
+	}
+	ci.sensorRange[idSensor] = timeRange
+
+	return []interface{}{channelTime.Time, value, idSensor, ci.receivedAt}, nil
+}
+
+func (ci *channelImport) Next() bool {
+	for {
+		record, err := ci.reader.Read()
+		if err == io.EOF {
+			return false
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			ci.report.Total++
+			ci.reject(parseErr.Line, parseErr.Err.Error())
+			continue
+		}
+		if err != nil {
+			ci.err = err
+			return false
+		}
+		ci.report.Total++
+		line, _ := ci.reader.FieldPos(0)
+
+		values, err := ci.parse(record)
+		if err != nil {
+			var fiberErr *fiber.Error
+			if !errors.As(err, &fiberErr) {
+				ci.err = err
+				return false
+			}
+			ci.reject(line, fiberErr.Message)
+			continue
+		}
+
+		ci.report.Accepted++
+		ci.values = values
+		return true
+	}
+}
+
+func (ci *channelImport) Values() ([]interface{}, error) {
+	return ci.values, nil
+}
+
+func (ci *channelImport) Err() error {
+	return ci.err
+}
+
+// ImportCsv insert the historical channel in a csv file for sensors owned by the user. Every row is validated,
+// nothing is inserted when a row is rejected or when dryRun is set. Imported channels don't notify the listeners
+// since they are not new readings, only their rollup is marked to be recomputed.
+func (h *ChannelHandler) ImportCsv(ctx context.Context, reader io.Reader, currentUser entities.UserRead, dryRun bool) (report entities.ChannelImportReport, err error) {
+	report = entities.ChannelImportReport{
+		DryRun: dryRun,
+		Errors: []entities.ChannelImportError{},
+	}
+	source, err := newChannelImport(ctx, h.db, h.sensorRepository, h.organizationRepository, reader, currentUser, &report)
+	if err != nil {
+		return report, err
+	}
+
+	if dryRun {
+		for source.Next() {
+		}
+		return report, source.Err()
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback(ctx)
+
+	inserted, err := h.repository.CreateFromSource(ctx, tx, source)
+	if err != nil {
+		return report, err
+	}
+	if report.Rejected > 0 {
+		return report, nil
+	}
+
+	for idSensor, timeRange := range source.sensorRange {
+		idSensor := idSensor
+		_, err = h.rollupRepository.MarkPendingRange(ctx, tx, &idSensor, &timeRange[0], &timeRange[1])
+		if err != nil {
+			return report, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Inserted = inserted
+	return report, nil
+}
+
+// Import accept the csv either as the file field of a multipart form or as the raw body
+func (h *ChannelHandler) Import(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	query := entities.ChannelImportQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader = bytes.NewReader(c.Body())
+	if strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return fiber.NewError(400, "CSV file is required in the file field")
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	report, err := h.ImportCsv(ctx, reader, currentUser, query.DryRun)
+	if err != nil {
+		return err
+	}
+
+	if report.Rejected > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(report)
+	}
+	if query.DryRun {
+		return c.JSON(report)
+	}
+	return c.Status(fiber.StatusCreated).JSON(report)
+}
+
+// GetWriterStats report the state of the write buffer, every count is zero when the ingest mode is sync
+func (h *ChannelHandler) GetWriterStats(c *fiber.Ctx) (err error) {
+	config := configs.GetConfig()
+	stats := dependencies.ChannelWriterStats{}
+	if h.writer != nil {
+		stats = h.writer.Stats()
+	}
 
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"mode":   config.Ingest.Mode,
+		"writer": stats,
+	})
 }
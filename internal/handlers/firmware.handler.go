@@ -0,0 +1,393 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type FirmwareHandler struct {
+	db                     helper.Querier
+	repository             repositories.FirmwareRepository
+	hardwareRepository     repositories.HardwareRepository
+	nodeRepository         repositories.NodeRepository
+	organizationRepository repositories.OrganizationRepository
+	validator              *dependencies.Validator
+}
+
+func NewFirmwareHandler(db helper.Querier, firmwareRepository repositories.FirmwareRepository, hardwareRepository repositories.HardwareRepository, nodeRepository repositories.NodeRepository, organizationRepository repositories.OrganizationRepository, validator *dependencies.Validator) (FirmwareHandler, error) {
+	return FirmwareHandler{
+		db:                     db,
+		repository:             firmwareRepository,
+		hardwareRepository:     hardwareRepository,
+		nodeRepository:         nodeRepository,
+		organizationRepository: organizationRepository,
+		validator:              validator,
+	}, nil
+}
+
+// parseRolloutNodes parse comma separated node id such as 1,2,3
+func (h *FirmwareHandler) parseRolloutNodes(rolloutNodes string) ([]int, error) {
+	ids := []int{}
+	for _, part := range strings.Split(rolloutNodes, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(part)
+		if err != nil || id <= 0 {
+			return ids, fiber.NewError(400, fmt.Sprintf("rollout_nodes must be comma separated node id, %q is not a valid id", part))
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// sign create the signature of a download url, the jwt secret key is used so no extra secret need to be configured
+func (h *FirmwareHandler) sign(idFirmware int, expires int64) string {
+	config := configs.GetConfig()
+	mac := hmac.New(sha256.New, []byte(config.JWT.SecretKey))
+	mac.Write([]byte(fmt.Sprintf("firmware:%d:%d", idFirmware, expires)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// saveFile copy the uploaded binary to the storage directory while computing its checksum
+func (h *FirmwareHandler) saveFile(c *fiber.Ctx, firmware *entities.Firmware) error {
+	config := configs.GetConfig()
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return fiber.NewError(400, "firmware binary must be uploaded on the file field")
+	}
+	if fileHeader.Size <= 0 {
+		return fiber.NewError(400, "firmware binary is empty")
+	}
+	if fileHeader.Size > int64(config.Firmware.MaxSize) {
+		return fiber.NewError(400, fmt.Sprintf("firmware binary is larger than %d byte", config.Firmware.MaxSize))
+	}
+
+	err = os.MkdirAll(config.Firmware.StorageDir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	src, err := fileHeader.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	filePath := filepath.Join(config.Firmware.StorageDir, uuid.New().String()+".bin")
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, hash), src)
+	if err != nil {
+		os.Remove(filePath)
+		return err
+	}
+
+	firmware.FileName = filepath.Base(fileHeader.Filename)
+	firmware.FilePath = filePath
+	firmware.Size = size
+	firmware.Checksum = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+func (h *FirmwareHandler) CreateForm(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	nodeHardware, err := h.hardwareRepository.GetAllNode(ctx, h.db)
+	if err != nil {
+		return err
+	}
+
+	return c.Render("firmware_form", fiber.Map{
+		"title":        "Upload Firmware",
+		"nodeHardware": nodeHardware,
+	}, "layouts/main")
+}
+
+func (h *FirmwareHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.FirmwareCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	hardware, err := h.hardwareRepository.GetById(ctx, h.db, bodyPayload.IdHardware)
+	if err != nil {
+		return err
+	}
+
+	hardwareType := strings.ToLower(hardware.Type)
+	if hardwareType != "microcontroller unit" && hardwareType != "single-board computer" {
+		return fiber.NewError(400, "Hardware type not match, type should be microcontroller unit or single-board computer")
+	}
+
+	rolloutNodes, err := h.parseRolloutNodes(bodyPayload.RolloutNodes)
+	if err != nil {
+		return err
+	}
+
+	firmware := entities.Firmware{
+		IdHardware:        bodyPayload.IdHardware,
+		Version:           bodyPayload.Version,
+		Description:       bodyPayload.Description,
+		RolloutPercentage: 100,
+		RolloutNodes:      rolloutNodes,
+	}
+	if bodyPayload.RolloutPercentage != nil {
+		firmware.RolloutPercentage = *bodyPayload.RolloutPercentage
+	}
+
+	err = h.saveFile(c, &firmware)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Create(ctx, h.db, &firmware)
+	if err != nil {
+		os.Remove(firmware.FilePath)
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(firmware)
+}
+
+func (h *FirmwareHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+
+	firmwares, err := h.repository.GetAll(ctx, h.db)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("firmware", fiber.Map{
+			"title":     "Firmware",
+			"firmwares": firmwares,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(firmwares)
+	}
+}
+
+func (h *FirmwareHandler) GetById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	firmware, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("firmware_detail", fiber.Map{
+			"title":    "Firmware Detail",
+			"firmware": firmware,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(firmware)
+	}
+}
+
+func (h *FirmwareHandler) UpdateForm(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	firmware, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Render("firmware_form", fiber.Map{
+		"title":    "Edit Firmware",
+		"firmware": firmware,
+		"edit":     true,
+	}, "layouts/main")
+}
+
+func (h *FirmwareHandler) Update(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := entities.FirmwareUpdate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	firmware, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Update(ctx, h.db, &firmware, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit firmware")
+}
+
+func (h *FirmwareHandler) Delete(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	firmware, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(firmware.FilePath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("[FIRMWARE] Failed to remove firmware file %s, %v", firmware.FilePath, err)
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete firmware, id: %d", id))
+}
+
+// Check find the newest firmware for the node hardware that is newer than the version currently running
+// on the node and rolled out to it, the response contain a signed url to download it
+func (h *FirmwareHandler) Check(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	config := configs.GetConfig()
+	id, _, err := getAccessibleNodeId(ctx, c, h.db, h.validator, h.nodeRepository, h.organizationRepository, entities.OrganizationRoleViewer)
+	if err != nil {
+		return err
+	}
+
+	query := entities.FirmwareCheckQuery{}
+	err = h.validator.ParseQuery(c, &query)
+	if err != nil {
+		return err
+	}
+
+	currentVersion, err := helper.ParseSemver(query.Version)
+	if err != nil {
+		return fiber.NewError(400, err.Error())
+	}
+
+	node, err := h.nodeRepository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	firmwares, err := h.repository.GetHardwareFirmware(ctx, h.db, node.IdHardware)
+	if err != nil {
+		return err
+	}
+
+	var newest *entities.Firmware
+	var newestVersion helper.Semver
+	for i := range firmwares {
+		version, err := helper.ParseSemver(firmwares[i].Version)
+		if err != nil {
+			log.Printf("[FIRMWARE] Firmware %d has an invalid version, %v", firmwares[i].IdFirmware, err)
+			continue
+		}
+		if version.Compare(currentVersion) <= 0 || !firmwares[i].IsRolledOutTo(id) {
+			continue
+		}
+		if newest == nil || version.Compare(newestVersion) > 0 {
+			newest = &firmwares[i]
+			newestVersion = version
+		}
+	}
+
+	if newest == nil {
+		return c.Status(fiber.StatusOK).JSON(entities.FirmwareCheck{UpdateAvailable: false})
+	}
+
+	expiresAt := time.Now().UTC().Add(config.Firmware.DownloadURLTTL)
+	expires := expiresAt.Unix()
+	downloadUrl := fmt.Sprintf("%s/firmware/%d/download?expires=%d&signature=%s", c.BaseURL(), newest.IdFirmware, expires, h.sign(newest.IdFirmware, expires))
+
+	return c.Status(fiber.StatusOK).JSON(entities.FirmwareCheck{
+		UpdateAvailable:   true,
+		Firmware:          newest,
+		DownloadUrl:       downloadUrl,
+		DownloadExpiresAt: &expiresAt,
+	})
+}
+
+// Download send the firmware binary, the request is authenticated by the signature of the url
+func (h *FirmwareHandler) Download(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil {
+		return fiber.NewError(403, "Download url is invalid")
+	}
+
+	signature, err := hex.DecodeString(c.Query("signature"))
+	if err != nil {
+		return fiber.NewError(403, "Download url is invalid")
+	}
+
+	expectedSignature, _ := hex.DecodeString(h.sign(id, expires))
+	if !hmac.Equal(signature, expectedSignature) {
+		return fiber.NewError(403, "Download url is invalid")
+	}
+
+	if time.Now().UTC().Unix() > expires {
+		return fiber.NewError(403, "Download url has expired")
+	}
+
+	firmware, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	c.Set("X-Checksum-Sha256", firmware.Checksum)
+	return c.Download(firmware.FilePath, firmware.FileName)
+}
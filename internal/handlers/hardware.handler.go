@@ -7,26 +7,28 @@ import (
 
 	"github.com/dafaath/iot-server/internal/dependencies"
 	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
 	"github.com/dafaath/iot-server/internal/repositories"
 	"github.com/gofiber/fiber/v2"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type HardwareHandler struct {
-	db               *pgxpool.Pool
-	repository       *repositories.HardwareRepository
+	db               helper.Querier
+	repository       repositories.HardwareRepository
 	validator        *dependencies.Validator
-	nodeRepository   *repositories.NodeRepository
-	sensorRepository *repositories.SensorRepository
+	nodeRepository   repositories.NodeRepository
+	sensorRepository repositories.SensorRepository
+	webhookHandler   *WebhookHandler
 }
 
-func NewHardwareHandler(db *pgxpool.Pool, hardwareRepository *repositories.HardwareRepository, nodeRepository *repositories.NodeRepository, sensorRepository *repositories.SensorRepository, validator *dependencies.Validator) (HardwareHandler, error) {
+func NewHardwareHandler(db helper.Querier, hardwareRepository repositories.HardwareRepository, nodeRepository repositories.NodeRepository, sensorRepository repositories.SensorRepository, webhookHandler *WebhookHandler, validator *dependencies.Validator) (HardwareHandler, error) {
 	return HardwareHandler{
 		db:               db,
 		validator:        validator,
 		repository:       hardwareRepository,
 		nodeRepository:   nodeRepository,
 		sensorRepository: sensorRepository,
+		webhookHandler:   webhookHandler,
 	}, nil
 }
 
@@ -45,10 +47,27 @@ func (h *HardwareHandler) Create(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	_, err = h.repository.Create(ctx, h.db, bodyPayload)
+	tx, err := h.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
+
+	hardware, err := h.repository.Create(ctx, tx, bodyPayload)
+	if err != nil {
+		return err
+	}
+	// Hardware is shared by every user so the event is sent to everyone subscribed to it
+	err = h.webhookHandler.Publish(ctx, tx, nil, entities.WebhookEventHardwareCreated, hardware)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	h.webhookHandler.Wake()
 
 	return c.Status(fiber.StatusCreated).SendString("Success add new hardware")
 }
@@ -193,10 +212,29 @@ func (h *HardwareHandler) Update(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	err = h.repository.Update(ctx, h.db, &hardware, bodyPayload)
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.Update(ctx, tx, &hardware, bodyPayload)
+	if err != nil {
+		return err
+	}
+	hardware.Name = bodyPayload.Name
+	hardware.Type = bodyPayload.Type
+	hardware.Description = bodyPayload.Description
+	err = h.webhookHandler.Publish(ctx, tx, nil, entities.WebhookEventHardwareUpdated, hardware)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
+	h.webhookHandler.Wake()
 
 	return c.Status(fiber.StatusOK).SendString("Success edit hardware")
 }
@@ -208,15 +246,31 @@ func (h *HardwareHandler) Delete(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	_, err = h.repository.GetById(ctx, h.db, id)
+	hardware, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.Delete(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	err = h.webhookHandler.Publish(ctx, tx, nil, entities.WebhookEventHardwareDeleted, hardware)
 	if err != nil {
 		return err
 	}
 
-	err = h.repository.Delete(ctx, h.db, id)
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
+	h.webhookHandler.Wake()
 
 	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete hardware, id: %d", id))
 }
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// MqttHandler is a hook for the embedded MQTT broker that store every reading
+// published on node/{id_node}/sensor/{id_sensor} as a channel and push node command
+// to node/{id_node}/command
+type MqttHandler struct {
+	mqtt.HookBase
+	db                     helper.Querier
+	channelRepository      repositories.ChannelRepository
+	sensorRepository       repositories.SensorRepository
+	nodeRepository         repositories.NodeRepository
+	nodeKeyRepository      repositories.NodeKeyRepository
+	organizationRepository repositories.OrganizationRepository
+	listeners              []ChannelListener
+	// Broker used to push command, nil when MQTT is disabled
+	server *mqtt.Server
+	// Authenticated user or device of every connected client. The key is the client and not its id because a new
+	// connection with the same id take over the session before the old one is disconnected
+	clientPrincipal sync.Map
+}
+
+func NewMqttHandler(db helper.Querier, channelRepository repositories.ChannelRepository, sensorRepository repositories.SensorRepository, nodeRepository repositories.NodeRepository, nodeKeyRepository repositories.NodeKeyRepository, organizationRepository repositories.OrganizationRepository, listeners []ChannelListener) (MqttHandler, error) {
+	return MqttHandler{
+		db:                     db,
+		channelRepository:      channelRepository,
+		sensorRepository:       sensorRepository,
+		nodeRepository:         nodeRepository,
+		nodeKeyRepository:      nodeKeyRepository,
+		organizationRepository: organizationRepository,
+		listeners:              listeners,
+	}, nil
+}
+
+// SetServer set the broker that the handler is attached to
+func (h *MqttHandler) SetServer(server *mqtt.Server) {
+	h.server = server
+}
+
+func (h *MqttHandler) ID() string {
+	return "iot-server-channel"
+}
+
+func (h *MqttHandler) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnConnectAuthenticate,
+		mqtt.OnACLCheck,
+		mqtt.OnDisconnect,
+		mqtt.OnPublish,
+	}, []byte{b})
+}
+
+// parseTopic get the node and sensor id from node/{id_node}/sensor/{id_sensor}
+func (h *MqttHandler) parseTopic(topic string) (idNode int, idSensor int, err error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) != 4 || parts[0] != "node" || parts[2] != "sensor" {
+		return 0, 0, fmt.Errorf("topic %s doesn't match node/{id_node}/sensor/{id_sensor}", topic)
+	}
+
+	idNode, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("id node in topic %s must be an integer", topic)
+	}
+
+	idSensor, err = strconv.Atoi(parts[3])
+	if err != nil {
+		return 0, 0, fmt.Errorf("id sensor in topic %s must be an integer", topic)
+	}
+
+	return idNode, idSensor, nil
+}
+
+// parseSubscribeTopic get the node id from node/{id_node}/command or node/{id_node}/shadow/delta
+func (h *MqttHandler) parseSubscribeTopic(topic string) (idNode int, err error) {
+	parts := strings.Split(topic, "/")
+	isCommand := len(parts) == 3 && parts[2] == "command"
+	isShadowDelta := len(parts) == 4 && parts[2] == "shadow" && parts[3] == "delta"
+	if parts[0] != "node" || (!isCommand && !isShadowDelta) {
+		return 0, fmt.Errorf("topic %s doesn't match node/{id_node}/command or node/{id_node}/shadow/delta", topic)
+	}
+
+	idNode, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("id node in topic %s must be an integer", topic)
+	}
+
+	return idNode, nil
+}
+
+// commandTopic is the topic a node subscribe to for receiving its command
+func (h *MqttHandler) commandTopic(idNode int) string {
+	return fmt.Sprintf("node/%d/command", idNode)
+}
+
+// shadowDeltaTopic is the topic a node subscribe to for receiving the change of its desired state
+func (h *MqttHandler) shadowDeltaTopic(idNode int) string {
+	return fmt.Sprintf("node/%d/shadow/delta", idNode)
+}
+
+// parsePayload accept either a plain number or a json object with value and optional time
+func (h *MqttHandler) parsePayload(payload []byte, idSensor int) (entities.ChannelCreate, error) {
+	channel := entities.ChannelCreate{}
+	value, err := strconv.ParseFloat(string(bytes.TrimSpace(payload)), 64)
+	if err == nil {
+		channel.Value = value
+	} else {
+		err = json.Unmarshal(payload, &channel)
+		if err != nil {
+			return channel, fmt.Errorf("payload must be a number or a json object with value and time, %w", err)
+		}
+	}
+
+	channel.IdSensor = idSensor
+	return channel, nil
+}
+
+// OnConnectAuthenticate use the password either as a node API key or as the same bearer token
+// that is sent on the authorization header, the 'Bearer ' prefix can be omitted
+func (h *MqttHandler) OnConnectAuthenticate(cl *mqtt.Client, pk packets.Packet) bool {
+	password := string(pk.Connect.Password)
+	if strings.HasPrefix(password, entities.NodeKeyPrefix) {
+		device, err := h.nodeKeyRepository.GetDeviceByKey(context.Background(), h.db, password)
+		if err != nil {
+			log.Printf("[MQTT] Client %s failed to authenticate with device key: %v", cl.ID, err)
+			return false
+		}
+
+		h.clientPrincipal.Store(cl, device)
+		return true
+	}
+
+	if !strings.HasPrefix(password, "Bearer ") {
+		password = "Bearer " + password
+	}
+
+	user, err := helper.ValidateUserAuthorization(password)
+	if err != nil {
+		log.Printf("[MQTT] Client %s failed to authenticate: %v", cl.ID, err)
+		return false
+	}
+
+	h.clientPrincipal.Store(cl, user)
+	return true
+}
+
+// OnACLCheck allow client to publish reading and to subscribe to the command and shadow topic of a node it can access
+func (h *MqttHandler) OnACLCheck(cl *mqtt.Client, topic string, write bool) bool {
+	if write {
+		_, _, err := h.parseTopic(topic)
+		return err == nil
+	}
+
+	idNode, err := h.parseSubscribeTopic(topic)
+	if err != nil {
+		return false
+	}
+
+	principal, ok := h.clientPrincipal.Load(cl)
+	if !ok {
+		return false
+	}
+
+	switch principal := principal.(type) {
+	case entities.Device:
+		return principal.IdNode == idNode
+	case entities.UserRead:
+		ctx := context.Background()
+		node, err := h.nodeRepository.GetById(ctx, h.db, idNode)
+		if err != nil {
+			return false
+		}
+		err = checkOrganizationRole(ctx, h.db, h.organizationRepository, &principal, node.IdOrganization, entities.OrganizationRoleViewer, "you can't subscribe to a node of another organization")
+		return err == nil
+	}
+
+	return false
+}
+
+func (h *MqttHandler) OnDisconnect(cl *mqtt.Client, err error, expire bool) {
+	h.clientPrincipal.Delete(cl)
+}
+
+func (h *MqttHandler) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet, error) {
+	// Command pushed by the server itself
+	if cl.Net.Inline {
+		return pk, nil
+	}
+
+	err := h.createChannel(cl, pk)
+	if err != nil {
+		log.Printf("[MQTT] Rejected publish from client %s on %s: %v", cl.ID, pk.TopicName, err)
+		return pk, packets.ErrRejectPacket
+	}
+
+	return pk, nil
+}
+
+func (h *MqttHandler) createChannel(cl *mqtt.Client, pk packets.Packet) error {
+	ctx := context.Background()
+	principal, ok := h.clientPrincipal.Load(cl)
+	if !ok {
+		return fmt.Errorf("client is not authenticated")
+	}
+
+	idNode, idSensor, err := h.parseTopic(pk.TopicName)
+	if err != nil {
+		return err
+	}
+
+	payload, err := h.parsePayload(pk.Payload, idSensor)
+	if err != nil {
+		return err
+	}
+
+	sensorOwners, err := h.sensorRepository.GetSensorOwnerByIds(ctx, h.db, []int{idSensor})
+	if err != nil {
+		return err
+	}
+
+	owner, sensorExist := sensorOwners[idSensor]
+	if !sensorExist || owner.IdNode != idNode {
+		return fmt.Errorf("sensor with id %d not found on node %d", idSensor, idNode)
+	}
+
+	switch principal := principal.(type) {
+	case entities.Device:
+		if owner.IdNode != principal.IdNode {
+			return fmt.Errorf("device key can only send channel to sensor on its own node")
+		}
+	case entities.UserRead:
+		err = checkOrganizationMember(ctx, h.db, h.organizationRepository, principal.IdUser, owner.IdOrganization, entities.OrganizationRoleEditor, "you can't send channel to a sensor of another organization")
+		if err != nil {
+			return err
+		}
+	}
+
+	channel, err := h.channelRepository.Create(ctx, h.db, &payload)
+	if err != nil {
+		return err
+	}
+	notifyChannelCreated(ctx, h.listeners, []entities.Channel{channel})
+
+	return nil
+}
+
+// PublishCommand push the command to the node that subscribe to node/{id_node}/command,
+// the command is still saved so a node that is not connected can get it by polling
+func (h *MqttHandler) PublishCommand(command entities.NodeCommand) error {
+	if h.server == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+
+	return h.server.Publish(h.commandTopic(command.IdNode), payload, false, 1)
+}
+
+// PublishShadowDelta push the desired state that the node has not applied yet to node/{id_node}/shadow/delta
+func (h *MqttHandler) PublishShadowDelta(delta entities.NodeShadowDelta) error {
+	if h.server == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+
+	// Retained so a node that connect later get the latest delta right after subscribing
+	return h.server.Publish(h.shadowDeltaTopic(delta.IdNode), payload, true, 1)
+}
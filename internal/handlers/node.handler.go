@@ -3,34 +3,84 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"log"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/dafaath/iot-server/configs"
 	"github.com/dafaath/iot-server/internal/dependencies"
 	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
 	"github.com/dafaath/iot-server/internal/repositories"
 	"github.com/gofiber/fiber/v2"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type NodeHandler struct {
-	db                 *pgxpool.Pool
-	repository         *repositories.NodeRepository
-	hardwareRepository *repositories.HardwareRepository
-	sensorRepository   *repositories.SensorRepository
-	validator          *dependencies.Validator
+	db                     helper.Querier
+	repository             repositories.NodeRepository
+	hardwareRepository     repositories.HardwareRepository
+	sensorRepository       repositories.SensorRepository
+	channelRepository      repositories.ChannelRepository
+	commandRepository      repositories.NodeCommandRepository
+	shadowRepository       repositories.NodeShadowRepository
+	userRepository         repositories.UserRepository
+	organizationRepository repositories.OrganizationRepository
+	webhookHandler         *WebhookHandler
+	validator              *dependencies.Validator
 }
 
-func NewNodeHandler(db *pgxpool.Pool, nodeRepository *repositories.NodeRepository, hardwareRepository *repositories.HardwareRepository, sensorRepository *repositories.SensorRepository, validator *dependencies.Validator) (NodeHandler, error) {
+func NewNodeHandler(db helper.Querier, nodeRepository repositories.NodeRepository, hardwareRepository repositories.HardwareRepository, sensorRepository repositories.SensorRepository, channelRepository repositories.ChannelRepository, commandRepository repositories.NodeCommandRepository, shadowRepository repositories.NodeShadowRepository, userRepository repositories.UserRepository, organizationRepository repositories.OrganizationRepository, webhookHandler *WebhookHandler, validator *dependencies.Validator) (NodeHandler, error) {
 	return NodeHandler{
-		db:                 db,
-		repository:         nodeRepository,
-		hardwareRepository: hardwareRepository,
-		sensorRepository:   sensorRepository,
-		validator:          validator,
+		db:                     db,
+		repository:             nodeRepository,
+		hardwareRepository:     hardwareRepository,
+		sensorRepository:       sensorRepository,
+		channelRepository:      channelRepository,
+		commandRepository:      commandRepository,
+		shadowRepository:       shadowRepository,
+		userRepository:         userRepository,
+		organizationRepository: organizationRepository,
+		webhookHandler:         webhookHandler,
+		validator:              validator,
 	}, nil
 }
 
+// getAccessibleNodeId parse the node id from the url and make sure the request is sent by the node itself
+// or by a member of the node organization with at least the minimum role, isDevice is true when the request
+// use the node API key
+func getAccessibleNodeId(ctx context.Context, c *fiber.Ctx, db helper.Querier, validator *dependencies.Validator, nodeRepository repositories.NodeRepository, organizationRepository repositories.OrganizationRepository, minimumRole string) (id int, isDevice bool, err error) {
+	id, err = validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return 0, false, err
+	}
+
+	device, isDevice := validator.GetDevice(c)
+	if isDevice {
+		if device.IdNode != id {
+			return 0, true, fiber.NewError(403, "Device key can only access its own node")
+		}
+		return id, true, nil
+	}
+
+	node, err := nodeRepository.GetById(ctx, db, id)
+	if err != nil {
+		return 0, false, err
+	}
+
+	currentUser, err := validator.GetAuthentication(c)
+	if err != nil {
+		return 0, false, err
+	}
+
+	err = checkOrganizationRole(ctx, db, organizationRepository, &currentUser, node.IdOrganization, minimumRole, "You can’t access a node of another organization")
+	if err != nil {
+		return 0, false, err
+	}
+
+	return id, false, nil
+}
+
 func (h *NodeHandler) CreateForm(c *fiber.Ctx) (err error) {
 	ctx := context.Background()
 
@@ -39,9 +89,27 @@ func (h *NodeHandler) CreateForm(c *fiber.Ctx) (err error) {
 		return err
 	}
 
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	// Node can only be added to the organization where the user is at least an editor
+	organizations, err := h.organizationRepository.GetAll(ctx, h.db, &currentUser)
+	if err != nil {
+		return err
+	}
+	editableOrganizations := []entities.OrganizationWithRole{}
+	for _, organization := range organizations {
+		if organization.IdPersonalUser == nil && entities.OrganizationRoleAtLeast(organization.Role, entities.OrganizationRoleEditor) {
+			editableOrganizations = append(editableOrganizations, organization)
+		}
+	}
+
 	return c.Render("node_form", fiber.Map{
-		"title":        "Create Node",
-		"nodeHardware": nodeHardware,
+		"title":         "Create Node",
+		"nodeHardware":  nodeHardware,
+		"organizations": editableOrganizations,
 	}, "layouts/main")
 }
 
@@ -89,10 +157,44 @@ func (h *NodeHandler) Create(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	_, err = h.repository.Create(ctx, h.db, &bodyPayload, &currentUser)
+	if bodyPayload.IdOrganization == 0 {
+		organization, err := h.organizationRepository.GetPersonal(ctx, h.db, &currentUser)
+		if err != nil {
+			return err
+		}
+		bodyPayload.IdOrganization = organization.IdOrganization
+	} else {
+		_, err = h.organizationRepository.GetById(ctx, h.db, bodyPayload.IdOrganization)
+		if err != nil {
+			return err
+		}
+
+		err = checkOrganizationMember(ctx, h.db, h.organizationRepository, currentUser.IdUser, bodyPayload.IdOrganization, entities.OrganizationRoleEditor, "You can’t add a node to another organization")
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	node, err := h.repository.Create(ctx, tx, &bodyPayload, &currentUser)
+	if err != nil {
+		return err
+	}
+	err = h.webhookHandler.PublishForOrganization(ctx, tx, node.IdOrganization, entities.WebhookEventNodeCreated, node)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
+	h.webhookHandler.Wake()
 
 	return c.Status(fiber.StatusCreated).SendString("Success add new node")
 }
@@ -155,8 +257,9 @@ func (h *NodeHandler) GetById(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	if node.IdUser != currentUser.IdUser && !currentUser.IsAdmin {
-		return fiber.NewError(403, "You can’t see another user’s node")
+	err = checkOrganizationRole(ctx, h.db, h.organizationRepository, &currentUser, node.IdOrganization, entities.OrganizationRoleViewer, "You can’t see a node of another organization")
+	if err != nil {
+		return err
 	}
 
 	hardware, err := h.hardwareRepository.GetById(ctx, h.db, node.IdHardware)
@@ -172,11 +275,23 @@ func (h *NodeHandler) GetById(c *fiber.Ctx) (err error) {
 	accept := c.Accepts("application/json", "text/html")
 	switch accept {
 	case "text/html":
+		commands, err := h.commandRepository.GetHistory(ctx, h.db, node.IdNode)
+		if err != nil {
+			return err
+		}
+
+		shadow, err := h.shadowRepository.GetByNode(ctx, h.db, node.IdNode)
+		if err != nil {
+			return err
+		}
+
 		return c.Render("node_detail", fiber.Map{
 			"title":    "Node Detail",
 			"node":     node,
 			"hardware": hardware,
 			"sensor":   sensors,
+			"commands": commands,
+			"shadow":   shadow,
 		}, "layouts/main")
 	default:
 		return c.Status(fiber.StatusOK).JSON(entities.NodeWithHardwareAndSensors{
@@ -187,6 +302,49 @@ func (h *NodeHandler) GetById(c *fiber.Ctx) (err error) {
 	}
 }
 
+// Export download the channel of every sensor of the node in a single file
+func (h *NodeHandler) Export(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	query := new(entities.ChannelExportQuery)
+	err = h.validator.ParseQuery(c, query)
+	if err != nil {
+		return err
+	}
+
+	node, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	err = checkOrganizationRole(ctx, h.db, h.organizationRepository, &currentUser, node.IdOrganization, entities.OrganizationRoleViewer, "You can’t see a node of another organization")
+	if err != nil {
+		return err
+	}
+
+	sensors, err := h.sensorRepository.GetNodeSensor(ctx, h.db, node.IdNode)
+	if err != nil {
+		return err
+	}
+
+	sensorIds := make([]int, 0, len(sensors))
+	for _, sensor := range sensors {
+		sensorIds = append(sensorIds, sensor.IdSensor)
+	}
+
+	exportChannel(c, h.db, h.channelRepository, sensorIds, query, fmt.Sprintf("node-%d", id))
+	return nil
+}
+
 func (h *NodeHandler) UpdateForm(c *fiber.Ctx) (err error) {
 	id, err := h.validator.ParseIdFromUrlParameter(c)
 	if err != nil {
@@ -235,14 +393,35 @@ func (h *NodeHandler) Update(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	if node.IdUser != currentUser.IdUser && !currentUser.IsAdmin {
-		return fiber.NewError(403, "Can’t edit another user’s data")
+	err = checkOrganizationRole(ctx, h.db, h.organizationRepository, &currentUser, node.IdOrganization, entities.OrganizationRoleEditor, "Can’t edit a node of another organization")
+	if err != nil {
+		return err
 	}
 
-	err = h.repository.Update(ctx, h.db, &node, bodyPayload)
+	tx, err := h.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.Update(ctx, tx, &node, bodyPayload)
+	if err != nil {
+		return err
+	}
+	node.Name = bodyPayload.Name
+	node.Location = bodyPayload.Location
+	node.OfflineTimeout = bodyPayload.OfflineTimeout
+	node.NotifyOffline = *bodyPayload.NotifyOffline
+	err = h.webhookHandler.PublishForOrganization(ctx, tx, node.IdOrganization, entities.WebhookEventNodeUpdated, node)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	h.webhookHandler.Wake()
 
 	return c.Status(fiber.StatusOK).SendString("Success edit node")
 }
@@ -264,14 +443,117 @@ func (h *NodeHandler) Delete(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	if node.IdUser != currentUser.IdUser && !currentUser.IsAdmin {
-		return fiber.NewError(403, "You can’t delete another user’s node")
+	err = checkOrganizationRole(ctx, h.db, h.organizationRepository, &currentUser, node.IdOrganization, entities.OrganizationRoleEditor, "You can’t delete a node of another organization")
+	if err != nil {
+		return err
 	}
 
-	err = h.repository.Delete(ctx, h.db, id)
+	tx, err := h.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.Delete(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	err = h.webhookHandler.PublishForOrganization(ctx, tx, node.IdOrganization, entities.WebhookEventNodeDeleted, node)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	h.webhookHandler.Wake()
 
 	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete node, id: %d", id))
 }
+
+// Heartbeat let a node tell it is still alive without sending any reading
+func (h *NodeHandler) Heartbeat(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, _, err := getAccessibleNodeId(ctx, c, h.db, h.validator, h.repository, h.organizationRepository, entities.OrganizationRoleEditor)
+	if err != nil {
+		return err
+	}
+
+	node, err := h.repository.Touch(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(node)
+}
+
+// OnChannelCreated update the last seen time of the node that send the channels
+func (h *NodeHandler) OnChannelCreated(ctx context.Context, channels []entities.Channel) {
+	sensorIds := make([]int, 0, len(channels))
+	for _, channel := range channels {
+		sensorIds = append(sensorIds, channel.IdSensor)
+	}
+
+	err := h.repository.TouchBySensor(ctx, h.db, sensorIds)
+	if err != nil {
+		log.Printf("[NODE] Failed to update node last seen, %v", err)
+	}
+}
+
+// StartStatusMonitor periodically email the owner of a node with notify_offline when it goes offline or comes back
+func (h *NodeHandler) StartStatusMonitor(ctx context.Context) {
+	config := configs.GetConfig()
+	ticker := time.NewTicker(config.Heartbeat.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		h.checkStatus(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *NodeHandler) checkStatus(ctx context.Context) {
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		log.Printf("[NODE] Failed to check node status, %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	notifications, err := h.repository.CheckStatus(ctx, tx)
+	if err != nil {
+		log.Printf("[NODE] Failed to check node status, %v", err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("[NODE] Failed to check node status, %v", err)
+		return
+	}
+
+	// Every member of the node organization is notified
+	for _, notification := range notifications {
+		members, err := h.organizationRepository.GetMembers(ctx, h.db, notification.IdOrganization)
+		if err != nil {
+			log.Printf("[NODE] Failed to get the member to notify of node %d, %v", notification.IdNode, err)
+			continue
+		}
+		for _, member := range members {
+			notification.Username = member.Username
+			notification.Email = member.Email
+			go func(notification entities.NodeStatusNotification) {
+				err := h.userRepository.SendEmailNodeStatus(context.Background(), notification)
+				if err != nil {
+					log.Printf("[NODE] Failed to send node %d status email to %s, %v", notification.IdNode, notification.Email, err)
+				}
+			}(notification)
+		}
+	}
+}
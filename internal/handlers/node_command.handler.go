@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+)
+
+type NodeCommandHandler struct {
+	db                     helper.Querier
+	repository             repositories.NodeCommandRepository
+	nodeRepository         repositories.NodeRepository
+	organizationRepository repositories.OrganizationRepository
+	mqttHandler            *MqttHandler
+	validator              *dependencies.Validator
+}
+
+func NewNodeCommandHandler(db helper.Querier, nodeCommandRepository repositories.NodeCommandRepository, nodeRepository repositories.NodeRepository, organizationRepository repositories.OrganizationRepository, mqttHandler *MqttHandler, validator *dependencies.Validator) (NodeCommandHandler, error) {
+	return NodeCommandHandler{
+		db:                     db,
+		repository:             nodeCommandRepository,
+		nodeRepository:         nodeRepository,
+		organizationRepository: organizationRepository,
+		mqttHandler:            mqttHandler,
+		validator:              validator,
+	}, nil
+}
+
+func (h *NodeCommandHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, isDevice, err := getAccessibleNodeId(ctx, c, h.db, h.validator, h.nodeRepository, h.organizationRepository, entities.OrganizationRoleEditor)
+	if err != nil {
+		return err
+	}
+	if isDevice {
+		return fiber.NewError(403, "Device can't send command to a node")
+	}
+
+	bodyPayload := entities.NodeCommandCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	command, err := h.repository.Create(ctx, h.db, id, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = h.mqttHandler.PublishCommand(command)
+	if err != nil {
+		// The node can still get the command by polling
+		log.Printf("[MQTT] Failed to push command %d to node %d: %v", command.IdNodeCommand, id, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(command)
+}
+
+// GetAll return the command history of the node to a user, while a node polling with its API key
+// get every command it has not acknowledged yet and those command are marked as delivered
+func (h *NodeCommandHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, isDevice, err := getAccessibleNodeId(ctx, c, h.db, h.validator, h.nodeRepository, h.organizationRepository, entities.OrganizationRoleViewer)
+	if err != nil {
+		return err
+	}
+
+	var commands []entities.NodeCommand
+	if isDevice {
+		commands, err = h.repository.Deliver(ctx, h.db, id)
+	} else {
+		commands, err = h.repository.GetHistory(ctx, h.db, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(commands)
+}
+
+func (h *NodeCommandHandler) Ack(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, _, err := getAccessibleNodeId(ctx, c, h.db, h.validator, h.nodeRepository, h.organizationRepository, entities.OrganizationRoleEditor)
+	if err != nil {
+		return err
+	}
+
+	idNodeCommand, err := c.ParamsInt("idCommand")
+	if err != nil || idNodeCommand <= 0 {
+		return fiber.NewError(400, "idCommand parameter must be a valid positive integer")
+	}
+
+	bodyPayload := entities.NodeCommandAck{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	command, err := h.repository.Ack(ctx, h.db, id, idNodeCommand, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(command)
+}
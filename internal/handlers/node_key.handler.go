@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+)
+
+type NodeKeyHandler struct {
+	db                     helper.Querier
+	repository             repositories.NodeKeyRepository
+	nodeRepository         repositories.NodeRepository
+	organizationRepository repositories.OrganizationRepository
+	validator              *dependencies.Validator
+}
+
+func NewNodeKeyHandler(db helper.Querier, nodeKeyRepository repositories.NodeKeyRepository, nodeRepository repositories.NodeRepository, organizationRepository repositories.OrganizationRepository, validator *dependencies.Validator) (NodeKeyHandler, error) {
+	return NodeKeyHandler{
+		db:                     db,
+		repository:             nodeKeyRepository,
+		nodeRepository:         nodeRepository,
+		organizationRepository: organizationRepository,
+		validator:              validator,
+	}, nil
+}
+
+// getOwnedNodeId parse the node id from the url and make sure the current user is an editor of the node organization
+func (h *NodeKeyHandler) getOwnedNodeId(ctx context.Context, c *fiber.Ctx) (int, error) {
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return 0, err
+	}
+
+	node, err := h.nodeRepository.GetById(ctx, h.db, id)
+	if err != nil {
+		return 0, err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkOrganizationRole(ctx, h.db, h.organizationRepository, &currentUser, node.IdOrganization, entities.OrganizationRoleEditor, "You can’t manage the node key of another organization")
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (h *NodeKeyHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.getOwnedNodeId(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	nodeKeys, err := h.repository.GetNodeKey(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(nodeKeys)
+}
+
+func (h *NodeKeyHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.getOwnedNodeId(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	nodeKey, err := h.repository.Create(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(nodeKey)
+}
+
+// Rotate revoke every active key of the node and create a new one
+func (h *NodeKeyHandler) Rotate(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.getOwnedNodeId(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.RevokeAll(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	nodeKey, err := h.repository.Create(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(nodeKey)
+}
+
+func (h *NodeKeyHandler) Revoke(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.getOwnedNodeId(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	idNodeKey, err := c.ParamsInt("idKey")
+	if err != nil || idNodeKey <= 0 {
+		return fiber.NewError(400, "idKey parameter must be a valid positive integer")
+	}
+
+	err = h.repository.Revoke(ctx, h.db, id, idNodeKey)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success revoke node key, id: %d", idNodeKey))
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+)
+
+type NodeShadowHandler struct {
+	db                     helper.Querier
+	repository             repositories.NodeShadowRepository
+	nodeRepository         repositories.NodeRepository
+	organizationRepository repositories.OrganizationRepository
+	mqttHandler            *MqttHandler
+	validator              *dependencies.Validator
+}
+
+func NewNodeShadowHandler(db helper.Querier, nodeShadowRepository repositories.NodeShadowRepository, nodeRepository repositories.NodeRepository, organizationRepository repositories.OrganizationRepository, mqttHandler *MqttHandler, validator *dependencies.Validator) (NodeShadowHandler, error) {
+	return NodeShadowHandler{
+		db:                     db,
+		repository:             nodeShadowRepository,
+		nodeRepository:         nodeRepository,
+		organizationRepository: organizationRepository,
+		mqttHandler:            mqttHandler,
+		validator:              validator,
+	}, nil
+}
+
+func (h *NodeShadowHandler) toDelta(shadow entities.NodeShadowWithDelta) entities.NodeShadowDelta {
+	return entities.NodeShadowDelta{
+		IdNode:  shadow.IdNode,
+		Version: shadow.Version,
+		Delta:   shadow.Delta,
+		InSync:  shadow.InSync,
+	}
+}
+
+func (h *NodeShadowHandler) GetById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, _, err := getAccessibleNodeId(ctx, c, h.db, h.validator, h.nodeRepository, h.organizationRepository, entities.OrganizationRoleViewer)
+	if err != nil {
+		return err
+	}
+
+	shadow, err := h.repository.GetByNode(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(shadow)
+}
+
+// GetDelta return only the desired state that the node has not reported yet
+func (h *NodeShadowHandler) GetDelta(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, _, err := getAccessibleNodeId(ctx, c, h.db, h.validator, h.nodeRepository, h.organizationRepository, entities.OrganizationRoleViewer)
+	if err != nil {
+		return err
+	}
+
+	shadow, err := h.repository.GetByNode(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(h.toDelta(shadow))
+}
+
+func (h *NodeShadowHandler) UpdateDesired(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, isDevice, err := getAccessibleNodeId(ctx, c, h.db, h.validator, h.nodeRepository, h.organizationRepository, entities.OrganizationRoleEditor)
+	if err != nil {
+		return err
+	}
+	if isDevice {
+		return fiber.NewError(403, "Device can only update the reported state")
+	}
+
+	bodyPayload := entities.NodeShadowDesiredUpdate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	shadow, err := h.repository.UpdateDesired(ctx, tx, id, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = h.mqttHandler.PublishShadowDelta(h.toDelta(shadow))
+	if err != nil {
+		// The node can still get the delta by polling
+		log.Printf("[MQTT] Failed to push shadow delta to node %d: %v", id, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(shadow)
+}
+
+func (h *NodeShadowHandler) Report(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, _, err := getAccessibleNodeId(ctx, c, h.db, h.validator, h.nodeRepository, h.organizationRepository, entities.OrganizationRoleEditor)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := entities.NodeShadowReport{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	shadow, err := h.repository.Report(ctx, tx, id, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = h.mqttHandler.PublishShadowDelta(h.toDelta(shadow))
+	if err != nil {
+		log.Printf("[MQTT] Failed to push shadow delta to node %d: %v", id, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(shadow)
+}
@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+)
+
+// checkOrganizationMember make sure the user is a member of the organization with at least the minimum role,
+// message is returned when the user is not a member at all
+func checkOrganizationMember(ctx context.Context, db helper.Querier, organizationRepository repositories.OrganizationRepository, idUser int, idOrganization int, minimumRole string, message string) error {
+	role, err := organizationRepository.GetRole(ctx, db, idOrganization, idUser)
+	if err != nil {
+		return err
+	}
+
+	if role == "" {
+		return fiber.NewError(403, message)
+	}
+	if !entities.OrganizationRoleAtLeast(role, minimumRole) {
+		return fiber.NewError(403, fmt.Sprintf("Your role in the organization is %s, %s is required", role, minimumRole))
+	}
+	return nil
+}
+
+// checkOrganizationRole is checkOrganizationMember that also let an admin through
+func checkOrganizationRole(ctx context.Context, db helper.Querier, organizationRepository repositories.OrganizationRepository, currentUser *entities.UserRead, idOrganization int, minimumRole string, message string) error {
+	if currentUser.IsAdmin {
+		return nil
+	}
+	return checkOrganizationMember(ctx, db, organizationRepository, currentUser.IdUser, idOrganization, minimumRole, message)
+}
+
+type OrganizationHandler struct {
+	db             helper.Querier
+	repository     repositories.OrganizationRepository
+	nodeRepository repositories.NodeRepository
+	userRepository repositories.UserRepository
+	validator      *dependencies.Validator
+}
+
+func NewOrganizationHandler(db helper.Querier, organizationRepository repositories.OrganizationRepository, nodeRepository repositories.NodeRepository, userRepository repositories.UserRepository, validator *dependencies.Validator) (OrganizationHandler, error) {
+	return OrganizationHandler{
+		db:             db,
+		repository:     organizationRepository,
+		nodeRepository: nodeRepository,
+		userRepository: userRepository,
+		validator:      validator,
+	}, nil
+}
+
+// getAccessibleOrganization get the organization from the url id and check that the current user
+// has at least the minimum role in it
+func (h *OrganizationHandler) getAccessibleOrganization(ctx context.Context, c *fiber.Ctx, minimumRole string) (organization entities.OrganizationWithRole, err error) {
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return organization, err
+	}
+
+	organization.Organization, err = h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return organization, err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return organization, err
+	}
+
+	err = checkOrganizationRole(ctx, h.db, h.repository, &currentUser, id, minimumRole, "You are not a member of the organization")
+	if err != nil {
+		return organization, err
+	}
+
+	organization.Role, err = h.repository.GetRole(ctx, h.db, id, currentUser.IdUser)
+	if err != nil {
+		return organization, err
+	}
+
+	return organization, nil
+}
+
+// parseIdUserFromUrlParameter parse the id of the member from the url
+func (h *OrganizationHandler) parseIdUserFromUrlParameter(c *fiber.Ctx) (int, error) {
+	idUser, err := c.ParamsInt("idUser")
+	if err != nil || idUser <= 0 {
+		return 0, fiber.NewError(400, "idUser parameter must be a valid positive integer")
+	}
+	return idUser, nil
+}
+
+// checkLastOwner make sure the organization still has an owner after the member stop being one
+func (h *OrganizationHandler) checkLastOwner(ctx context.Context, tx helper.Querier, organization *entities.OrganizationWithRole, idUser int) error {
+	if organization.IdPersonalUser != nil && *organization.IdPersonalUser == idUser {
+		return fiber.NewError(400, "The user of a personal organization must stay its owner")
+	}
+
+	members, err := h.repository.GetMembers(ctx, tx, organization.IdOrganization)
+	if err != nil {
+		return err
+	}
+
+	isOwner := false
+	ownerCount := 0
+	for _, member := range members {
+		if member.Role == entities.OrganizationRoleOwner {
+			ownerCount++
+			isOwner = isOwner || member.IdUser == idUser
+		}
+	}
+	if isOwner && ownerCount == 1 {
+		return fiber.NewError(400, "Organization must have at least one owner")
+	}
+	return nil
+}
+
+func (h *OrganizationHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.OrganizationCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	organization, err := h.repository.Create(ctx, h.db, &bodyPayload, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(organization)
+}
+
+func (h *OrganizationHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	// Make sure a user who never created a node still see the organization their node will go to
+	_, err = h.repository.GetPersonal(ctx, h.db, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	organizations, err := h.repository.GetAll(ctx, h.db, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("organization", fiber.Map{
+			"title":         "Organization",
+			"organizations": organizations,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(organizations)
+	}
+}
+
+func (h *OrganizationHandler) GetById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	organization, err := h.getAccessibleOrganization(ctx, c, entities.OrganizationRoleViewer)
+	if err != nil {
+		return err
+	}
+
+	members, err := h.repository.GetMembers(ctx, h.db, organization.IdOrganization)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := h.nodeRepository.GetOrganizationNode(ctx, h.db, organization.IdOrganization)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("organization_detail", fiber.Map{
+			"title":        "Organization Detail",
+			"organization": organization,
+			"members":      members,
+			"nodes":        nodes,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(entities.OrganizationWithMemberAndNode{
+			OrganizationWithRole: organization,
+			Member:               members,
+			Node:                 nodes,
+		})
+	}
+}
+
+func (h *OrganizationHandler) Update(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.OrganizationUpdate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	organization, err := h.getAccessibleOrganization(ctx, c, entities.OrganizationRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Update(ctx, h.db, &organization.Organization, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit organization")
+}
+
+// Delete remove an organization that doesn't own any node anymore, a personal organization is only
+// deleted together with its user
+func (h *OrganizationHandler) Delete(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	organization, err := h.getAccessibleOrganization(ctx, c, entities.OrganizationRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	if organization.IdPersonalUser != nil {
+		return fiber.NewError(400, "Personal organization can't be deleted")
+	}
+
+	nodes, err := h.nodeRepository.GetOrganizationNode(ctx, h.db, organization.IdOrganization)
+	if err != nil {
+		return err
+	}
+	if len(nodes) > 0 {
+		return fiber.NewError(400, fmt.Sprintf("Organization still own %d node, delete them first", len(nodes)))
+	}
+
+	err = h.repository.Delete(ctx, h.db, organization.IdOrganization)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete organization, id: %d", organization.IdOrganization))
+}
+
+func (h *OrganizationHandler) GetMembers(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	organization, err := h.getAccessibleOrganization(ctx, c, entities.OrganizationRoleViewer)
+	if err != nil {
+		return err
+	}
+
+	members, err := h.repository.GetMembers(ctx, h.db, organization.IdOrganization)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(members)
+}
+
+func (h *OrganizationHandler) AddMember(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.OrganizationMemberCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	organization, err := h.getAccessibleOrganization(ctx, c, entities.OrganizationRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.userRepository.GetByUsername(ctx, h.db, bodyPayload.Username)
+	if err != nil {
+		return err
+	}
+
+	role, err := h.repository.GetRole(ctx, h.db, organization.IdOrganization, user.IdUser)
+	if err != nil {
+		return err
+	}
+	if role != "" {
+		return fiber.NewError(400, fmt.Sprintf("User %s is already a member of the organization", user.Username))
+	}
+
+	err = h.repository.AddMember(ctx, h.db, organization.IdOrganization, user.IdUser, bodyPayload.Role)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).SendString(fmt.Sprintf("Success add %s as %s", user.Username, bodyPayload.Role))
+}
+
+func (h *OrganizationHandler) UpdateMember(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.OrganizationMemberUpdate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	idUser, err := h.parseIdUserFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	organization, err := h.getAccessibleOrganization(ctx, c, entities.OrganizationRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if bodyPayload.Role != entities.OrganizationRoleOwner {
+		err = h.checkLastOwner(ctx, tx, &organization, idUser)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = h.repository.UpdateMemberRole(ctx, tx, organization.IdOrganization, idUser, bodyPayload.Role)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit member")
+}
+
+// RemoveMember let an owner remove any member and any member leave the organization
+func (h *OrganizationHandler) RemoveMember(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	idUser, err := h.parseIdUserFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	minimumRole := entities.OrganizationRoleOwner
+	if idUser == currentUser.IdUser {
+		minimumRole = entities.OrganizationRoleViewer
+	}
+	organization, err := h.getAccessibleOrganization(ctx, c, minimumRole)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.checkLastOwner(ctx, tx, &organization, idUser)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.RemoveMember(ctx, tx, organization.IdOrganization, idUser)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success remove member, id: %d", idUser))
+}
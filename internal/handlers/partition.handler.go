@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+)
+
+type PartitionHandler struct {
+	db             helper.Querier
+	repository     repositories.PartitionRepository
+	userRepository repositories.UserRepository
+	validator      *dependencies.Validator
+}
+
+func NewPartitionHandler(db helper.Querier, partitionRepository repositories.PartitionRepository, userRepository repositories.UserRepository, validator *dependencies.Validator) (PartitionHandler, error) {
+	return PartitionHandler{
+		db:             db,
+		repository:     partitionRepository,
+		userRepository: userRepository,
+		validator:      validator,
+	}, nil
+}
+
+func (h *PartitionHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	partitions, err := h.repository.GetAll(ctx, h.db)
+	if err != nil {
+		return err
+	}
+
+	report := entities.ChannelPartitionReport{Partitions: partitions}
+	for _, partition := range partitions {
+		report.TotalRows += partition.Rows
+		report.TotalSize += partition.Size
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		config := configs.GetConfig()
+		return c.Render("partition", fiber.Map{
+			"title":      "Partition",
+			"report":     report,
+			"interval":   config.Partition.Interval,
+			"retention":  config.Partition.Retention.String(),
+			"detachOnly": config.Partition.DetachOnly,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(report)
+	}
+}
+
+// UpdateUserRetention set the retention of the user in the url, it is applied on the next maintenance
+func (h *PartitionHandler) UpdateUserRetention(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	bodyPayload := entities.UserRetentionUpdate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	err = h.userRepository.UpdateRetention(ctx, h.db, id, bodyPayload.RetentionDays)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success update user retention")
+}
+
+// StartMaintenance periodically create the upcoming channel partition and remove the expired channel
+func (h *PartitionHandler) StartMaintenance(ctx context.Context) {
+	config := configs.GetConfig()
+	ticker := time.NewTicker(config.Partition.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		h.maintain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *PartitionHandler) maintain(ctx context.Context) {
+	config := configs.GetConfig()
+	partitions, err := h.repository.GetAll(ctx, h.db)
+	if err != nil {
+		log.Printf("[PARTITION] Failed to get partition, %v", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	interval := config.Partition.Interval
+
+	// Continue after the latest partition so changing the interval never create an overlapping range
+	start := entities.TruncatePartitionTime(now, interval)
+	for _, partition := range partitions {
+		if partition.To != nil && partition.To.After(start) {
+			start = *partition.To
+		}
+	}
+	until := now
+	for i := 0; i < config.Partition.Premake; i++ {
+		until = entities.NextPartitionTime(until, interval)
+	}
+	for start.Before(until) {
+		end := entities.NextPartitionTime(start, interval)
+		name, err := h.repository.Create(ctx, h.db, start, end)
+		if err != nil {
+			// Happen when the default partition already contain channel in this range
+			log.Printf("[PARTITION] Failed to create partition %s, %v", name, err)
+			break
+		}
+		log.Printf("[PARTITION] Created partition %s", name)
+		start = end
+	}
+
+	if config.Partition.Retention > 0 {
+		cutoff := now.Add(-config.Partition.Retention)
+		for _, partition := range partitions {
+			if partition.IsDefault || partition.To.After(cutoff) {
+				continue
+			}
+
+			if config.Partition.DetachOnly {
+				archiveName, err := h.repository.Detach(ctx, h.db, partition.Name)
+				if err != nil {
+					log.Printf("[PARTITION] Failed to detach partition %s, %v", partition.Name, err)
+					continue
+				}
+				log.Printf("[PARTITION] Detached partition %s as %s", partition.Name, archiveName)
+			} else {
+				err := h.repository.Drop(ctx, h.db, partition.Name)
+				if err != nil {
+					log.Printf("[PARTITION] Failed to drop partition %s, %v", partition.Name, err)
+					continue
+				}
+				log.Printf("[PARTITION] Dropped partition %s", partition.Name)
+			}
+		}
+
+		count, err := h.repository.DeleteDefaultBefore(ctx, h.db, cutoff)
+		if err != nil {
+			log.Printf("[PARTITION] Failed to delete expired channel in default partition, %v", err)
+		} else if count > 0 {
+			log.Printf("[PARTITION] Deleted %d expired channel in default partition", count)
+		}
+	}
+
+	count, err := h.repository.DeleteExpiredUserChannel(ctx, h.db)
+	if err != nil {
+		log.Printf("[PARTITION] Failed to delete channel past user retention, %v", err)
+	} else if count > 0 {
+		log.Printf("[PARTITION] Deleted %d channel past user retention", count)
+	}
+}
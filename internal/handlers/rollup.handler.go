@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+)
+
+// RollupHandler keep the hourly and daily rollup of the channel up to date
+type RollupHandler struct {
+	db         helper.Querier
+	repository repositories.RollupRepository
+}
+
+func NewRollupHandler(db helper.Querier, rollupRepository repositories.RollupRepository) (RollupHandler, error) {
+	return RollupHandler{
+		db:         db,
+		repository: rollupRepository,
+	}, nil
+}
+
+// StartWorker periodically recompute every pending hour
+func (h *RollupHandler) StartWorker(ctx context.Context) {
+	config := configs.GetConfig()
+	ticker := time.NewTicker(config.Rollup.Interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := h.RefreshAll(ctx)
+		if err != nil {
+			log.Printf("[ROLLUP] Failed to refresh rollup, %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RefreshAll recompute pending hour batch by batch until there is none left, it return the number of hour refreshed
+func (h *RollupHandler) RefreshAll(ctx context.Context) (total int, err error) {
+	config := configs.GetConfig()
+	for {
+		count, err := h.refresh(ctx, config.Rollup.BatchSize)
+		if err != nil {
+			return total, err
+		}
+		total += count
+		if count < config.Rollup.BatchSize {
+			return total, nil
+		}
+	}
+}
+
+func (h *RollupHandler) refresh(ctx context.Context, limit int) (count int, err error) {
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	count, err = h.repository.RefreshPending(ctx, tx, limit)
+	if err != nil {
+		return 0, err
+	}
+	return count, tx.Commit(ctx)
+}
+
+// Backfill recompute the rollup of every hour that have channel in the range, idSensor limit it to a single sensor
+func (h *RollupHandler) Backfill(ctx context.Context, idSensor *int, from *time.Time, to *time.Time) (total int, err error) {
+	_, err = h.repository.MarkPendingRange(ctx, h.db, idSensor, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return h.RefreshAll(ctx)
+}
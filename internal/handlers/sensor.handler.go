@@ -1,34 +1,46 @@
 package handlers
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/dafaath/iot-server/configs"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dafaath/iot-server/internal/dependencies"
 	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
 	"github.com/dafaath/iot-server/internal/repositories"
 	"github.com/gofiber/fiber/v2"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type SensorHandler struct {
-	db                 *pgxpool.Pool
-	repository         *repositories.SensorRepository
-	hardwareRepository *repositories.HardwareRepository
-	nodeRepository     *repositories.NodeRepository
-	validator          *dependencies.Validator
+	db                     helper.Querier
+	repository             repositories.SensorRepository
+	hardwareRepository     repositories.HardwareRepository
+	nodeRepository         repositories.NodeRepository
+	channelRepository      repositories.ChannelRepository
+	organizationRepository repositories.OrganizationRepository
+	webhookHandler         *WebhookHandler
+	channelHub             *dependencies.ChannelHub
+	validator              *dependencies.Validator
 }
 
-func NewSensorHandler(db *pgxpool.Pool, sensorRepository *repositories.SensorRepository, hardwareRepository *repositories.HardwareRepository, nodeRepository *repositories.NodeRepository, validator *dependencies.Validator) (SensorHandler, error) {
+func NewSensorHandler(db helper.Querier, sensorRepository repositories.SensorRepository, hardwareRepository repositories.HardwareRepository, nodeRepository repositories.NodeRepository, channelRepository repositories.ChannelRepository, organizationRepository repositories.OrganizationRepository, webhookHandler *WebhookHandler, channelHub *dependencies.ChannelHub, validator *dependencies.Validator) (SensorHandler, error) {
 	return SensorHandler{
-		db:                 db,
-		repository:         sensorRepository,
-		hardwareRepository: hardwareRepository,
-		nodeRepository:     nodeRepository,
-		validator:          validator,
+		db:                     db,
+		repository:             sensorRepository,
+		hardwareRepository:     hardwareRepository,
+		nodeRepository:         nodeRepository,
+		channelRepository:      channelRepository,
+		organizationRepository: organizationRepository,
+		webhookHandler:         webhookHandler,
+		channelHub:             channelHub,
+		validator:              validator,
 	}, nil
 }
 
@@ -86,14 +98,31 @@ func (h *SensorHandler) Create(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	if currentUser.IdUser != node.IdUser {
-		return fiber.NewError(403, "You can’t use other user’s node")
+	err = checkOrganizationMember(ctx, h.db, h.organizationRepository, currentUser.IdUser, node.IdOrganization, entities.OrganizationRoleEditor, "You can’t use a node of another organization")
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	sensor, err := h.repository.Create(ctx, tx, &bodyPayload)
+	if err != nil {
+		return err
+	}
+	err = h.webhookHandler.PublishForOrganization(ctx, tx, node.IdOrganization, entities.WebhookEventSensorCreated, sensor)
+	if err != nil {
+		return err
 	}
 
-	_, err = h.repository.Create(ctx, h.db, &bodyPayload)
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
+	h.webhookHandler.Wake()
 
 	return c.Status(fiber.StatusCreated).SendString("Success add new sensor")
 }
@@ -127,6 +156,78 @@ func (h *SensorHandler) GetAll(c *fiber.Ctx) (err error) {
 	}
 }
 
+// checkCanSeeSensor make sure the current user is a member of the sensor organization or is an admin
+func (h *SensorHandler) checkCanSeeSensor(ctx context.Context, c *fiber.Ctx, id int) error {
+	sensorOwner, err := h.repository.GetSensorOwnerById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	return checkOrganizationRole(ctx, h.db, h.organizationRepository, &currentUser, sensorOwner.IdOrganization, entities.OrganizationRoleViewer, "You can’t see a sensor of another organization")
+}
+
+// downsampleBuckets are the bucket that can be chosen when the chart has too many point
+var downsampleBuckets = []string{"1m", "5m", "15m", "30m", "1h", "3h", "6h", "12h", "1d", "7d", "30d"}
+
+// maxChartPoint is the number of point the sensor chart can show before it is downsampled
+const maxChartPoint = 1000
+
+// getDownsampledChannel return the average of every bucket in the whole query range when the range contains
+// more than maxChartPoint channel. The bucket is empty when no downsampling is needed.
+func (h *SensorHandler) getDownsampledChannel(ctx context.Context, id int, query *entities.ChannelQuery) (mappedChannel []interface{}, bucket string, err error) {
+	mappedChannel = []interface{}{}
+	span, err := h.repository.GetSensorChannelSpan(ctx, h.db, id, query.From, query.To)
+	if err != nil {
+		return mappedChannel, bucket, err
+	}
+
+	if span.Count <= maxChartPoint || span.From == nil || span.To == nil {
+		return mappedChannel, bucket, nil
+	}
+
+	// Pick the smallest bucket that make the chart fit in maxChartPoint
+	timeRange := span.To.Sub(*span.From)
+	for _, downsampleBucket := range downsampleBuckets {
+		bucket = downsampleBucket
+		duration, err := helper.ParseBucket(downsampleBucket)
+		if err != nil {
+			return mappedChannel, "", err
+		}
+		if timeRange/duration < maxChartPoint {
+			break
+		}
+	}
+
+	duration, err := helper.ParseBucket(bucket)
+	if err != nil {
+		return mappedChannel, "", err
+	}
+
+	aggregates, err := h.repository.GetSensorChannelAggregate(ctx, h.db, id, duration, &entities.ChannelAggregateQuery{
+		Bucket:    bucket,
+		Functions: []string{"avg"},
+		From:      query.From,
+		To:        query.To,
+	})
+	if err != nil {
+		return mappedChannel, "", err
+	}
+
+	for _, aggregate := range aggregates {
+		mappedChannel = append(mappedChannel, []interface{}{
+			aggregate.Time.UnixMilli(),
+			aggregate.Values["avg"],
+		})
+	}
+
+	return mappedChannel, bucket, nil
+}
+
 func (h *SensorHandler) GetById(c *fiber.Ctx) (err error) {
 	ctx := context.Background()
 	id, err := h.validator.ParseIdFromUrlParameter(c)
@@ -134,44 +235,57 @@ func (h *SensorHandler) GetById(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	sensor, err := h.repository.GetById(ctx, h.db, id)
+	query := new(entities.ChannelQuery)
+	err = h.validator.ParseQuery(c, query)
 	if err != nil {
 		return err
 	}
 
-	channels, err := h.repository.GetSensorChannel(ctx, h.db, id)
+	sensor, err := h.repository.GetById(ctx, h.db, id)
 	if err != nil {
 		return err
 	}
 
-	sensorOwnerId, err := h.repository.GetIdUserWhoOwnSensorById(ctx, h.db, id)
+	err = h.checkCanSeeSensor(ctx, c, id)
 	if err != nil {
 		return err
 	}
 
-	currentUser, err := h.validator.GetAuthentication(c)
+	channelPage, err := h.repository.GetSensorChannel(ctx, h.db, id, query)
 	if err != nil {
 		return err
 	}
-
-	if sensorOwnerId != currentUser.IdUser && !currentUser.IsAdmin {
-		return fiber.NewError(403, "You can’t see another user’s sensor")
-	}
+	channels := channelPage.Channel
 
 	accept := c.Accepts("application/json", "text/html")
 	switch accept {
 	case "text/html":
-		sort.Slice(channels, func(i, j int) bool {
-			return channels[i].Time.Before(channels[j].Time)
-		})
+		downsample, err := strconv.ParseBool(c.Query("downsample", "true"))
+		if err != nil {
+			return fiber.NewError(400, "downsample must be a boolean")
+		}
 
 		mappedChannel := []interface{}{}
-		for _, channel := range channels {
-			// Convert time to epoch milliseconds
-			mappedChannel = append(mappedChannel, []interface{}{
-				channel.Time.UnixMilli(),
-				channel.Value,
+		bucket := ""
+		if downsample {
+			mappedChannel, bucket, err = h.getDownsampledChannel(ctx, id, query)
+			if err != nil {
+				return err
+			}
+		}
+
+		if bucket == "" {
+			sort.Slice(channels, func(i, j int) bool {
+				return channels[i].Time.Before(channels[j].Time)
 			})
+
+			for _, channel := range channels {
+				// Convert time to epoch milliseconds
+				mappedChannel = append(mappedChannel, []interface{}{
+					channel.Time.UnixMilli(),
+					channel.Value,
+				})
+			}
 		}
 
 		channelJSONString, err := json.Marshal(mappedChannel)
@@ -183,16 +297,107 @@ func (h *SensorHandler) GetById(c *fiber.Ctx) (err error) {
 			"title":   "Sensor Detail",
 			"sensor":  sensor,
 			"channel": string(channelJSONString),
+			"bucket":  bucket,
 		}, "layouts/main")
 	default:
 		sensorWithChannelItem := entities.SensorWithChannel{
-			Sensor:  sensor,
-			Channel: channels,
+			Sensor:     sensor,
+			Channel:    channels,
+			NextCursor: channelPage.NextCursor,
 		}
 		return c.Status(fiber.StatusOK).JSON(sensorWithChannelItem)
 	}
 }
 
+func (h *SensorHandler) GetChannel(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	query := new(entities.ChannelQuery)
+	err = h.validator.ParseQuery(c, query)
+	if err != nil {
+		return err
+	}
+
+	err = h.checkCanSeeSensor(ctx, c, id)
+	if err != nil {
+		return err
+	}
+
+	channelPage, err := h.repository.GetSensorChannel(ctx, h.db, id, query)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(channelPage)
+}
+
+// Export download every channel of the sensor as csv or json lines
+func (h *SensorHandler) Export(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	query := new(entities.ChannelExportQuery)
+	err = h.validator.ParseQuery(c, query)
+	if err != nil {
+		return err
+	}
+
+	err = h.checkCanSeeSensor(ctx, c, id)
+	if err != nil {
+		return err
+	}
+
+	exportChannel(c, h.db, h.channelRepository, []int{id}, query, fmt.Sprintf("sensor-%d", id))
+	return nil
+}
+
+func (h *SensorHandler) GetAggregate(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	query := new(entities.ChannelAggregateQuery)
+	err = h.validator.ParseQuery(c, query)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := helper.ParseBucket(query.Bucket)
+	if err != nil {
+		return fiber.NewError(400, err.Error())
+	}
+
+	sensor, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	err = h.checkCanSeeSensor(ctx, c, id)
+	if err != nil {
+		return err
+	}
+
+	aggregates, err := h.repository.GetSensorChannelAggregate(ctx, h.db, id, bucket, query)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(entities.SensorWithAggregate{
+		Sensor:    sensor,
+		Bucket:    query.Bucket,
+		Aggregate: aggregates,
+	})
+}
+
 func (h *SensorHandler) UpdateForm(c *fiber.Ctx) (err error) {
 	id, err := h.validator.ParseIdFromUrlParameter(c)
 	if err != nil {
@@ -247,7 +452,7 @@ func (h *SensorHandler) Update(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	sensorOwnerId, err := h.repository.GetIdUserWhoOwnSensorById(ctx, h.db, id)
+	sensorOwner, err := h.repository.GetSensorOwnerById(ctx, h.db, id)
 	if err != nil {
 		return err
 	}
@@ -257,14 +462,33 @@ func (h *SensorHandler) Update(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	if sensorOwnerId != currentUser.IdUser && !currentUser.IsAdmin {
-		return fiber.NewError(403, "You can’t edit another user’s sensor")
+	err = checkOrganizationRole(ctx, h.db, h.organizationRepository, &currentUser, sensorOwner.IdOrganization, entities.OrganizationRoleEditor, "You can’t edit a sensor of another organization")
+	if err != nil {
+		return err
 	}
 
-	err = h.repository.Update(ctx, h.db, &sensor, bodyPayload)
+	tx, err := h.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.Update(ctx, tx, &sensor, bodyPayload)
+	if err != nil {
+		return err
+	}
+	sensor.Name = bodyPayload.Name
+	sensor.Unit = bodyPayload.Unit
+	err = h.webhookHandler.PublishForOrganization(ctx, tx, sensorOwner.IdOrganization, entities.WebhookEventSensorUpdated, sensor)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	h.webhookHandler.Wake()
 
 	return c.Status(fiber.StatusOK).SendString("Success edit sensor")
 }
@@ -276,7 +500,7 @@ func (h *SensorHandler) Delete(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	sensorOwnerId, err := h.repository.GetIdUserWhoOwnSensorById(ctx, h.db, id)
+	sensorOwner, err := h.repository.GetSensorOwnerById(ctx, h.db, id)
 	if err != nil {
 		return err
 	}
@@ -286,14 +510,90 @@ func (h *SensorHandler) Delete(c *fiber.Ctx) (err error) {
 		return err
 	}
 
-	if sensorOwnerId != currentUser.IdUser && !currentUser.IsAdmin {
-		return fiber.NewError(403, "You can't delete another user's sensor")
+	err = checkOrganizationRole(ctx, h.db, h.organizationRepository, &currentUser, sensorOwner.IdOrganization, entities.OrganizationRoleEditor, "You can't delete a sensor of another organization")
+	if err != nil {
+		return err
+	}
+
+	sensor, err := h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = h.repository.Delete(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	err = h.webhookHandler.PublishForOrganization(ctx, tx, sensorOwner.IdOrganization, entities.WebhookEventSensorDeleted, sensor)
+	if err != nil {
+		return err
 	}
 
-	err = h.repository.Delete(ctx, h.db, id)
+	err = tx.Commit(ctx)
 	if err != nil {
 		return err
 	}
+	h.webhookHandler.Wake()
 
 	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete sensor, id: %d", id))
 }
+
+// Stream push every new channel of the sensor as a server-sent event until the client disconnect
+func (h *SensorHandler) Stream(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.checkCanSeeSensor(ctx, c, id)
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	config := configs.GetConfig()
+	subscription := h.channelHub.Subscribe(id)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer h.channelHub.Unsubscribe(subscription)
+		keepAlive := time.NewTicker(config.Stream.KeepAliveInterval)
+		defer keepAlive.Stop()
+
+		fmt.Fprint(w, "retry: 5000\n\n")
+		if w.Flush() != nil {
+			return
+		}
+
+		for {
+			select {
+			case channels := <-subscription.C:
+				for _, channel := range channels {
+					data, err := json.Marshal(channel)
+					if err != nil {
+						return
+					}
+					fmt.Fprintf(w, "event: channel\ndata: %s\n\n", data)
+				}
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			}
+
+			// Flush fail once the client is gone
+			if w.Flush() != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
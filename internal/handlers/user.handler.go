@@ -4,26 +4,52 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"time"
 
+	"github.com/dafaath/iot-server/configs"
 	"github.com/dafaath/iot-server/internal/dependencies"
 	"github.com/dafaath/iot-server/internal/entities"
 	"github.com/dafaath/iot-server/internal/helper"
 	"github.com/dafaath/iot-server/internal/repositories"
 	"github.com/gofiber/fiber/v2"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type UserHandler struct {
-	db         *pgxpool.Pool
-	repository *repositories.UserRepository
-	validator  *dependencies.Validator
+	db                      helper.Querier
+	repository              repositories.UserRepository
+	refreshTokenRepository  repositories.RefreshTokenRepository
+	passwordResetRepository repositories.PasswordResetRepository
+	validator               *dependencies.Validator
 }
 
-func NewUserHandler(db *pgxpool.Pool, userRepository *repositories.UserRepository, validator *dependencies.Validator) (UserHandler, error) {
+func NewUserHandler(db helper.Querier, userRepository repositories.UserRepository, refreshTokenRepository repositories.RefreshTokenRepository, passwordResetRepository repositories.PasswordResetRepository, validator *dependencies.Validator) (UserHandler, error) {
 	return UserHandler{
-		db:         db,
-		validator:  validator,
-		repository: userRepository,
+		db:                      db,
+		validator:               validator,
+		repository:              userRepository,
+		refreshTokenRepository:  refreshTokenRepository,
+		passwordResetRepository: passwordResetRepository,
+	}, nil
+}
+
+// issueToken create a new access token and refresh token pair for the user
+func (u *UserHandler) issueToken(ctx context.Context, tx helper.Querier, user entities.UserRead) (userToken entities.UserToken, err error) {
+	config := configs.GetConfig()
+	accessToken, err := u.repository.SignJWT(ctx, user)
+	if err != nil {
+		return userToken, err
+	}
+
+	refreshToken, err := u.refreshTokenRepository.Create(ctx, tx, user.IdUser)
+	if err != nil {
+		return userToken, err
+	}
+
+	return entities.UserToken{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(config.JWT.AccessTokenTTL.Seconds()),
 	}, nil
 }
 
@@ -105,12 +131,93 @@ func (u *UserHandler) Login(c *fiber.Ctx) (err error) {
 		return fiber.NewError(401, "Username or password is incorrect")
 	}
 
-	token, err := u.repository.SignJWT(ctx, user)
+	userToken, err := u.issueToken(ctx, u.db, user)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(userToken)
+}
+
+// Refresh exchange a refresh token with a new token pair, the used refresh token is revoked.
+// Using a revoked refresh token again revoke every refresh token of the user because it may be stolen.
+func (u *UserHandler) Refresh(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := new(entities.UserRefreshToken)
+	err = u.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	refreshToken, err := u.refreshTokenRepository.GetByToken(ctx, u.db, bodyPayload.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	if refreshToken.RevokedAt != nil {
+		err = u.refreshTokenRepository.RevokeAllByUser(ctx, u.db, refreshToken.IdUser)
+		if err != nil {
+			return err
+		}
+		return fiber.NewError(401, "Refresh token has been revoked, please login again")
+	}
+
+	if time.Now().UTC().After(refreshToken.ExpiresAt) {
+		return fiber.NewError(401, "Refresh token is expired, please login again")
+	}
+
+	user, err := u.repository.GetById(ctx, u.db, refreshToken.IdUser)
+	if err != nil {
+		return err
+	}
+
+	if !user.Status {
+		return fiber.NewError(400, "Account is inactive, check email for activation")
+	}
+
+	tx, err := u.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = u.refreshTokenRepository.Revoke(ctx, tx, refreshToken.IdRefreshToken)
+	if err != nil {
+		return err
+	}
+
+	userToken, err := u.issueToken(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(userToken)
+}
+
+func (u *UserHandler) Logout(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := new(entities.UserRefreshToken)
+	err = u.validator.ParseBody(c, bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	refreshToken, err := u.refreshTokenRepository.GetByToken(ctx, u.db, bodyPayload.RefreshToken)
 	if err != nil {
 		return err
 	}
 
-	return c.Status(fiber.StatusOK).SendString(token)
+	err = u.refreshTokenRepository.Revoke(ctx, u.db, refreshToken.IdRefreshToken)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success logout")
 }
 
 func (u *UserHandler) Activation(c *fiber.Ctx) (err error) {
@@ -166,8 +273,8 @@ func (u *UserHandler) ForgotPassword(c *fiber.Ctx) (err error) {
 		return fiber.NewError(403, "Your account is inactive. Check your email for activation")
 	}
 
-	newPassword := helper.GenerateRandomString(8)
-	err = u.repository.UpdatePassword(ctx, u.db, user.IdUser, newPassword)
+	// The password is only changed once the user open the link and submit a new one
+	resetToken, err := u.passwordResetRepository.Create(ctx, u.db, user.IdUser)
 	if err != nil {
 		return err
 	}
@@ -179,13 +286,66 @@ func (u *UserHandler) ForgotPassword(c *fiber.Ctx) (err error) {
 	}
 
 	if sendEmail {
-		err = u.repository.SendEmailForgotPassword(ctx, user, newPassword)
+		err = u.repository.SendEmailForgotPassword(ctx, user, resetToken)
 		if err != nil {
 			return err
 		}
 	}
 
-	return c.Status(fiber.StatusOK).SendString("New password request sent. Check email for new password")
+	return c.Status(fiber.StatusOK).SendString("Reset password link sent. Check email to reset your password")
+}
+
+func (u *UserHandler) ResetPasswordPage(c *fiber.Ctx) (err error) {
+	return c.Render("reset_password", fiber.Map{
+		"title": "Reset Password",
+		"token": c.Query("token"),
+	}, "layouts/main")
+}
+
+func (u *UserHandler) ResetPassword(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	body := new(entities.UserResetPassword)
+	err = u.validator.ParseBody(c, body)
+	if err != nil {
+		return err
+	}
+
+	tx, err := u.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	passwordReset, err := u.passwordResetRepository.GetByToken(ctx, tx, body.Token)
+	if err != nil {
+		return err
+	}
+
+	if time.Now().UTC().After(passwordReset.ExpiresAt) {
+		return fiber.NewError(400, "Reset password link is expired, request a new one")
+	}
+
+	err = u.passwordResetRepository.Use(ctx, tx, passwordReset.IdPasswordReset)
+	if err != nil {
+		return err
+	}
+
+	err = u.repository.UpdatePassword(ctx, tx, passwordReset.IdUser, body.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	err = u.refreshTokenRepository.RevokeAllByUser(ctx, tx, passwordReset.IdUser)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Password has been reset, please login with the new password")
 }
 
 func (u *UserHandler) GetAll(c *fiber.Ctx) (err error) {
@@ -242,6 +402,12 @@ func (u *UserHandler) Update(c *fiber.Ctx) (err error) {
 		return err
 	}
 
+	// Log out every session that still use the old password
+	err = u.refreshTokenRepository.RevokeAllByUser(ctx, u.db, id)
+	if err != nil {
+		return err
+	}
+
 	return c.Status(fiber.StatusOK).SendString("Success change password")
 }
 
@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/dependencies"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+)
+
+// webhookClaimLimit is the number of delivery the dispatcher send on every round
+const webhookClaimLimit = 50
+
+// WebhookSignatureHeader contains "sha256=" followed by the hex HMAC-SHA256 of the body using the webhook secret
+const WebhookSignatureHeader = "X-Webhook-Signature-256"
+
+var errWebhookPrivateAddress = errors.New("webhook can't be sent to a loopback, private or link local address")
+
+// webhookBlockedNetworks are the networks that are not covered by the net.IP methods used by isPublicAddress
+var webhookBlockedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	// Carrier grade NAT, used for internal network by some cloud provider
+	mustParseCIDR("100.64.0.0/10"),
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	helper.PanicIfError(err)
+	return network
+}
+
+// isPublicAddress report whether a webhook can be sent to ip. The server itself and its private network, like the
+// cloud metadata endpoint 169.254.169.254, are rejected so a webhook can't be used to reach them.
+func isPublicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range webhookBlockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// newWebhookClient create the client of the dispatcher. The address is checked when it is dialed, after the host
+// is resolved, so a public host name that resolve to a private address or a redirect to one is also rejected.
+func newWebhookClient(timeout time.Duration, allowPrivateAddress bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			if allowPrivateAddress {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicAddress(ip) {
+				return errWebhookPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed instead of the webhook so its address would be the one checked
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// checkWebhookUrl reject a webhook url whose host is a private address or localhost when it is saved, the address
+// a host name resolve to is only known when the webhook is sent
+func checkWebhookUrl(webhookUrl string) error {
+	config := configs.GetConfig()
+	if config.Webhook.AllowPrivateAddress {
+		return nil
+	}
+
+	parsedUrl, err := url.Parse(webhookUrl)
+	if err != nil {
+		return fiber.NewError(400, "Invalid webhook url, "+err.Error())
+	}
+	host := strings.ToLower(parsedUrl.Hostname())
+	ip := net.ParseIP(host)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || (ip != nil && !isPublicAddress(ip)) {
+		return fiber.NewError(400, "Webhook url can't be a loopback, private or link local address")
+	}
+	return nil
+}
+
+type WebhookHandler struct {
+	db               helper.Querier
+	repository       repositories.WebhookRepository
+	sensorRepository repositories.SensorRepository
+	validator        *dependencies.Validator
+	client           *http.Client
+	// wake the dispatcher when a new delivery is queued
+	wake chan struct{}
+}
+
+func NewWebhookHandler(db helper.Querier, webhookRepository repositories.WebhookRepository, sensorRepository repositories.SensorRepository, validator *dependencies.Validator) (WebhookHandler, error) {
+	config := configs.GetConfig()
+	return WebhookHandler{
+		db:               db,
+		repository:       webhookRepository,
+		sensorRepository: sensorRepository,
+		validator:        validator,
+		client:           newWebhookClient(config.Webhook.Timeout, config.Webhook.AllowPrivateAddress),
+		wake:             make(chan struct{}, 1),
+	}, nil
+}
+
+// getAccessibleWebhook get the webhook from the url id and check that the current user own it
+func (h *WebhookHandler) getAccessibleWebhook(ctx context.Context, c *fiber.Ctx) (webhook entities.Webhook, err error) {
+	id, err := h.validator.ParseIdFromUrlParameter(c)
+	if err != nil {
+		return webhook, err
+	}
+
+	webhook, err = h.repository.GetById(ctx, h.db, id)
+	if err != nil {
+		return webhook, err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return webhook, err
+	}
+
+	if webhook.IdUser != currentUser.IdUser && !currentUser.IsAdmin {
+		return webhook, fiber.NewError(403, "You can't access another user's webhook")
+	}
+
+	return webhook, nil
+}
+
+func (h *WebhookHandler) CreateForm(c *fiber.Ctx) (err error) {
+	return c.Render("webhook_form", fiber.Map{
+		"title": "Create Webhook",
+	}, "layouts/main")
+}
+
+func (h *WebhookHandler) Create(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.WebhookCreate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+	err = checkWebhookUrl(bodyPayload.Url)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	webhook, err := h.repository.Create(ctx, h.db, currentUser.IdUser, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(webhook)
+}
+
+func (h *WebhookHandler) GetAll(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	currentUser, err := h.validator.GetAuthentication(c)
+	if err != nil {
+		return err
+	}
+
+	webhooks, err := h.repository.GetAll(ctx, h.db, &currentUser)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("webhook", fiber.Map{
+			"title":    "Webhook",
+			"webhooks": webhooks,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(webhooks)
+	}
+}
+
+func (h *WebhookHandler) GetById(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	webhook, err := h.getAccessibleWebhook(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := h.repository.GetDeliveries(ctx, h.db, webhook.IdWebhook)
+	if err != nil {
+		return err
+	}
+
+	accept := c.Accepts("application/json", "text/html")
+	switch accept {
+	case "text/html":
+		return c.Render("webhook_detail", fiber.Map{
+			"title":      "Webhook Detail",
+			"webhook":    webhook,
+			"deliveries": deliveries,
+		}, "layouts/main")
+	default:
+		return c.Status(fiber.StatusOK).JSON(entities.WebhookWithDelivery{
+			Webhook:    webhook,
+			Deliveries: deliveries,
+		})
+	}
+}
+
+func (h *WebhookHandler) UpdateForm(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	webhook, err := h.getAccessibleWebhook(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	return c.Render("webhook_form", fiber.Map{
+		"title":      "Update Webhook",
+		"webhook":    webhook,
+		"eventTypes": strings.Join(webhook.EventTypes, ","),
+		"edit":       true,
+	}, "layouts/main")
+}
+
+func (h *WebhookHandler) Update(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	bodyPayload := entities.WebhookUpdate{}
+	err = h.validator.ParseBody(c, &bodyPayload)
+	if err != nil {
+		return err
+	}
+	if bodyPayload.Url != "" {
+		err = checkWebhookUrl(bodyPayload.Url)
+		if err != nil {
+			return err
+		}
+	}
+
+	webhook, err := h.getAccessibleWebhook(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Update(ctx, h.db, &webhook, &bodyPayload)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString("Success edit webhook")
+}
+
+func (h *WebhookHandler) Delete(c *fiber.Ctx) (err error) {
+	ctx := context.Background()
+	webhook, err := h.getAccessibleWebhook(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	err = h.repository.Delete(ctx, h.db, webhook.IdWebhook)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).SendString(fmt.Sprintf("Success delete webhook, id: %d", webhook.IdWebhook))
+}
+
+// Publish queue the event for every webhook subscribed to it of the organization members, a nil idOrganization
+// send it to every user. The event is queued with tx so it is stored together with the change that trigger it,
+// call Wake after the commit so it is delivered right away instead of on the next poll.
+func (h *WebhookHandler) Publish(ctx context.Context, tx helper.Querier, idOrganization *int, eventType string, data interface{}) error {
+	dataJson, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(entities.WebhookPayload{
+		Event: eventType,
+		Time:  time.Now().UTC(),
+		Data:  dataJson,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = h.repository.Enqueue(ctx, tx, idOrganization, eventType, payload)
+	return err
+}
+
+// PublishForOrganization is Publish for event about the node of an organization, like its sensor and channel
+func (h *WebhookHandler) PublishForOrganization(ctx context.Context, tx helper.Querier, idOrganization int, eventType string, data interface{}) error {
+	return h.Publish(ctx, tx, &idOrganization, eventType, data)
+}
+
+// Wake make the dispatcher look for the deliveries queued by Publish
+func (h *WebhookHandler) Wake() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// OnChannelCreated queue the new channels for the webhooks of the members of the organization that own the sensor,
+// one event per organization. It run from the channel listener queue after the reading is saved, so the event is lost
+// when queuing fail or the server stop before the queue is drained, failing is only logged.
+func (h *WebhookHandler) OnChannelCreated(ctx context.Context, channels []entities.Channel) {
+	sensorIds := []int{}
+	seenSensor := map[int]bool{}
+	for _, channel := range channels {
+		if !seenSensor[channel.IdSensor] {
+			seenSensor[channel.IdSensor] = true
+			sensorIds = append(sensorIds, channel.IdSensor)
+		}
+	}
+
+	sensorOwners, err := h.sensorRepository.GetSensorOwnerByIds(ctx, h.db, sensorIds)
+	if err != nil {
+		log.Printf("[WEBHOOK] Failed to get sensor owner of new channel, %v", err)
+		return
+	}
+
+	channelByOrganization := map[int][]entities.Channel{}
+	for _, channel := range channels {
+		owner := sensorOwners[channel.IdSensor]
+		channelByOrganization[owner.IdOrganization] = append(channelByOrganization[owner.IdOrganization], channel)
+	}
+
+	for idOrganization, organizationChannels := range channelByOrganization {
+		err = h.PublishForOrganization(ctx, h.db, idOrganization, entities.WebhookEventChannelCreated, organizationChannels)
+		if err != nil {
+			log.Printf("[WEBHOOK] Failed to queue %s event, %v", entities.WebhookEventChannelCreated, err)
+		}
+	}
+	h.Wake()
+}
+
+// StartDispatcher send the queued deliveries until the context is done, it is woken up by Wake
+// and also check every poll interval for retry that become due
+func (h *WebhookHandler) StartDispatcher(ctx context.Context) {
+	config := configs.GetConfig()
+	ticker := time.NewTicker(config.Webhook.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		h.dispatch(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-h.wake:
+		}
+	}
+}
+
+// dispatch send every due delivery, claiming them in small group so several server can share the queue
+func (h *WebhookHandler) dispatch(ctx context.Context) {
+	config := configs.GetConfig()
+	for {
+		// The lease must outlive every request of the group, otherwise a delivery may be claimed twice
+		leaseUntil := time.Now().UTC().Add(config.Webhook.Timeout*webhookClaimLimit + time.Minute)
+		jobs, err := h.repository.ClaimDue(ctx, h.db, webhookClaimLimit, leaseUntil)
+		if err != nil {
+			log.Printf("[WEBHOOK] Failed to claim delivery, %v", err)
+			return
+		}
+
+		for _, job := range jobs {
+			h.deliver(ctx, job)
+		}
+
+		if len(jobs) < webhookClaimLimit {
+			return
+		}
+	}
+}
+
+func (h *WebhookHandler) sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *WebhookHandler) deliver(ctx context.Context, job entities.WebhookDeliveryJob) {
+	config := configs.GetConfig()
+	statusCode, err := h.send(ctx, job)
+	if err == nil {
+		err = h.repository.MarkSuccess(ctx, h.db, job.IdWebhookDelivery, statusCode)
+		if err != nil {
+			log.Printf("[WEBHOOK] Failed to save delivery %d, %v", job.IdWebhookDelivery, err)
+		}
+		return
+	}
+
+	var lastStatusCode *int
+	if statusCode != 0 {
+		lastStatusCode = &statusCode
+	}
+
+	attempt := job.Attempt + 1
+	giveUp := attempt >= config.Webhook.MaxAttempt
+	nextAttemptAt := time.Now().UTC().Add(config.Webhook.RetryBaseDelay * time.Duration(1<<(attempt-1)))
+	err = h.repository.MarkFailure(ctx, h.db, job.IdWebhookDelivery, lastStatusCode, err.Error(), nextAttemptAt, giveUp)
+	if err != nil {
+		log.Printf("[WEBHOOK] Failed to save delivery %d, %v", job.IdWebhookDelivery, err)
+	}
+}
+
+// send post the payload to the webhook, any non 2xx response is an error
+func (h *WebhookHandler) send(ctx context.Context, job entities.WebhookDeliveryJob) (statusCode int, err error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, job.Url, bytes.NewReader(job.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "iot-server-webhook")
+	request.Header.Set("X-Webhook-Event", job.EventType)
+	request.Header.Set("X-Webhook-Delivery", strconv.FormatInt(job.IdWebhookDelivery, 10))
+	request.Header.Set(WebhookSignatureHeader, h.sign(job.Secret, job.Payload))
+
+	response, err := h.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return response.StatusCode, fmt.Errorf("webhook responded with status %d", response.StatusCode)
+	}
+
+	return response.StatusCode, nil
+}
@@ -0,0 +1,36 @@
+package helper
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var bucketUnits = map[byte]time.Duration{
+	's': time.Second,
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// ParseBucket parse a bucket size such as 30s, 15m, 1h, 1d or 1w.
+// Unlike time.ParseDuration it support day and week unit.
+func ParseBucket(bucket string) (time.Duration, error) {
+	invalidBucketError := fmt.Errorf("bucket %q is invalid, use a positive number followed by s, m, h, d or w, e.g. 1h", bucket)
+	if len(bucket) < 2 {
+		return 0, invalidBucketError
+	}
+
+	unit, ok := bucketUnits[bucket[len(bucket)-1]]
+	if !ok {
+		return 0, invalidBucketError
+	}
+
+	amount, err := strconv.Atoi(bucket[:len(bucket)-1])
+	if err != nil || amount <= 0 {
+		return 0, invalidBucketError
+	}
+
+	return time.Duration(amount) * unit, nil
+}
@@ -0,0 +1,13 @@
+package helper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HashToken hash a high entropy secret such as an API key so it can be stored and looked up,
+// don't use this for password
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,75 @@
+package helper
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+// ParseJsonObject decode a raw json that must be an object, empty or null is an empty object
+func ParseJsonObject(raw json.RawMessage) (map[string]interface{}, error) {
+	object := map[string]interface{}{}
+	if len(raw) == 0 || string(raw) == "null" {
+		return object, nil
+	}
+
+	err := json.Unmarshal(raw, &object)
+	if err != nil || object == nil {
+		return map[string]interface{}{}, errors.New("value must be a json object")
+	}
+
+	return object, nil
+}
+
+// MergePatch apply a json merge patch (RFC 7386) to the target, a null value remove the key
+func MergePatch(target map[string]interface{}, patch map[string]interface{}) map[string]interface{} {
+	if target == nil {
+		target = map[string]interface{}{}
+	}
+
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+
+		patchObject, isObject := value.(map[string]interface{})
+		if !isObject {
+			target[key] = value
+			continue
+		}
+
+		targetObject, _ := target[key].(map[string]interface{})
+		target[key] = MergePatch(targetObject, patchObject)
+	}
+
+	return target
+}
+
+// JsonDelta get every key of desired that is missing or different in reported, nested object are compared per key
+func JsonDelta(desired map[string]interface{}, reported map[string]interface{}) map[string]interface{} {
+	delta := map[string]interface{}{}
+	for key, desiredValue := range desired {
+		reportedValue, exist := reported[key]
+		if !exist {
+			delta[key] = desiredValue
+			continue
+		}
+
+		desiredObject, desiredIsObject := desiredValue.(map[string]interface{})
+		reportedObject, reportedIsObject := reportedValue.(map[string]interface{})
+		if desiredIsObject && reportedIsObject {
+			nestedDelta := JsonDelta(desiredObject, reportedObject)
+			if len(nestedDelta) > 0 {
+				delta[key] = nestedDelta
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(desiredValue, reportedValue) {
+			delta[key] = desiredValue
+		}
+	}
+
+	return delta
+}
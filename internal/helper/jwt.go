@@ -13,8 +13,13 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
+// ErrTokenExpired is returned when a valid access token has passed its expiration time
+var ErrTokenExpired = fiber.NewError(401, "Token is expired, get a new one from /user/refresh")
+
+// SignUserToken sign a short lived access token, it expires after the configured access token TTL
 func SignUserToken(user entities.UserRead) (string, error) {
 	config := configs.GetConfig()
+	now := time.Now()
 	// Create a new token object, specifying signing method and the claims
 	// you would like it to contain.
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
@@ -23,7 +28,8 @@ func SignUserToken(user entities.UserRead) (string, error) {
 		"username": user.Username,
 		"status":   user.Status,
 		"isAdmin":  user.IsAdmin,
-		"iat":      time.Now().Unix(),
+		"iat":      now.Unix(),
+		"exp":      now.Add(config.JWT.AccessTokenTTL).Unix(),
 	})
 
 	// Sign and get the complete encoded token as a string using the secret
@@ -48,23 +54,33 @@ func ValidateUserToken(tokenString string) (user entities.UserRead, err error) {
 		return []byte(config.JWT.SecretKey), nil
 	})
 	if err != nil {
-		return user, err
+		switch {
+		case errors.Is(err, jwt.ErrTokenExpired):
+			return user, ErrTokenExpired
+		case errors.Is(err, jwt.ErrTokenMalformed):
+			return user, fiber.NewError(401, "Token is malformed")
+		case errors.Is(err, jwt.ErrTokenNotValidYet):
+			return user, fiber.NewError(401, "Token is not valid yet")
+		default:
+			return user, fiber.NewError(401, fmt.Sprintf("Couldn't handle this token: %s", err.Error()))
+		}
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		user.IdUser = int(claims["idUser"].(float64))
-		user.Email = claims["email"].(string)
-		user.Username = claims["username"].(string)
-		user.Status = claims["status"].(bool)
-		user.IsAdmin = claims["isAdmin"].(bool)
-		return user, nil
-	} else if errors.Is(err, jwt.ErrTokenMalformed) {
-		return user, fiber.NewError(401, "Token is malformed")
-	} else if errors.Is(err, jwt.ErrTokenExpired) || errors.Is(err, jwt.ErrTokenNotValidYet) {
-		return user, fiber.NewError(401, "Token is expired or not valid yet")
-	} else {
-		return user, fiber.NewError(401, fmt.Sprintf("Couldn't handle this token: %s", err.Error()))
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return user, fiber.NewError(401, "Couldn't handle this token")
+	}
+	// Token signed before access token expire have no exp claim, which jwt accept as never expiring
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return user, ErrTokenExpired
 	}
+
+	user.IdUser = int(claims["idUser"].(float64))
+	user.Email = claims["email"].(string)
+	user.Username = claims["username"].(string)
+	user.Status = claims["status"].(bool)
+	user.IsAdmin = claims["isAdmin"].(bool)
+	return user, nil
 }
 
 func ValidateUserCredentical(c *fiber.Ctx) (user entities.UserRead, err error) {
@@ -89,9 +105,14 @@ func ValidateUserCredentical(c *fiber.Ctx) (user entities.UserRead, err error) {
 		authorization = authorizationCookies
 	}
 
-	authorizationSplit := strings.Split(authorization, " ")
+	return ValidateUserAuthorization(authorization)
+}
+
+// ValidateUserAuthorization validate an authorization value in 'Bearer {token}' format
+func ValidateUserAuthorization(authorization string) (user entities.UserRead, err error) {
+	authorizationSplit := strings.SplitN(authorization, " ", 2)
 	authorizationType := authorizationSplit[0]
-	if authorizationType != "Bearer" {
+	if authorizationType != "Bearer" || len(authorizationSplit) != 2 {
 		return user, fiber.NewError(401, "Authorization type is not Bearer, please use 'Bearer {token}' format on your authorization header")
 	}
 
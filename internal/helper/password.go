@@ -0,0 +1,59 @@
+package helper
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/dafaath/iot-server/configs"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// isLegacyPasswordHash check if the hash is the old unsalted sha256 hex format
+func isLegacyPasswordHash(hashedPassword string) bool {
+	if len(hashedPassword) != sha256.Size*2 || strings.HasPrefix(hashedPassword, "$") {
+		return false
+	}
+
+	_, err := hex.DecodeString(hashedPassword)
+	return err == nil
+}
+
+// HashPassword hash the password with bcrypt using the configured cost
+func HashPassword(password string) (hashedPassword string, err error) {
+	config := configs.GetConfig()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), config.Password.BcryptCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// CheckPassword compare the password with a stored hash, needRehash is true when the hash
+// is in the legacy sha256 format or use a different cost than the configured one
+func CheckPassword(hashedPassword string, password string) (match bool, needRehash bool, err error) {
+	if isLegacyPasswordHash(hashedPassword) {
+		hash := sha256.Sum256([]byte(password))
+		passwordHash := hex.EncodeToString(hash[:])
+		match = subtle.ConstantTimeCompare([]byte(passwordHash), []byte(hashedPassword)) == 1
+		return match, match, nil
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, err
+	}
+
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	if err != nil {
+		return false, false, err
+	}
+
+	config := configs.GetConfig()
+	return true, cost != config.Password.BcryptCost, nil
+}
@@ -1,13 +1,16 @@
 package helper
 
-import "math/rand"
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
 
-const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-
-func GenerateRandomString(digit int) string {
-	b := make([]byte, digit)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+// GenerateSecureToken generate a url safe token from byteLength cryptographically secure random bytes
+func GenerateSecureToken(byteLength int) (string, error) {
+	b := make([]byte, byteLength)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
 	}
-	return string(b)
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
@@ -0,0 +1,101 @@
+package helper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Semver is a parsed semantic version, build metadata is ignored
+type Semver struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease []string
+}
+
+// ParseSemver parse a version such as 1.2.3, 1.2.3-rc.1 or 1.2.3+build.5
+func ParseSemver(version string) (semver Semver, err error) {
+	invalidVersionError := fmt.Errorf("version %q is not a valid semantic version, e.g. 1.2.3", version)
+	version, _, _ = strings.Cut(version, "+")
+	version, prerelease, hasPrerelease := strings.Cut(version, "-")
+	if hasPrerelease {
+		if prerelease == "" {
+			return semver, invalidVersionError
+		}
+		semver.Prerelease = strings.Split(prerelease, ".")
+	}
+
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return semver, invalidVersionError
+	}
+
+	numbers := make([]int, 3)
+	for i, part := range parts {
+		numbers[i], err = strconv.Atoi(part)
+		if err != nil || numbers[i] < 0 {
+			return semver, invalidVersionError
+		}
+	}
+	semver.Major, semver.Minor, semver.Patch = numbers[0], numbers[1], numbers[2]
+
+	return semver, nil
+}
+
+func compareInt(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Compare return -1 when v is older than other, 1 when it is newer and 0 when both have the same precedence
+func (v Semver) Compare(other Semver) int {
+	if result := compareInt(v.Major, other.Major); result != 0 {
+		return result
+	}
+	if result := compareInt(v.Minor, other.Minor); result != 0 {
+		return result
+	}
+	if result := compareInt(v.Patch, other.Patch); result != 0 {
+		return result
+	}
+
+	// A version without prerelease is newer than the same version with prerelease
+	switch {
+	case len(v.Prerelease) == 0 && len(other.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(other.Prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.Prerelease) && i < len(other.Prerelease); i++ {
+		a, b := v.Prerelease[i], other.Prerelease[i]
+		aNumber, aErr := strconv.Atoi(a)
+		bNumber, bErr := strconv.Atoi(b)
+		switch {
+		case aErr == nil && bErr == nil:
+			if result := compareInt(aNumber, bNumber); result != 0 {
+				return result
+			}
+		case aErr == nil:
+			// Numeric identifier is older than alphanumeric one
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if result := strings.Compare(a, b); result != 0 {
+				return result
+			}
+		}
+	}
+
+	return compareInt(len(v.Prerelease), len(other.Prerelease))
+}
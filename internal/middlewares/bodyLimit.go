@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"io"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// BodyLimitMiddleware limit the request body size. The server stream the request body instead of reading it
+// before routing, so the limit is checked here and a route like the firmware upload can accept a larger body.
+type BodyLimitMiddleware struct {
+	defaultLimit int
+	// Limit of the routes that accept a larger body, key is the method and the path like "POST /firmware"
+	routeLimit map[string]int
+}
+
+func NewBodyLimitMiddleware(defaultLimit int, routeLimit map[string]int) BodyLimitMiddleware {
+	return BodyLimitMiddleware{
+		defaultLimit: defaultLimit,
+		routeLimit:   routeLimit,
+	}
+}
+
+// Limit reject the request with 413 when its body is larger than the limit of its route
+func (m *BodyLimitMiddleware) Limit(c *fiber.Ctx) error {
+	limit, ok := m.routeLimit[c.Method()+" "+strings.TrimSuffix(c.Path(), "/")]
+	if !ok {
+		limit = m.defaultLimit
+	}
+
+	request := c.Request()
+	contentLength := request.Header.ContentLength()
+	if contentLength > limit {
+		// The rest of the body is not read so the connection can't be reused
+		c.Context().SetConnectionClose()
+		return fiber.ErrRequestEntityTooLarge
+	}
+
+	// A chunked body has no length, read it now so the handler can't read more than the limit
+	stream := c.Context().RequestBodyStream()
+	if contentLength == -1 && stream != nil {
+		body, err := io.ReadAll(io.LimitReader(stream, int64(limit)+1))
+		if err != nil {
+			return fiber.NewError(400, "Failed to read request body, "+err.Error())
+		}
+		if len(body) > limit {
+			c.Context().SetConnectionClose()
+			return fiber.ErrRequestEntityTooLarge
+		}
+		request.SetBody(body)
+	}
+
+	return c.Next()
+}
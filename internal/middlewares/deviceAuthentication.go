@@ -0,0 +1,44 @@
+package middlewares
+
+import (
+	"context"
+
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/dafaath/iot-server/internal/repositories"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DeviceKeyHeader is the header used by node to send its API key
+const DeviceKeyHeader = "X-Device-Key"
+
+type DeviceAuthenticationMiddleware struct {
+	db                       helper.Querier
+	nodeKeyRepository        repositories.NodeKeyRepository
+	authenticationMiddleware *AuthenticationMiddleware
+}
+
+func NewDeviceAuthenticationMiddleware(db helper.Querier, nodeKeyRepository repositories.NodeKeyRepository, authenticationMiddleware *AuthenticationMiddleware) DeviceAuthenticationMiddleware {
+	return DeviceAuthenticationMiddleware{
+		db:                       db,
+		nodeKeyRepository:        nodeKeyRepository,
+		authenticationMiddleware: authenticationMiddleware,
+	}
+}
+
+// ValidateUserOrDevice accept either a node API key on the X-Device-Key header or a normal user token.
+// A device is saved as currentDevice instead of currentUser so it can't act as the node owner.
+func (d *DeviceAuthenticationMiddleware) ValidateUserOrDevice(c *fiber.Ctx) error {
+	deviceKey := c.Get(DeviceKeyHeader)
+	if deviceKey == "" {
+		return d.authenticationMiddleware.ValidateUser(c)
+	}
+
+	device, err := d.nodeKeyRepository.GetDeviceByKey(context.Background(), d.db, deviceKey)
+	if err != nil {
+		return err
+	}
+
+	c.Locals("currentDevice", device)
+
+	return c.Next()
+}
@@ -0,0 +1,40 @@
+const isEdit = window.location.href.includes("edit");
+const separated = window.location.href.split("/");
+const id = separated[separated.length - 2];
+let editOptions = {};
+if (SENSOR_ID !== "0") {
+  $("#id_sensor").val(SENSOR_ID).change();
+}
+if (CONDITION) {
+  $("#condition").val(CONDITION).change();
+}
+if (isEdit) {
+  editOptions = {
+    url: `/alert/${id}`,
+    method: "PUT",
+  };
+}
+handleFormSubmit({
+  url: "/alert/",
+  ...editOptions,
+  handleResponse: (res) => {
+    setTimeout(() => {
+      window.location.href = "/alert";
+    }, 1000);
+  },
+  alterData: (data) => {
+    if (isEdit) {
+      delete data.id_sensor;
+      data.enabled = document.querySelector("#enabled").checked;
+    } else {
+      data.id_sensor = parseInt(data.id_sensor);
+    }
+    data.lower_threshold =
+      data.lower_threshold === "" ? null : parseFloat(data.lower_threshold);
+    data.upper_threshold =
+      data.upper_threshold === "" ? null : parseFloat(data.upper_threshold);
+    data.consecutive = parseInt(data.consecutive);
+    data.duration_second = parseInt(data.duration_second);
+    return data;
+  },
+});
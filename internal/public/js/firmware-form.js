@@ -0,0 +1,35 @@
+const isEdit = window.location.href.includes("edit");
+const separated = window.location.href.split("/");
+const id = separated[separated.length - 2];
+let editOptions = {};
+if (isEdit) {
+  editOptions = {
+    url: `/firmware/${id}`,
+    method: "PUT",
+    successMessage: "Success edit firmware",
+  };
+}
+handleFormSubmit({
+  url: "/firmware/",
+  successMessage: "Success upload firmware",
+  handleResponse: (res) => {
+    setTimeout(() => {
+      window.location.href = "/firmware";
+    }, 1000);
+  },
+  ...editOptions,
+  alterData: (data) => {
+    if (!isEdit) {
+      // The binary is sent as multipart form
+      return new FormData(document.querySelector("#submit-form"));
+    }
+
+    data.rollout_percentage = parseInt(data.rollout_percentage);
+    data.rollout_nodes = data.rollout_nodes
+      .split(",")
+      .map((node) => node.trim())
+      .filter((node) => node)
+      .map((node) => parseInt(node));
+    return data;
+  },
+});
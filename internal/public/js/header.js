@@ -7,6 +7,14 @@ if (authorizationCookie) {
   loginRegisterSection.style.display = "none";
   const jwt = authorizationCookie.split(" ")[1];
   const decoded = jwt_decode(jwt);
+  // The page was loaded with an expired access token, get a new one and reload
+  if (decoded.exp && decoded.exp * 1000 <= Date.now()) {
+    refreshToken().then((ok) => {
+      if (ok) {
+        window.location.reload();
+      }
+    });
+  }
   if (decoded.isAdmin === true) {
     document.querySelector(
       "#head-username"
@@ -25,6 +33,12 @@ const logoutButton = document.querySelector("#logout-button");
 
 logoutButton?.addEventListener("click", (e) => {
   e.preventDefault();
-  Cookies.remove("authorization");
-  window.location.href = "/";
+  const refreshTokenCookie = Cookies.get("refresh_token");
+  const logout = refreshTokenCookie
+    ? axios.post("/user/logout", { refresh_token: refreshTokenCookie })
+    : Promise.resolve();
+  logout.finally(() => {
+    clearToken();
+    window.location.href = "/";
+  });
 });
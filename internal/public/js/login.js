@@ -1,8 +1,7 @@
 handleFormSubmit({
   url: "/user/login",
   handleResponse: (res) => {
-    const token = res.data;
-    Cookies.set("authorization", `Bearer ${token}`, { expires: 365 });
+    saveToken(res.data);
     window.location.href = "/hardware";
   },
   successMessage: "Login Successful",
@@ -0,0 +1,30 @@
+handleFormSubmit({
+  url: `/node/${NODE_ID}/commands`,
+  successMessage: "Success send command",
+  handleResponse: (res) => {
+    setTimeout(() => {
+      window.location.reload();
+    }, 1000);
+  },
+  alterData: (data) => {
+    if (data.args.trim() === "") {
+      data.args = {};
+      return data;
+    }
+
+    try {
+      data.args = JSON.parse(data.args);
+    } catch (err) {
+      Swal.fire({
+        position: "top",
+        icon: "error",
+        title: "Args must be a valid JSON",
+        showConfirmButton: false,
+        toast: true,
+        timer: 5000,
+      });
+      throw err;
+    }
+    return data;
+  },
+});
@@ -19,6 +19,17 @@ handleFormSubmit({
   },
   alterData: (data) => {
     data.id_hardware = parseInt(data.id_hardware);
+    if (data.id_organization === undefined || data.id_organization === "default") {
+      delete data.id_organization;
+    } else {
+      data.id_organization = parseInt(data.id_organization);
+    }
+    if (data.offline_timeout === "") {
+      delete data.offline_timeout;
+    } else {
+      data.offline_timeout = parseInt(data.offline_timeout);
+    }
+    data.notify_offline = document.querySelector("#notify_offline").checked;
     return data;
   },
 });
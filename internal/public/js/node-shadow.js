@@ -0,0 +1,27 @@
+handleFormSubmit({
+  url: `/node/${NODE_ID}/shadow/desired`,
+  method: "PUT",
+  formSelector: "#shadow-form",
+  successMessage: "Success update desired state",
+  handleResponse: (res) => {
+    setTimeout(() => {
+      window.location.reload();
+    }, 1000);
+  },
+  alterData: (data) => {
+    try {
+      data.desired = JSON.parse(data.desired);
+    } catch (err) {
+      Swal.fire({
+        position: "top",
+        icon: "error",
+        title: "Desired state must be a valid JSON",
+        showConfirmButton: false,
+        toast: true,
+        timer: 5000,
+      });
+      throw err;
+    }
+    return data;
+  },
+});
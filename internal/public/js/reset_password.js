@@ -1,3 +1,12 @@
-handleFormSubmit({
-  url: "/user/forget-password",
-});
+if (document.querySelector("#token")) {
+  handleFormSubmit({
+    url: "/user/reset-password",
+    handleResponse: () => {
+      window.location.href = "/user/login";
+    },
+  });
+} else {
+  handleFormSubmit({
+    url: "/user/forget-password",
+  });
+}
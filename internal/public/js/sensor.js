@@ -70,6 +70,45 @@ var options = {
 var chart = new ApexCharts(document.querySelector("#channel-chart"), options);
 chart.render();
 
+// Add every new reading to the chart, only when the chart is not limited to a past range
+function streamChannel() {
+  const stream = new EventSource(`/sensor/${SENSOR_ID}/stream`);
+  stream.addEventListener("channel", (e) => {
+    const channel = JSON.parse(e.data);
+    chart.appendData([
+      {
+        data: [[new Date(channel.time).getTime(), channel.value]],
+      },
+    ]);
+  });
+  stream.onerror = () => {
+    // The access token may be expired, get a new one before reconnecting
+    if (stream.readyState === EventSource.CLOSED) {
+      refreshToken().then((ok) => {
+        if (ok) {
+          streamChannel();
+        }
+      });
+    }
+  };
+}
+
+if (!new URLSearchParams(window.location.search).has("to")) {
+  streamChannel();
+}
+
+// Export the same range that is shown on the chart
+const pageQuery = new URLSearchParams(window.location.search);
+document.querySelectorAll(".export-link").forEach((link) => {
+  const url = new URL(link.href);
+  ["from", "to"].forEach((key) => {
+    if (pageQuery.has(key)) {
+      url.searchParams.set(key, pageQuery.get(key));
+    }
+  });
+  link.href = url.toString();
+});
+
 // var resetCssClasses = function (activeEl) {
 //   var els = document.querySelectorAll("button");
 //   Array.prototype.forEach.call(els, function (el) {
@@ -1,3 +1,58 @@
+function saveToken(userToken) {
+  Cookies.set("authorization", `${userToken.token_type} ${userToken.access_token}`, {
+    expires: 365,
+  });
+  Cookies.set("refresh_token", userToken.refresh_token, { expires: 365 });
+}
+
+function clearToken() {
+  Cookies.remove("authorization");
+  Cookies.remove("refresh_token");
+}
+
+let refreshTokenRequest = null;
+
+// refreshToken exchange the refresh token cookie with a new token pair,
+// concurrent callers share the same request because a refresh token can only be used once
+function refreshToken() {
+  const refreshTokenCookie = Cookies.get("refresh_token");
+  if (!refreshTokenCookie) {
+    return Promise.resolve(false);
+  }
+
+  if (!refreshTokenRequest) {
+    refreshTokenRequest = axios
+      .post("/user/refresh", { refresh_token: refreshTokenCookie }, { skipRefresh: true })
+      .then((res) => {
+        saveToken(res.data);
+        return true;
+      })
+      .catch(() => {
+        clearToken();
+        return false;
+      })
+      .finally(() => {
+        refreshTokenRequest = null;
+      });
+  }
+
+  return refreshTokenRequest;
+}
+
+// Retry a request once with a new access token when the current one is expired
+axios.interceptors.response.use(null, async (err) => {
+  const config = err.config;
+  if (err.response?.status === 401 && config && !config.skipRefresh && !config.retried) {
+    config.retried = true;
+    const ok = await refreshToken();
+    if (ok) {
+      return axios(config);
+    }
+  }
+
+  return Promise.reject(err);
+});
+
 async function handleFormSubmit({
   url,
   method = "post",
@@ -5,11 +60,12 @@ async function handleFormSubmit({
   successMessage = "",
   handleResponse = null,
   alterData = null,
+  formSelector = "#submit-form",
 }) {
   console.log("handleFormSubmit called");
-  const form = document.querySelector("#submit-form");
+  const form = document.querySelector(formSelector);
   if (!form) {
-    throw new Error("No form found with id #submit-form");
+    throw new Error(`No form found with selector ${formSelector}`);
   }
 
   form?.addEventListener("submit", (e) => {
@@ -0,0 +1,45 @@
+const isEdit = window.location.href.includes("edit");
+const separated = window.location.href.split("/");
+const id = separated[separated.length - 2];
+let editOptions = {};
+document.querySelectorAll(".event-type").forEach((checkbox) => {
+  checkbox.checked = EVENT_TYPES.includes(checkbox.value);
+});
+if (isEdit) {
+  editOptions = {
+    url: `/webhook/${id}`,
+    method: "PUT",
+    successMessage: "Success edit webhook",
+    handleResponse: (res) => {
+      setTimeout(() => {
+        window.location.href = "/webhook";
+      }, 1000);
+    },
+  };
+}
+handleFormSubmit({
+  url: "/webhook/",
+  successMessage: "Success add new webhook",
+  // The secret is only shown once after the webhook is created
+  handleResponse: (res) => {
+    Swal.fire({
+      icon: "success",
+      title: "Webhook created",
+      html: `Save this secret to verify the signature, it won't be shown again<br/><code>${res.data.secret}</code>`,
+    }).then(() => {
+      window.location.href = "/webhook";
+    });
+  },
+  ...editOptions,
+  alterData: (data) => {
+    data.event_types = Array.from(
+      document.querySelectorAll(".event-type:checked")
+    ).map((checkbox) => checkbox.value);
+    if (isEdit) {
+      data.enabled = document.querySelector("#enabled").checked;
+    } else if (!data.secret) {
+      delete data.secret;
+    }
+    return data;
+  },
+});
@@ -0,0 +1,324 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// alertEventLimit is the number of latest event shown on the alert rule detail
+const alertEventLimit = 100
+
+type PostgresAlertRepository struct{}
+
+func NewAlertRepository() (PostgresAlertRepository, error) {
+	return PostgresAlertRepository{}, nil
+}
+
+func (a *PostgresAlertRepository) alertRuleField() string {
+	return "alert_rule.id_alert_rule, alert_rule.id_sensor, alert_rule.name, alert_rule.condition, alert_rule.lower_threshold, alert_rule.upper_threshold, alert_rule.consecutive, alert_rule.duration_second, alert_rule.enabled, alert_rule.state, alert_rule.breach_count, alert_rule.breach_since, alert_rule.created_at"
+}
+
+func (a *PostgresAlertRepository) alertRulePointer(alertRule *entities.AlertRule) []interface{} {
+	return []interface{}{
+		&alertRule.IdAlertRule,
+		&alertRule.IdSensor,
+		&alertRule.Name,
+		&alertRule.Condition,
+		&alertRule.LowerThreshold,
+		&alertRule.UpperThreshold,
+		&alertRule.Consecutive,
+		&alertRule.DurationSecond,
+		&alertRule.Enabled,
+		&alertRule.State,
+		&alertRule.BreachCount,
+		&alertRule.BreachSince,
+		&alertRule.CreatedAt,
+	}
+}
+
+func (a *PostgresAlertRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.AlertRuleCreate) (alertRule entities.AlertRule, err error) {
+	payload.SetDefault()
+	err = payload.CheckThreshold()
+	if err != nil {
+		return alertRule, fiber.NewError(400, err.Error())
+	}
+
+	sqlStatement := fmt.Sprintf(`
+	INSERT INTO alert_rule (
+		id_sensor,
+		name,
+		condition,
+		lower_threshold,
+		upper_threshold,
+		consecutive,
+		duration_second
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING %s`, a.alertRuleField())
+	err = tx.QueryRow(ctx, sqlStatement,
+		payload.IdSensor,
+		payload.Name,
+		payload.Condition,
+		payload.LowerThreshold,
+		payload.UpperThreshold,
+		payload.Consecutive,
+		payload.DurationSecond,
+	).Scan(a.alertRulePointer(&alertRule)...)
+	if err != nil {
+		return alertRule, err
+	}
+
+	return alertRule, nil
+}
+
+func (a *PostgresAlertRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (alertRules []entities.AlertRule, err error) {
+	alertRules = []entities.AlertRule{}
+	var rows pgx.Rows
+	if currentUser.IsAdmin {
+		sqlStatement := fmt.Sprintf(`SELECT %s FROM alert_rule ORDER BY alert_rule.id_alert_rule`, a.alertRuleField())
+		rows, err = tx.Query(ctx, sqlStatement)
+	} else {
+		sqlStatement := fmt.Sprintf(`
+		SELECT %s FROM alert_rule
+		INNER JOIN sensor ON sensor.id_sensor=alert_rule.id_sensor
+		INNER JOIN node ON node.id_node=sensor.id_node
+		WHERE node.id_organization IN (SELECT id_organization FROM organization_member WHERE id_user=$1)
+		ORDER BY alert_rule.id_alert_rule`, a.alertRuleField())
+		rows, err = tx.Query(ctx, sqlStatement, currentUser.IdUser)
+	}
+	if err != nil {
+		return alertRules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var alertRule entities.AlertRule
+		err := rows.Scan(a.alertRulePointer(&alertRule)...)
+		if err != nil {
+			return alertRules, err
+		}
+		alertRules = append(alertRules, alertRule)
+	}
+	if err := rows.Err(); err != nil {
+		return alertRules, err
+	}
+	return alertRules, nil
+}
+
+func (a *PostgresAlertRepository) GetById(ctx context.Context, tx helper.Querier, id int) (alertRule entities.AlertRule, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM alert_rule WHERE id_alert_rule=$1`, a.alertRuleField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(a.alertRulePointer(&alertRule)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return alertRule, fiber.NewError(404, fmt.Sprintf("Alert rule with id %d not found", id))
+		}
+		return alertRule, err
+	}
+	return alertRule, nil
+}
+
+// GetEvents get the latest firing and resolved event of the alert rule
+func (a *PostgresAlertRepository) GetEvents(ctx context.Context, tx helper.Querier, idAlertRule int) (events []entities.AlertEvent, err error) {
+	events = []entities.AlertEvent{}
+	sqlStatement := `
+	SELECT id_alert_event, id_alert_rule, kind, value, time, created_at
+	FROM alert_event
+	WHERE id_alert_rule=$1
+	ORDER BY time DESC, id_alert_event DESC
+	LIMIT $2`
+	rows, err := tx.Query(ctx, sqlStatement, idAlertRule, alertEventLimit)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event entities.AlertEvent
+		err := rows.Scan(&event.IdAlertEvent, &event.IdAlertRule, &event.Kind, &event.Value, &event.Time, &event.CreatedAt)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return events, err
+	}
+	return events, nil
+}
+
+// Update change the rule and reset the breach tracking because the old breach may not match the new threshold
+func (a *PostgresAlertRepository) Update(ctx context.Context, tx helper.Querier, alertRule *entities.AlertRule, payload *entities.AlertRuleUpdate) (err error) {
+	payload.ChangeSettedFieldOnly(alertRule)
+	merged := payload.ToCreate(alertRule.IdSensor)
+	err = merged.CheckThreshold()
+	if err != nil {
+		return fiber.NewError(400, err.Error())
+	}
+
+	sqlStatement := `
+	UPDATE alert_rule
+	SET name=$1, condition=$2, lower_threshold=$3, upper_threshold=$4, consecutive=$5, duration_second=$6, enabled=$7, breach_count=0, breach_since=NULL
+	WHERE id_alert_rule=$8`
+	res, err := tx.Exec(ctx, sqlStatement,
+		merged.Name,
+		merged.Condition,
+		merged.LowerThreshold,
+		merged.UpperThreshold,
+		merged.Consecutive,
+		merged.DurationSecond,
+		*payload.Enabled,
+		alertRule.IdAlertRule,
+	)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update alert rule with id %d", alertRule.IdAlertRule))
+	}
+	return nil
+}
+
+func (a *PostgresAlertRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	sqlStatement := `DELETE FROM alert_rule WHERE id_alert_rule=$1`
+	res, err := tx.Exec(ctx, sqlStatement, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+	}
+	return nil
+}
+
+// Evaluate run the new channels through every enabled rule of their sensor and store the new rule state.
+// A notification is returned only when a rule start firing or is resolved, so repeated breach are not
+// notified twice. The rules are locked until the transaction end so concurrent ingest don't race.
+func (a *PostgresAlertRepository) Evaluate(ctx context.Context, tx helper.Querier, channels []entities.Channel) (notifications []entities.AlertNotification, err error) {
+	notifications = []entities.AlertNotification{}
+	channelBySensor := map[int][]entities.Channel{}
+	sensorIds := []int{}
+	for _, channel := range channels {
+		if _, ok := channelBySensor[channel.IdSensor]; !ok {
+			sensorIds = append(sensorIds, channel.IdSensor)
+		}
+		channelBySensor[channel.IdSensor] = append(channelBySensor[channel.IdSensor], channel)
+	}
+
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s, sensor.name, sensor.unit, node.id_organization
+	FROM alert_rule
+	INNER JOIN sensor ON sensor.id_sensor=alert_rule.id_sensor
+	INNER JOIN node ON node.id_node=sensor.id_node
+	WHERE alert_rule.id_sensor=ANY($1) AND alert_rule.enabled
+	ORDER BY alert_rule.id_alert_rule
+	FOR UPDATE OF alert_rule`, a.alertRuleField())
+	rows, err := tx.Query(ctx, sqlStatement, sensorIds)
+	if err != nil {
+		return notifications, err
+	}
+	defer rows.Close()
+
+	rules := []entities.AlertNotification{}
+	for rows.Next() {
+		var rule entities.AlertNotification
+		pointers := append(a.alertRulePointer(&rule.AlertRule), &rule.SensorName, &rule.Unit, &rule.IdOrganization)
+		err := rows.Scan(pointers...)
+		if err != nil {
+			return notifications, err
+		}
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return notifications, err
+	}
+	rows.Close()
+
+	for _, rule := range rules {
+		sensorChannels := channelBySensor[rule.IdSensor]
+		sort.SliceStable(sensorChannels, func(i, j int) bool {
+			return sensorChannels[i].Time.Before(sensorChannels[j].Time)
+		})
+
+		events := []entities.AlertEvent{}
+		for _, channel := range sensorChannels {
+			event, changed := evaluateAlertRule(&rule.AlertRule, channel)
+			if changed {
+				events = append(events, event)
+			}
+		}
+
+		sqlStatement := `
+		UPDATE alert_rule
+		SET state=$1, breach_count=$2, breach_since=$3
+		WHERE id_alert_rule=$4`
+		_, err = tx.Exec(ctx, sqlStatement, rule.State, rule.BreachCount, rule.BreachSince, rule.IdAlertRule)
+		if err != nil {
+			return notifications, err
+		}
+
+		for _, event := range events {
+			sqlStatement := `
+			INSERT INTO alert_event (
+				id_alert_rule,
+				kind,
+				value,
+				time
+			)
+			VALUES ($1, $2, $3, $4) RETURNING id_alert_event, created_at`
+			err = tx.QueryRow(ctx, sqlStatement, event.IdAlertRule, event.Kind, event.Value, event.Time).Scan(&event.IdAlertEvent, &event.CreatedAt)
+			if err != nil {
+				return notifications, err
+			}
+
+			notification := rule
+			notification.Event = event
+			notifications = append(notifications, notification)
+		}
+	}
+
+	return notifications, nil
+}
+
+// evaluateAlertRule update the rule state with a single reading, changed is true when the rule start firing or is resolved
+func evaluateAlertRule(rule *entities.AlertRule, channel entities.Channel) (event entities.AlertEvent, changed bool) {
+	event = entities.AlertEvent{
+		IdAlertRule: rule.IdAlertRule,
+		Value:       channel.Value,
+		Time:        channel.Time,
+	}
+
+	if !rule.IsBreached(channel.Value) {
+		rule.BreachCount = 0
+		rule.BreachSince = nil
+		if rule.State == entities.AlertStateFiring {
+			rule.State = entities.AlertStateOk
+			event.Kind = entities.AlertEventResolved
+			return event, true
+		}
+		return event, false
+	}
+
+	rule.BreachCount++
+	if rule.BreachSince == nil {
+		breachSince := channel.Time
+		rule.BreachSince = &breachSince
+	}
+
+	breachDuration := channel.Time.Sub(*rule.BreachSince)
+	if rule.State != entities.AlertStateFiring &&
+		rule.BreachCount >= rule.Consecutive &&
+		breachDuration >= time.Duration(rule.DurationSecond)*time.Second {
+		rule.State = entities.AlertStateFiring
+		event.Kind = entities.AlertEventFiring
+		return event, true
+	}
+	return event, false
+}
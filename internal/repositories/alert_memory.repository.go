@@ -0,0 +1,181 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MemoryAlertRepository is AlertRepository on the memory database
+type MemoryAlertRepository struct{}
+
+func NewMemoryAlertRepository() (MemoryAlertRepository, error) {
+	return MemoryAlertRepository{}, nil
+}
+
+func (a *MemoryAlertRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.AlertRuleCreate) (alertRule entities.AlertRule, err error) {
+	payload.SetDefault()
+	err = payload.CheckThreshold()
+	if err != nil {
+		return alertRule, fiber.NewError(400, err.Error())
+	}
+
+	alertRule = entities.AlertRule{
+		AlertRuleCreate: *payload,
+		Enabled:         true,
+		State:           entities.AlertStateOk,
+		CreatedAt:       time.Now().UTC(),
+	}
+	err = memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.sensors[alertRule.IdSensor]; !ok {
+			return memoryForeignKeyError("alert_rule", "id_sensor", alertRule.IdSensor)
+		}
+
+		alertRule.IdAlertRule = int(data.nextId("alert_rule"))
+		data.alertRules[alertRule.IdAlertRule] = alertRule
+		return nil
+	})
+	return alertRule, err
+}
+
+func (a *MemoryAlertRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (alertRules []entities.AlertRule, err error) {
+	alertRules = []entities.AlertRule{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, id := range sortedIds(data.alertRules) {
+			alertRule := data.alertRules[id]
+			idOrganization := data.nodes[data.sensors[alertRule.IdSensor].IdNode].IdOrganization
+			if currentUser.IsAdmin || data.memberRole(idOrganization, currentUser.IdUser) != "" {
+				alertRules = append(alertRules, alertRule)
+			}
+		}
+		return nil
+	})
+	return alertRules, err
+}
+
+func (a *MemoryAlertRepository) GetById(ctx context.Context, tx helper.Querier, id int) (alertRule entities.AlertRule, err error) {
+	err = memoryRead(tx, func(data *memoryData) error {
+		var ok bool
+		alertRule, ok = data.alertRules[id]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("Alert rule with id %d not found", id))
+		}
+		return nil
+	})
+	return alertRule, err
+}
+
+// GetEvents get the latest firing and resolved event of the alert rule
+func (a *MemoryAlertRepository) GetEvents(ctx context.Context, tx helper.Querier, idAlertRule int) (events []entities.AlertEvent, err error) {
+	events = []entities.AlertEvent{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, event := range data.alertEvents {
+			if event.IdAlertRule == idAlertRule {
+				events = append(events, event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return events, err
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].Time.Equal(events[j].Time) {
+			return events[i].Time.After(events[j].Time)
+		}
+		return events[i].IdAlertEvent > events[j].IdAlertEvent
+	})
+	if len(events) > alertEventLimit {
+		events = events[:alertEventLimit]
+	}
+	return events, nil
+}
+
+// Update change the rule and reset the breach tracking because the old breach may not match the new threshold
+func (a *MemoryAlertRepository) Update(ctx context.Context, tx helper.Querier, alertRule *entities.AlertRule, payload *entities.AlertRuleUpdate) (err error) {
+	payload.ChangeSettedFieldOnly(alertRule)
+	merged := payload.ToCreate(alertRule.IdSensor)
+	err = merged.CheckThreshold()
+	if err != nil {
+		return fiber.NewError(400, err.Error())
+	}
+
+	return memoryWrite(tx, func(data *memoryData) error {
+		stored, ok := data.alertRules[alertRule.IdAlertRule]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on update alert rule with id %d", alertRule.IdAlertRule))
+		}
+		stored.AlertRuleCreate = merged
+		stored.Enabled = *payload.Enabled
+		stored.BreachCount = 0
+		stored.BreachSince = nil
+		data.alertRules[alertRule.IdAlertRule] = stored
+		return nil
+	})
+}
+
+func (a *MemoryAlertRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.alertRules[id]; !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+		}
+		data.deleteAlertRule(id)
+		return nil
+	})
+}
+
+// Evaluate run the new channels through every enabled rule of their sensor and store the new rule state.
+// A notification is returned only when a rule start firing or is resolved, so repeated breach are not
+// notified twice.
+func (a *MemoryAlertRepository) Evaluate(ctx context.Context, tx helper.Querier, channels []entities.Channel) (notifications []entities.AlertNotification, err error) {
+	notifications = []entities.AlertNotification{}
+	channelBySensor := map[int][]entities.Channel{}
+	for _, channel := range channels {
+		channelBySensor[channel.IdSensor] = append(channelBySensor[channel.IdSensor], channel)
+	}
+
+	err = memoryWrite(tx, func(data *memoryData) error {
+		now := time.Now().UTC()
+		for _, id := range sortedIds(data.alertRules) {
+			rule := data.alertRules[id]
+			sensorChannels, ok := channelBySensor[rule.IdSensor]
+			if !ok || !rule.Enabled {
+				continue
+			}
+			sort.SliceStable(sensorChannels, func(i, j int) bool {
+				return sensorChannels[i].Time.Before(sensorChannels[j].Time)
+			})
+
+			events := []entities.AlertEvent{}
+			for _, channel := range sensorChannels {
+				event, changed := evaluateAlertRule(&rule, channel)
+				if changed {
+					event.IdAlertEvent = int(data.nextId("alert_event"))
+					event.CreatedAt = now
+					events = append(events, event)
+				}
+			}
+			data.alertRules[id] = rule
+			data.alertEvents = append(data.alertEvents, events...)
+
+			sensor := data.sensors[rule.IdSensor]
+			for _, event := range events {
+				notifications = append(notifications, entities.AlertNotification{
+					AlertRule:      rule,
+					Event:          event,
+					SensorName:     sensor.Name,
+					Unit:           sensor.Unit,
+					IdOrganization: data.nodes[sensor.IdNode].IdOrganization,
+				})
+			}
+		}
+		return nil
+	})
+	return notifications, err
+}
@@ -2,33 +2,198 @@ package repositories
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/dafaath/iot-server/configs"
 	"github.com/dafaath/iot-server/internal/entities"
 	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
 )
 
-type ChannelRepository struct{}
+type PostgresChannelRepository struct{}
 
-func NewChannelRepository() (ChannelRepository, error) {
-	return ChannelRepository{}, nil
+func NewChannelRepository() (PostgresChannelRepository, error) {
+	return PostgresChannelRepository{}, nil
 }
 
-func (c *ChannelRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.ChannelCreate) (entities.Channel, error) {
+// newChannel build the channel that will be stored from the payload. The device time
+// is used when it is present and inside the allowed window, otherwise receivedAt is used.
+func newChannel(payload *entities.ChannelCreate, receivedAt time.Time) (entities.Channel, error) {
 	channel := entities.Channel{
-		Time:          time.Now().UTC(),
+		Time:          receivedAt,
+		ReceivedAt:    receivedAt,
 		ChannelCreate: *payload,
 	}
+	if payload.Time == nil {
+		return channel, nil
+	}
+
+	config := configs.GetConfig()
+	deviceTime := payload.Time.UTC()
+	if deviceTime.After(receivedAt.Add(config.Channel.MaxFutureDrift)) {
+		return channel, fiber.NewError(400, fmt.Sprintf("Channel time %s is too far in the future, max drift is %s", deviceTime.Format(time.RFC3339), config.Channel.MaxFutureDrift))
+	}
+
+	if config.Channel.MaxPastAge > 0 && deviceTime.Before(receivedAt.Add(-config.Channel.MaxPastAge)) {
+		return channel, fiber.NewError(400, fmt.Sprintf("Channel time %s is too far in the past, max age is %s", deviceTime.Format(time.RFC3339), config.Channel.MaxPastAge))
+	}
+
+	channel.Time = deviceTime
+	return channel, nil
+}
+
+func (c *PostgresChannelRepository) NewChannel(payload *entities.ChannelCreate, receivedAt time.Time) (entities.Channel, error) {
+	return newChannel(payload, receivedAt)
+}
+
+func (c *PostgresChannelRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.ChannelCreate) (entities.Channel, error) {
+	channel, err := c.NewChannel(payload, time.Now().UTC())
+	if err != nil {
+		return channel, err
+	}
+
+	// The hour is marked as pending by the same statement, so the rollup never miss the channel
 	sqlStatement := `
-	INSERT INTO "channel" (
-		time, 
-		value, 
-		id_sensor)
-	VALUES ($1, $2, $3)`
-	_, err := tx.Exec(ctx, sqlStatement, channel.Time, channel.Value, channel.IdSensor)
+	WITH inserted AS (
+		INSERT INTO "channel" (
+			time, 
+			value, 
+			id_sensor,
+			received_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id_sensor, time
+	)
+	INSERT INTO channel_rollup_pending (id_sensor, bucket)
+	SELECT id_sensor, date_trunc('hour', time) FROM inserted
+	ON CONFLICT DO NOTHING`
+	_, err = tx.Exec(ctx, sqlStatement, channel.Time, channel.Value, channel.IdSensor, channel.ReceivedAt)
 	if err != nil {
 		return channel, err
 	}
 
 	return channel, nil
 }
+
+// CreateBatch insert many channel at once using the postgres copy protocol and mark their hour as pending
+// in the same transaction
+func (c *PostgresChannelRepository) CreateBatch(ctx context.Context, tx helper.Querier, channels []entities.Channel) (int64, error) {
+	rows := make([][]interface{}, 0, len(channels))
+	for _, channel := range channels {
+		rows = append(rows, []interface{}{channel.Time, channel.Value, channel.IdSensor, channel.ReceivedAt})
+	}
+
+	// A savepoint when tx is already a transaction
+	batchTx, err := tx.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer batchTx.Rollback(ctx)
+
+	count, err := c.CreateFromSource(ctx, batchTx, pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, err
+	}
+
+	err = markChannelPending(ctx, batchTx, channels)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, batchTx.Commit(ctx)
+}
+
+// DeleteBefore delete every channel older than before, only the channel of idSensor when it is set.
+// The rollup of the deleted channel are recomputed by the rollup worker.
+func (c *PostgresChannelRepository) DeleteBefore(ctx context.Context, tx helper.Querier, before time.Time, idSensor *int) (count int64, err error) {
+	sqlStatement := `DELETE FROM channel WHERE time < $1`
+	args := []interface{}{before}
+	if idSensor != nil {
+		sqlStatement += ` AND id_sensor = $2`
+		args = append(args, *idSensor)
+	}
+	sqlStatement += ` RETURNING id_sensor, time`
+
+	err = tx.QueryRow(ctx, markDeletedChannelPending(sqlStatement), args...).Scan(&count)
+	return count, err
+}
+
+// CreateFromSource insert every channel of the source using the postgres copy protocol,
+// the values of each row are time, value, id_sensor and received_at. The caller mark the hour
+// of the channels as pending, like the import with MarkPendingRange.
+func (c *PostgresChannelRepository) CreateFromSource(ctx context.Context, tx helper.Querier, source pgx.CopyFromSource) (int64, error) {
+	return tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"channel"},
+		[]string{"time", "value", "id_sensor", "received_at"},
+		source,
+	)
+}
+
+// channelExportBatchSize is the number of row fetched from the export cursor at once
+const channelExportBatchSize = 1000
+
+// Export call handle for every channel of the sensors ordered by time. The rows are read in batch
+// from a server side cursor so a long history is never loaded in memory at once.
+func (c *PostgresChannelRepository) Export(ctx context.Context, tx helper.Querier, sensorIds []int, query *entities.ChannelExportQuery, handle func(channel entities.ChannelExport) error) (err error) {
+	conditions := []string{"channel.id_sensor=ANY($1)"}
+	args := []interface{}{sensorIds}
+	if query.From != nil {
+		args = append(args, query.From.UTC())
+		conditions = append(conditions, fmt.Sprintf("channel.time>=$%d", len(args)))
+	}
+	if query.To != nil {
+		args = append(args, query.To.UTC())
+		conditions = append(conditions, fmt.Sprintf("channel.time<=$%d", len(args)))
+	}
+
+	// A cursor only live inside a transaction
+	cursorTx, err := tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer cursorTx.Rollback(ctx)
+
+	sqlStatement := fmt.Sprintf(`
+	DECLARE channel_export NO SCROLL CURSOR FOR
+	SELECT channel.id_channel, channel.time, channel.received_at, channel.id_sensor, sensor.name, sensor.unit, channel.value
+	FROM "channel" INNER JOIN "sensor" ON sensor.id_sensor=channel.id_sensor
+	WHERE %s
+	ORDER BY channel.time, channel.id_channel`, strings.Join(conditions, " AND "))
+	_, err = cursorTx.Exec(ctx, sqlStatement, args...)
+	if err != nil {
+		return err
+	}
+
+	fetchStatement := fmt.Sprintf(`FETCH FORWARD %d FROM channel_export`, channelExportBatchSize)
+	for {
+		rows, err := cursorTx.Query(ctx, fetchStatement)
+		if err != nil {
+			return err
+		}
+
+		count := 0
+		for rows.Next() {
+			var channel entities.ChannelExport
+			err = rows.Scan(&channel.IdChannel, &channel.Time, &channel.ReceivedAt, &channel.IdSensor, &channel.SensorName, &channel.Unit, &channel.Value)
+			if err == nil {
+				err = handle(channel)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			count++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if count < channelExportBatchSize {
+			return nil
+		}
+	}
+}
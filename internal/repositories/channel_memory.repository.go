@@ -0,0 +1,153 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/jackc/pgx/v5"
+)
+
+// MemoryChannelRepository is ChannelRepository on the memory database
+type MemoryChannelRepository struct{}
+
+func NewMemoryChannelRepository() (MemoryChannelRepository, error) {
+	return MemoryChannelRepository{}, nil
+}
+
+func (c *MemoryChannelRepository) NewChannel(payload *entities.ChannelCreate, receivedAt time.Time) (entities.Channel, error) {
+	return newChannel(payload, receivedAt)
+}
+
+func (c *MemoryChannelRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.ChannelCreate) (entities.Channel, error) {
+	channel, err := c.NewChannel(payload, time.Now().UTC())
+	if err != nil {
+		return channel, err
+	}
+
+	_, err = c.CreateBatch(ctx, tx, []entities.Channel{channel})
+	return channel, err
+}
+
+// CreateBatch insert every channel or none of them when one of the sensor doesn't exist, the hour of
+// the channels are marked as pending with them
+func (c *MemoryChannelRepository) CreateBatch(ctx context.Context, tx helper.Querier, channels []entities.Channel) (int64, error) {
+	err := memoryWrite(tx, func(data *memoryData) error {
+		for _, channel := range channels {
+			if _, ok := data.sensors[channel.IdSensor]; !ok {
+				return memoryForeignKeyError("channel", "id_sensor", channel.IdSensor)
+			}
+		}
+
+		for _, channel := range channels {
+			channel.IdChannel = data.nextId("channel")
+			data.channels = append(data.channels, channel)
+			data.rollupPending[memoryRollupKey{IdSensor: channel.IdSensor, Bucket: channel.Time.UTC().Truncate(time.Hour)}] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(channels)), nil
+}
+
+func (c *MemoryChannelRepository) DeleteBefore(ctx context.Context, tx helper.Querier, before time.Time, idSensor *int) (count int64, err error) {
+	err = memoryWrite(tx, func(data *memoryData) error {
+		count = data.deleteChannel(func(channel entities.Channel) bool {
+			return channel.Time.Before(before) && (idSensor == nil || channel.IdSensor == *idSensor)
+		})
+		return nil
+	})
+	return count, err
+}
+
+// CreateFromSource insert every channel of the source, the values of each row are time, value, id_sensor and received_at.
+// The source is read before the data is locked since it can read the database itself.
+func (c *MemoryChannelRepository) CreateFromSource(ctx context.Context, tx helper.Querier, source pgx.CopyFromSource) (int64, error) {
+	channels := []entities.Channel{}
+	for source.Next() {
+		values, err := source.Values()
+		if err != nil {
+			return 0, err
+		}
+		if len(values) != 4 {
+			return 0, fmt.Errorf("expected 4 values for channel, got %d", len(values))
+		}
+
+		channelTime, okTime := values[0].(time.Time)
+		value, okValue := values[1].(float64)
+		idSensor, okSensor := values[2].(int)
+		receivedAt, okReceivedAt := values[3].(time.Time)
+		if !okTime || !okValue || !okSensor || !okReceivedAt {
+			return 0, fmt.Errorf("invalid channel values %v", values)
+		}
+
+		channels = append(channels, entities.Channel{
+			Time:          channelTime.UTC(),
+			ReceivedAt:    receivedAt.UTC(),
+			ChannelCreate: entities.ChannelCreate{Value: value, IdSensor: idSensor},
+		})
+	}
+	if err := source.Err(); err != nil {
+		return 0, err
+	}
+
+	return c.CreateBatch(ctx, tx, channels)
+}
+
+// Export call handle for every channel of the sensors ordered by time. The rows are copied first
+// so handle run without holding the lock of the database.
+func (c *MemoryChannelRepository) Export(ctx context.Context, tx helper.Querier, sensorIds []int, query *entities.ChannelExportQuery, handle func(channel entities.ChannelExport) error) (err error) {
+	exports := []entities.ChannelExport{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		exported := map[int]bool{}
+		for _, idSensor := range sensorIds {
+			exported[idSensor] = true
+		}
+
+		for _, channel := range data.channels {
+			sensor, ok := data.sensors[channel.IdSensor]
+			if !ok || !exported[channel.IdSensor] {
+				continue
+			}
+			if query.From != nil && channel.Time.Before(query.From.UTC()) {
+				continue
+			}
+			if query.To != nil && channel.Time.After(query.To.UTC()) {
+				continue
+			}
+
+			exports = append(exports, entities.ChannelExport{
+				IdChannel:  channel.IdChannel,
+				Time:       channel.Time,
+				ReceivedAt: channel.ReceivedAt,
+				IdSensor:   channel.IdSensor,
+				SensorName: sensor.Name,
+				Unit:       sensor.Unit,
+				Value:      channel.Value,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(exports, func(i, j int) bool {
+		if !exports[i].Time.Equal(exports[j].Time) {
+			return exports[i].Time.Before(exports[j].Time)
+		}
+		return exports[i].IdChannel < exports[j].IdChannel
+	})
+	for _, export := range exports {
+		err = handle(export)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type PostgresFirmwareRepository struct{}
+
+func NewFirmwareRepository() (PostgresFirmwareRepository, error) {
+	return PostgresFirmwareRepository{}, nil
+}
+
+func (f *PostgresFirmwareRepository) firmwareField() string {
+	return "id_firmware, id_hardware, version, description, file_name, file_path, size, checksum, rollout_percentage, rollout_nodes, created_at"
+}
+
+func (f *PostgresFirmwareRepository) firmwarePointer(firmware *entities.Firmware) []interface{} {
+	return []interface{}{
+		&firmware.IdFirmware,
+		&firmware.IdHardware,
+		&firmware.Version,
+		&firmware.Description,
+		&firmware.FileName,
+		&firmware.FilePath,
+		&firmware.Size,
+		&firmware.Checksum,
+		&firmware.RolloutPercentage,
+		&firmware.RolloutNodes,
+		&firmware.CreatedAt,
+	}
+}
+
+func (f *PostgresFirmwareRepository) scanAll(rows pgx.Rows) (firmwares []entities.Firmware, err error) {
+	firmwares = []entities.Firmware{}
+	defer rows.Close()
+	for rows.Next() {
+		var firmware entities.Firmware
+		err := rows.Scan(f.firmwarePointer(&firmware)...)
+		if err != nil {
+			return firmwares, err
+		}
+		firmwares = append(firmwares, firmware)
+	}
+	if err := rows.Err(); err != nil {
+		return firmwares, err
+	}
+	return firmwares, nil
+}
+
+func (f *PostgresFirmwareRepository) Create(ctx context.Context, tx helper.Querier, firmware *entities.Firmware) (err error) {
+	if firmware.RolloutNodes == nil {
+		firmware.RolloutNodes = []int{}
+	}
+
+	sqlStatement := `
+	INSERT INTO firmware (
+		id_hardware,
+		version,
+		description,
+		file_name,
+		file_path,
+		size,
+		checksum,
+		rollout_percentage,
+		rollout_nodes
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id_firmware, created_at`
+	err = tx.QueryRow(ctx, sqlStatement, firmware.IdHardware, firmware.Version, firmware.Description, firmware.FileName,
+		firmware.FilePath, firmware.Size, firmware.Checksum, firmware.RolloutPercentage, firmware.RolloutNodes).Scan(&firmware.IdFirmware, &firmware.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fiber.NewError(400, fmt.Sprintf("Firmware version %s already exist for hardware %d", firmware.Version, firmware.IdHardware))
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (f *PostgresFirmwareRepository) GetAll(ctx context.Context, tx helper.Querier) (firmwares []entities.Firmware, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM firmware ORDER BY id_hardware, id_firmware DESC`, f.firmwareField())
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return []entities.Firmware{}, err
+	}
+	return f.scanAll(rows)
+}
+
+func (f *PostgresFirmwareRepository) GetHardwareFirmware(ctx context.Context, tx helper.Querier, idHardware int) (firmwares []entities.Firmware, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM firmware WHERE id_hardware=$1`, f.firmwareField())
+	rows, err := tx.Query(ctx, sqlStatement, idHardware)
+	if err != nil {
+		return []entities.Firmware{}, err
+	}
+	return f.scanAll(rows)
+}
+
+func (f *PostgresFirmwareRepository) GetById(ctx context.Context, tx helper.Querier, id int) (firmware entities.Firmware, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM firmware WHERE id_firmware=$1`, f.firmwareField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(f.firmwarePointer(&firmware)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return firmware, fiber.NewError(404, fmt.Sprintf("Firmware with id %d not found", id))
+		}
+		return firmware, err
+	}
+	return firmware, nil
+}
+
+func (f *PostgresFirmwareRepository) Update(ctx context.Context, tx helper.Querier, firmware *entities.Firmware, payload *entities.FirmwareUpdate) (err error) {
+	payload.ChangeSettedFieldOnly(firmware)
+
+	sqlStatement := `
+	UPDATE firmware
+	SET description=$1, rollout_percentage=$2, rollout_nodes=$3
+	WHERE id_firmware=$4`
+	res, err := tx.Exec(ctx, sqlStatement, payload.Description, *payload.RolloutPercentage, payload.RolloutNodes, firmware.IdFirmware)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update firmware with id %d", firmware.IdFirmware))
+	}
+	return nil
+}
+
+func (f *PostgresFirmwareRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	sqlStatement := `DELETE FROM firmware WHERE id_firmware=$1`
+	res, err := tx.Exec(ctx, sqlStatement, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MemoryFirmwareRepository is FirmwareRepository on the memory database
+type MemoryFirmwareRepository struct{}
+
+func NewMemoryFirmwareRepository() (MemoryFirmwareRepository, error) {
+	return MemoryFirmwareRepository{}, nil
+}
+
+func (f *MemoryFirmwareRepository) Create(ctx context.Context, tx helper.Querier, firmware *entities.Firmware) (err error) {
+	if firmware.RolloutNodes == nil {
+		firmware.RolloutNodes = []int{}
+	}
+
+	return memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.hardwares[firmware.IdHardware]; !ok {
+			return memoryForeignKeyError("firmware", "id_hardware", firmware.IdHardware)
+		}
+		for _, stored := range data.firmwares {
+			if stored.IdHardware == firmware.IdHardware && stored.Version == firmware.Version {
+				return fiber.NewError(400, fmt.Sprintf("Firmware version %s already exist for hardware %d", firmware.Version, firmware.IdHardware))
+			}
+		}
+
+		firmware.IdFirmware = int(data.nextId("firmware"))
+		firmware.CreatedAt = time.Now().UTC()
+		stored := *firmware
+		stored.RolloutNodes = append([]int{}, firmware.RolloutNodes...)
+		data.firmwares[firmware.IdFirmware] = stored
+		return nil
+	})
+}
+
+func (f *MemoryFirmwareRepository) filter(tx helper.Querier, condition func(firmware entities.Firmware) bool) (firmwares []entities.Firmware, err error) {
+	firmwares = []entities.Firmware{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, id := range sortedIds(data.firmwares) {
+			if condition(data.firmwares[id]) {
+				firmwares = append(firmwares, data.firmwares[id])
+			}
+		}
+		return nil
+	})
+	return firmwares, err
+}
+
+func (f *MemoryFirmwareRepository) GetAll(ctx context.Context, tx helper.Querier) (firmwares []entities.Firmware, err error) {
+	firmwares, err = f.filter(tx, func(firmware entities.Firmware) bool {
+		return true
+	})
+	sort.SliceStable(firmwares, func(i, j int) bool {
+		if firmwares[i].IdHardware != firmwares[j].IdHardware {
+			return firmwares[i].IdHardware < firmwares[j].IdHardware
+		}
+		return firmwares[i].IdFirmware > firmwares[j].IdFirmware
+	})
+	return firmwares, err
+}
+
+func (f *MemoryFirmwareRepository) GetHardwareFirmware(ctx context.Context, tx helper.Querier, idHardware int) (firmwares []entities.Firmware, err error) {
+	return f.filter(tx, func(firmware entities.Firmware) bool {
+		return firmware.IdHardware == idHardware
+	})
+}
+
+func (f *MemoryFirmwareRepository) GetById(ctx context.Context, tx helper.Querier, id int) (firmware entities.Firmware, err error) {
+	err = memoryRead(tx, func(data *memoryData) error {
+		var ok bool
+		firmware, ok = data.firmwares[id]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("Firmware with id %d not found", id))
+		}
+		return nil
+	})
+	return firmware, err
+}
+
+func (f *MemoryFirmwareRepository) Update(ctx context.Context, tx helper.Querier, firmware *entities.Firmware, payload *entities.FirmwareUpdate) (err error) {
+	payload.ChangeSettedFieldOnly(firmware)
+
+	return memoryWrite(tx, func(data *memoryData) error {
+		stored, ok := data.firmwares[firmware.IdFirmware]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on update firmware with id %d", firmware.IdFirmware))
+		}
+		stored.Description = payload.Description
+		stored.RolloutPercentage = *payload.RolloutPercentage
+		stored.RolloutNodes = append([]int{}, payload.RolloutNodes...)
+		data.firmwares[firmware.IdFirmware] = stored
+		return nil
+	})
+}
+
+func (f *MemoryFirmwareRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.firmwares[id]; !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+		}
+		delete(data.firmwares, id)
+		return nil
+	})
+}
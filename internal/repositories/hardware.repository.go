@@ -10,21 +10,21 @@ import (
 	"github.com/jackc/pgx/v5"
 )
 
-type HardwareRepository struct{}
+type PostgresHardwareRepository struct{}
 
-func NewHardwareRepository() (HardwareRepository, error) {
-	return HardwareRepository{}, nil
+func NewHardwareRepository() (PostgresHardwareRepository, error) {
+	return PostgresHardwareRepository{}, nil
 }
 
-func (u *HardwareRepository) hardwareField() string {
+func (u *PostgresHardwareRepository) hardwareField() string {
 	return "id_hardware, name, type, description"
 }
 
-func (u *HardwareRepository) hardwarePointer(hardware *entities.Hardware) []interface{} {
+func (u *PostgresHardwareRepository) hardwarePointer(hardware *entities.Hardware) []interface{} {
 	return []interface{}{&hardware.IdHardware, &hardware.Name, &hardware.Type, &hardware.Description}
 }
 
-func (h *HardwareRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.HardwareCreate) (hardware entities.Hardware, err error) {
+func (h *PostgresHardwareRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.HardwareCreate) (hardware entities.Hardware, err error) {
 	hardware = entities.Hardware{
 		HardwareCreate: *payload,
 	}
@@ -43,7 +43,7 @@ func (h *HardwareRepository) Create(ctx context.Context, tx helper.Querier, payl
 	return hardware, nil
 }
 
-func (u *HardwareRepository) getAllItem(ctx context.Context, tx helper.Querier, sqlStatement string) (hardwares []entities.Hardware, err error) {
+func (u *PostgresHardwareRepository) getAllItem(ctx context.Context, tx helper.Querier, sqlStatement string) (hardwares []entities.Hardware, err error) {
 	hardwares = []entities.Hardware{}
 	rows, err := tx.Query(ctx, sqlStatement)
 	if err != nil {
@@ -68,21 +68,21 @@ func (u *HardwareRepository) getAllItem(ctx context.Context, tx helper.Querier,
 
 }
 
-func (u *HardwareRepository) GetAllHardware(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error) {
+func (u *PostgresHardwareRepository) GetAllHardware(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error) {
 	sqlStatement := fmt.Sprintf(`SELECT %s FROM "hardware"`, u.hardwareField())
 	return u.getAllItem(ctx, tx, sqlStatement)
 }
 
-func (u *HardwareRepository) GetAllNode(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error) {
+func (u *PostgresHardwareRepository) GetAllNode(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error) {
 	sqlStatement := fmt.Sprintf(`SELECT %s FROM "hardware" WHERE lower(type) = 'single-board computer' or lower(type) = 'microcontroller unit'`, u.hardwareField())
 	return u.getAllItem(ctx, tx, sqlStatement)
 }
-func (u *HardwareRepository) GetAllSensor(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error) {
+func (u *PostgresHardwareRepository) GetAllSensor(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error) {
 	sqlStatement := fmt.Sprintf(`SELECT %s FROM "hardware" WHERE lower(type) = 'sensor'`, u.hardwareField())
 	return u.getAllItem(ctx, tx, sqlStatement)
 }
 
-func (u *HardwareRepository) GetById(ctx context.Context, tx helper.Querier, id int) (hardware entities.Hardware, err error) {
+func (u *PostgresHardwareRepository) GetById(ctx context.Context, tx helper.Querier, id int) (hardware entities.Hardware, err error) {
 	sqlStatement := fmt.Sprintf(`SELECT %s FROM "hardware" WHERE id_hardware=$1`, u.hardwareField())
 	err = tx.QueryRow(ctx, sqlStatement, id).Scan(
 		u.hardwarePointer(&hardware)...,
@@ -96,7 +96,7 @@ func (u *HardwareRepository) GetById(ctx context.Context, tx helper.Querier, id
 	return hardware, nil
 }
 
-func (u *HardwareRepository) Update(ctx context.Context, tx helper.Querier, hardware *entities.Hardware, payload *entities.HardwareUpdate) (err error) {
+func (u *PostgresHardwareRepository) Update(ctx context.Context, tx helper.Querier, hardware *entities.Hardware, payload *entities.HardwareUpdate) (err error) {
 	payload.ChangeSettedFieldOnly(hardware)
 
 	sqlStatement := `
@@ -114,7 +114,7 @@ func (u *HardwareRepository) Update(ctx context.Context, tx helper.Querier, hard
 	return nil
 }
 
-func (u *HardwareRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+func (u *PostgresHardwareRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
 	sqlStatement := `DELETE FROM "hardware" WHERE id_hardware=$1`
 	res, err := tx.Exec(ctx, sqlStatement, id)
 	if err != nil {
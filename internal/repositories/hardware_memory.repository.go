@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MemoryHardwareRepository is HardwareRepository on the memory database
+type MemoryHardwareRepository struct{}
+
+func NewMemoryHardwareRepository() (MemoryHardwareRepository, error) {
+	return MemoryHardwareRepository{}, nil
+}
+
+func (h *MemoryHardwareRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.HardwareCreate) (hardware entities.Hardware, err error) {
+	hardware = entities.Hardware{
+		HardwareCreate: *payload,
+	}
+	err = memoryWrite(tx, func(data *memoryData) error {
+		hardware.IdHardware = int(data.nextId("hardware"))
+		data.hardwares[hardware.IdHardware] = hardware
+		return nil
+	})
+	return hardware, err
+}
+
+func (h *MemoryHardwareRepository) getAllItem(tx helper.Querier, types ...string) (hardwares []entities.Hardware, err error) {
+	hardwares = []entities.Hardware{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, id := range sortedIds(data.hardwares) {
+			hardware := data.hardwares[id]
+			match := len(types) == 0
+			for _, hardwareType := range types {
+				if strings.ToLower(hardware.Type) == hardwareType {
+					match = true
+				}
+			}
+			if match {
+				hardwares = append(hardwares, hardware)
+			}
+		}
+		return nil
+	})
+	return hardwares, err
+}
+
+func (h *MemoryHardwareRepository) GetAllHardware(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error) {
+	return h.getAllItem(tx)
+}
+
+func (h *MemoryHardwareRepository) GetAllNode(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error) {
+	return h.getAllItem(tx, "single-board computer", "microcontroller unit")
+}
+
+func (h *MemoryHardwareRepository) GetAllSensor(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error) {
+	return h.getAllItem(tx, "sensor")
+}
+
+func (h *MemoryHardwareRepository) GetById(ctx context.Context, tx helper.Querier, id int) (hardware entities.Hardware, err error) {
+	err = memoryRead(tx, func(data *memoryData) error {
+		var ok bool
+		hardware, ok = data.hardwares[id]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("Hardware with id %d not found", id))
+		}
+		return nil
+	})
+	return hardware, err
+}
+
+func (h *MemoryHardwareRepository) Update(ctx context.Context, tx helper.Querier, hardware *entities.Hardware, payload *entities.HardwareUpdate) (err error) {
+	payload.ChangeSettedFieldOnly(hardware)
+
+	return memoryWrite(tx, func(data *memoryData) error {
+		stored, ok := data.hardwares[hardware.IdHardware]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on update hardware with id %d", hardware.IdHardware))
+		}
+		stored.Name = payload.Name
+		stored.Type = payload.Type
+		stored.Description = payload.Description
+		data.hardwares[hardware.IdHardware] = stored
+		return nil
+	})
+}
+
+func (h *MemoryHardwareRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.hardwares[id]; !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+		}
+		data.deleteHardware(id)
+		return nil
+	})
+}
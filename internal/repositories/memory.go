@@ -0,0 +1,552 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrMemorySql is returned when sql is run on the memory database, only the memory repositories can use it
+	ErrMemorySql = errors.New("sql is not supported by the memory database")
+	// ErrMemoryQuerier is returned when a memory repository is called with a postgres connection
+	ErrMemoryQuerier = errors.New("memory repository can only be used with a memory database")
+)
+
+type memoryUser struct {
+	entities.UserRead
+	Password      string
+	RetentionDays *int
+}
+
+type memoryNode struct {
+	entities.Node
+	NotifiedStatus *string
+}
+
+type memoryOrganizationMemberKey struct {
+	IdOrganization int
+	IdUser         int
+}
+
+type memoryOrganizationMember struct {
+	Role      string
+	CreatedAt time.Time
+}
+
+type memoryNodeKey struct {
+	entities.NodeKey
+	KeyHash string
+}
+
+type memoryRefreshToken struct {
+	entities.RefreshToken
+	TokenHash string
+}
+
+type memoryPasswordReset struct {
+	entities.PasswordReset
+	TokenHash string
+}
+
+type memoryWebhook struct {
+	entities.Webhook
+	Secret string
+}
+
+type memoryWebhookDelivery struct {
+	entities.WebhookDelivery
+	Payload []byte
+}
+
+type memoryRollupKey struct {
+	IdSensor int
+	Bucket   time.Time
+}
+
+type memoryRollup struct {
+	Count int64
+	Min   float64
+	Max   float64
+	Sum   float64
+}
+
+type memoryPartition struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+// memoryData is every table of the memory database. Row are stored by value and slice field are
+// replaced instead of modified, so a shallow copy of every table is enough to take a snapshot.
+type memoryData struct {
+	sequences         map[string]int64
+	users             map[int]memoryUser
+	refreshTokens     map[int]memoryRefreshToken
+	passwordResets    map[int]memoryPasswordReset
+	hardwares         map[int]entities.Hardware
+	organizations     map[int]entities.Organization
+	members           map[memoryOrganizationMemberKey]memoryOrganizationMember
+	nodes             map[int]memoryNode
+	nodeKeys          map[int]memoryNodeKey
+	sensors           map[int]entities.Sensor
+	channels          []entities.Channel
+	alertRules        map[int]entities.AlertRule
+	alertEvents       []entities.AlertEvent
+	webhooks          map[int]memoryWebhook
+	webhookDeliveries []memoryWebhookDelivery
+	nodeCommands      map[int]entities.NodeCommand
+	nodeShadows       map[int]entities.NodeShadow
+	firmwares         map[int]entities.Firmware
+	partitions        []memoryPartition
+	rollupPending     map[memoryRollupKey]struct{}
+	rollupHours       map[memoryRollupKey]memoryRollup
+	rollupDays        map[memoryRollupKey]memoryRollup
+}
+
+func newMemoryData() *memoryData {
+	return &memoryData{
+		sequences:         map[string]int64{},
+		users:             map[int]memoryUser{},
+		refreshTokens:     map[int]memoryRefreshToken{},
+		passwordResets:    map[int]memoryPasswordReset{},
+		hardwares:         map[int]entities.Hardware{},
+		organizations:     map[int]entities.Organization{},
+		members:           map[memoryOrganizationMemberKey]memoryOrganizationMember{},
+		nodes:             map[int]memoryNode{},
+		nodeKeys:          map[int]memoryNodeKey{},
+		sensors:           map[int]entities.Sensor{},
+		channels:          []entities.Channel{},
+		alertRules:        map[int]entities.AlertRule{},
+		alertEvents:       []entities.AlertEvent{},
+		webhooks:          map[int]memoryWebhook{},
+		webhookDeliveries: []memoryWebhookDelivery{},
+		nodeCommands:      map[int]entities.NodeCommand{},
+		nodeShadows:       map[int]entities.NodeShadow{},
+		firmwares:         map[int]entities.Firmware{},
+		partitions:        []memoryPartition{},
+		rollupPending:     map[memoryRollupKey]struct{}{},
+		rollupHours:       map[memoryRollupKey]memoryRollup{},
+		rollupDays:        map[memoryRollupKey]memoryRollup{},
+	}
+}
+
+func cloneMemoryTable[K comparable, V any](table map[K]V) map[K]V {
+	cloned := make(map[K]V, len(table))
+	for key, value := range table {
+		cloned[key] = value
+	}
+	return cloned
+}
+
+func cloneMemorySlice[V any](rows []V) []V {
+	return append(make([]V, 0, len(rows)), rows...)
+}
+
+// clone copy the data for a transaction. The channels and the alert events are the largest tables and are only
+// appended to or replaced by a filtered copy, never changed in place, so the transaction share them instead of
+// copying them. Its append write after the length seen by the other readers, and writes are serialized by the
+// writeMutex, so the shared rows are never changed and an append lost on rollback is overwritten by the next one.
+func (d *memoryData) clone() *memoryData {
+	return &memoryData{
+		sequences:         cloneMemoryTable(d.sequences),
+		users:             cloneMemoryTable(d.users),
+		refreshTokens:     cloneMemoryTable(d.refreshTokens),
+		passwordResets:    cloneMemoryTable(d.passwordResets),
+		hardwares:         cloneMemoryTable(d.hardwares),
+		organizations:     cloneMemoryTable(d.organizations),
+		members:           cloneMemoryTable(d.members),
+		nodes:             cloneMemoryTable(d.nodes),
+		nodeKeys:          cloneMemoryTable(d.nodeKeys),
+		sensors:           cloneMemoryTable(d.sensors),
+		channels:          d.channels,
+		alertRules:        cloneMemoryTable(d.alertRules),
+		alertEvents:       d.alertEvents,
+		webhooks:          cloneMemoryTable(d.webhooks),
+		webhookDeliveries: cloneMemorySlice(d.webhookDeliveries),
+		nodeCommands:      cloneMemoryTable(d.nodeCommands),
+		nodeShadows:       cloneMemoryTable(d.nodeShadows),
+		firmwares:         cloneMemoryTable(d.firmwares),
+		partitions:        cloneMemorySlice(d.partitions),
+		rollupPending:     cloneMemoryTable(d.rollupPending),
+		rollupHours:       cloneMemoryTable(d.rollupHours),
+		rollupDays:        cloneMemoryTable(d.rollupDays),
+	}
+}
+
+// nextId return the next value of the sequence of the table, like a SERIAL column
+func (d *memoryData) nextId(table string) int64 {
+	d.sequences[table]++
+	return d.sequences[table]
+}
+
+// sortedIds return the key of the table in ascending order, the order postgres usually return row without ORDER BY
+func sortedIds[V any](table map[int]V) []int {
+	ids := make([]int, 0, len(table))
+	for id := range table {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// The delete below cascade to the referencing table the same way as the ON DELETE CASCADE foreign key
+
+func (d *memoryData) deleteUser(id int) {
+	delete(d.users, id)
+	for idRefreshToken, refreshToken := range d.refreshTokens {
+		if refreshToken.IdUser == id {
+			delete(d.refreshTokens, idRefreshToken)
+		}
+	}
+	for idPasswordReset, passwordReset := range d.passwordResets {
+		if passwordReset.IdUser == id {
+			delete(d.passwordResets, idPasswordReset)
+		}
+	}
+	for idNode, node := range d.nodes {
+		if node.IdUser == id {
+			d.deleteNode(idNode)
+		}
+	}
+	for idWebhook, webhook := range d.webhooks {
+		if webhook.IdUser == id {
+			d.deleteWebhook(idWebhook)
+		}
+	}
+	for key := range d.members {
+		if key.IdUser == id {
+			delete(d.members, key)
+		}
+	}
+	for idOrganization, organization := range d.organizations {
+		if organization.IdPersonalUser != nil && *organization.IdPersonalUser == id {
+			d.deleteOrganization(idOrganization)
+		}
+	}
+}
+
+func (d *memoryData) deleteOrganization(id int) {
+	delete(d.organizations, id)
+	for key := range d.members {
+		if key.IdOrganization == id {
+			delete(d.members, key)
+		}
+	}
+	for idNode, node := range d.nodes {
+		if node.IdOrganization == id {
+			d.deleteNode(idNode)
+		}
+	}
+}
+
+// memberRole get the role of the user in the organization, it is empty when the user is not a member
+func (d *memoryData) memberRole(idOrganization int, idUser int) string {
+	return d.members[memoryOrganizationMemberKey{IdOrganization: idOrganization, IdUser: idUser}].Role
+}
+
+func (d *memoryData) deleteHardware(id int) {
+	delete(d.hardwares, id)
+	for idNode, node := range d.nodes {
+		if node.IdHardware == id {
+			d.deleteNode(idNode)
+		}
+	}
+	for idSensor, sensor := range d.sensors {
+		if sensor.IdHardware == id {
+			d.deleteSensor(idSensor)
+		}
+	}
+	for idFirmware, firmware := range d.firmwares {
+		if firmware.IdHardware == id {
+			delete(d.firmwares, idFirmware)
+		}
+	}
+}
+
+func (d *memoryData) deleteNode(id int) {
+	delete(d.nodes, id)
+	delete(d.nodeShadows, id)
+	for idNodeKey, nodeKey := range d.nodeKeys {
+		if nodeKey.IdNode == id {
+			delete(d.nodeKeys, idNodeKey)
+		}
+	}
+	for idNodeCommand, command := range d.nodeCommands {
+		if command.IdNode == id {
+			delete(d.nodeCommands, idNodeCommand)
+		}
+	}
+	for idSensor, sensor := range d.sensors {
+		if sensor.IdNode == id {
+			d.deleteSensor(idSensor)
+		}
+	}
+}
+
+func (d *memoryData) deleteSensor(id int) {
+	delete(d.sensors, id)
+	d.deleteChannel(func(channel entities.Channel) bool {
+		return channel.IdSensor == id
+	})
+	for idAlertRule, alertRule := range d.alertRules {
+		if alertRule.IdSensor == id {
+			d.deleteAlertRule(idAlertRule)
+		}
+	}
+	for _, table := range []map[memoryRollupKey]memoryRollup{d.rollupHours, d.rollupDays} {
+		for key := range table {
+			if key.IdSensor == id {
+				delete(table, key)
+			}
+		}
+	}
+	for key := range d.rollupPending {
+		if key.IdSensor == id {
+			delete(d.rollupPending, key)
+		}
+	}
+}
+
+func (d *memoryData) deleteAlertRule(id int) {
+	delete(d.alertRules, id)
+	events := []entities.AlertEvent{}
+	for _, event := range d.alertEvents {
+		if event.IdAlertRule != id {
+			events = append(events, event)
+		}
+	}
+	d.alertEvents = events
+}
+
+func (d *memoryData) deleteWebhook(id int) {
+	delete(d.webhooks, id)
+	deliveries := []memoryWebhookDelivery{}
+	for _, delivery := range d.webhookDeliveries {
+		if delivery.IdWebhook != id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	d.webhookDeliveries = deliveries
+}
+
+// deleteChannel delete every channel matching the condition and return how many were deleted,
+// the hour of the deleted channel are recomputed by the rollup worker
+func (d *memoryData) deleteChannel(condition func(channel entities.Channel) bool) int64 {
+	channels := make([]entities.Channel, 0, len(d.channels))
+	for _, channel := range d.channels {
+		if !condition(channel) {
+			channels = append(channels, channel)
+		} else {
+			d.rollupPending[memoryRollupKey{IdSensor: channel.IdSensor, Bucket: channel.Time.UTC().Truncate(time.Hour)}] = struct{}{}
+		}
+	}
+	count := int64(len(d.channels) - len(channels))
+	d.channels = channels
+	return count
+}
+
+// MemoryDatabase is an in memory replacement of the postgres pool used for test and demo. It only
+// implement helper.Querier so it can be passed to the memory repositories, any sql return ErrMemorySql.
+// Transactions work on a copy of the data, sharing the append only tables, that replace it on commit. They are
+// serialized with the write done outside a transaction, while read outside a transaction only see committed data.
+type MemoryDatabase struct {
+	// writeMutex is held by the open transaction or the running write
+	writeMutex sync.Mutex
+	// mutex guard data
+	mutex sync.RWMutex
+	data  *memoryData
+}
+
+func NewMemoryDatabase() *MemoryDatabase {
+	return &MemoryDatabase{data: newMemoryData()}
+}
+
+func (m *MemoryDatabase) Begin(ctx context.Context) (pgx.Tx, error) {
+	m.writeMutex.Lock()
+	m.mutex.RLock()
+	data := m.data.clone()
+	m.mutex.RUnlock()
+	return &memoryTx{database: m, data: data}, nil
+}
+
+func (m *MemoryDatabase) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, ErrMemorySql
+}
+
+func (m *MemoryDatabase) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return nil, ErrMemorySql
+}
+
+func (m *MemoryDatabase) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return memoryErrorRow{err: ErrMemorySql}
+}
+
+func (m *MemoryDatabase) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return 0, ErrMemorySql
+}
+
+type memoryErrorRow struct {
+	err error
+}
+
+func (r memoryErrorRow) Scan(dest ...any) error {
+	return r.err
+}
+
+// memoryTx is a transaction of the memory database, a nested transaction has a parent instead of a database
+type memoryTx struct {
+	database *MemoryDatabase
+	parent   *memoryTx
+	data     *memoryData
+	closed   bool
+}
+
+func (t *memoryTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	if t.closed {
+		return nil, pgx.ErrTxClosed
+	}
+	return &memoryTx{parent: t, data: t.data.clone()}, nil
+}
+
+func (t *memoryTx) Commit(ctx context.Context) error {
+	if t.closed {
+		return pgx.ErrTxClosed
+	}
+	t.closed = true
+
+	if t.parent != nil {
+		t.parent.data = t.data
+		return nil
+	}
+	t.database.mutex.Lock()
+	t.database.data = t.data
+	t.database.mutex.Unlock()
+	t.database.writeMutex.Unlock()
+	return nil
+}
+
+func (t *memoryTx) Rollback(ctx context.Context) error {
+	if t.closed {
+		return pgx.ErrTxClosed
+	}
+	t.closed = true
+
+	if t.parent == nil {
+		t.database.writeMutex.Unlock()
+	}
+	return nil
+}
+
+func (t *memoryTx) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return 0, ErrMemorySql
+}
+
+func (t *memoryTx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return memoryErrorBatch{}
+}
+
+func (t *memoryTx) LargeObjects() pgx.LargeObjects {
+	return pgx.LargeObjects{}
+}
+
+func (t *memoryTx) Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error) {
+	return nil, ErrMemorySql
+}
+
+func (t *memoryTx) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, ErrMemorySql
+}
+
+func (t *memoryTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return nil, ErrMemorySql
+}
+
+func (t *memoryTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return memoryErrorRow{err: ErrMemorySql}
+}
+
+func (t *memoryTx) Conn() *pgx.Conn {
+	return nil
+}
+
+type memoryErrorBatch struct{}
+
+func (b memoryErrorBatch) Exec() (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, ErrMemorySql
+}
+
+func (b memoryErrorBatch) Query() (pgx.Rows, error) {
+	return nil, ErrMemorySql
+}
+
+func (b memoryErrorBatch) QueryRow() pgx.Row {
+	return memoryErrorRow{err: ErrMemorySql}
+}
+
+func (b memoryErrorBatch) Close() error {
+	return ErrMemorySql
+}
+
+// memoryRead run handle on the data seen by tx
+func memoryRead(tx helper.Querier, handle func(data *memoryData) error) error {
+	switch tx := tx.(type) {
+	case *memoryTx:
+		if tx.closed {
+			return pgx.ErrTxClosed
+		}
+		return handle(tx.data)
+	case *MemoryDatabase:
+		tx.mutex.RLock()
+		defer tx.mutex.RUnlock()
+		return handle(tx.data)
+	default:
+		return ErrMemoryQuerier
+	}
+}
+
+// memoryWrite run handle on the data changed by tx, outside a transaction handle must check
+// everything before changing the data because there is nothing to roll back
+func memoryWrite(tx helper.Querier, handle func(data *memoryData) error) error {
+	switch tx := tx.(type) {
+	case *memoryTx:
+		if tx.closed {
+			return pgx.ErrTxClosed
+		}
+		return handle(tx.data)
+	case *MemoryDatabase:
+		tx.writeMutex.Lock()
+		defer tx.writeMutex.Unlock()
+		tx.mutex.Lock()
+		defer tx.mutex.Unlock()
+		return handle(tx.data)
+	default:
+		return ErrMemoryQuerier
+	}
+}
+
+// memoryConstraintError is returned when a write break a constraint, like the integrity constraint violation of postgres
+type memoryConstraintError struct {
+	message string
+}
+
+func (e *memoryConstraintError) Error() string {
+	return e.message
+}
+
+// memoryForeignKeyError mimic the error of postgres when a row reference a row that doesn't exist
+func memoryForeignKeyError(table string, column string, id int) error {
+	return &memoryConstraintError{fmt.Sprintf("insert or update on table %q violates foreign key constraint, %s %d is not present", table, column, id)}
+}
+
+func memoryUniqueError(table string, column string, value interface{}) error {
+	return &memoryConstraintError{fmt.Sprintf("duplicate key value violates unique constraint on table %q, %s %v already exists", table, column, value)}
+}
@@ -3,32 +3,39 @@ package repositories
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"github.com/dafaath/iot-server/configs"
 	"github.com/dafaath/iot-server/internal/entities"
 	"github.com/dafaath/iot-server/internal/helper"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jackc/pgx/v5"
 )
 
-type NodeRepository struct{}
+type PostgresNodeRepository struct{}
 
-func NewNodeRepository() (NodeRepository, error) {
-	return NodeRepository{}, nil
+func NewNodeRepository() (PostgresNodeRepository, error) {
+	return PostgresNodeRepository{}, nil
 }
 
-func (u *NodeRepository) nodeFieldWithoutId() string {
-	return "name, location, id_user, id_hardware"
+func (u *PostgresNodeRepository) nodeFieldWithoutId() string {
+	return "name, location, id_user, id_organization, id_hardware, offline_timeout, notify_offline"
 }
 
-func (u *NodeRepository) nodeField() string {
-	return "id_node, " + u.nodeFieldWithoutId()
+func (u *PostgresNodeRepository) nodeField() string {
+	return "id_node, " + u.nodeFieldWithoutId() + ", last_seen"
 }
 
-func (u *NodeRepository) nodePointer(node *entities.Node) []interface{} {
-	return []interface{}{&node.IdNode, &node.Name, &node.Location, &node.IdUser, &node.IdHardware}
+func (u *PostgresNodeRepository) nodePointer(node *entities.Node) []interface{} {
+	return []interface{}{&node.IdNode, &node.Name, &node.Location, &node.IdUser, &node.IdOrganization, &node.IdHardware, &node.OfflineTimeout, &node.NotifyOffline, &node.LastSeen}
 }
 
-func (h *NodeRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.NodeCreate, currentUser *entities.UserRead) (node entities.Node, err error) {
+func (u *PostgresNodeRepository) setStatus(node *entities.Node) {
+	config := configs.GetConfig()
+	node.SetStatus(time.Now().UTC(), config.Heartbeat.OfflineTimeout)
+}
+
+func (h *PostgresNodeRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.NodeCreate, currentUser *entities.UserRead) (node entities.Node, err error) {
 	node = entities.Node{
 		NodeCreate: *payload,
 		IdUser:     currentUser.IdUser,
@@ -37,16 +44,17 @@ func (h *NodeRepository) Create(ctx context.Context, tx helper.Querier, payload
 	INSERT INTO "node" (
 		%s
 	)
-	VALUES ($1, $2, $3, $4) RETURNING id_node`, h.nodeFieldWithoutId())
-	err = tx.QueryRow(ctx, sqlStatement, node.Name, node.Location, node.IdUser, node.IdHardware).Scan(&node.IdNode)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id_node`, h.nodeFieldWithoutId())
+	err = tx.QueryRow(ctx, sqlStatement, node.Name, node.Location, node.IdUser, node.IdOrganization, node.IdHardware, node.OfflineTimeout, node.NotifyOffline).Scan(&node.IdNode)
 	if err != nil {
 		return node, err
 	}
+	h.setStatus(&node)
 
 	return node, nil
 }
 
-func (u *NodeRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (nodes []entities.Node, err error) {
+func (u *PostgresNodeRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (nodes []entities.Node, err error) {
 	nodes = []entities.Node{}
 	var sqlStatement string
 	var rows pgx.Rows
@@ -58,7 +66,7 @@ func (u *NodeRepository) GetAll(ctx context.Context, tx helper.Querier, currentU
 		}
 		defer rows.Close()
 	} else {
-		sqlStatement = fmt.Sprintf(`SELECT %s FROM "node" WHERE id_user=$1`, u.nodeField())
+		sqlStatement = fmt.Sprintf(`SELECT %s FROM "node" WHERE id_organization IN (SELECT id_organization FROM organization_member WHERE id_user=$1)`, u.nodeField())
 		rows, err = tx.Query(ctx, sqlStatement, currentUser.IdUser)
 		if err != nil {
 			return nodes, err
@@ -74,6 +82,7 @@ func (u *NodeRepository) GetAll(ctx context.Context, tx helper.Querier, currentU
 		if err != nil {
 			return nodes, err
 		}
+		u.setStatus(&node)
 		nodes = append(nodes, node)
 	}
 	if err := rows.Err(); err != nil {
@@ -82,7 +91,7 @@ func (u *NodeRepository) GetAll(ctx context.Context, tx helper.Querier, currentU
 	return nodes, nil
 }
 
-func (u *NodeRepository) GetById(ctx context.Context, tx helper.Querier, id int) (node entities.Node, err error) {
+func (u *PostgresNodeRepository) GetById(ctx context.Context, tx helper.Querier, id int) (node entities.Node, err error) {
 	sqlStatement := fmt.Sprintf(`SELECT %s FROM "node" WHERE id_node=$1`, u.nodeField())
 	err = tx.QueryRow(ctx, sqlStatement, id).Scan(
 		u.nodePointer(&node)...,
@@ -93,10 +102,11 @@ func (u *NodeRepository) GetById(ctx context.Context, tx helper.Querier, id int)
 		}
 		return node, err
 	}
+	u.setStatus(&node)
 	return node, nil
 }
 
-func (u *NodeRepository) GetHardwareNode(ctx context.Context, tx helper.Querier, hardwareId int) ([]entities.Node, error) {
+func (u *PostgresNodeRepository) GetHardwareNode(ctx context.Context, tx helper.Querier, hardwareId int) ([]entities.Node, error) {
 	nodes := []entities.Node{}
 	sqlStatement := fmt.Sprintf(`SELECT %s FROM "node" WHERE id_hardware=$1`, u.nodeField())
 	rows, err := tx.Query(ctx, sqlStatement, hardwareId)
@@ -113,6 +123,34 @@ func (u *NodeRepository) GetHardwareNode(ctx context.Context, tx helper.Querier,
 		if err != nil {
 			return nodes, err
 		}
+		u.setStatus(&node)
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nodes, err
+	}
+
+	return nodes, nil
+}
+
+func (u *PostgresNodeRepository) GetOrganizationNode(ctx context.Context, tx helper.Querier, organizationId int) ([]entities.Node, error) {
+	nodes := []entities.Node{}
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "node" WHERE id_organization=$1`, u.nodeField())
+	rows, err := tx.Query(ctx, sqlStatement, organizationId)
+	if err != nil {
+		return nodes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var node entities.Node
+		err := rows.Scan(
+			u.nodePointer(&node)...,
+		)
+		if err != nil {
+			return nodes, err
+		}
+		u.setStatus(&node)
 		nodes = append(nodes, node)
 	}
 	if err := rows.Err(); err != nil {
@@ -122,14 +160,17 @@ func (u *NodeRepository) GetHardwareNode(ctx context.Context, tx helper.Querier,
 	return nodes, nil
 }
 
-func (u *NodeRepository) Update(ctx context.Context, tx helper.Querier, node *entities.Node, payload *entities.NodeUpdate) (err error) {
+func (u *PostgresNodeRepository) Update(ctx context.Context, tx helper.Querier, node *entities.Node, payload *entities.NodeUpdate) (err error) {
 	payload.ChangeSettedFieldOnly(node)
 
+	// Forget the notified status when the notification is turned on or off, so turning it on doesn't
+	// send an email for a node that is already offline
 	sqlStatement := `
 	UPDATE "node"
-	SET name=$1, location=$2
-	WHERE id_node=$3`
-	res, err := tx.Exec(ctx, sqlStatement, payload.Name, payload.Location, node.IdNode)
+	SET name=$1, location=$2, offline_timeout=$3, notify_offline=$4,
+		notified_status=CASE WHEN notify_offline=$4 THEN notified_status ELSE NULL END
+	WHERE id_node=$5`
+	res, err := tx.Exec(ctx, sqlStatement, payload.Name, payload.Location, payload.OfflineTimeout, *payload.NotifyOffline, node.IdNode)
 	if err != nil {
 		return err
 	}
@@ -140,7 +181,7 @@ func (u *NodeRepository) Update(ctx context.Context, tx helper.Querier, node *en
 	return nil
 }
 
-func (u *NodeRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+func (u *PostgresNodeRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
 	sqlStatement := `DELETE FROM "node" WHERE id_node=$1`
 	res, err := tx.Exec(ctx, sqlStatement, id)
 	if err != nil {
@@ -152,3 +193,89 @@ func (u *NodeRepository) Delete(ctx context.Context, tx helper.Querier, id int)
 	}
 	return nil
 }
+
+// Touch set the last seen time of the node to now
+func (u *PostgresNodeRepository) Touch(ctx context.Context, tx helper.Querier, id int) (node entities.Node, err error) {
+	sqlStatement := fmt.Sprintf(`
+	UPDATE "node"
+	SET last_seen=(NOW() AT TIME ZONE 'utc')
+	WHERE id_node=$1
+	RETURNING %s`, u.nodeField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(u.nodePointer(&node)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return node, fiber.NewError(404, fmt.Sprintf("Node with id %d not found", id))
+		}
+		return node, err
+	}
+	u.setStatus(&node)
+	return node, nil
+}
+
+// TouchBySensor set the last seen time of every node that own one of the sensors to now
+func (u *PostgresNodeRepository) TouchBySensor(ctx context.Context, tx helper.Querier, sensorIds []int) (err error) {
+	sqlStatement := `
+	UPDATE "node"
+	SET last_seen=(NOW() AT TIME ZONE 'utc')
+	WHERE id_node IN (SELECT id_node FROM "sensor" WHERE id_sensor=ANY($1))`
+	_, err = tx.Exec(ctx, sqlStatement, sensorIds)
+	return err
+}
+
+// CheckStatus find every node with notify_offline that went offline or came back online since the last check
+// and save its new status, tx should be a transaction. A stale node is still considered online.
+func (u *PostgresNodeRepository) CheckStatus(ctx context.Context, tx helper.Querier) (notifications []entities.NodeStatusNotification, err error) {
+	notifications = []entities.NodeStatusNotification{}
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s, notified_status
+	FROM "node"
+	WHERE notify_offline
+	FOR UPDATE OF "node" SKIP LOCKED`, u.nodeField())
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return notifications, err
+	}
+
+	type nodeStatus struct {
+		notification   entities.NodeStatusNotification
+		notifiedStatus *string
+	}
+	nodeStatuses := []nodeStatus{}
+	for rows.Next() {
+		var status nodeStatus
+		pointers := append(u.nodePointer(&status.notification.Node), &status.notifiedStatus)
+		err := rows.Scan(pointers...)
+		if err != nil {
+			rows.Close()
+			return notifications, err
+		}
+		u.setStatus(&status.notification.Node)
+		nodeStatuses = append(nodeStatuses, status)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return notifications, err
+	}
+
+	for _, status := range nodeStatuses {
+		newStatus := entities.NodeStatusOnline
+		if status.notification.Status == entities.NodeStatusOffline {
+			newStatus = entities.NodeStatusOffline
+		}
+		if status.notifiedStatus != nil && *status.notifiedStatus == newStatus {
+			continue
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE "node" SET notified_status=$1 WHERE id_node=$2`, newStatus, status.notification.IdNode)
+		if err != nil {
+			return notifications, err
+		}
+
+		// The first check only save the current status
+		if status.notifiedStatus != nil {
+			notifications = append(notifications, status.notification)
+		}
+	}
+
+	return notifications, nil
+}
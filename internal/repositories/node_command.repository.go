@@ -0,0 +1,182 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// nodeCommandHistoryLimit is the number of latest command shown on the node detail
+const nodeCommandHistoryLimit = 100
+
+type PostgresNodeCommandRepository struct{}
+
+func NewNodeCommandRepository() (PostgresNodeCommandRepository, error) {
+	return PostgresNodeCommandRepository{}, nil
+}
+
+func (n *PostgresNodeCommandRepository) nodeCommandField() string {
+	return "id_node_command, id_node, name, args, status, result, created_at, expires_at, delivered_at, acked_at"
+}
+
+func (n *PostgresNodeCommandRepository) nodeCommandPointer(command *entities.NodeCommand) []interface{} {
+	return []interface{}{
+		&command.IdNodeCommand,
+		&command.IdNode,
+		&command.Name,
+		&command.Args,
+		&command.Status,
+		&command.Result,
+		&command.CreatedAt,
+		&command.ExpiresAt,
+		&command.DeliveredAt,
+		&command.AckedAt,
+	}
+}
+
+func (n *PostgresNodeCommandRepository) scanAll(rows pgx.Rows) (commands []entities.NodeCommand, err error) {
+	commands = []entities.NodeCommand{}
+	defer rows.Close()
+	for rows.Next() {
+		var command entities.NodeCommand
+		err := rows.Scan(n.nodeCommandPointer(&command)...)
+		if err != nil {
+			return commands, err
+		}
+		commands = append(commands, command)
+	}
+	if err := rows.Err(); err != nil {
+		return commands, err
+	}
+	return commands, nil
+}
+
+func (n *PostgresNodeCommandRepository) Create(ctx context.Context, tx helper.Querier, idNode int, payload *entities.NodeCommandCreate) (command entities.NodeCommand, err error) {
+	config := configs.GetConfig()
+	args := payload.Args
+	if len(args) == 0 || string(args) == "null" {
+		args = json.RawMessage("{}")
+	}
+
+	ttl := config.Command.DefaultTTL
+	if payload.ExpiresIn > 0 {
+		ttl = time.Duration(payload.ExpiresIn) * time.Second
+	}
+
+	sqlStatement := fmt.Sprintf(`
+	INSERT INTO node_command (
+		id_node,
+		name,
+		args,
+		expires_at
+	)
+	VALUES ($1, $2, $3, $4) RETURNING %s`, n.nodeCommandField())
+	err = tx.QueryRow(ctx, sqlStatement, idNode, payload.Name, args, time.Now().UTC().Add(ttl)).Scan(n.nodeCommandPointer(&command)...)
+	if err != nil {
+		return command, err
+	}
+
+	return command, nil
+}
+
+// Expire mark the command of the node that are not acknowledged before their expiry time
+func (n *PostgresNodeCommandRepository) Expire(ctx context.Context, tx helper.Querier, idNode int) (err error) {
+	sqlStatement := `
+	UPDATE node_command
+	SET status='expired'
+	WHERE id_node=$1 AND status IN ('pending', 'delivered') AND expires_at<=(NOW() AT TIME ZONE 'utc')`
+	_, err = tx.Exec(ctx, sqlStatement, idNode)
+	return err
+}
+
+// GetHistory get the latest command of the node, newest first
+func (n *PostgresNodeCommandRepository) GetHistory(ctx context.Context, tx helper.Querier, idNode int) (commands []entities.NodeCommand, err error) {
+	err = n.Expire(ctx, tx, idNode)
+	if err != nil {
+		return commands, err
+	}
+
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM node_command
+	WHERE id_node=$1
+	ORDER BY id_node_command DESC
+	LIMIT $2`, n.nodeCommandField())
+	rows, err := tx.Query(ctx, sqlStatement, idNode, nodeCommandHistoryLimit)
+	if err != nil {
+		return []entities.NodeCommand{}, err
+	}
+	return n.scanAll(rows)
+}
+
+// Deliver get every command the node still has to acknowledge, oldest first, and mark them delivered.
+// Delivered command are sent again on the next poll until they are acknowledged or expired.
+func (n *PostgresNodeCommandRepository) Deliver(ctx context.Context, tx helper.Querier, idNode int) (commands []entities.NodeCommand, err error) {
+	err = n.Expire(ctx, tx, idNode)
+	if err != nil {
+		return commands, err
+	}
+
+	sqlStatement := fmt.Sprintf(`
+	UPDATE node_command
+	SET status='delivered', delivered_at=COALESCE(delivered_at, (NOW() AT TIME ZONE 'utc'))
+	WHERE id_node=$1 AND status IN ('pending', 'delivered')
+	RETURNING %s`, n.nodeCommandField())
+	rows, err := tx.Query(ctx, sqlStatement, idNode)
+	if err != nil {
+		return []entities.NodeCommand{}, err
+	}
+
+	commands, err = n.scanAll(rows)
+	if err != nil {
+		return commands, err
+	}
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].IdNodeCommand < commands[j].IdNodeCommand
+	})
+	return commands, nil
+}
+
+// Ack store the result of the command, only a pending or delivered command that is not expired can be acknowledged
+func (n *PostgresNodeCommandRepository) Ack(ctx context.Context, tx helper.Querier, idNode int, idNodeCommand int, payload *entities.NodeCommandAck) (command entities.NodeCommand, err error) {
+	err = n.Expire(ctx, tx, idNode)
+	if err != nil {
+		return command, err
+	}
+
+	sqlStatement := fmt.Sprintf(`
+	UPDATE node_command
+	SET status=$1, result=$2, acked_at=(NOW() AT TIME ZONE 'utc')
+	WHERE id_node_command=$3 AND id_node=$4 AND status IN ('pending', 'delivered')
+	RETURNING %s`, n.nodeCommandField())
+	var result interface{}
+	if len(payload.Result) > 0 {
+		result = payload.Result
+	}
+	err = tx.QueryRow(ctx, sqlStatement, payload.Status, result, idNodeCommand, idNode).Scan(n.nodeCommandPointer(&command)...)
+	if err == nil {
+		return command, nil
+	}
+	if err != pgx.ErrNoRows {
+		return command, err
+	}
+
+	var status string
+	sqlStatement = `SELECT status FROM node_command WHERE id_node_command=$1 AND id_node=$2`
+	err = tx.QueryRow(ctx, sqlStatement, idNodeCommand, idNode).Scan(&status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return command, fiber.NewError(404, fmt.Sprintf("Command with id %d not found on node %d", idNodeCommand, idNode))
+		}
+		return command, err
+	}
+	return command, fiber.NewError(400, fmt.Sprintf("Command with id %d is already %s", idNodeCommand, status))
+}
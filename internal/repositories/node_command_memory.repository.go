@@ -0,0 +1,157 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MemoryNodeCommandRepository is NodeCommandRepository on the memory database
+type MemoryNodeCommandRepository struct{}
+
+func NewMemoryNodeCommandRepository() (MemoryNodeCommandRepository, error) {
+	return MemoryNodeCommandRepository{}, nil
+}
+
+func (n *MemoryNodeCommandRepository) Create(ctx context.Context, tx helper.Querier, idNode int, payload *entities.NodeCommandCreate) (command entities.NodeCommand, err error) {
+	config := configs.GetConfig()
+	args := payload.Args
+	if len(args) == 0 || string(args) == "null" {
+		args = json.RawMessage("{}")
+	}
+
+	compacted := bytes.Buffer{}
+	err = json.Compact(&compacted, args)
+	if err != nil {
+		return command, fiber.NewError(400, err.Error())
+	}
+
+	ttl := config.Command.DefaultTTL
+	if payload.ExpiresIn > 0 {
+		ttl = time.Duration(payload.ExpiresIn) * time.Second
+	}
+
+	now := time.Now().UTC()
+	command = entities.NodeCommand{
+		IdNode:    idNode,
+		Name:      payload.Name,
+		Args:      compacted.Bytes(),
+		Status:    entities.NodeCommandPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	err = memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.nodes[idNode]; !ok {
+			return memoryForeignKeyError("node_command", "id_node", idNode)
+		}
+
+		command.IdNodeCommand = int(data.nextId("node_command"))
+		data.nodeCommands[command.IdNodeCommand] = command
+		return nil
+	})
+	return command, err
+}
+
+// expire mark the command of the node that are not acknowledged before their expiry time
+func (n *MemoryNodeCommandRepository) expire(data *memoryData, idNode int) {
+	now := time.Now().UTC()
+	for id, command := range data.nodeCommands {
+		isOpen := command.Status == entities.NodeCommandPending || command.Status == entities.NodeCommandDelivered
+		if command.IdNode == idNode && isOpen && !command.ExpiresAt.After(now) {
+			command.Status = entities.NodeCommandExpired
+			data.nodeCommands[id] = command
+		}
+	}
+}
+
+func (n *MemoryNodeCommandRepository) Expire(ctx context.Context, tx helper.Querier, idNode int) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		n.expire(data, idNode)
+		return nil
+	})
+}
+
+// GetHistory get the latest command of the node, newest first
+func (n *MemoryNodeCommandRepository) GetHistory(ctx context.Context, tx helper.Querier, idNode int) (commands []entities.NodeCommand, err error) {
+	commands = []entities.NodeCommand{}
+	err = memoryWrite(tx, func(data *memoryData) error {
+		n.expire(data, idNode)
+
+		ids := sortedIds(data.nodeCommands)
+		for i := len(ids) - 1; i >= 0 && len(commands) < nodeCommandHistoryLimit; i-- {
+			if data.nodeCommands[ids[i]].IdNode == idNode {
+				commands = append(commands, data.nodeCommands[ids[i]])
+			}
+		}
+		return nil
+	})
+	return commands, err
+}
+
+// Deliver get every command the node still has to acknowledge, oldest first, and mark them delivered.
+// Delivered command are sent again on the next poll until they are acknowledged or expired.
+func (n *MemoryNodeCommandRepository) Deliver(ctx context.Context, tx helper.Querier, idNode int) (commands []entities.NodeCommand, err error) {
+	commands = []entities.NodeCommand{}
+	err = memoryWrite(tx, func(data *memoryData) error {
+		n.expire(data, idNode)
+
+		now := time.Now().UTC()
+		for _, id := range sortedIds(data.nodeCommands) {
+			command := data.nodeCommands[id]
+			isOpen := command.Status == entities.NodeCommandPending || command.Status == entities.NodeCommandDelivered
+			if command.IdNode != idNode || !isOpen {
+				continue
+			}
+
+			command.Status = entities.NodeCommandDelivered
+			if command.DeliveredAt == nil {
+				command.DeliveredAt = &now
+			}
+			data.nodeCommands[id] = command
+			commands = append(commands, command)
+		}
+		return nil
+	})
+	return commands, err
+}
+
+// Ack store the result of the command, only a pending or delivered command that is not expired can be acknowledged
+func (n *MemoryNodeCommandRepository) Ack(ctx context.Context, tx helper.Querier, idNode int, idNodeCommand int, payload *entities.NodeCommandAck) (command entities.NodeCommand, err error) {
+	var result json.RawMessage
+	if len(payload.Result) > 0 {
+		compacted := bytes.Buffer{}
+		err = json.Compact(&compacted, payload.Result)
+		if err != nil {
+			return command, fiber.NewError(400, err.Error())
+		}
+		result = compacted.Bytes()
+	}
+
+	err = memoryWrite(tx, func(data *memoryData) error {
+		n.expire(data, idNode)
+
+		stored, ok := data.nodeCommands[idNodeCommand]
+		if !ok || stored.IdNode != idNode {
+			return fiber.NewError(404, fmt.Sprintf("Command with id %d not found on node %d", idNodeCommand, idNode))
+		}
+		if stored.Status != entities.NodeCommandPending && stored.Status != entities.NodeCommandDelivered {
+			return fiber.NewError(400, fmt.Sprintf("Command with id %d is already %s", idNodeCommand, stored.Status))
+		}
+
+		now := time.Now().UTC()
+		stored.Status = payload.Status
+		stored.Result = result
+		stored.AckedAt = &now
+		data.nodeCommands[idNodeCommand] = stored
+		command = stored
+		return nil
+	})
+	return command, err
+}
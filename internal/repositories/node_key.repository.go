@@ -0,0 +1,118 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgresNodeKeyRepository struct{}
+
+func NewNodeKeyRepository() (PostgresNodeKeyRepository, error) {
+	return PostgresNodeKeyRepository{}, nil
+}
+
+func (u *PostgresNodeKeyRepository) nodeKeyField() string {
+	return "id_node_key, id_node, prefix, created_at, revoked_at"
+}
+
+func (u *PostgresNodeKeyRepository) nodeKeyPointer(nodeKey *entities.NodeKey) []interface{} {
+	return []interface{}{&nodeKey.IdNodeKey, &nodeKey.IdNode, &nodeKey.Prefix, &nodeKey.CreatedAt, &nodeKey.RevokedAt}
+}
+
+// Create generate a new API key for the node, only the hash of the key is stored
+func (u *PostgresNodeKeyRepository) Create(ctx context.Context, tx helper.Querier, idNode int) (nodeKey entities.NodeKeyCreated, err error) {
+	prefix, err := helper.GenerateSecureToken(6)
+	if err != nil {
+		return nodeKey, err
+	}
+
+	secret, err := helper.GenerateSecureToken(32)
+	if err != nil {
+		return nodeKey, err
+	}
+
+	nodeKey.IdNode = idNode
+	nodeKey.Prefix = entities.NodeKeyPrefix + prefix
+	nodeKey.Key = fmt.Sprintf("%s.%s", nodeKey.Prefix, secret)
+	sqlStatement := `
+	INSERT INTO "node_key" (
+		id_node,
+		prefix,
+		key_hash
+	)
+	VALUES ($1, $2, $3) RETURNING id_node_key, created_at`
+	err = tx.QueryRow(ctx, sqlStatement, idNode, nodeKey.Prefix, helper.HashToken(nodeKey.Key)).Scan(&nodeKey.IdNodeKey, &nodeKey.CreatedAt)
+	if err != nil {
+		return nodeKey, err
+	}
+
+	return nodeKey, nil
+}
+
+func (u *PostgresNodeKeyRepository) GetNodeKey(ctx context.Context, tx helper.Querier, idNode int) (nodeKeys []entities.NodeKey, err error) {
+	nodeKeys = []entities.NodeKey{}
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM "node_key" WHERE id_node=$1 ORDER BY id_node_key`, u.nodeKeyField())
+	rows, err := tx.Query(ctx, sqlStatement, idNode)
+	if err != nil {
+		return nodeKeys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var nodeKey entities.NodeKey
+		err := rows.Scan(
+			u.nodeKeyPointer(&nodeKey)...,
+		)
+		if err != nil {
+			return nodeKeys, err
+		}
+		nodeKeys = append(nodeKeys, nodeKey)
+	}
+	if err := rows.Err(); err != nil {
+		return nodeKeys, err
+	}
+	return nodeKeys, nil
+}
+
+// GetDeviceByKey find the node that own a key which is not revoked yet
+func (u *PostgresNodeKeyRepository) GetDeviceByKey(ctx context.Context, tx helper.Querier, key string) (device entities.Device, err error) {
+	sqlStatement := `SELECT node_key.id_node_key, node_key.id_node, node.id_user FROM "node_key" INNER JOIN "node" ON node.id_node=node_key.id_node WHERE node_key.key_hash=$1 AND node_key.revoked_at IS NULL`
+	err = tx.QueryRow(ctx, sqlStatement, helper.HashToken(key)).Scan(&device.IdNodeKey, &device.IdNode, &device.IdUser)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return device, fiber.NewError(401, "Device key is invalid or revoked")
+		}
+		return device, err
+	}
+	return device, nil
+}
+
+func (u *PostgresNodeKeyRepository) Revoke(ctx context.Context, tx helper.Querier, idNode int, idNodeKey int) (err error) {
+	sqlStatement := `
+	UPDATE "node_key"
+	SET revoked_at=(NOW() AT TIME ZONE 'utc')
+	WHERE id_node=$1 AND id_node_key=$2 AND revoked_at IS NULL`
+	res, err := tx.Exec(ctx, sqlStatement, idNode, idNodeKey)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("Active key with id %d not found on node %d", idNodeKey, idNode))
+	}
+	return nil
+}
+
+func (u *PostgresNodeKeyRepository) RevokeAll(ctx context.Context, tx helper.Querier, idNode int) (err error) {
+	sqlStatement := `
+	UPDATE "node_key"
+	SET revoked_at=(NOW() AT TIME ZONE 'utc')
+	WHERE id_node=$1 AND revoked_at IS NULL`
+	_, err = tx.Exec(ctx, sqlStatement, idNode)
+	return err
+}
@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MemoryNodeKeyRepository is NodeKeyRepository on the memory database
+type MemoryNodeKeyRepository struct{}
+
+func NewMemoryNodeKeyRepository() (MemoryNodeKeyRepository, error) {
+	return MemoryNodeKeyRepository{}, nil
+}
+
+// Create generate a new API key for the node, only the hash of the key is stored
+func (u *MemoryNodeKeyRepository) Create(ctx context.Context, tx helper.Querier, idNode int) (nodeKey entities.NodeKeyCreated, err error) {
+	prefix, err := helper.GenerateSecureToken(6)
+	if err != nil {
+		return nodeKey, err
+	}
+
+	secret, err := helper.GenerateSecureToken(32)
+	if err != nil {
+		return nodeKey, err
+	}
+
+	nodeKey.IdNode = idNode
+	nodeKey.Prefix = entities.NodeKeyPrefix + prefix
+	nodeKey.Key = fmt.Sprintf("%s.%s", nodeKey.Prefix, secret)
+	nodeKey.CreatedAt = time.Now().UTC()
+	err = memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.nodes[idNode]; !ok {
+			return memoryForeignKeyError("node_key", "id_node", idNode)
+		}
+
+		nodeKey.IdNodeKey = int(data.nextId("node_key"))
+		data.nodeKeys[nodeKey.IdNodeKey] = memoryNodeKey{NodeKey: nodeKey.NodeKey, KeyHash: helper.HashToken(nodeKey.Key)}
+		return nil
+	})
+	return nodeKey, err
+}
+
+func (u *MemoryNodeKeyRepository) GetNodeKey(ctx context.Context, tx helper.Querier, idNode int) (nodeKeys []entities.NodeKey, err error) {
+	nodeKeys = []entities.NodeKey{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, id := range sortedIds(data.nodeKeys) {
+			if data.nodeKeys[id].IdNode == idNode {
+				nodeKeys = append(nodeKeys, data.nodeKeys[id].NodeKey)
+			}
+		}
+		return nil
+	})
+	return nodeKeys, err
+}
+
+// GetDeviceByKey find the node that own a key which is not revoked yet
+func (u *MemoryNodeKeyRepository) GetDeviceByKey(ctx context.Context, tx helper.Querier, key string) (device entities.Device, err error) {
+	keyHash := helper.HashToken(key)
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, nodeKey := range data.nodeKeys {
+			if nodeKey.KeyHash != keyHash || nodeKey.RevokedAt != nil {
+				continue
+			}
+			device = entities.Device{
+				IdNodeKey: nodeKey.IdNodeKey,
+				IdNode:    nodeKey.IdNode,
+				IdUser:    data.nodes[nodeKey.IdNode].IdUser,
+			}
+			return nil
+		}
+		return fiber.NewError(401, "Device key is invalid or revoked")
+	})
+	return device, err
+}
+
+// revoke revoke every active key of the node matching the condition and return how many were revoked
+func (u *MemoryNodeKeyRepository) revoke(tx helper.Querier, idNode int, condition func(nodeKey memoryNodeKey) bool) (count int, err error) {
+	err = memoryWrite(tx, func(data *memoryData) error {
+		now := time.Now().UTC()
+		for id, nodeKey := range data.nodeKeys {
+			if nodeKey.IdNode != idNode || nodeKey.RevokedAt != nil || !condition(nodeKey) {
+				continue
+			}
+			nodeKey.RevokedAt = &now
+			data.nodeKeys[id] = nodeKey
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+func (u *MemoryNodeKeyRepository) Revoke(ctx context.Context, tx helper.Querier, idNode int, idNodeKey int) (err error) {
+	count, err := u.revoke(tx, idNode, func(nodeKey memoryNodeKey) bool {
+		return nodeKey.IdNodeKey == idNodeKey
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("Active key with id %d not found on node %d", idNodeKey, idNode))
+	}
+	return nil
+}
+
+func (u *MemoryNodeKeyRepository) RevokeAll(ctx context.Context, tx helper.Querier, idNode int) (err error) {
+	_, err = u.revoke(tx, idNode, func(nodeKey memoryNodeKey) bool {
+		return true
+	})
+	return err
+}
@@ -0,0 +1,194 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MemoryNodeRepository is NodeRepository on the memory database
+type MemoryNodeRepository struct{}
+
+func NewMemoryNodeRepository() (MemoryNodeRepository, error) {
+	return MemoryNodeRepository{}, nil
+}
+
+func (n *MemoryNodeRepository) read(node memoryNode) entities.Node {
+	config := configs.GetConfig()
+	node.SetStatus(time.Now().UTC(), config.Heartbeat.OfflineTimeout)
+	return node.Node
+}
+
+func (n *MemoryNodeRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.NodeCreate, currentUser *entities.UserRead) (node entities.Node, err error) {
+	node = entities.Node{
+		NodeCreate: *payload,
+		IdUser:     currentUser.IdUser,
+	}
+	err = memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.users[node.IdUser]; !ok {
+			return memoryForeignKeyError("node", "id_user", node.IdUser)
+		}
+		if _, ok := data.organizations[node.IdOrganization]; !ok {
+			return memoryForeignKeyError("node", "id_organization", node.IdOrganization)
+		}
+		if _, ok := data.hardwares[node.IdHardware]; !ok {
+			return memoryForeignKeyError("node", "id_hardware", node.IdHardware)
+		}
+
+		node.IdNode = int(data.nextId("node"))
+		data.nodes[node.IdNode] = memoryNode{Node: node}
+		return nil
+	})
+	if err != nil {
+		return node, err
+	}
+	return n.read(memoryNode{Node: node}), nil
+}
+
+func (n *MemoryNodeRepository) filter(tx helper.Querier, condition func(data *memoryData, node memoryNode) bool) (nodes []entities.Node, err error) {
+	nodes = []entities.Node{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, id := range sortedIds(data.nodes) {
+			if condition(data, data.nodes[id]) {
+				nodes = append(nodes, n.read(data.nodes[id]))
+			}
+		}
+		return nil
+	})
+	return nodes, err
+}
+
+func (n *MemoryNodeRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (nodes []entities.Node, err error) {
+	return n.filter(tx, func(data *memoryData, node memoryNode) bool {
+		return currentUser.IsAdmin || data.memberRole(node.IdOrganization, currentUser.IdUser) != ""
+	})
+}
+
+func (n *MemoryNodeRepository) GetById(ctx context.Context, tx helper.Querier, id int) (node entities.Node, err error) {
+	err = memoryRead(tx, func(data *memoryData) error {
+		stored, ok := data.nodes[id]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("Node with id %d not found", id))
+		}
+		node = n.read(stored)
+		return nil
+	})
+	return node, err
+}
+
+func (n *MemoryNodeRepository) GetHardwareNode(ctx context.Context, tx helper.Querier, hardwareId int) ([]entities.Node, error) {
+	return n.filter(tx, func(data *memoryData, node memoryNode) bool {
+		return node.IdHardware == hardwareId
+	})
+}
+
+func (n *MemoryNodeRepository) GetOrganizationNode(ctx context.Context, tx helper.Querier, organizationId int) ([]entities.Node, error) {
+	return n.filter(tx, func(data *memoryData, node memoryNode) bool {
+		return node.IdOrganization == organizationId
+	})
+}
+
+func (n *MemoryNodeRepository) Update(ctx context.Context, tx helper.Querier, node *entities.Node, payload *entities.NodeUpdate) (err error) {
+	payload.ChangeSettedFieldOnly(node)
+
+	return memoryWrite(tx, func(data *memoryData) error {
+		stored, ok := data.nodes[node.IdNode]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on update node with id %d", node.IdNode))
+		}
+
+		// Forget the notified status when the notification is turned on or off, the same as the postgres repository
+		if stored.NotifyOffline != *payload.NotifyOffline {
+			stored.NotifiedStatus = nil
+		}
+		stored.Name = payload.Name
+		stored.Location = payload.Location
+		stored.OfflineTimeout = payload.OfflineTimeout
+		stored.NotifyOffline = *payload.NotifyOffline
+		data.nodes[node.IdNode] = stored
+		return nil
+	})
+}
+
+func (n *MemoryNodeRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.nodes[id]; !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+		}
+		data.deleteNode(id)
+		return nil
+	})
+}
+
+// Touch set the last seen time of the node to now
+func (n *MemoryNodeRepository) Touch(ctx context.Context, tx helper.Querier, id int) (node entities.Node, err error) {
+	err = memoryWrite(tx, func(data *memoryData) error {
+		stored, ok := data.nodes[id]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("Node with id %d not found", id))
+		}
+		now := time.Now().UTC()
+		stored.LastSeen = &now
+		data.nodes[id] = stored
+		node = n.read(stored)
+		return nil
+	})
+	return node, err
+}
+
+// TouchBySensor set the last seen time of every node that own one of the sensors to now
+func (n *MemoryNodeRepository) TouchBySensor(ctx context.Context, tx helper.Querier, sensorIds []int) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		now := time.Now().UTC()
+		for _, idSensor := range sensorIds {
+			sensor, ok := data.sensors[idSensor]
+			if !ok {
+				continue
+			}
+			if stored, ok := data.nodes[sensor.IdNode]; ok {
+				stored.LastSeen = &now
+				data.nodes[sensor.IdNode] = stored
+			}
+		}
+		return nil
+	})
+}
+
+// CheckStatus find every node with notify_offline that went offline or came back online since the last check
+// and save its new status. A stale node is still considered online.
+func (n *MemoryNodeRepository) CheckStatus(ctx context.Context, tx helper.Querier) (notifications []entities.NodeStatusNotification, err error) {
+	notifications = []entities.NodeStatusNotification{}
+	err = memoryWrite(tx, func(data *memoryData) error {
+		for _, id := range sortedIds(data.nodes) {
+			stored := data.nodes[id]
+			if !stored.NotifyOffline {
+				continue
+			}
+
+			node := n.read(stored)
+			newStatus := entities.NodeStatusOnline
+			if node.Status == entities.NodeStatusOffline {
+				newStatus = entities.NodeStatusOffline
+			}
+			if stored.NotifiedStatus != nil && *stored.NotifiedStatus == newStatus {
+				continue
+			}
+
+			previousStatus := stored.NotifiedStatus
+			stored.NotifiedStatus = &newStatus
+			data.nodes[id] = stored
+
+			// The first check only save the current status
+			if previousStatus != nil {
+				notifications = append(notifications, entities.NodeStatusNotification{Node: node})
+			}
+		}
+		return nil
+	})
+	return notifications, err
+}
@@ -0,0 +1,171 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+type PostgresNodeShadowRepository struct{}
+
+func NewNodeShadowRepository() (PostgresNodeShadowRepository, error) {
+	return PostgresNodeShadowRepository{}, nil
+}
+
+func (n *PostgresNodeShadowRepository) nodeShadowField() string {
+	return "id_node, desired, reported, version, reported_version, desired_updated_at, reported_at"
+}
+
+func (n *PostgresNodeShadowRepository) nodeShadowPointer(shadow *entities.NodeShadow) []interface{} {
+	return []interface{}{
+		&shadow.IdNode,
+		&shadow.Desired,
+		&shadow.Reported,
+		&shadow.Version,
+		&shadow.ReportedVersion,
+		&shadow.DesiredUpdatedAt,
+		&shadow.ReportedAt,
+	}
+}
+
+// create the empty shadow of the node if it doesn't exist yet
+func (n *PostgresNodeShadowRepository) create(ctx context.Context, tx helper.Querier, idNode int) error {
+	sqlStatement := `INSERT INTO node_shadow (id_node) VALUES ($1) ON CONFLICT (id_node) DO NOTHING`
+	_, err := tx.Exec(ctx, sqlStatement, idNode)
+	return err
+}
+
+func (n *PostgresNodeShadowRepository) get(ctx context.Context, tx helper.Querier, idNode int, forUpdate bool) (shadow entities.NodeShadow, err error) {
+	err = n.create(ctx, tx, idNode)
+	if err != nil {
+		return shadow, err
+	}
+
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM node_shadow WHERE id_node=$1`, n.nodeShadowField())
+	if forUpdate {
+		sqlStatement += " FOR UPDATE"
+	}
+	err = tx.QueryRow(ctx, sqlStatement, idNode).Scan(n.nodeShadowPointer(&shadow)...)
+	if err != nil {
+		return shadow, err
+	}
+
+	return shadow, nil
+}
+
+// nodeShadowWithDelta compute the difference between the desired and the reported state
+func nodeShadowWithDelta(shadow entities.NodeShadow) (entities.NodeShadowWithDelta, error) {
+	desired, err := helper.ParseJsonObject(shadow.Desired)
+	if err != nil {
+		return entities.NodeShadowWithDelta{}, err
+	}
+
+	reported, err := helper.ParseJsonObject(shadow.Reported)
+	if err != nil {
+		return entities.NodeShadowWithDelta{}, err
+	}
+
+	delta := helper.JsonDelta(desired, reported)
+	rawDelta, err := json.Marshal(delta)
+	if err != nil {
+		return entities.NodeShadowWithDelta{}, err
+	}
+
+	return entities.NodeShadowWithDelta{
+		NodeShadow: shadow,
+		Delta:      rawDelta,
+		InSync:     len(delta) == 0,
+	}, nil
+}
+
+func (n *PostgresNodeShadowRepository) WithDelta(shadow entities.NodeShadow) (entities.NodeShadowWithDelta, error) {
+	return nodeShadowWithDelta(shadow)
+}
+
+func (n *PostgresNodeShadowRepository) GetByNode(ctx context.Context, tx helper.Querier, idNode int) (shadow entities.NodeShadowWithDelta, err error) {
+	nodeShadow, err := n.get(ctx, tx, idNode, false)
+	if err != nil {
+		return shadow, err
+	}
+
+	return n.WithDelta(nodeShadow)
+}
+
+// patch lock the shadow row so concurrent update are merged one after another, tx should be a transaction
+func (n *PostgresNodeShadowRepository) patch(ctx context.Context, tx helper.Querier, idNode int, raw json.RawMessage, current func(shadow *entities.NodeShadow) json.RawMessage) (shadow entities.NodeShadow, merged json.RawMessage, err error) {
+	patch, err := helper.ParseJsonObject(raw)
+	if err != nil {
+		return shadow, merged, fiber.NewError(400, err.Error())
+	}
+
+	shadow, err = n.get(ctx, tx, idNode, true)
+	if err != nil {
+		return shadow, merged, err
+	}
+
+	target, err := helper.ParseJsonObject(current(&shadow))
+	if err != nil {
+		return shadow, merged, err
+	}
+
+	merged, err = json.Marshal(helper.MergePatch(target, patch))
+	if err != nil {
+		return shadow, merged, err
+	}
+
+	return shadow, merged, nil
+}
+
+// UpdateDesired merge the payload to the desired state and increase the version, tx should be a transaction
+func (n *PostgresNodeShadowRepository) UpdateDesired(ctx context.Context, tx helper.Querier, idNode int, payload *entities.NodeShadowDesiredUpdate) (shadow entities.NodeShadowWithDelta, err error) {
+	_, desired, err := n.patch(ctx, tx, idNode, payload.Desired, func(shadow *entities.NodeShadow) json.RawMessage {
+		return shadow.Desired
+	})
+	if err != nil {
+		return shadow, err
+	}
+
+	var nodeShadow entities.NodeShadow
+	sqlStatement := fmt.Sprintf(`
+	UPDATE node_shadow
+	SET desired=$1, version=version+1, desired_updated_at=(NOW() AT TIME ZONE 'utc')
+	WHERE id_node=$2
+	RETURNING %s`, n.nodeShadowField())
+	err = tx.QueryRow(ctx, sqlStatement, desired, idNode).Scan(n.nodeShadowPointer(&nodeShadow)...)
+	if err != nil {
+		return shadow, err
+	}
+
+	return n.WithDelta(nodeShadow)
+}
+
+// Report merge the payload to the reported state, tx should be a transaction
+func (n *PostgresNodeShadowRepository) Report(ctx context.Context, tx helper.Querier, idNode int, payload *entities.NodeShadowReport) (shadow entities.NodeShadowWithDelta, err error) {
+	current, reported, err := n.patch(ctx, tx, idNode, payload.Reported, func(shadow *entities.NodeShadow) json.RawMessage {
+		return shadow.Reported
+	})
+	if err != nil {
+		return shadow, err
+	}
+
+	if payload.Version > current.Version {
+		return shadow, fiber.NewError(400, fmt.Sprintf("Version %d is newer than the desired version %d", payload.Version, current.Version))
+	}
+
+	var nodeShadow entities.NodeShadow
+	sqlStatement := fmt.Sprintf(`
+	UPDATE node_shadow
+	SET reported=$1, reported_version=GREATEST(reported_version, $2), reported_at=(NOW() AT TIME ZONE 'utc')
+	WHERE id_node=$3
+	RETURNING %s`, n.nodeShadowField())
+	err = tx.QueryRow(ctx, sqlStatement, reported, payload.Version, idNode).Scan(n.nodeShadowPointer(&nodeShadow)...)
+	if err != nil {
+		return shadow, err
+	}
+
+	return n.WithDelta(nodeShadow)
+}
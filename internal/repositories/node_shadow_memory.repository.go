@@ -0,0 +1,125 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MemoryNodeShadowRepository is NodeShadowRepository on the memory database
+type MemoryNodeShadowRepository struct{}
+
+func NewMemoryNodeShadowRepository() (MemoryNodeShadowRepository, error) {
+	return MemoryNodeShadowRepository{}, nil
+}
+
+// get return the shadow of the node, the empty shadow is created if it doesn't exist yet
+func (n *MemoryNodeShadowRepository) get(data *memoryData, idNode int) (shadow entities.NodeShadow, err error) {
+	shadow, ok := data.nodeShadows[idNode]
+	if ok {
+		return shadow, nil
+	}
+	if _, ok := data.nodes[idNode]; !ok {
+		return shadow, memoryForeignKeyError("node_shadow", "id_node", idNode)
+	}
+
+	shadow = entities.NodeShadow{
+		IdNode:   idNode,
+		Desired:  json.RawMessage("{}"),
+		Reported: json.RawMessage("{}"),
+	}
+	data.nodeShadows[idNode] = shadow
+	return shadow, nil
+}
+
+func (n *MemoryNodeShadowRepository) WithDelta(shadow entities.NodeShadow) (entities.NodeShadowWithDelta, error) {
+	return nodeShadowWithDelta(shadow)
+}
+
+func (n *MemoryNodeShadowRepository) GetByNode(ctx context.Context, tx helper.Querier, idNode int) (shadow entities.NodeShadowWithDelta, err error) {
+	var nodeShadow entities.NodeShadow
+	err = memoryWrite(tx, func(data *memoryData) error {
+		nodeShadow, err = n.get(data, idNode)
+		return err
+	})
+	if err != nil {
+		return shadow, err
+	}
+
+	return n.WithDelta(nodeShadow)
+}
+
+// patch merge raw into the state returned by current and let update store the merged state
+func (n *MemoryNodeShadowRepository) patch(tx helper.Querier, idNode int, raw json.RawMessage, current func(shadow *entities.NodeShadow) json.RawMessage, update func(shadow *entities.NodeShadow, merged json.RawMessage) error) (shadow entities.NodeShadowWithDelta, err error) {
+	patch, err := helper.ParseJsonObject(raw)
+	if err != nil {
+		return shadow, fiber.NewError(400, err.Error())
+	}
+
+	var nodeShadow entities.NodeShadow
+	err = memoryWrite(tx, func(data *memoryData) error {
+		nodeShadow, err = n.get(data, idNode)
+		if err != nil {
+			return err
+		}
+
+		target, err := helper.ParseJsonObject(current(&nodeShadow))
+		if err != nil {
+			return err
+		}
+
+		merged, err := json.Marshal(helper.MergePatch(target, patch))
+		if err != nil {
+			return err
+		}
+
+		err = update(&nodeShadow, merged)
+		if err != nil {
+			return err
+		}
+		data.nodeShadows[idNode] = nodeShadow
+		return nil
+	})
+	if err != nil {
+		return shadow, err
+	}
+
+	return n.WithDelta(nodeShadow)
+}
+
+// UpdateDesired merge the payload to the desired state and increase the version
+func (n *MemoryNodeShadowRepository) UpdateDesired(ctx context.Context, tx helper.Querier, idNode int, payload *entities.NodeShadowDesiredUpdate) (shadow entities.NodeShadowWithDelta, err error) {
+	return n.patch(tx, idNode, payload.Desired, func(shadow *entities.NodeShadow) json.RawMessage {
+		return shadow.Desired
+	}, func(shadow *entities.NodeShadow, merged json.RawMessage) error {
+		now := time.Now().UTC()
+		shadow.Desired = merged
+		shadow.Version++
+		shadow.DesiredUpdatedAt = &now
+		return nil
+	})
+}
+
+// Report merge the payload to the reported state
+func (n *MemoryNodeShadowRepository) Report(ctx context.Context, tx helper.Querier, idNode int, payload *entities.NodeShadowReport) (shadow entities.NodeShadowWithDelta, err error) {
+	return n.patch(tx, idNode, payload.Reported, func(shadow *entities.NodeShadow) json.RawMessage {
+		return shadow.Reported
+	}, func(shadow *entities.NodeShadow, merged json.RawMessage) error {
+		if payload.Version > shadow.Version {
+			return fiber.NewError(400, fmt.Sprintf("Version %d is newer than the desired version %d", payload.Version, shadow.Version))
+		}
+
+		now := time.Now().UTC()
+		shadow.Reported = merged
+		if payload.Version > shadow.ReportedVersion {
+			shadow.ReportedVersion = payload.Version
+		}
+		shadow.ReportedAt = &now
+		return nil
+	})
+}
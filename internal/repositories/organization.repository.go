@@ -0,0 +1,219 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgresOrganizationRepository struct{}
+
+func NewOrganizationRepository() (PostgresOrganizationRepository, error) {
+	return PostgresOrganizationRepository{}, nil
+}
+
+func (o *PostgresOrganizationRepository) organizationField() string {
+	return "organization.id_organization, organization.name, organization.id_personal_user, organization.created_at"
+}
+
+func (o *PostgresOrganizationRepository) organizationPointer(organization *entities.Organization) []interface{} {
+	return []interface{}{&organization.IdOrganization, &organization.Name, &organization.IdPersonalUser, &organization.CreatedAt}
+}
+
+func (o *PostgresOrganizationRepository) memberField() string {
+	return "organization_member.id_organization, organization_member.id_user, user_person.username, user_person.email, organization_member.role, organization_member.created_at"
+}
+
+func (o *PostgresOrganizationRepository) memberPointer(member *entities.OrganizationMember) []interface{} {
+	return []interface{}{&member.IdOrganization, &member.IdUser, &member.Username, &member.Email, &member.Role, &member.CreatedAt}
+}
+
+// Create add the organization with the current user as its owner
+func (o *PostgresOrganizationRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.OrganizationCreate, currentUser *entities.UserRead) (organization entities.Organization, err error) {
+	sqlStatement := fmt.Sprintf(`
+	WITH created AS (
+		INSERT INTO organization (name) VALUES ($1) RETURNING *
+	), member AS (
+		INSERT INTO organization_member (id_organization, id_user, role)
+		SELECT id_organization, $2, 'owner' FROM created
+	)
+	SELECT %s FROM created AS organization`, o.organizationField())
+	err = tx.QueryRow(ctx, sqlStatement, payload.Name, currentUser.IdUser).Scan(o.organizationPointer(&organization)...)
+	if err != nil {
+		return organization, err
+	}
+	return organization, nil
+}
+
+// GetPersonal get the personal organization of the user, it is created when the user doesn't have one yet
+func (o *PostgresOrganizationRepository) GetPersonal(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (organization entities.Organization, err error) {
+	// Both select see the table before the insert, so only one of them return a row
+	sqlStatement := fmt.Sprintf(`
+	WITH created AS (
+		INSERT INTO organization (name, id_personal_user) VALUES ($1, $2)
+		ON CONFLICT (id_personal_user) DO NOTHING RETURNING *
+	), member AS (
+		INSERT INTO organization_member (id_organization, id_user, role)
+		SELECT id_organization, $2, 'owner' FROM created
+	)
+	SELECT %[1]s FROM created AS organization
+	UNION ALL
+	SELECT %[1]s FROM organization WHERE id_personal_user=$2`, o.organizationField())
+	err = tx.QueryRow(ctx, sqlStatement, currentUser.Username, currentUser.IdUser).Scan(o.organizationPointer(&organization)...)
+	if err != nil {
+		return organization, err
+	}
+	return organization, nil
+}
+
+func (o *PostgresOrganizationRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (organizations []entities.OrganizationWithRole, err error) {
+	organizations = []entities.OrganizationWithRole{}
+	var rows pgx.Rows
+	if currentUser.IsAdmin {
+		sqlStatement := fmt.Sprintf(`
+		SELECT %s, COALESCE(organization_member.role, '') FROM organization
+		LEFT JOIN organization_member ON organization_member.id_organization=organization.id_organization AND organization_member.id_user=$1
+		ORDER BY organization.id_organization`, o.organizationField())
+		rows, err = tx.Query(ctx, sqlStatement, currentUser.IdUser)
+	} else {
+		sqlStatement := fmt.Sprintf(`
+		SELECT %s, organization_member.role FROM organization
+		INNER JOIN organization_member ON organization_member.id_organization=organization.id_organization
+		WHERE organization_member.id_user=$1
+		ORDER BY organization.id_organization`, o.organizationField())
+		rows, err = tx.Query(ctx, sqlStatement, currentUser.IdUser)
+	}
+	if err != nil {
+		return organizations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var organization entities.OrganizationWithRole
+		err := rows.Scan(append(o.organizationPointer(&organization.Organization), &organization.Role)...)
+		if err != nil {
+			return organizations, err
+		}
+		organizations = append(organizations, organization)
+	}
+	if err := rows.Err(); err != nil {
+		return organizations, err
+	}
+	return organizations, nil
+}
+
+func (o *PostgresOrganizationRepository) GetById(ctx context.Context, tx helper.Querier, id int) (organization entities.Organization, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM organization WHERE id_organization=$1`, o.organizationField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(o.organizationPointer(&organization)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return organization, fiber.NewError(404, fmt.Sprintf("Organization with id %d not found", id))
+		}
+		return organization, err
+	}
+	return organization, nil
+}
+
+func (o *PostgresOrganizationRepository) Update(ctx context.Context, tx helper.Querier, organization *entities.Organization, payload *entities.OrganizationUpdate) (err error) {
+	payload.ChangeSettedFieldOnly(organization)
+
+	sqlStatement := `UPDATE organization SET name=$1 WHERE id_organization=$2`
+	res, err := tx.Exec(ctx, sqlStatement, payload.Name, organization.IdOrganization)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update organization with id %d", organization.IdOrganization))
+	}
+	return nil
+}
+
+func (o *PostgresOrganizationRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	sqlStatement := `DELETE FROM organization WHERE id_organization=$1`
+	res, err := tx.Exec(ctx, sqlStatement, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+	}
+	return nil
+}
+
+// GetRole get the role of the user in the organization, it is empty when the user is not a member
+func (o *PostgresOrganizationRepository) GetRole(ctx context.Context, tx helper.Querier, idOrganization int, idUser int) (role string, err error) {
+	sqlStatement := `SELECT role FROM organization_member WHERE id_organization=$1 AND id_user=$2`
+	err = tx.QueryRow(ctx, sqlStatement, idOrganization, idUser).Scan(&role)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", nil
+		}
+		return role, err
+	}
+	return role, nil
+}
+
+func (o *PostgresOrganizationRepository) GetMembers(ctx context.Context, tx helper.Querier, idOrganization int) (members []entities.OrganizationMember, err error) {
+	members = []entities.OrganizationMember{}
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM organization_member
+	INNER JOIN user_person ON user_person.id_user=organization_member.id_user
+	WHERE organization_member.id_organization=$1
+	ORDER BY organization_member.id_user`, o.memberField())
+	rows, err := tx.Query(ctx, sqlStatement, idOrganization)
+	if err != nil {
+		return members, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var member entities.OrganizationMember
+		err := rows.Scan(o.memberPointer(&member)...)
+		if err != nil {
+			return members, err
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return members, err
+	}
+	return members, nil
+}
+
+func (o *PostgresOrganizationRepository) AddMember(ctx context.Context, tx helper.Querier, idOrganization int, idUser int, role string) (err error) {
+	sqlStatement := `INSERT INTO organization_member (id_organization, id_user, role) VALUES ($1, $2, $3)`
+	_, err = tx.Exec(ctx, sqlStatement, idOrganization, idUser, role)
+	return err
+}
+
+func (o *PostgresOrganizationRepository) UpdateMemberRole(ctx context.Context, tx helper.Querier, idOrganization int, idUser int, role string) (err error) {
+	sqlStatement := `UPDATE organization_member SET role=$1 WHERE id_organization=$2 AND id_user=$3`
+	res, err := tx.Exec(ctx, sqlStatement, role, idOrganization, idUser)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update organization member with id %d", idUser))
+	}
+	return nil
+}
+
+func (o *PostgresOrganizationRepository) RemoveMember(ctx context.Context, tx helper.Querier, idOrganization int, idUser int) (err error) {
+	sqlStatement := `DELETE FROM organization_member WHERE id_organization=$1 AND id_user=$2`
+	res, err := tx.Exec(ctx, sqlStatement, idOrganization, idUser)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", idUser))
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MemoryOrganizationRepository is OrganizationRepository on the memory database
+type MemoryOrganizationRepository struct{}
+
+func NewMemoryOrganizationRepository() (MemoryOrganizationRepository, error) {
+	return MemoryOrganizationRepository{}, nil
+}
+
+// create add the organization with the user as its owner
+func (o *MemoryOrganizationRepository) create(data *memoryData, organization *entities.Organization, idUser int) error {
+	if _, ok := data.users[idUser]; !ok {
+		return memoryForeignKeyError("organization_member", "id_user", idUser)
+	}
+
+	organization.IdOrganization = int(data.nextId("organization"))
+	organization.CreatedAt = time.Now().UTC()
+	data.organizations[organization.IdOrganization] = *organization
+	data.members[memoryOrganizationMemberKey{IdOrganization: organization.IdOrganization, IdUser: idUser}] = memoryOrganizationMember{
+		Role:      entities.OrganizationRoleOwner,
+		CreatedAt: organization.CreatedAt,
+	}
+	return nil
+}
+
+// Create add the organization with the current user as its owner
+func (o *MemoryOrganizationRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.OrganizationCreate, currentUser *entities.UserRead) (organization entities.Organization, err error) {
+	organization = entities.Organization{Name: payload.Name}
+	err = memoryWrite(tx, func(data *memoryData) error {
+		return o.create(data, &organization, currentUser.IdUser)
+	})
+	return organization, err
+}
+
+// GetPersonal get the personal organization of the user, it is created when the user doesn't have one yet
+func (o *MemoryOrganizationRepository) GetPersonal(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (organization entities.Organization, err error) {
+	err = memoryWrite(tx, func(data *memoryData) error {
+		for _, id := range sortedIds(data.organizations) {
+			stored := data.organizations[id]
+			if stored.IdPersonalUser != nil && *stored.IdPersonalUser == currentUser.IdUser {
+				organization = stored
+				return nil
+			}
+		}
+
+		idUser := currentUser.IdUser
+		organization = entities.Organization{Name: currentUser.Username, IdPersonalUser: &idUser}
+		return o.create(data, &organization, idUser)
+	})
+	return organization, err
+}
+
+func (o *MemoryOrganizationRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (organizations []entities.OrganizationWithRole, err error) {
+	organizations = []entities.OrganizationWithRole{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, id := range sortedIds(data.organizations) {
+			role := data.memberRole(id, currentUser.IdUser)
+			if currentUser.IsAdmin || role != "" {
+				organizations = append(organizations, entities.OrganizationWithRole{
+					Organization: data.organizations[id],
+					Role:         role,
+				})
+			}
+		}
+		return nil
+	})
+	return organizations, err
+}
+
+func (o *MemoryOrganizationRepository) GetById(ctx context.Context, tx helper.Querier, id int) (organization entities.Organization, err error) {
+	err = memoryRead(tx, func(data *memoryData) error {
+		stored, ok := data.organizations[id]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("Organization with id %d not found", id))
+		}
+		organization = stored
+		return nil
+	})
+	return organization, err
+}
+
+func (o *MemoryOrganizationRepository) Update(ctx context.Context, tx helper.Querier, organization *entities.Organization, payload *entities.OrganizationUpdate) (err error) {
+	payload.ChangeSettedFieldOnly(organization)
+
+	return memoryWrite(tx, func(data *memoryData) error {
+		stored, ok := data.organizations[organization.IdOrganization]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on update organization with id %d", organization.IdOrganization))
+		}
+		stored.Name = payload.Name
+		data.organizations[organization.IdOrganization] = stored
+		return nil
+	})
+}
+
+func (o *MemoryOrganizationRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.organizations[id]; !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+		}
+		data.deleteOrganization(id)
+		return nil
+	})
+}
+
+// GetRole get the role of the user in the organization, it is empty when the user is not a member
+func (o *MemoryOrganizationRepository) GetRole(ctx context.Context, tx helper.Querier, idOrganization int, idUser int) (role string, err error) {
+	err = memoryRead(tx, func(data *memoryData) error {
+		role = data.memberRole(idOrganization, idUser)
+		return nil
+	})
+	return role, err
+}
+
+func (o *MemoryOrganizationRepository) GetMembers(ctx context.Context, tx helper.Querier, idOrganization int) (members []entities.OrganizationMember, err error) {
+	members = []entities.OrganizationMember{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		for key, member := range data.members {
+			if key.IdOrganization != idOrganization {
+				continue
+			}
+			user := data.users[key.IdUser]
+			members = append(members, entities.OrganizationMember{
+				IdOrganization: key.IdOrganization,
+				IdUser:         key.IdUser,
+				Username:       user.Username,
+				Email:          user.Email,
+				Role:           member.Role,
+				CreatedAt:      member.CreatedAt,
+			})
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].IdUser < members[j].IdUser
+		})
+		return nil
+	})
+	return members, err
+}
+
+func (o *MemoryOrganizationRepository) AddMember(ctx context.Context, tx helper.Querier, idOrganization int, idUser int, role string) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.organizations[idOrganization]; !ok {
+			return memoryForeignKeyError("organization_member", "id_organization", idOrganization)
+		}
+		if _, ok := data.users[idUser]; !ok {
+			return memoryForeignKeyError("organization_member", "id_user", idUser)
+		}
+		key := memoryOrganizationMemberKey{IdOrganization: idOrganization, IdUser: idUser}
+		if _, ok := data.members[key]; ok {
+			return memoryUniqueError("organization_member", "id_user", idUser)
+		}
+
+		data.members[key] = memoryOrganizationMember{Role: role, CreatedAt: time.Now().UTC()}
+		return nil
+	})
+}
+
+func (o *MemoryOrganizationRepository) UpdateMemberRole(ctx context.Context, tx helper.Querier, idOrganization int, idUser int, role string) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		key := memoryOrganizationMemberKey{IdOrganization: idOrganization, IdUser: idUser}
+		member, ok := data.members[key]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on update organization member with id %d", idUser))
+		}
+		member.Role = role
+		data.members[key] = member
+		return nil
+	})
+}
+
+func (o *MemoryOrganizationRepository) RemoveMember(ctx context.Context, tx helper.Querier, idOrganization int, idUser int) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		key := memoryOrganizationMemberKey{IdOrganization: idOrganization, IdUser: idUser}
+		if _, ok := data.members[key]; !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", idUser))
+		}
+		delete(data.members, key)
+		return nil
+	})
+}
@@ -0,0 +1,155 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/jackc/pgx/v5"
+)
+
+// Layout of a timestamp in the partition bound returned by pg_get_expr
+const partitionBoundLayout = "2006-01-02 15:04:05"
+
+var partitionBound = regexp.MustCompile(`FROM \('([^']+)'\) TO \('([^']+)'\)`)
+
+type PostgresPartitionRepository struct{}
+
+func NewPartitionRepository() (PostgresPartitionRepository, error) {
+	return PostgresPartitionRepository{}, nil
+}
+
+func (p *PostgresPartitionRepository) partitionName(from time.Time) string {
+	return "channel_p" + from.Format("20060102")
+}
+
+// GetAll get every partition of the channel table ordered by time, the default partition is last
+func (p *PostgresPartitionRepository) GetAll(ctx context.Context, tx helper.Querier) (partitions []entities.ChannelPartition, err error) {
+	partitions = []entities.ChannelPartition{}
+	sqlStatement := `
+	SELECT child.relname, pg_get_expr(child.relpartbound, child.oid), GREATEST(child.reltuples, 0)::BIGINT, pg_total_relation_size(child.oid)
+	FROM pg_inherits
+	INNER JOIN pg_class child ON child.oid=pg_inherits.inhrelid
+	WHERE pg_inherits.inhparent='channel'::regclass
+	ORDER BY child.relname`
+	rows, err := tx.Query(ctx, sqlStatement)
+	if err != nil {
+		return partitions, err
+	}
+	defer rows.Close()
+
+	var defaultPartition *entities.ChannelPartition
+	for rows.Next() {
+		var partition entities.ChannelPartition
+		var bound string
+		err := rows.Scan(&partition.Name, &bound, &partition.Rows, &partition.Size)
+		if err != nil {
+			return partitions, err
+		}
+
+		match := partitionBound.FindStringSubmatch(bound)
+		if match == nil {
+			partition.IsDefault = true
+			defaultPartition = &partition
+			continue
+		}
+		from, err := time.Parse(partitionBoundLayout, match[1])
+		if err != nil {
+			return partitions, err
+		}
+		to, err := time.Parse(partitionBoundLayout, match[2])
+		if err != nil {
+			return partitions, err
+		}
+		partition.From = &from
+		partition.To = &to
+		partitions = append(partitions, partition)
+	}
+	if err := rows.Err(); err != nil {
+		return partitions, err
+	}
+
+	if defaultPartition != nil {
+		partitions = append(partitions, *defaultPartition)
+	}
+	return partitions, nil
+}
+
+// Create add a partition for the channel between from inclusive and to exclusive
+func (p *PostgresPartitionRepository) Create(ctx context.Context, tx helper.Querier, from time.Time, to time.Time) (name string, err error) {
+	name = p.partitionName(from)
+	sqlStatement := fmt.Sprintf(`CREATE TABLE %s PARTITION OF channel FOR VALUES FROM ('%s') TO ('%s')`,
+		pgx.Identifier{name}.Sanitize(), from.Format(partitionBoundLayout), to.Format(partitionBoundLayout))
+	_, err = tx.Exec(ctx, sqlStatement)
+	return name, err
+}
+
+// markPartitionPending mark the hour of every channel in the partition to be recomputed by the rollup worker,
+// which delete the rollup once the partition is gone
+func (p *PostgresPartitionRepository) markPartitionPending(ctx context.Context, tx helper.Querier, name string) (err error) {
+	_, err = tx.Exec(ctx, fmt.Sprintf(`
+	INSERT INTO channel_rollup_pending (id_sensor, bucket)
+	SELECT DISTINCT id_sensor, date_trunc('hour', time) FROM %s
+	ON CONFLICT DO NOTHING`, pgx.Identifier{name}.Sanitize()))
+	return err
+}
+
+func (p *PostgresPartitionRepository) Drop(ctx context.Context, tx helper.Querier, name string) (err error) {
+	err = p.markPartitionPending(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf(`DROP TABLE %s`, pgx.Identifier{name}.Sanitize()))
+	return err
+}
+
+// Detach remove the partition from the channel table and rename it to channel_archive_*,
+// the data is kept as a standalone table that can be dumped and dropped later
+func (p *PostgresPartitionRepository) Detach(ctx context.Context, tx helper.Querier, name string) (archiveName string, err error) {
+	archiveName = "channel_archive_" + name[len("channel_"):]
+	err = p.markPartitionPending(ctx, tx, name)
+	if err != nil {
+		return archiveName, err
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf(`ALTER TABLE channel DETACH PARTITION %s`, pgx.Identifier{name}.Sanitize()))
+	if err != nil {
+		return archiveName, err
+	}
+
+	_, err = tx.Exec(ctx, fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, pgx.Identifier{name}.Sanitize(), pgx.Identifier{archiveName}.Sanitize()))
+	return archiveName, err
+}
+
+// DeleteDefaultBefore delete the channel in the default partition older than before, they
+// are not removed when an expired partition is dropped
+func (p *PostgresPartitionRepository) DeleteDefaultBefore(ctx context.Context, tx helper.Querier, before time.Time) (count int64, err error) {
+	sqlStatement := markDeletedChannelPending(`DELETE FROM channel_default WHERE time < $1 RETURNING id_sensor, time`)
+	err = tx.QueryRow(ctx, sqlStatement, before).Scan(&count)
+	return count, err
+}
+
+// DeleteExpiredUserChannel delete the channel older than the longest retention of the members of the organization
+// that own the sensor, nothing is deleted when one of the members doesn't have a retention
+func (p *PostgresPartitionRepository) DeleteExpiredUserChannel(ctx context.Context, tx helper.Querier) (count int64, err error) {
+	sqlStatement := markDeletedChannelPending(`
+	DELETE FROM channel
+	USING sensor, node, (
+		SELECT organization_member.id_organization, MAX(user_person.retention_days) AS retention_days
+		FROM organization_member
+		INNER JOIN user_person ON user_person.id_user=organization_member.id_user
+		GROUP BY organization_member.id_organization
+		HAVING COUNT(*)=COUNT(user_person.retention_days)
+	) AS organization_retention
+	WHERE sensor.id_sensor=channel.id_sensor
+	AND node.id_node=sensor.id_node
+	AND organization_retention.id_organization=node.id_organization
+	AND channel.time < (NOW() AT TIME ZONE 'utc') - make_interval(days => organization_retention.retention_days)
+	RETURNING channel.id_sensor, channel.time`)
+	err = tx.QueryRow(ctx, sqlStatement).Scan(&count)
+	return count, err
+}
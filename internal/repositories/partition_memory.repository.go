@@ -0,0 +1,168 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+// memoryDefaultPartition is the name of the partition that hold the channel outside every range
+const memoryDefaultPartition = "channel_default"
+
+// MemoryPartitionRepository is PartitionRepository on the memory database. A partition is only a time range,
+// a channel belong to the partition containing its time or to the default partition. The size is not tracked.
+type MemoryPartitionRepository struct{}
+
+func NewMemoryPartitionRepository() (MemoryPartitionRepository, error) {
+	return MemoryPartitionRepository{}, nil
+}
+
+func (p *MemoryPartitionRepository) partitionName(from time.Time) string {
+	return "channel_p" + from.Format("20060102")
+}
+
+// find return the index of the partition containing the time, -1 when it belong to the default partition
+func (p *MemoryPartitionRepository) find(data *memoryData, channelTime time.Time) int {
+	for i, partition := range data.partitions {
+		if !channelTime.Before(partition.From) && channelTime.Before(partition.To) {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetAll get every partition of the channel table ordered by time, the default partition is last
+func (p *MemoryPartitionRepository) GetAll(ctx context.Context, tx helper.Querier) (partitions []entities.ChannelPartition, err error) {
+	partitions = []entities.ChannelPartition{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		rows := make([]int64, len(data.partitions)+1)
+		for _, channel := range data.channels {
+			rows[p.find(data, channel.Time)+1]++
+		}
+
+		for i, partition := range data.partitions {
+			from := partition.From
+			to := partition.To
+			partitions = append(partitions, entities.ChannelPartition{
+				Name: partition.Name,
+				From: &from,
+				To:   &to,
+				Rows: rows[i+1],
+			})
+		}
+		sort.SliceStable(partitions, func(i, j int) bool {
+			return partitions[i].Name < partitions[j].Name
+		})
+		partitions = append(partitions, entities.ChannelPartition{
+			Name:      memoryDefaultPartition,
+			IsDefault: true,
+			Rows:      rows[0],
+		})
+		return nil
+	})
+	return partitions, err
+}
+
+// Create add a partition for the channel between from inclusive and to exclusive, it fails like postgres when
+// the range overlap another partition or when the default partition already has channel in the range
+func (p *MemoryPartitionRepository) Create(ctx context.Context, tx helper.Querier, from time.Time, to time.Time) (name string, err error) {
+	name = p.partitionName(from)
+	err = memoryWrite(tx, func(data *memoryData) error {
+		for _, partition := range data.partitions {
+			if partition.Name == name {
+				return fmt.Errorf("relation %q already exists", name)
+			}
+			if from.Before(partition.To) && partition.From.Before(to) {
+				return fmt.Errorf("partition %q would overlap partition %q", name, partition.Name)
+			}
+		}
+		for _, channel := range data.channels {
+			if !channel.Time.Before(from) && channel.Time.Before(to) && p.find(data, channel.Time) == -1 {
+				return fmt.Errorf("updated partition constraint for default partition %q would be violated by some row", memoryDefaultPartition)
+			}
+		}
+
+		data.partitions = append(cloneMemorySlice(data.partitions), memoryPartition{Name: name, From: from, To: to})
+		return nil
+	})
+	return name, err
+}
+
+// remove delete the partition and the channel in it
+func (p *MemoryPartitionRepository) remove(tx helper.Querier, name string) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		partitions := []memoryPartition{}
+		var removed *memoryPartition
+		for _, partition := range data.partitions {
+			partition := partition
+			if partition.Name == name {
+				removed = &partition
+				continue
+			}
+			partitions = append(partitions, partition)
+		}
+		if removed == nil {
+			return fmt.Errorf("table %q does not exist", name)
+		}
+
+		data.deleteChannel(func(channel entities.Channel) bool {
+			return !channel.Time.Before(removed.From) && channel.Time.Before(removed.To)
+		})
+		data.partitions = partitions
+		return nil
+	})
+}
+
+func (p *MemoryPartitionRepository) Drop(ctx context.Context, tx helper.Querier, name string) (err error) {
+	return p.remove(tx, name)
+}
+
+// Detach remove the partition like Drop since the memory database can't keep an archive table,
+// the returned archive name is the one postgres would have used
+func (p *MemoryPartitionRepository) Detach(ctx context.Context, tx helper.Querier, name string) (archiveName string, err error) {
+	archiveName = "channel_archive_" + name[len("channel_"):]
+	return archiveName, p.remove(tx, name)
+}
+
+// DeleteDefaultBefore delete the channel in the default partition older than before, they
+// are not removed when an expired partition is dropped
+func (p *MemoryPartitionRepository) DeleteDefaultBefore(ctx context.Context, tx helper.Querier, before time.Time) (count int64, err error) {
+	err = memoryWrite(tx, func(data *memoryData) error {
+		count = data.deleteChannel(func(channel entities.Channel) bool {
+			return channel.Time.Before(before) && p.find(data, channel.Time) == -1
+		})
+		return nil
+	})
+	return count, err
+}
+
+// DeleteExpiredUserChannel delete the channel older than the longest retention of the members of the organization
+// that own the sensor, nothing is deleted when one of the members doesn't have a retention
+func (p *MemoryPartitionRepository) DeleteExpiredUserChannel(ctx context.Context, tx helper.Querier) (count int64, err error) {
+	err = memoryWrite(tx, func(data *memoryData) error {
+		now := time.Now().UTC()
+		// Organization without any member keep its channel like a member without retention
+		retentionDays := map[int]int{}
+		unlimited := map[int]bool{}
+		for key := range data.members {
+			user := data.users[key.IdUser]
+			if user.RetentionDays == nil {
+				unlimited[key.IdOrganization] = true
+			} else if *user.RetentionDays > retentionDays[key.IdOrganization] {
+				retentionDays[key.IdOrganization] = *user.RetentionDays
+			}
+		}
+
+		count = data.deleteChannel(func(channel entities.Channel) bool {
+			idOrganization := data.nodes[data.sensors[channel.IdSensor].IdNode].IdOrganization
+			days, ok := retentionDays[idOrganization]
+			return ok && !unlimited[idOrganization] && channel.Time.Before(now.AddDate(0, 0, -days))
+		})
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,87 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+type PostgresPasswordResetRepository struct{}
+
+func NewPasswordResetRepository() (PostgresPasswordResetRepository, error) {
+	return PostgresPasswordResetRepository{}, nil
+}
+
+// Create generate a new reset token for the user and invalidate the previous unused one,
+// only the hash of the token is stored
+func (p *PostgresPasswordResetRepository) Create(ctx context.Context, tx helper.Querier, idUser int) (token string, err error) {
+	config := configs.GetConfig()
+	token, err = helper.GenerateSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	sqlStatement := `
+	UPDATE password_reset
+	SET used_at=(NOW() AT TIME ZONE 'utc')
+	WHERE id_user=$1 AND used_at IS NULL`
+	_, err = tx.Exec(ctx, sqlStatement, idUser)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().UTC().Add(config.Account.PasswordResetTTL)
+	sqlStatement = `
+	INSERT INTO password_reset (
+		id_user,
+		token_hash,
+		expires_at
+	)
+	VALUES ($1, $2, $3)`
+	_, err = tx.Exec(ctx, sqlStatement, idUser, helper.HashToken(token), expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (p *PostgresPasswordResetRepository) GetByToken(ctx context.Context, tx helper.Querier, token string) (passwordReset entities.PasswordReset, err error) {
+	sqlStatement := `SELECT id_password_reset, id_user, expires_at, created_at, used_at FROM password_reset WHERE token_hash=$1`
+	err = tx.QueryRow(ctx, sqlStatement, helper.HashToken(token)).Scan(
+		&passwordReset.IdPasswordReset,
+		&passwordReset.IdUser,
+		&passwordReset.ExpiresAt,
+		&passwordReset.CreatedAt,
+		&passwordReset.UsedAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return passwordReset, fiber.NewError(400, "Reset password link is invalid")
+		}
+		return passwordReset, err
+	}
+	return passwordReset, nil
+}
+
+// Use mark the reset token as used, it fails when the token is already used so a link can't be used twice
+func (p *PostgresPasswordResetRepository) Use(ctx context.Context, tx helper.Querier, idPasswordReset int) (err error) {
+	sqlStatement := `
+	UPDATE password_reset
+	SET used_at=(NOW() AT TIME ZONE 'utc')
+	WHERE id_password_reset=$1 AND used_at IS NULL`
+	res, err := tx.Exec(ctx, sqlStatement, idPasswordReset)
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() == 0 {
+		return fiber.NewError(400, "Reset password link has already been used")
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MemoryPasswordResetRepository is PasswordResetRepository on the memory database
+type MemoryPasswordResetRepository struct{}
+
+func NewMemoryPasswordResetRepository() (MemoryPasswordResetRepository, error) {
+	return MemoryPasswordResetRepository{}, nil
+}
+
+// Create generate a new reset token for the user and invalidate the previous unused one,
+// only the hash of the token is stored
+func (p *MemoryPasswordResetRepository) Create(ctx context.Context, tx helper.Querier, idUser int) (token string, err error) {
+	config := configs.GetConfig()
+	token, err = helper.GenerateSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	err = memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.users[idUser]; !ok {
+			return memoryForeignKeyError("password_reset", "id_user", idUser)
+		}
+
+		for id, passwordReset := range data.passwordResets {
+			if passwordReset.IdUser == idUser && passwordReset.UsedAt == nil {
+				passwordReset.UsedAt = &now
+				data.passwordResets[id] = passwordReset
+			}
+		}
+
+		id := int(data.nextId("password_reset"))
+		data.passwordResets[id] = memoryPasswordReset{
+			PasswordReset: entities.PasswordReset{
+				IdPasswordReset: id,
+				IdUser:          idUser,
+				ExpiresAt:       now.Add(config.Account.PasswordResetTTL),
+				CreatedAt:       now,
+			},
+			TokenHash: helper.HashToken(token),
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (p *MemoryPasswordResetRepository) GetByToken(ctx context.Context, tx helper.Querier, token string) (passwordReset entities.PasswordReset, err error) {
+	tokenHash := helper.HashToken(token)
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, stored := range data.passwordResets {
+			if stored.TokenHash == tokenHash {
+				passwordReset = stored.PasswordReset
+				return nil
+			}
+		}
+		return fiber.NewError(400, "Reset password link is invalid")
+	})
+	return passwordReset, err
+}
+
+// Use mark the reset token as used, it fails when the token is already used so a link can't be used twice
+func (p *MemoryPasswordResetRepository) Use(ctx context.Context, tx helper.Querier, idPasswordReset int) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		passwordReset, ok := data.passwordResets[idPasswordReset]
+		if !ok || passwordReset.UsedAt != nil {
+			return fiber.NewError(400, "Reset password link has already been used")
+		}
+
+		now := time.Now().UTC()
+		passwordReset.UsedAt = &now
+		data.passwordResets[idPasswordReset] = passwordReset
+		return nil
+	})
+}
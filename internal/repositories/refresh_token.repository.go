@@ -0,0 +1,89 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// errRefreshTokenRevoked is returned when revoking a refresh token that is already revoked
+var errRefreshTokenRevoked = fiber.NewError(401, "Refresh token has been revoked, please login again")
+
+type PostgresRefreshTokenRepository struct{}
+
+func NewRefreshTokenRepository() (PostgresRefreshTokenRepository, error) {
+	return PostgresRefreshTokenRepository{}, nil
+}
+
+// Create generate a new refresh token for the user, only the hash of the token is stored
+func (r *PostgresRefreshTokenRepository) Create(ctx context.Context, tx helper.Querier, idUser int) (token string, err error) {
+	config := configs.GetConfig()
+	token, err = helper.GenerateSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().UTC().Add(config.JWT.RefreshTokenTTL)
+	sqlStatement := `
+	INSERT INTO refresh_token (
+		id_user,
+		token_hash,
+		expires_at
+	)
+	VALUES ($1, $2, $3)`
+	_, err = tx.Exec(ctx, sqlStatement, idUser, helper.HashToken(token), expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (r *PostgresRefreshTokenRepository) GetByToken(ctx context.Context, tx helper.Querier, token string) (refreshToken entities.RefreshToken, err error) {
+	sqlStatement := `SELECT id_refresh_token, id_user, expires_at, created_at, revoked_at FROM refresh_token WHERE token_hash=$1`
+	err = tx.QueryRow(ctx, sqlStatement, helper.HashToken(token)).Scan(
+		&refreshToken.IdRefreshToken,
+		&refreshToken.IdUser,
+		&refreshToken.ExpiresAt,
+		&refreshToken.CreatedAt,
+		&refreshToken.RevokedAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return refreshToken, fiber.NewError(401, "Refresh token is invalid")
+		}
+		return refreshToken, err
+	}
+	return refreshToken, nil
+}
+
+// Revoke fail when the refresh token is already revoked, so it can only be used once even by concurrent request
+func (r *PostgresRefreshTokenRepository) Revoke(ctx context.Context, tx helper.Querier, idRefreshToken int) (err error) {
+	sqlStatement := `
+	UPDATE refresh_token
+	SET revoked_at=(NOW() AT TIME ZONE 'utc')
+	WHERE id_refresh_token=$1 AND revoked_at IS NULL`
+	res, err := tx.Exec(ctx, sqlStatement, idRefreshToken)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return errRefreshTokenRevoked
+	}
+	return nil
+}
+
+// RevokeAllByUser revoke every refresh token of the user, used on logout everywhere or password change
+func (r *PostgresRefreshTokenRepository) RevokeAllByUser(ctx context.Context, tx helper.Querier, idUser int) (err error) {
+	sqlStatement := `
+	UPDATE refresh_token
+	SET revoked_at=(NOW() AT TIME ZONE 'utc')
+	WHERE id_user=$1 AND revoked_at IS NULL`
+	_, err = tx.Exec(ctx, sqlStatement, idUser)
+	return err
+}
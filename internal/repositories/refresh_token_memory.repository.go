@@ -0,0 +1,102 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/dafaath/iot-server/configs"
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MemoryRefreshTokenRepository is RefreshTokenRepository on the memory database
+type MemoryRefreshTokenRepository struct{}
+
+func NewMemoryRefreshTokenRepository() (MemoryRefreshTokenRepository, error) {
+	return MemoryRefreshTokenRepository{}, nil
+}
+
+// Create generate a new refresh token for the user, only the hash of the token is stored
+func (r *MemoryRefreshTokenRepository) Create(ctx context.Context, tx helper.Querier, idUser int) (token string, err error) {
+	config := configs.GetConfig()
+	token, err = helper.GenerateSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	err = memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.users[idUser]; !ok {
+			return memoryForeignKeyError("refresh_token", "id_user", idUser)
+		}
+
+		id := int(data.nextId("refresh_token"))
+		data.refreshTokens[id] = memoryRefreshToken{
+			RefreshToken: entities.RefreshToken{
+				IdRefreshToken: id,
+				IdUser:         idUser,
+				ExpiresAt:      now.Add(config.JWT.RefreshTokenTTL),
+				CreatedAt:      now,
+			},
+			TokenHash: helper.HashToken(token),
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (r *MemoryRefreshTokenRepository) GetByToken(ctx context.Context, tx helper.Querier, token string) (refreshToken entities.RefreshToken, err error) {
+	tokenHash := helper.HashToken(token)
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, stored := range data.refreshTokens {
+			if stored.TokenHash == tokenHash {
+				refreshToken = stored.RefreshToken
+				return nil
+			}
+		}
+		return fiber.NewError(401, "Refresh token is invalid")
+	})
+	return refreshToken, err
+}
+
+// revoke revoke every active refresh token matching the condition
+func (r *MemoryRefreshTokenRepository) revoke(tx helper.Querier, condition func(refreshToken memoryRefreshToken) bool) (count int, err error) {
+	err = memoryWrite(tx, func(data *memoryData) error {
+		now := time.Now().UTC()
+		for id, refreshToken := range data.refreshTokens {
+			if refreshToken.RevokedAt == nil && condition(refreshToken) {
+				refreshToken.RevokedAt = &now
+				data.refreshTokens[id] = refreshToken
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Revoke fail when the refresh token is already revoked, so it can only be used once even by concurrent request
+func (r *MemoryRefreshTokenRepository) Revoke(ctx context.Context, tx helper.Querier, idRefreshToken int) (err error) {
+	count, err := r.revoke(tx, func(refreshToken memoryRefreshToken) bool {
+		return refreshToken.IdRefreshToken == idRefreshToken
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errRefreshTokenRevoked
+	}
+	return nil
+}
+
+// RevokeAllByUser revoke every refresh token of the user, used on logout everywhere or password change
+func (r *MemoryRefreshTokenRepository) RevokeAllByUser(ctx context.Context, tx helper.Querier, idUser int) (err error) {
+	_, err = r.revoke(tx, func(refreshToken memoryRefreshToken) bool {
+		return refreshToken.IdUser == idUser
+	})
+	return err
+}
@@ -0,0 +1,449 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gopkg.in/gomail.v2"
+)
+
+// The handlers depend on these interfaces so the server can run on postgres or on the in memory store
+
+type HardwareRepository interface {
+	Create(ctx context.Context, tx helper.Querier, payload *entities.HardwareCreate) (hardware entities.Hardware, err error)
+	GetAllHardware(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error)
+	GetAllNode(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error)
+	GetAllSensor(ctx context.Context, tx helper.Querier) (hardwares []entities.Hardware, err error)
+	GetById(ctx context.Context, tx helper.Querier, id int) (hardware entities.Hardware, err error)
+	Update(ctx context.Context, tx helper.Querier, hardware *entities.Hardware, payload *entities.HardwareUpdate) (err error)
+	Delete(ctx context.Context, tx helper.Querier, id int) (err error)
+}
+
+type OrganizationRepository interface {
+	Create(ctx context.Context, tx helper.Querier, payload *entities.OrganizationCreate, currentUser *entities.UserRead) (organization entities.Organization, err error)
+	GetPersonal(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (organization entities.Organization, err error)
+	GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (organizations []entities.OrganizationWithRole, err error)
+	GetById(ctx context.Context, tx helper.Querier, id int) (organization entities.Organization, err error)
+	Update(ctx context.Context, tx helper.Querier, organization *entities.Organization, payload *entities.OrganizationUpdate) (err error)
+	Delete(ctx context.Context, tx helper.Querier, id int) (err error)
+	GetRole(ctx context.Context, tx helper.Querier, idOrganization int, idUser int) (role string, err error)
+	GetMembers(ctx context.Context, tx helper.Querier, idOrganization int) (members []entities.OrganizationMember, err error)
+	AddMember(ctx context.Context, tx helper.Querier, idOrganization int, idUser int, role string) (err error)
+	UpdateMemberRole(ctx context.Context, tx helper.Querier, idOrganization int, idUser int, role string) (err error)
+	RemoveMember(ctx context.Context, tx helper.Querier, idOrganization int, idUser int) (err error)
+}
+
+type NodeRepository interface {
+	Create(ctx context.Context, tx helper.Querier, payload *entities.NodeCreate, currentUser *entities.UserRead) (node entities.Node, err error)
+	GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (nodes []entities.Node, err error)
+	GetById(ctx context.Context, tx helper.Querier, id int) (node entities.Node, err error)
+	GetHardwareNode(ctx context.Context, tx helper.Querier, hardwareId int) ([]entities.Node, error)
+	GetOrganizationNode(ctx context.Context, tx helper.Querier, organizationId int) ([]entities.Node, error)
+	Update(ctx context.Context, tx helper.Querier, node *entities.Node, payload *entities.NodeUpdate) (err error)
+	Delete(ctx context.Context, tx helper.Querier, id int) (err error)
+	Touch(ctx context.Context, tx helper.Querier, id int) (node entities.Node, err error)
+	TouchBySensor(ctx context.Context, tx helper.Querier, sensorIds []int) (err error)
+	CheckStatus(ctx context.Context, tx helper.Querier) (notifications []entities.NodeStatusNotification, err error)
+}
+
+type SensorRepository interface {
+	Create(ctx context.Context, tx helper.Querier, payload *entities.SensorCreate) (sensor entities.Sensor, err error)
+	GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (sensors []entities.Sensor, err error)
+	GetUserSensor(ctx context.Context, tx helper.Querier, userId int, roles []string) ([]entities.Sensor, error)
+	GetById(ctx context.Context, tx helper.Querier, id int) (sensor entities.Sensor, err error)
+	GetHardwareSensor(ctx context.Context, tx helper.Querier, hardwareId int) ([]entities.Sensor, error)
+	GetNodeSensor(ctx context.Context, tx helper.Querier, nodeId int) ([]entities.Sensor, error)
+	GetSensorChannel(ctx context.Context, tx helper.Querier, sensorId int, query *entities.ChannelQuery) (page entities.ChannelPage, err error)
+	GetSensorChannelSpan(ctx context.Context, tx helper.Querier, sensorId int, from *entities.ChannelTime, to *entities.ChannelTime) (span entities.ChannelSpan, err error)
+	GetSensorChannelAggregate(ctx context.Context, tx helper.Querier, sensorId int, bucket time.Duration, query *entities.ChannelAggregateQuery) (aggregates []entities.ChannelAggregate, err error)
+	GetSensorOwnerById(ctx context.Context, tx helper.Querier, sensorId int) (owner entities.SensorOwner, err error)
+	GetSensorOwnerByIds(ctx context.Context, tx helper.Querier, sensorIds []int) (owners map[int]entities.SensorOwner, err error)
+	Update(ctx context.Context, tx helper.Querier, sensor *entities.Sensor, payload *entities.SensorUpdate) (err error)
+	Delete(ctx context.Context, tx helper.Querier, id int) (err error)
+}
+
+type ChannelRepository interface {
+	NewChannel(payload *entities.ChannelCreate, receivedAt time.Time) (entities.Channel, error)
+	Create(ctx context.Context, tx helper.Querier, payload *entities.ChannelCreate) (entities.Channel, error)
+	CreateBatch(ctx context.Context, tx helper.Querier, channels []entities.Channel) (int64, error)
+	DeleteBefore(ctx context.Context, tx helper.Querier, before time.Time, idSensor *int) (int64, error)
+	CreateFromSource(ctx context.Context, tx helper.Querier, source pgx.CopyFromSource) (int64, error)
+	Export(ctx context.Context, tx helper.Querier, sensorIds []int, query *entities.ChannelExportQuery, handle func(channel entities.ChannelExport) error) (err error)
+}
+
+type UserRepository interface {
+	Create(ctx context.Context, tx helper.Querier, payload entities.UserCreate) (user entities.UserRead, err error)
+	GetAll(ctx context.Context, tx helper.Querier) (users []entities.UserRead, err error)
+	GetById(ctx context.Context, tx helper.Querier, id int) (user entities.UserRead, err error)
+	UpdatePassword(ctx context.Context, tx helper.Querier, id int, password string) (err error)
+	UpdateStatus(ctx context.Context, tx helper.Querier, id int, status bool) (err error)
+	UpdateIsAdmin(ctx context.Context, tx helper.Querier, id int, isAdmin bool) (err error)
+	UpdateRetention(ctx context.Context, tx helper.Querier, id int, retentionDays *int) (err error)
+	Delete(ctx context.Context, tx helper.Querier, id int) (err error)
+	GetByEmail(ctx context.Context, tx helper.Querier, email string) (user entities.UserRead, err error)
+	GetByUsername(ctx context.Context, tx helper.Querier, username string) (user entities.UserRead, err error)
+	GetByToken(ctx context.Context, tx helper.Querier, token string) (user entities.UserRead, err error)
+	MatchPassword(ctx context.Context, tx helper.Querier, user entities.UserRead, password string) (err error)
+	SendEmail(ctx context.Context, to string, subject string, body string) (err error)
+	SendEmailActivation(ctx context.Context, user entities.UserRead) (err error)
+	SendEmailForgotPassword(ctx context.Context, user entities.UserRead, resetToken string) (err error)
+	SendEmailAlert(ctx context.Context, notification entities.AlertNotification) (err error)
+	SendEmailNodeStatus(ctx context.Context, notification entities.NodeStatusNotification) (err error)
+	SignJWT(ctx context.Context, user entities.UserRead) (token string, err error)
+}
+
+type NodeKeyRepository interface {
+	Create(ctx context.Context, tx helper.Querier, idNode int) (nodeKey entities.NodeKeyCreated, err error)
+	GetNodeKey(ctx context.Context, tx helper.Querier, idNode int) (nodeKeys []entities.NodeKey, err error)
+	GetDeviceByKey(ctx context.Context, tx helper.Querier, key string) (device entities.Device, err error)
+	Revoke(ctx context.Context, tx helper.Querier, idNode int, idNodeKey int) (err error)
+	RevokeAll(ctx context.Context, tx helper.Querier, idNode int) (err error)
+}
+
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, tx helper.Querier, idUser int) (token string, err error)
+	GetByToken(ctx context.Context, tx helper.Querier, token string) (refreshToken entities.RefreshToken, err error)
+	Revoke(ctx context.Context, tx helper.Querier, idRefreshToken int) (err error)
+	RevokeAllByUser(ctx context.Context, tx helper.Querier, idUser int) (err error)
+}
+
+type PasswordResetRepository interface {
+	Create(ctx context.Context, tx helper.Querier, idUser int) (token string, err error)
+	GetByToken(ctx context.Context, tx helper.Querier, token string) (passwordReset entities.PasswordReset, err error)
+	Use(ctx context.Context, tx helper.Querier, idPasswordReset int) (err error)
+}
+
+type AlertRepository interface {
+	Create(ctx context.Context, tx helper.Querier, payload *entities.AlertRuleCreate) (alertRule entities.AlertRule, err error)
+	GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (alertRules []entities.AlertRule, err error)
+	GetById(ctx context.Context, tx helper.Querier, id int) (alertRule entities.AlertRule, err error)
+	GetEvents(ctx context.Context, tx helper.Querier, idAlertRule int) (events []entities.AlertEvent, err error)
+	Update(ctx context.Context, tx helper.Querier, alertRule *entities.AlertRule, payload *entities.AlertRuleUpdate) (err error)
+	Delete(ctx context.Context, tx helper.Querier, id int) (err error)
+	Evaluate(ctx context.Context, tx helper.Querier, channels []entities.Channel) (notifications []entities.AlertNotification, err error)
+}
+
+type WebhookRepository interface {
+	Create(ctx context.Context, tx helper.Querier, idUser int, payload *entities.WebhookCreate) (webhook entities.WebhookCreated, err error)
+	GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (webhooks []entities.Webhook, err error)
+	GetById(ctx context.Context, tx helper.Querier, id int) (webhook entities.Webhook, err error)
+	Update(ctx context.Context, tx helper.Querier, webhook *entities.Webhook, payload *entities.WebhookUpdate) (err error)
+	Delete(ctx context.Context, tx helper.Querier, id int) (err error)
+	GetDeliveries(ctx context.Context, tx helper.Querier, idWebhook int) (deliveries []entities.WebhookDelivery, err error)
+	Enqueue(ctx context.Context, tx helper.Querier, idOrganization *int, eventType string, payload []byte) (count int64, err error)
+	ClaimDue(ctx context.Context, tx helper.Querier, limit int, leaseUntil time.Time) (jobs []entities.WebhookDeliveryJob, err error)
+	MarkSuccess(ctx context.Context, tx helper.Querier, idWebhookDelivery int64, statusCode int) (err error)
+	MarkFailure(ctx context.Context, tx helper.Querier, idWebhookDelivery int64, statusCode *int, lastError string, nextAttemptAt time.Time, giveUp bool) (err error)
+}
+
+type NodeCommandRepository interface {
+	Create(ctx context.Context, tx helper.Querier, idNode int, payload *entities.NodeCommandCreate) (command entities.NodeCommand, err error)
+	Expire(ctx context.Context, tx helper.Querier, idNode int) (err error)
+	GetHistory(ctx context.Context, tx helper.Querier, idNode int) (commands []entities.NodeCommand, err error)
+	Deliver(ctx context.Context, tx helper.Querier, idNode int) (commands []entities.NodeCommand, err error)
+	Ack(ctx context.Context, tx helper.Querier, idNode int, idNodeCommand int, payload *entities.NodeCommandAck) (command entities.NodeCommand, err error)
+}
+
+type NodeShadowRepository interface {
+	WithDelta(shadow entities.NodeShadow) (entities.NodeShadowWithDelta, error)
+	GetByNode(ctx context.Context, tx helper.Querier, idNode int) (shadow entities.NodeShadowWithDelta, err error)
+	UpdateDesired(ctx context.Context, tx helper.Querier, idNode int, payload *entities.NodeShadowDesiredUpdate) (shadow entities.NodeShadowWithDelta, err error)
+	Report(ctx context.Context, tx helper.Querier, idNode int, payload *entities.NodeShadowReport) (shadow entities.NodeShadowWithDelta, err error)
+}
+
+type FirmwareRepository interface {
+	Create(ctx context.Context, tx helper.Querier, firmware *entities.Firmware) (err error)
+	GetAll(ctx context.Context, tx helper.Querier) (firmwares []entities.Firmware, err error)
+	GetHardwareFirmware(ctx context.Context, tx helper.Querier, idHardware int) (firmwares []entities.Firmware, err error)
+	GetById(ctx context.Context, tx helper.Querier, id int) (firmware entities.Firmware, err error)
+	Update(ctx context.Context, tx helper.Querier, firmware *entities.Firmware, payload *entities.FirmwareUpdate) (err error)
+	Delete(ctx context.Context, tx helper.Querier, id int) (err error)
+}
+
+type PartitionRepository interface {
+	GetAll(ctx context.Context, tx helper.Querier) (partitions []entities.ChannelPartition, err error)
+	Create(ctx context.Context, tx helper.Querier, from time.Time, to time.Time) (name string, err error)
+	Drop(ctx context.Context, tx helper.Querier, name string) (err error)
+	Detach(ctx context.Context, tx helper.Querier, name string) (archiveName string, err error)
+	DeleteDefaultBefore(ctx context.Context, tx helper.Querier, before time.Time) (int64, error)
+	DeleteExpiredUserChannel(ctx context.Context, tx helper.Querier) (int64, error)
+}
+
+type RollupRepository interface {
+	MarkPendingRange(ctx context.Context, tx helper.Querier, idSensor *int, from *time.Time, to *time.Time) (count int64, err error)
+	RefreshPending(ctx context.Context, tx helper.Querier, limit int) (count int, err error)
+}
+
+var (
+	_ HardwareRepository      = &PostgresHardwareRepository{}
+	_ OrganizationRepository  = &PostgresOrganizationRepository{}
+	_ NodeRepository          = &PostgresNodeRepository{}
+	_ SensorRepository        = &PostgresSensorRepository{}
+	_ ChannelRepository       = &PostgresChannelRepository{}
+	_ UserRepository          = &PostgresUserRepository{}
+	_ NodeKeyRepository       = &PostgresNodeKeyRepository{}
+	_ RefreshTokenRepository  = &PostgresRefreshTokenRepository{}
+	_ PasswordResetRepository = &PostgresPasswordResetRepository{}
+	_ AlertRepository         = &PostgresAlertRepository{}
+	_ WebhookRepository       = &PostgresWebhookRepository{}
+	_ NodeCommandRepository   = &PostgresNodeCommandRepository{}
+	_ NodeShadowRepository    = &PostgresNodeShadowRepository{}
+	_ FirmwareRepository      = &PostgresFirmwareRepository{}
+	_ PartitionRepository     = &PostgresPartitionRepository{}
+	_ RollupRepository        = &PostgresRollupRepository{}
+
+	_ HardwareRepository      = &MemoryHardwareRepository{}
+	_ OrganizationRepository  = &MemoryOrganizationRepository{}
+	_ NodeRepository          = &MemoryNodeRepository{}
+	_ SensorRepository        = &MemorySensorRepository{}
+	_ ChannelRepository       = &MemoryChannelRepository{}
+	_ UserRepository          = &MemoryUserRepository{}
+	_ NodeKeyRepository       = &MemoryNodeKeyRepository{}
+	_ RefreshTokenRepository  = &MemoryRefreshTokenRepository{}
+	_ PasswordResetRepository = &MemoryPasswordResetRepository{}
+	_ AlertRepository         = &MemoryAlertRepository{}
+	_ WebhookRepository       = &MemoryWebhookRepository{}
+	_ NodeCommandRepository   = &MemoryNodeCommandRepository{}
+	_ NodeShadowRepository    = &MemoryNodeShadowRepository{}
+	_ FirmwareRepository      = &MemoryFirmwareRepository{}
+	_ PartitionRepository     = &MemoryPartitionRepository{}
+	_ RollupRepository        = &MemoryRollupRepository{}
+)
+
+// Repositories is every repository of the server, built either on postgres or on the memory database
+type Repositories struct {
+	User          UserRepository
+	Hardware      HardwareRepository
+	Organization  OrganizationRepository
+	Node          NodeRepository
+	Sensor        SensorRepository
+	Channel       ChannelRepository
+	NodeKey       NodeKeyRepository
+	RefreshToken  RefreshTokenRepository
+	PasswordReset PasswordResetRepository
+	Alert         AlertRepository
+	Webhook       WebhookRepository
+	NodeCommand   NodeCommandRepository
+	NodeShadow    NodeShadowRepository
+	Firmware      FirmwareRepository
+	Partition     PartitionRepository
+	Rollup        RollupRepository
+}
+
+func NewPostgresRepositories(mailDialer *gomail.Dialer) (repositories Repositories, err error) {
+	user, err := NewUserRepository(mailDialer)
+	if err != nil {
+		return repositories, err
+	}
+	repositories.User = &user
+
+	hardware, err := NewHardwareRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Hardware = &hardware
+
+	organization, err := NewOrganizationRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Organization = &organization
+
+	node, err := NewNodeRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Node = &node
+
+	sensor, err := NewSensorRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Sensor = &sensor
+
+	channel, err := NewChannelRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Channel = &channel
+
+	nodeKey, err := NewNodeKeyRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.NodeKey = &nodeKey
+
+	refreshToken, err := NewRefreshTokenRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.RefreshToken = &refreshToken
+
+	passwordReset, err := NewPasswordResetRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.PasswordReset = &passwordReset
+
+	alert, err := NewAlertRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Alert = &alert
+
+	webhook, err := NewWebhookRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Webhook = &webhook
+
+	nodeCommand, err := NewNodeCommandRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.NodeCommand = &nodeCommand
+
+	nodeShadow, err := NewNodeShadowRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.NodeShadow = &nodeShadow
+
+	firmware, err := NewFirmwareRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Firmware = &firmware
+
+	partition, err := NewPartitionRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Partition = &partition
+
+	rollup, err := NewRollupRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Rollup = &rollup
+
+	return repositories, nil
+}
+
+func NewMemoryRepositories() (repositories Repositories, err error) {
+	user, err := NewMemoryUserRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.User = &user
+
+	hardware, err := NewMemoryHardwareRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Hardware = &hardware
+
+	organization, err := NewMemoryOrganizationRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Organization = &organization
+
+	node, err := NewMemoryNodeRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Node = &node
+
+	sensor, err := NewMemorySensorRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Sensor = &sensor
+
+	channel, err := NewMemoryChannelRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Channel = &channel
+
+	nodeKey, err := NewMemoryNodeKeyRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.NodeKey = &nodeKey
+
+	refreshToken, err := NewMemoryRefreshTokenRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.RefreshToken = &refreshToken
+
+	passwordReset, err := NewMemoryPasswordResetRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.PasswordReset = &passwordReset
+
+	alert, err := NewMemoryAlertRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Alert = &alert
+
+	webhook, err := NewMemoryWebhookRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Webhook = &webhook
+
+	nodeCommand, err := NewMemoryNodeCommandRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.NodeCommand = &nodeCommand
+
+	nodeShadow, err := NewMemoryNodeShadowRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.NodeShadow = &nodeShadow
+
+	firmware, err := NewMemoryFirmwareRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Firmware = &firmware
+
+	partition, err := NewMemoryPartitionRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Partition = &partition
+
+	rollup, err := NewMemoryRollupRepository()
+	if err != nil {
+		return repositories, err
+	}
+	repositories.Rollup = &rollup
+
+	return repositories, nil
+}
+
+// IsConstraintError report whether the write was rejected because of the data itself, like a reference to a deleted
+// row, so retrying it can't succeed
+func IsConstraintError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 23 is integrity constraint violation
+		return strings.HasPrefix(pgErr.Code, "23")
+	}
+	var memoryErr *memoryConstraintError
+	return errors.As(err, &memoryErr)
+}
@@ -0,0 +1,163 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+// PostgresRollupRepository maintain the hourly and daily summary of the channel. New channel mark their
+// hour as pending, the worker then recompute every pending hour from the channel and its day from the hours.
+type PostgresRollupRepository struct{}
+
+func NewRollupRepository() (PostgresRollupRepository, error) {
+	return PostgresRollupRepository{}, nil
+}
+
+// markDeletedChannelPending wrap a "DELETE FROM channel ... RETURNING id_sensor, time" statement so the hour of
+// every deleted channel is recomputed by the worker, the statement return the number of deleted channel
+func markDeletedChannelPending(deleteStatement string) string {
+	return fmt.Sprintf(`
+	WITH deleted AS (%s), pending AS (
+		INSERT INTO channel_rollup_pending (id_sensor, bucket)
+		SELECT DISTINCT id_sensor, date_trunc('hour', time) FROM deleted
+		ON CONFLICT DO NOTHING
+	)
+	SELECT COUNT(*) FROM deleted`, deleteStatement)
+}
+
+// markChannelPending mark the hour of every new channel to be recomputed, it is run in the transaction that
+// insert the channels so an hour is never served from a rollup that miss one of its channels
+func markChannelPending(ctx context.Context, tx helper.Querier, channels []entities.Channel) (err error) {
+	sensorIds := []int{}
+	buckets := []time.Time{}
+	seen := map[string]bool{}
+	for _, channel := range channels {
+		bucket := channel.Time.UTC().Truncate(time.Hour)
+		key := fmt.Sprintf("%d_%d", channel.IdSensor, bucket.Unix())
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		sensorIds = append(sensorIds, channel.IdSensor)
+		buckets = append(buckets, bucket)
+	}
+	if len(sensorIds) == 0 {
+		return nil
+	}
+
+	sqlStatement := `
+	INSERT INTO channel_rollup_pending (id_sensor, bucket)
+	SELECT * FROM unnest($1::INTEGER[], $2::TIMESTAMP[])
+	ON CONFLICT DO NOTHING`
+	_, err = tx.Exec(ctx, sqlStatement, sensorIds, buckets)
+	return err
+}
+
+// MarkPendingRange mark every hour that have channel between from and to to be recomputed,
+// idSensor limit it to a single sensor and a nil from or to is unbounded
+func (r *PostgresRollupRepository) MarkPendingRange(ctx context.Context, tx helper.Querier, idSensor *int, from *time.Time, to *time.Time) (count int64, err error) {
+	sqlStatement := `
+	INSERT INTO channel_rollup_pending (id_sensor, bucket)
+	SELECT DISTINCT id_sensor, date_trunc('hour', time) FROM channel
+	WHERE ($1::INTEGER IS NULL OR id_sensor=$1)
+	AND ($2::TIMESTAMP IS NULL OR time>=$2)
+	AND ($3::TIMESTAMP IS NULL OR time<=$3)
+	ON CONFLICT DO NOTHING`
+	res, err := tx.Exec(ctx, sqlStatement, idSensor, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// RefreshPending recompute at most limit pending hour and the day containing them, it return the number
+// of hour refreshed. An hour or day without channel anymore is deleted. Pending row are locked with
+// SKIP LOCKED so several server can run the worker.
+func (r *PostgresRollupRepository) RefreshPending(ctx context.Context, tx helper.Querier, limit int) (count int, err error) {
+	rows, err := tx.Query(ctx, `
+	DELETE FROM channel_rollup_pending
+	WHERE (id_sensor, bucket) IN (
+		SELECT id_sensor, bucket FROM channel_rollup_pending
+		ORDER BY bucket
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id_sensor, bucket`, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	sensorIds := []int{}
+	buckets := []time.Time{}
+	for rows.Next() {
+		var idSensor int
+		var bucket time.Time
+		err := rows.Scan(&idSensor, &bucket)
+		if err != nil {
+			return 0, err
+		}
+		sensorIds = append(sensorIds, idSensor)
+		buckets = append(buckets, bucket)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(sensorIds) == 0 {
+		return 0, nil
+	}
+
+	_, err = tx.Exec(ctx, `
+	INSERT INTO channel_rollup_hour (id_sensor, bucket, count, min, max, sum)
+	SELECT channel.id_sensor, pending.bucket, COUNT(*), MIN(channel.value), MAX(channel.value), SUM(channel.value)
+	FROM unnest($1::INTEGER[], $2::TIMESTAMP[]) AS pending(id_sensor, bucket)
+	INNER JOIN channel ON channel.id_sensor=pending.id_sensor AND channel.time>=pending.bucket AND channel.time<pending.bucket + INTERVAL '1 hour'
+	GROUP BY channel.id_sensor, pending.bucket
+	ON CONFLICT (id_sensor, bucket) DO UPDATE SET count=EXCLUDED.count, min=EXCLUDED.min, max=EXCLUDED.max, sum=EXCLUDED.sum`,
+		sensorIds, buckets)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `
+	DELETE FROM channel_rollup_hour hour
+	USING unnest($1::INTEGER[], $2::TIMESTAMP[]) AS pending(id_sensor, bucket)
+	WHERE hour.id_sensor=pending.id_sensor AND hour.bucket=pending.bucket
+	AND NOT EXISTS (
+		SELECT 1 FROM channel
+		WHERE channel.id_sensor=pending.id_sensor AND channel.time>=pending.bucket AND channel.time<pending.bucket + INTERVAL '1 hour'
+	)`, sensorIds, buckets)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `
+	INSERT INTO channel_rollup_day (id_sensor, bucket, count, min, max, sum)
+	SELECT hour.id_sensor, pending.bucket, SUM(hour.count), MIN(hour.min), MAX(hour.max), SUM(hour.sum)
+	FROM (SELECT DISTINCT id_sensor, date_trunc('day', bucket) AS bucket FROM unnest($1::INTEGER[], $2::TIMESTAMP[]) AS p(id_sensor, bucket)) AS pending
+	INNER JOIN channel_rollup_hour hour ON hour.id_sensor=pending.id_sensor AND hour.bucket>=pending.bucket AND hour.bucket<pending.bucket + INTERVAL '1 day'
+	GROUP BY hour.id_sensor, pending.bucket
+	ON CONFLICT (id_sensor, bucket) DO UPDATE SET count=EXCLUDED.count, min=EXCLUDED.min, max=EXCLUDED.max, sum=EXCLUDED.sum`,
+		sensorIds, buckets)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `
+	DELETE FROM channel_rollup_day day
+	USING (SELECT DISTINCT id_sensor, date_trunc('day', bucket) AS bucket FROM unnest($1::INTEGER[], $2::TIMESTAMP[]) AS p(id_sensor, bucket)) AS pending
+	WHERE day.id_sensor=pending.id_sensor AND day.bucket=pending.bucket
+	AND NOT EXISTS (
+		SELECT 1 FROM channel_rollup_hour hour
+		WHERE hour.id_sensor=pending.id_sensor AND hour.bucket>=pending.bucket AND hour.bucket<pending.bucket + INTERVAL '1 day'
+	)`, sensorIds, buckets)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(sensorIds), nil
+}
@@ -0,0 +1,117 @@
+package repositories
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/helper"
+)
+
+// MemoryRollupRepository is RollupRepository on the memory database. The memory sensor repository
+// compute the aggregate from the channel, the rollup are only kept so the worker behave the same.
+type MemoryRollupRepository struct{}
+
+func NewMemoryRollupRepository() (MemoryRollupRepository, error) {
+	return MemoryRollupRepository{}, nil
+}
+
+// MarkPendingRange mark every hour that have channel between from and to to be recomputed,
+// idSensor limit it to a single sensor and a nil from or to is unbounded
+func (r *MemoryRollupRepository) MarkPendingRange(ctx context.Context, tx helper.Querier, idSensor *int, from *time.Time, to *time.Time) (count int64, err error) {
+	err = memoryWrite(tx, func(data *memoryData) error {
+		for _, channel := range data.channels {
+			if idSensor != nil && channel.IdSensor != *idSensor {
+				continue
+			}
+			if (from != nil && channel.Time.Before(*from)) || (to != nil && channel.Time.After(*to)) {
+				continue
+			}
+
+			key := memoryRollupKey{IdSensor: channel.IdSensor, Bucket: channel.Time.UTC().Truncate(time.Hour)}
+			if _, ok := data.rollupPending[key]; !ok {
+				data.rollupPending[key] = struct{}{}
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// add merge the summary of a single value or of another rollup into the rollup
+func (r *MemoryRollupRepository) add(rollup memoryRollup, other memoryRollup) memoryRollup {
+	if rollup.Count == 0 {
+		return other
+	}
+	if other.Min < rollup.Min {
+		rollup.Min = other.Min
+	}
+	if other.Max > rollup.Max {
+		rollup.Max = other.Max
+	}
+	rollup.Count += other.Count
+	rollup.Sum += other.Sum
+	return rollup
+}
+
+// RefreshPending recompute at most limit pending hour and the day containing them, it return the number
+// of hour refreshed. An hour or day without channel anymore is deleted.
+func (r *MemoryRollupRepository) RefreshPending(ctx context.Context, tx helper.Querier, limit int) (count int, err error) {
+	err = memoryWrite(tx, func(data *memoryData) error {
+		pending := make([]memoryRollupKey, 0, len(data.rollupPending))
+		for key := range data.rollupPending {
+			pending = append(pending, key)
+		}
+		sort.Slice(pending, func(i, j int) bool {
+			if !pending[i].Bucket.Equal(pending[j].Bucket) {
+				return pending[i].Bucket.Before(pending[j].Bucket)
+			}
+			return pending[i].IdSensor < pending[j].IdSensor
+		})
+		if len(pending) > limit {
+			pending = pending[:limit]
+		}
+
+		hours := map[memoryRollupKey]memoryRollup{}
+		days := map[memoryRollupKey]bool{}
+		for _, key := range pending {
+			delete(data.rollupPending, key)
+			hours[key] = memoryRollup{}
+			days[memoryRollupKey{IdSensor: key.IdSensor, Bucket: key.Bucket.Truncate(24 * time.Hour)}] = true
+		}
+
+		for _, channel := range data.channels {
+			key := memoryRollupKey{IdSensor: channel.IdSensor, Bucket: channel.Time.UTC().Truncate(time.Hour)}
+			if rollup, ok := hours[key]; ok {
+				hours[key] = r.add(rollup, memoryRollup{Count: 1, Min: channel.Value, Max: channel.Value, Sum: channel.Value})
+			}
+		}
+		// An hour without channel anymore is deleted, the same as the postgres repository
+		for key, rollup := range hours {
+			if rollup.Count > 0 {
+				data.rollupHours[key] = rollup
+			} else {
+				delete(data.rollupHours, key)
+			}
+		}
+
+		for day := range days {
+			rollup := memoryRollup{}
+			for key, hour := range data.rollupHours {
+				if key.IdSensor == day.IdSensor && key.Bucket.Truncate(24*time.Hour).Equal(day.Bucket) {
+					rollup = r.add(rollup, hour)
+				}
+			}
+			if rollup.Count > 0 {
+				data.rollupDays[day] = rollup
+			} else {
+				delete(data.rollupDays, day)
+			}
+		}
+
+		count = len(pending)
+		return nil
+	})
+	return count, err
+}
@@ -2,7 +2,11 @@ package repositories
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dafaath/iot-server/internal/entities"
 	"github.com/dafaath/iot-server/internal/helper"
@@ -10,25 +14,25 @@ import (
 	"github.com/jackc/pgx/v5"
 )
 
-type SensorRepository struct{}
+type PostgresSensorRepository struct{}
 
-func NewSensorRepository() (SensorRepository, error) {
-	return SensorRepository{}, nil
+func NewSensorRepository() (PostgresSensorRepository, error) {
+	return PostgresSensorRepository{}, nil
 }
 
-func (u *SensorRepository) sensorFieldWithoutId() string {
+func (u *PostgresSensorRepository) sensorFieldWithoutId() string {
 	return "name, unit, id_node, id_hardware"
 }
 
-func (u *SensorRepository) sensorField() string {
+func (u *PostgresSensorRepository) sensorField() string {
 	return "sensor.id_sensor, sensor.name, sensor.unit, sensor.id_node, sensor.id_hardware"
 }
 
-func (u *SensorRepository) sensorPointer(sensor *entities.Sensor) []interface{} {
+func (u *PostgresSensorRepository) sensorPointer(sensor *entities.Sensor) []interface{} {
 	return []interface{}{&sensor.IdSensor, &sensor.Name, &sensor.Unit, &sensor.IdNode, &sensor.IdHardware}
 }
 
-func (h *SensorRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.SensorCreate) (sensor entities.Sensor, err error) {
+func (h *PostgresSensorRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.SensorCreate) (sensor entities.Sensor, err error) {
 	sensor = entities.Sensor{
 		IdSensor:     0,
 		SensorCreate: *payload,
@@ -46,7 +50,7 @@ func (h *SensorRepository) Create(ctx context.Context, tx helper.Querier, payloa
 	return sensor, nil
 }
 
-func (u *SensorRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (sensors []entities.Sensor, err error) {
+func (u *PostgresSensorRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (sensors []entities.Sensor, err error) {
 	sensors = []entities.Sensor{}
 	var sqlStatement string
 	var rows pgx.Rows
@@ -58,7 +62,9 @@ func (u *SensorRepository) GetAll(ctx context.Context, tx helper.Querier, curren
 		}
 		defer rows.Close()
 	} else {
-		sqlStatement = fmt.Sprintf(`SELECT %s FROM "sensor" INNER JOIN "node" ON node.id_node=sensor.id_node WHERE node.id_user=$1`, u.sensorField())
+		sqlStatement = fmt.Sprintf(`
+		SELECT %s FROM "sensor" INNER JOIN "node" ON node.id_node=sensor.id_node
+		WHERE node.id_organization IN (SELECT id_organization FROM organization_member WHERE id_user=$1)`, u.sensorField())
 		rows, err = tx.Query(ctx, sqlStatement, currentUser.IdUser)
 		if err != nil {
 			return sensors, err
@@ -82,7 +88,36 @@ func (u *SensorRepository) GetAll(ctx context.Context, tx helper.Querier, curren
 	return sensors, nil
 }
 
-func (u *SensorRepository) GetById(ctx context.Context, tx helper.Querier, id int) (sensor entities.Sensor, err error) {
+// GetUserSensor get every sensor on the nodes of the organizations where the user has one of the roles,
+// even when the user is an admin
+func (u *PostgresSensorRepository) GetUserSensor(ctx context.Context, tx helper.Querier, userId int, roles []string) ([]entities.Sensor, error) {
+	sensors := []entities.Sensor{}
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM "sensor" INNER JOIN "node" ON node.id_node=sensor.id_node
+	WHERE node.id_organization IN (SELECT id_organization FROM organization_member WHERE id_user=$1 AND role=ANY($2))`, u.sensorField())
+	rows, err := tx.Query(ctx, sqlStatement, userId, roles)
+	if err != nil {
+		return sensors, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sensor entities.Sensor
+		err := rows.Scan(
+			u.sensorPointer(&sensor)...,
+		)
+		if err != nil {
+			return sensors, err
+		}
+		sensors = append(sensors, sensor)
+	}
+	if err := rows.Err(); err != nil {
+		return sensors, err
+	}
+	return sensors, nil
+}
+
+func (u *PostgresSensorRepository) GetById(ctx context.Context, tx helper.Querier, id int) (sensor entities.Sensor, err error) {
 	sqlStatement := fmt.Sprintf(`SELECT %s FROM "sensor" WHERE id_sensor=$1`, u.sensorField())
 	err = tx.QueryRow(ctx, sqlStatement, id).Scan(
 		u.sensorPointer(&sensor)...,
@@ -96,7 +131,7 @@ func (u *SensorRepository) GetById(ctx context.Context, tx helper.Querier, id in
 	return sensor, nil
 }
 
-func (u *SensorRepository) GetHardwareSensor(ctx context.Context, tx helper.Querier, hardwareId int) ([]entities.Sensor, error) {
+func (u *PostgresSensorRepository) GetHardwareSensor(ctx context.Context, tx helper.Querier, hardwareId int) ([]entities.Sensor, error) {
 	sensors := []entities.Sensor{}
 	sqlStatement := fmt.Sprintf(`SELECT %s FROM "sensor" WHERE id_hardware=$1`, u.sensorField())
 	rows, err := tx.Query(ctx, sqlStatement, hardwareId)
@@ -122,7 +157,7 @@ func (u *SensorRepository) GetHardwareSensor(ctx context.Context, tx helper.Quer
 	return sensors, nil
 }
 
-func (u *SensorRepository) GetNodeSensor(ctx context.Context, tx helper.Querier, nodeId int) ([]entities.Sensor, error) {
+func (u *PostgresSensorRepository) GetNodeSensor(ctx context.Context, tx helper.Querier, nodeId int) ([]entities.Sensor, error) {
 	sensors := []entities.Sensor{}
 	sqlStatement := fmt.Sprintf(`SELECT %s FROM "sensor" WHERE id_node=$1`, u.sensorField())
 	rows, err := tx.Query(ctx, sqlStatement, nodeId)
@@ -148,44 +183,306 @@ func (u *SensorRepository) GetNodeSensor(ctx context.Context, tx helper.Querier,
 	return sensors, nil
 }
 
-func (u *SensorRepository) GetSensorChannel(ctx context.Context, tx helper.Querier, sensorId int) (channels []entities.Channel, err error) {
-	channels = []entities.Channel{}
-	sqlStatement := `SELECT channel.time, channel.value, channel.id_sensor FROM "channel" WHERE channel.id_sensor=$1`
-	rows, err := tx.Query(ctx, sqlStatement, sensorId)
+// encodeChannelCursor create an opaque cursor pointing at the channel, time and id
+// are both used so channel with the same time are never skipped
+func encodeChannelCursor(channel entities.Channel) string {
+	cursor := fmt.Sprintf("%d_%d", channel.Time.UnixNano(), channel.IdChannel)
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+func decodeChannelCursor(cursor string) (cursorTime time.Time, idChannel int64, err error) {
+	invalidCursorError := fiber.NewError(400, "cursor is invalid")
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return cursorTime, idChannel, invalidCursorError
+	}
+
+	parts := strings.Split(string(decoded), "_")
+	if len(parts) != 2 {
+		return cursorTime, idChannel, invalidCursorError
+	}
+
+	unixNano, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return cursorTime, idChannel, invalidCursorError
+	}
+
+	idChannel, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return cursorTime, idChannel, invalidCursorError
+	}
+
+	return time.Unix(0, unixNano).UTC(), idChannel, nil
+}
+
+// channelRangeCondition create the where condition for channel of a sensor between from (inclusive) and to (exclusive)
+func (u *PostgresSensorRepository) channelRangeCondition(sensorId int, from *entities.ChannelTime, to *entities.ChannelTime) (conditions []string, args []interface{}) {
+	return u.rangeCondition("channel", "time", sensorId, from, to)
+}
+
+// rangeCondition create the same condition as channelRangeCondition on another table, such as a rollup table
+func (u *PostgresSensorRepository) rangeCondition(table string, timeColumn string, sensorId int, from *entities.ChannelTime, to *entities.ChannelTime) (conditions []string, args []interface{}) {
+	conditions = []string{fmt.Sprintf("%s.id_sensor=$1", table)}
+	args = []interface{}{sensorId}
+	if from != nil {
+		args = append(args, from.Time)
+		conditions = append(conditions, fmt.Sprintf("%s.%s>=$%d", table, timeColumn, len(args)))
+	}
+
+	if to != nil {
+		args = append(args, to.Time)
+		conditions = append(conditions, fmt.Sprintf("%s.%s<$%d", table, timeColumn, len(args)))
+	}
+	return conditions, args
+}
+
+func (u *PostgresSensorRepository) GetSensorChannel(ctx context.Context, tx helper.Querier, sensorId int, query *entities.ChannelQuery) (page entities.ChannelPage, err error) {
+	page = entities.ChannelPage{Channel: []entities.Channel{}}
+	query.SetDefault()
+
+	conditions, args := u.channelRangeCondition(sensorId, query.From, query.To)
+
+	direction := "ASC"
+	comparator := ">"
+	if query.Order == "desc" {
+		direction = "DESC"
+		comparator = "<"
+	}
+
+	if query.Cursor != "" {
+		cursorTime, cursorId, err := decodeChannelCursor(query.Cursor)
+		if err != nil {
+			return page, err
+		}
+		args = append(args, cursorTime, cursorId)
+		conditions = append(conditions, fmt.Sprintf("(channel.time, channel.id_channel)%s($%d, $%d)", comparator, len(args)-1, len(args)))
+	}
+
+	// Fetch one more row than the limit to know whether there is a next page
+	args = append(args, query.Limit+1)
+	sqlStatement := fmt.Sprintf(`SELECT channel.id_channel, channel.time, channel.received_at, channel.value, channel.id_sensor FROM "channel" WHERE %s ORDER BY channel.time %s, channel.id_channel %s LIMIT $%d`,
+		strings.Join(conditions, " AND "), direction, direction, len(args))
+	rows, err := tx.Query(ctx, sqlStatement, args...)
 	if err != nil {
-		return channels, err
+		return page, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var channel entities.Channel
 		err := rows.Scan(
-			&channel.Time, &channel.Value, &channel.IdSensor,
+			&channel.IdChannel, &channel.Time, &channel.ReceivedAt, &channel.Value, &channel.IdSensor,
 		)
 		if err != nil {
-			return channels, err
+			return page, err
+		}
+		page.Channel = append(page.Channel, channel)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	if len(page.Channel) > query.Limit {
+		page.Channel = page.Channel[:query.Limit]
+		page.NextCursor = encodeChannelCursor(page.Channel[len(page.Channel)-1])
+	}
+	return page, nil
+}
+
+func (u *PostgresSensorRepository) GetSensorChannelSpan(ctx context.Context, tx helper.Querier, sensorId int, from *entities.ChannelTime, to *entities.ChannelTime) (span entities.ChannelSpan, err error) {
+	conditions, args := u.channelRangeCondition(sensorId, from, to)
+	sqlStatement := fmt.Sprintf(`SELECT COUNT(*), MIN(channel.time), MAX(channel.time) FROM "channel" WHERE %s`, strings.Join(conditions, " AND "))
+	err = tx.QueryRow(ctx, sqlStatement, args...).Scan(&span.Count, &span.From, &span.To)
+	if err != nil {
+		return span, err
+	}
+	return span, nil
+}
+
+// aggregateFunctionSql map every aggregate function name to the sql expression,
+// the percentile expression need the placeholder number of the percentile argument
+var aggregateFunctionSql = map[string]string{
+	"min":        "MIN(channel.value)",
+	"max":        "MAX(channel.value)",
+	"avg":        "AVG(channel.value)",
+	"sum":        "SUM(channel.value)",
+	"count":      "COUNT(channel.value)::FLOAT",
+	"first":      "(ARRAY_AGG(channel.value ORDER BY channel.time ASC))[1]",
+	"last":       "(ARRAY_AGG(channel.value ORDER BY channel.time DESC))[1]",
+	"stddev":     "STDDEV_SAMP(channel.value)",
+	"percentile": "PERCENTILE_CONT($%d::FLOAT) WITHIN GROUP (ORDER BY channel.value)",
+}
+
+// rollupFunctionSql map the aggregate function that can be computed from a rollup table to the sql expression
+var rollupFunctionSql = map[string]string{
+	"min":   "MIN(rollup.min)",
+	"max":   "MAX(rollup.max)",
+	"avg":   "SUM(rollup.sum) / NULLIF(SUM(rollup.count), 0)",
+	"sum":   "SUM(rollup.sum)",
+	"count": "SUM(rollup.count)::FLOAT",
+}
+
+// rollupTable choose the coarsest rollup table that give the same result as the channel table, it return
+// an empty string when the functions, bucket or range can't be computed from a rollup
+func (u *PostgresSensorRepository) rollupTable(bucket time.Duration, query *entities.ChannelAggregateQuery) string {
+	for _, function := range query.Functions {
+		if _, ok := rollupFunctionSql[function]; !ok {
+			return ""
+		}
+	}
+
+	isAligned := func(granularity time.Duration) bool {
+		if bucket%granularity != 0 {
+			return false
+		}
+		for _, t := range []*entities.ChannelTime{query.From, query.To} {
+			if t != nil && t.UnixNano()%int64(granularity) != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	if isAligned(24 * time.Hour) {
+		return "channel_rollup_day"
+	}
+	if isAligned(time.Hour) {
+		return "channel_rollup_hour"
+	}
+	return ""
+}
+
+// getSensorRollupAggregate compute the aggregate from a rollup table, bucket are aligned the same way as the channel query
+func (u *PostgresSensorRepository) getSensorRollupAggregate(ctx context.Context, tx helper.Querier, table string, sensorId int, bucket time.Duration, query *entities.ChannelAggregateQuery) (aggregates []entities.ChannelAggregate, err error) {
+	aggregates = []entities.ChannelAggregate{}
+	conditions, args := u.rangeCondition("rollup", "bucket", sensorId, query.From, query.To)
+	args = append(args, bucket.Seconds())
+	bucketSql := fmt.Sprintf("TO_TIMESTAMP(FLOOR(EXTRACT(EPOCH FROM rollup.bucket) / $%d) * $%d) AT TIME ZONE 'utc'", len(args), len(args))
+
+	selectedFunctions := []string{}
+	for _, function := range query.Functions {
+		selectedFunctions = append(selectedFunctions, rollupFunctionSql[function])
+	}
+
+	args = append(args, entities.ChannelAggregateMaxBucket)
+	sqlStatement := fmt.Sprintf(`SELECT %s AS bucket, %s FROM %s rollup WHERE %s GROUP BY bucket ORDER BY bucket ASC LIMIT $%d`,
+		bucketSql, strings.Join(selectedFunctions, ", "), table, strings.Join(conditions, " AND "), len(args))
+	return u.scanChannelAggregate(ctx, tx, sqlStatement, args, query)
+}
+
+func (u *PostgresSensorRepository) scanChannelAggregate(ctx context.Context, tx helper.Querier, sqlStatement string, args []interface{}, query *entities.ChannelAggregateQuery) (aggregates []entities.ChannelAggregate, err error) {
+	aggregates = []entities.ChannelAggregate{}
+	rows, err := tx.Query(ctx, sqlStatement, args...)
+	if err != nil {
+		return aggregates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		aggregate := entities.ChannelAggregate{Values: map[string]*float64{}}
+		values := make([]*float64, len(query.Functions))
+		pointers := []interface{}{&aggregate.Time}
+		for i := range values {
+			pointers = append(pointers, &values[i])
+		}
+
+		err := rows.Scan(pointers...)
+		if err != nil {
+			return aggregates, err
+		}
+
+		for i, function := range query.Functions {
+			aggregate.Values[function] = values[i]
 		}
-		channels = append(channels, channel)
+		aggregates = append(aggregates, aggregate)
 	}
 	if err := rows.Err(); err != nil {
-		return channels, err
+		return aggregates, err
+	}
+	return aggregates, nil
+}
+
+// GetSensorChannelAggregate use a rollup table when the query allow it and none of the hour in
+// the range is waiting for the rollup worker, otherwise the channel are aggregated directly
+func (u *PostgresSensorRepository) GetSensorChannelAggregate(ctx context.Context, tx helper.Querier, sensorId int, bucket time.Duration, query *entities.ChannelAggregateQuery) (aggregates []entities.ChannelAggregate, err error) {
+	aggregates = []entities.ChannelAggregate{}
+	query.SetDefault()
+
+	table := u.rollupTable(bucket, query)
+	if table != "" {
+		conditions, args := u.rangeCondition("pending", "bucket", sensorId, query.From, query.To)
+		var isPending bool
+		sqlStatement := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM channel_rollup_pending pending WHERE %s)`, strings.Join(conditions, " AND "))
+		err = tx.QueryRow(ctx, sqlStatement, args...).Scan(&isPending)
+		if err != nil {
+			return aggregates, err
+		}
+		if !isPending {
+			return u.getSensorRollupAggregate(ctx, tx, table, sensorId, bucket, query)
+		}
+	}
+
+	conditions, args := u.channelRangeCondition(sensorId, query.From, query.To)
+	args = append(args, bucket.Seconds())
+	bucketSql := fmt.Sprintf("TO_TIMESTAMP(FLOOR(EXTRACT(EPOCH FROM channel.time) / $%d) * $%d) AT TIME ZONE 'utc'", len(args), len(args))
+
+	selectedFunctions := []string{}
+	for _, function := range query.Functions {
+		functionSql, ok := aggregateFunctionSql[function]
+		if !ok {
+			return aggregates, fiber.NewError(400, fmt.Sprintf("Aggregate function %s is not supported", function))
+		}
+
+		if function == "percentile" {
+			args = append(args, query.Percentile)
+			functionSql = fmt.Sprintf(functionSql, len(args))
+		}
+		selectedFunctions = append(selectedFunctions, functionSql)
 	}
-	return channels, nil
+
+	args = append(args, entities.ChannelAggregateMaxBucket)
+	sqlStatement := fmt.Sprintf(`SELECT %s AS bucket, %s FROM "channel" WHERE %s GROUP BY bucket ORDER BY bucket ASC LIMIT $%d`,
+		bucketSql, strings.Join(selectedFunctions, ", "), strings.Join(conditions, " AND "), len(args))
+	return u.scanChannelAggregate(ctx, tx, sqlStatement, args, query)
 }
 
-func (u *SensorRepository) GetIdUserWhoOwnSensorById(ctx context.Context, tx helper.Querier, sensorId int) (userId int, err error) {
-	sqlStatement := `SELECT node.id_user FROM "sensor" INNER JOIN "node" ON node.id_node=sensor.id_node WHERE sensor.id_sensor=$1`
-	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&userId)
+func (u *PostgresSensorRepository) GetSensorOwnerById(ctx context.Context, tx helper.Querier, sensorId int) (owner entities.SensorOwner, err error) {
+	sqlStatement := `SELECT sensor.id_sensor, sensor.id_node, node.id_user, node.id_organization FROM "sensor" INNER JOIN "node" ON node.id_node=sensor.id_node WHERE sensor.id_sensor=$1`
+	err = tx.QueryRow(ctx, sqlStatement, sensorId).Scan(&owner.IdSensor, &owner.IdNode, &owner.IdUser, &owner.IdOrganization)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return userId, fiber.NewError(404, fmt.Sprintf("Sensor with id %d not found", sensorId))
+			return owner, fiber.NewError(404, fmt.Sprintf("Sensor with id %d not found", sensorId))
 		}
-		return userId, err
+		return owner, err
+	}
+	return owner, nil
+}
+
+// GetSensorOwnerByIds get the node, user and organization who own every sensor in sensorIds with a single query.
+// Sensor that doesn't exist will not be present in the returned map.
+func (u *PostgresSensorRepository) GetSensorOwnerByIds(ctx context.Context, tx helper.Querier, sensorIds []int) (owners map[int]entities.SensorOwner, err error) {
+	owners = map[int]entities.SensorOwner{}
+	sqlStatement := `SELECT sensor.id_sensor, sensor.id_node, node.id_user, node.id_organization FROM "sensor" INNER JOIN "node" ON node.id_node=sensor.id_node WHERE sensor.id_sensor=ANY($1)`
+	rows, err := tx.Query(ctx, sqlStatement, sensorIds)
+	if err != nil {
+		return owners, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var owner entities.SensorOwner
+		err := rows.Scan(&owner.IdSensor, &owner.IdNode, &owner.IdUser, &owner.IdOrganization)
+		if err != nil {
+			return owners, err
+		}
+		owners[owner.IdSensor] = owner
+	}
+	if err := rows.Err(); err != nil {
+		return owners, err
 	}
-	return userId, nil
+	return owners, nil
 }
 
-func (u *SensorRepository) Update(ctx context.Context, tx helper.Querier, sensor *entities.Sensor, payload *entities.SensorUpdate) (err error) {
+func (u *PostgresSensorRepository) Update(ctx context.Context, tx helper.Querier, sensor *entities.Sensor, payload *entities.SensorUpdate) (err error) {
 	payload.ChangeSettedFieldOnly(sensor)
 
 	sqlStatement := `
@@ -203,7 +500,7 @@ func (u *SensorRepository) Update(ctx context.Context, tx helper.Querier, sensor
 	return nil
 }
 
-func (u *SensorRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+func (u *PostgresSensorRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
 	sqlStatement := `DELETE FROM "sensor" WHERE id_sensor=$1`
 	res, err := tx.Exec(ctx, sqlStatement, id)
 	if err != nil {
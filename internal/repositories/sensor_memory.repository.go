@@ -0,0 +1,344 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MemorySensorRepository is SensorRepository on the memory database. The aggregate is always computed
+// from the channel, it give the same result as the rollup used by the postgres repository.
+type MemorySensorRepository struct{}
+
+func NewMemorySensorRepository() (MemorySensorRepository, error) {
+	return MemorySensorRepository{}, nil
+}
+
+func (s *MemorySensorRepository) Create(ctx context.Context, tx helper.Querier, payload *entities.SensorCreate) (sensor entities.Sensor, err error) {
+	sensor = entities.Sensor{
+		SensorCreate: *payload,
+	}
+	err = memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.nodes[sensor.IdNode]; !ok {
+			return memoryForeignKeyError("sensor", "id_node", sensor.IdNode)
+		}
+		if _, ok := data.hardwares[sensor.IdHardware]; !ok {
+			return memoryForeignKeyError("sensor", "id_hardware", sensor.IdHardware)
+		}
+
+		sensor.IdSensor = int(data.nextId("sensor"))
+		data.sensors[sensor.IdSensor] = sensor
+		return nil
+	})
+	return sensor, err
+}
+
+func (s *MemorySensorRepository) filter(tx helper.Querier, condition func(data *memoryData, sensor entities.Sensor) bool) (sensors []entities.Sensor, err error) {
+	sensors = []entities.Sensor{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, id := range sortedIds(data.sensors) {
+			if condition(data, data.sensors[id]) {
+				sensors = append(sensors, data.sensors[id])
+			}
+		}
+		return nil
+	})
+	return sensors, err
+}
+
+func (s *MemorySensorRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (sensors []entities.Sensor, err error) {
+	return s.filter(tx, func(data *memoryData, sensor entities.Sensor) bool {
+		return currentUser.IsAdmin || data.memberRole(data.nodes[sensor.IdNode].IdOrganization, currentUser.IdUser) != ""
+	})
+}
+
+// GetUserSensor get every sensor on the nodes of the organizations where the user has one of the roles,
+// even when the user is an admin
+func (s *MemorySensorRepository) GetUserSensor(ctx context.Context, tx helper.Querier, userId int, roles []string) ([]entities.Sensor, error) {
+	return s.filter(tx, func(data *memoryData, sensor entities.Sensor) bool {
+		role := data.memberRole(data.nodes[sensor.IdNode].IdOrganization, userId)
+		for _, allowedRole := range roles {
+			if role == allowedRole {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (s *MemorySensorRepository) GetById(ctx context.Context, tx helper.Querier, id int) (sensor entities.Sensor, err error) {
+	err = memoryRead(tx, func(data *memoryData) error {
+		var ok bool
+		sensor, ok = data.sensors[id]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("Sensor with id %d not found", id))
+		}
+		return nil
+	})
+	return sensor, err
+}
+
+func (s *MemorySensorRepository) GetHardwareSensor(ctx context.Context, tx helper.Querier, hardwareId int) ([]entities.Sensor, error) {
+	return s.filter(tx, func(data *memoryData, sensor entities.Sensor) bool {
+		return sensor.IdHardware == hardwareId
+	})
+}
+
+func (s *MemorySensorRepository) GetNodeSensor(ctx context.Context, tx helper.Querier, nodeId int) ([]entities.Sensor, error) {
+	return s.filter(tx, func(data *memoryData, sensor entities.Sensor) bool {
+		return sensor.IdNode == nodeId
+	})
+}
+
+// channelInRange get the channel of a sensor between from (inclusive) and to (exclusive) ordered by time and id
+func (s *MemorySensorRepository) channelInRange(data *memoryData, sensorId int, from *entities.ChannelTime, to *entities.ChannelTime) []entities.Channel {
+	channels := []entities.Channel{}
+	for _, channel := range data.channels {
+		if channel.IdSensor != sensorId {
+			continue
+		}
+		if from != nil && channel.Time.Before(from.Time) {
+			continue
+		}
+		if to != nil && !channel.Time.Before(to.Time) {
+			continue
+		}
+		channels = append(channels, channel)
+	}
+
+	sort.SliceStable(channels, func(i, j int) bool {
+		if !channels[i].Time.Equal(channels[j].Time) {
+			return channels[i].Time.Before(channels[j].Time)
+		}
+		return channels[i].IdChannel < channels[j].IdChannel
+	})
+	return channels
+}
+
+func (s *MemorySensorRepository) GetSensorChannel(ctx context.Context, tx helper.Querier, sensorId int, query *entities.ChannelQuery) (page entities.ChannelPage, err error) {
+	page = entities.ChannelPage{Channel: []entities.Channel{}}
+	query.SetDefault()
+
+	var cursorTime time.Time
+	var cursorId int64
+	if query.Cursor != "" {
+		cursorTime, cursorId, err = decodeChannelCursor(query.Cursor)
+		if err != nil {
+			return page, err
+		}
+	}
+
+	var channels []entities.Channel
+	err = memoryRead(tx, func(data *memoryData) error {
+		channels = s.channelInRange(data, sensorId, query.From, query.To)
+		return nil
+	})
+	if err != nil {
+		return page, err
+	}
+
+	if query.Order == "desc" {
+		for i, j := 0, len(channels)-1; i < j; i, j = i+1, j-1 {
+			channels[i], channels[j] = channels[j], channels[i]
+		}
+	}
+
+	for _, channel := range channels {
+		if query.Cursor != "" {
+			isAfter := channel.Time.After(cursorTime) || (channel.Time.Equal(cursorTime) && channel.IdChannel > cursorId)
+			isBefore := channel.Time.Before(cursorTime) || (channel.Time.Equal(cursorTime) && channel.IdChannel < cursorId)
+			if (query.Order == "desc" && !isBefore) || (query.Order != "desc" && !isAfter) {
+				continue
+			}
+		}
+
+		if len(page.Channel) == query.Limit {
+			page.NextCursor = encodeChannelCursor(page.Channel[len(page.Channel)-1])
+			break
+		}
+		page.Channel = append(page.Channel, channel)
+	}
+	return page, nil
+}
+
+func (s *MemorySensorRepository) GetSensorChannelSpan(ctx context.Context, tx helper.Querier, sensorId int, from *entities.ChannelTime, to *entities.ChannelTime) (span entities.ChannelSpan, err error) {
+	err = memoryRead(tx, func(data *memoryData) error {
+		channels := s.channelInRange(data, sensorId, from, to)
+		span.Count = int64(len(channels))
+		if len(channels) > 0 {
+			first := channels[0].Time
+			last := channels[len(channels)-1].Time
+			span.From = &first
+			span.To = &last
+		}
+		return nil
+	})
+	return span, err
+}
+
+// aggregate compute a single aggregate function on the values of a bucket ordered by time, nil is returned
+// where postgres return null
+func (s *MemorySensorRepository) aggregate(function string, values []float64, percentile float64) *float64 {
+	result := func(value float64) *float64 {
+		return &value
+	}
+
+	count := float64(len(values))
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+
+	switch function {
+	case "min", "max":
+		extreme := values[0]
+		for _, value := range values {
+			if (function == "min" && value < extreme) || (function == "max" && value > extreme) {
+				extreme = value
+			}
+		}
+		return result(extreme)
+	case "avg":
+		return result(sum / count)
+	case "sum":
+		return result(sum)
+	case "count":
+		return result(count)
+	case "first":
+		return result(values[0])
+	case "last":
+		return result(values[len(values)-1])
+	case "stddev":
+		if len(values) < 2 {
+			return nil
+		}
+		mean := sum / count
+		variance := 0.0
+		for _, value := range values {
+			variance += (value - mean) * (value - mean)
+		}
+		return result(math.Sqrt(variance / (count - 1)))
+	case "percentile":
+		sorted := append([]float64{}, values...)
+		sort.Float64s(sorted)
+		position := percentile * (count - 1)
+		lower := int(math.Floor(position))
+		upper := int(math.Ceil(position))
+		return result(sorted[lower] + (sorted[upper]-sorted[lower])*(position-float64(lower)))
+	}
+	return nil
+}
+
+func (s *MemorySensorRepository) GetSensorChannelAggregate(ctx context.Context, tx helper.Querier, sensorId int, bucket time.Duration, query *entities.ChannelAggregateQuery) (aggregates []entities.ChannelAggregate, err error) {
+	aggregates = []entities.ChannelAggregate{}
+	query.SetDefault()
+
+	for _, function := range query.Functions {
+		if _, ok := aggregateFunctionSql[function]; !ok {
+			return aggregates, fiber.NewError(400, fmt.Sprintf("Aggregate function %s is not supported", function))
+		}
+	}
+
+	var channels []entities.Channel
+	err = memoryRead(tx, func(data *memoryData) error {
+		channels = s.channelInRange(data, sensorId, query.From, query.To)
+		return nil
+	})
+	if err != nil {
+		return aggregates, err
+	}
+
+	// Channel are ordered by time so every bucket is contiguous
+	buckets := []time.Time{}
+	valuesByBucket := map[time.Time][]float64{}
+	for _, channel := range channels {
+		start := channel.Time.UnixNano() / int64(bucket)
+		if channel.Time.UnixNano()%int64(bucket) < 0 {
+			start--
+		}
+		bucketTime := time.Unix(0, start*int64(bucket)).UTC()
+		if _, ok := valuesByBucket[bucketTime]; !ok {
+			if len(buckets) == entities.ChannelAggregateMaxBucket {
+				break
+			}
+			buckets = append(buckets, bucketTime)
+		}
+		valuesByBucket[bucketTime] = append(valuesByBucket[bucketTime], channel.Value)
+	}
+
+	for _, bucketTime := range buckets {
+		aggregate := entities.ChannelAggregate{Time: bucketTime, Values: map[string]*float64{}}
+		for _, function := range query.Functions {
+			aggregate.Values[function] = s.aggregate(function, valuesByBucket[bucketTime], query.Percentile)
+		}
+		aggregates = append(aggregates, aggregate)
+	}
+	return aggregates, nil
+}
+
+func (s *MemorySensorRepository) GetSensorOwnerById(ctx context.Context, tx helper.Querier, sensorId int) (owner entities.SensorOwner, err error) {
+	owners, err := s.GetSensorOwnerByIds(ctx, tx, []int{sensorId})
+	if err != nil {
+		return owner, err
+	}
+
+	owner, ok := owners[sensorId]
+	if !ok {
+		return owner, fiber.NewError(404, fmt.Sprintf("Sensor with id %d not found", sensorId))
+	}
+	return owner, nil
+}
+
+// GetSensorOwnerByIds get the node, user and organization who own every sensor in sensorIds.
+// Sensor that doesn't exist will not be present in the returned map.
+func (s *MemorySensorRepository) GetSensorOwnerByIds(ctx context.Context, tx helper.Querier, sensorIds []int) (owners map[int]entities.SensorOwner, err error) {
+	owners = map[int]entities.SensorOwner{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, idSensor := range sensorIds {
+			sensor, ok := data.sensors[idSensor]
+			if !ok {
+				continue
+			}
+			node := data.nodes[sensor.IdNode]
+			owners[idSensor] = entities.SensorOwner{
+				IdSensor:       idSensor,
+				IdNode:         sensor.IdNode,
+				IdUser:         node.IdUser,
+				IdOrganization: node.IdOrganization,
+			}
+		}
+		return nil
+	})
+	return owners, err
+}
+
+func (s *MemorySensorRepository) Update(ctx context.Context, tx helper.Querier, sensor *entities.Sensor, payload *entities.SensorUpdate) (err error) {
+	payload.ChangeSettedFieldOnly(sensor)
+
+	return memoryWrite(tx, func(data *memoryData) error {
+		stored, ok := data.sensors[sensor.IdSensor]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on update sensor with id %d", sensor.IdSensor))
+		}
+		stored.Name = payload.Name
+		stored.Unit = payload.Unit
+		data.sensors[sensor.IdSensor] = stored
+		return nil
+	})
+}
+
+func (s *MemorySensorRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.sensors[id]; !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+		}
+		data.deleteSensor(id)
+		return nil
+	})
+}
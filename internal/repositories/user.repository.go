@@ -2,9 +2,9 @@ package repositories
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
+	"log"
+	"time"
 
 	"github.com/dafaath/iot-server/configs"
 	"github.com/dafaath/iot-server/internal/entities"
@@ -15,12 +15,12 @@ import (
 	"gopkg.in/gomail.v2"
 )
 
-type UserRepository struct {
+type PostgresUserRepository struct {
 	mailDialer *gomail.Dialer
 }
 
-func (u *UserRepository) Create(ctx context.Context, tx helper.Querier, payload entities.UserCreate) (user entities.UserRead, err error) {
-	hashedPassword, err := u.hashPassword(context.Background(), payload.Password)
+func (u *PostgresUserRepository) Create(ctx context.Context, tx helper.Querier, payload entities.UserCreate) (user entities.UserRead, err error) {
+	hashedPassword, err := helper.HashPassword(payload.Password)
 	if err != nil {
 		return user, err
 	}
@@ -54,7 +54,7 @@ func (u *UserRepository) Create(ctx context.Context, tx helper.Querier, payload
 	return user, nil
 }
 
-func (u *UserRepository) GetAll(ctx context.Context, tx helper.Querier) (users []entities.UserRead, err error) {
+func (u *PostgresUserRepository) GetAll(ctx context.Context, tx helper.Querier) (users []entities.UserRead, err error) {
 	users = []entities.UserRead{}
 	sqlStatement := `SELECT id_user, email, username,  status, token,  isadmin FROM user_person`
 	rows, err := tx.Query(ctx, sqlStatement)
@@ -84,7 +84,7 @@ func (u *UserRepository) GetAll(ctx context.Context, tx helper.Querier) (users [
 	return users, nil
 }
 
-func (u *UserRepository) GetById(ctx context.Context, tx helper.Querier, id int) (user entities.UserRead, err error) {
+func (u *PostgresUserRepository) GetById(ctx context.Context, tx helper.Querier, id int) (user entities.UserRead, err error) {
 	sqlStatement := `SELECT id_user, email, username, status, token, isadmin FROM user_person WHERE id_user=$1`
 	err = tx.QueryRow(ctx, sqlStatement, id).Scan(
 		&user.IdUser,
@@ -103,8 +103,8 @@ func (u *UserRepository) GetById(ctx context.Context, tx helper.Querier, id int)
 	return user, nil
 }
 
-func (u *UserRepository) UpdatePassword(ctx context.Context, tx helper.Querier, id int, password string) (err error) {
-	hashPassword, err := u.hashPassword(ctx, password)
+func (u *PostgresUserRepository) UpdatePassword(ctx context.Context, tx helper.Querier, id int, password string) (err error) {
+	hashPassword, err := helper.HashPassword(password)
 	if err != nil {
 		return err
 	}
@@ -124,7 +124,7 @@ func (u *UserRepository) UpdatePassword(ctx context.Context, tx helper.Querier,
 	return nil
 }
 
-func (u *UserRepository) UpdateStatus(ctx context.Context, tx helper.Querier, id int, status bool) (err error) {
+func (u *PostgresUserRepository) UpdateStatus(ctx context.Context, tx helper.Querier, id int, status bool) (err error) {
 	sqlStatement := `
 	UPDATE user_person 
 	set status=$1 
@@ -140,7 +140,40 @@ func (u *UserRepository) UpdateStatus(ctx context.Context, tx helper.Querier, id
 	return nil
 }
 
-func (u *UserRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+func (u *PostgresUserRepository) UpdateIsAdmin(ctx context.Context, tx helper.Querier, id int, isAdmin bool) (err error) {
+	sqlStatement := `
+	UPDATE user_person 
+	set isadmin=$1 
+	WHERE id_user=$2`
+	res, err := tx.Exec(ctx, sqlStatement, isAdmin, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update user admin with id %d", id))
+	}
+	return nil
+}
+
+// UpdateRetention set how many days the channel of the user are kept, nil remove the user retention
+func (u *PostgresUserRepository) UpdateRetention(ctx context.Context, tx helper.Querier, id int, retentionDays *int) (err error) {
+	sqlStatement := `
+	UPDATE user_person 
+	set retention_days=$1 
+	WHERE id_user=$2`
+	res, err := tx.Exec(ctx, sqlStatement, retentionDays, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update user retention with id %d", id))
+	}
+	return nil
+}
+
+func (u *PostgresUserRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
 	sqlStatement := `DELETE FROM user_person WHERE id_user=$1`
 	res, err := tx.Exec(ctx, sqlStatement, id)
 	if err != nil {
@@ -153,7 +186,7 @@ func (u *UserRepository) Delete(ctx context.Context, tx helper.Querier, id int)
 	return nil
 }
 
-func (u *UserRepository) GetByEmail(ctx context.Context, tx helper.Querier, email string) (user entities.UserRead, err error) {
+func (u *PostgresUserRepository) GetByEmail(ctx context.Context, tx helper.Querier, email string) (user entities.UserRead, err error) {
 	sqlStatement := `SELECT id_user, email, username,  status, token,  isAdmin FROM user_person WHERE email=$1`
 	err = tx.QueryRow(ctx, sqlStatement, email).Scan(
 		&user.IdUser,
@@ -172,7 +205,7 @@ func (u *UserRepository) GetByEmail(ctx context.Context, tx helper.Querier, emai
 	return user, nil
 }
 
-func (u *UserRepository) GetByUsername(ctx context.Context, tx helper.Querier, username string) (user entities.UserRead, err error) {
+func (u *PostgresUserRepository) GetByUsername(ctx context.Context, tx helper.Querier, username string) (user entities.UserRead, err error) {
 	sqlStatement := `SELECT id_user, email, username,  status, token,  isAdmin FROM user_person WHERE username=$1`
 	err = tx.QueryRow(ctx, sqlStatement, username).Scan(
 		&user.IdUser,
@@ -191,7 +224,7 @@ func (u *UserRepository) GetByUsername(ctx context.Context, tx helper.Querier, u
 	return user, nil
 }
 
-func (u *UserRepository) GetByToken(ctx context.Context, tx helper.Querier, token string) (user entities.UserRead, err error) {
+func (u *PostgresUserRepository) GetByToken(ctx context.Context, tx helper.Querier, token string) (user entities.UserRead, err error) {
 	sqlStatement := `SELECT id_user, email, username,  status, token,  isAdmin FROM user_person WHERE token=$1`
 	err = tx.QueryRow(ctx, sqlStatement, token).Scan(
 		&user.IdUser,
@@ -210,7 +243,7 @@ func (u *UserRepository) GetByToken(ctx context.Context, tx helper.Querier, toke
 	return user, nil
 }
 
-func (u *UserRepository) MatchPassword(ctx context.Context, tx helper.Querier, user entities.UserRead, password string) (err error) {
+func (u *PostgresUserRepository) MatchPassword(ctx context.Context, tx helper.Querier, user entities.UserRead, password string) (err error) {
 	var userPassword string
 	sqlStatement := `SELECT password FROM user_person WHERE id_user=$1`
 	err = tx.QueryRow(ctx, sqlStatement, user.IdUser).Scan(
@@ -220,53 +253,34 @@ func (u *UserRepository) MatchPassword(ctx context.Context, tx helper.Querier, u
 		return err
 	}
 
-	passwordHashString, err := u.hashPassword(ctx, password)
+	match, needRehash, err := helper.CheckPassword(userPassword, password)
 	if err != nil {
 		return err
 	}
 
-	if userPassword != passwordHashString {
+	if !match {
 		return fiber.NewError(401, "Wrong password")
 	}
 
-	return err
-}
-
-func (u *UserRepository) hashPassword(ctx context.Context, password string) (hashedPassword string, err error) {
-	hasher := sha256.New()
-	_, err = hasher.Write([]byte(password))
-	if err != nil {
-		return "", err
-	}
-
-	passwordHashBytes := hasher.Sum(nil)
-	passwordHashString := hex.EncodeToString(passwordHashBytes)
-	return passwordHashString, nil
-}
-
-func (u *UserRepository) SendEmail(ctx context.Context, to string, subject string, body string) (err error) {
-	configs := configs.GetConfig()
-
-	mailer := gomail.NewMessage()
-	mailer.SetHeader("From", configs.Mail.SenderName)
-	mailer.SetHeader("To", to)
-	mailer.SetHeader("Subject", subject)
-	mailer.SetBody("text/html", body)
-
-	err = u.mailDialer.DialAndSend(mailer)
-	if err != nil {
-		return err
+	// Upgrade legacy or outdated hash now that the plain password is known,
+	// failing here should not block the login
+	if needRehash {
+		rehashErr := u.UpdatePassword(ctx, tx, user.IdUser, password)
+		if rehashErr != nil {
+			log.Printf("Failed to rehash password of user %d, %v", user.IdUser, rehashErr)
+		}
 	}
 
 	return nil
 }
 
-func (u *UserRepository) SendEmailActivation(ctx context.Context, user entities.UserRead) (err error) {
+// activationEmail build the subject and body of the email sent after the registration
+func activationEmail(user entities.UserRead) (subject string, body string) {
 	configs := configs.GetConfig()
 
 	urlCode := fmt.Sprintf("http://%s:%d/user/activation?token=%s", configs.Server.Host, configs.Server.Port, user.Token)
-	subject := "Registration Email"
-	body := fmt.Sprintf(`<html>              
+	subject = "Registration Email"
+	body = fmt.Sprintf(`<html>              
 			<head>>
 				<title>Activation Message</title>
 			</head>
@@ -284,28 +298,94 @@ func (u *UserRepository) SendEmailActivation(ctx context.Context, user entities.
 			</body>
     </html> `, user.Username, user.IdUser, user.Username, urlCode)
 
-	err = u.SendEmail(ctx, user.Email, subject, body)
-	if err != nil {
-		return err
+	return subject, body
+}
+
+// forgotPasswordEmail build the subject and body of the email containing the reset password link
+func forgotPasswordEmail(user entities.UserRead, resetToken string) (subject string, body string) {
+	configs := configs.GetConfig()
+
+	urlCode := fmt.Sprintf("http://%s:%d/user/reset-password?token=%s", configs.Server.Host, configs.Server.Port, resetToken)
+	subject = "Forgot Password Email"
+	body = fmt.Sprintf(`<html>
+		  <head>
+		  </head>
+		  <body>
+			<h3>Dear %s. </h3>
+			<p>We have accepted your forget password request. Click <a href=%s>here</a> to set a new password.</p>
+			<p>The link expires in %s and can only be used once. Ignore this email if you didn't request it, your password is not changed.</p>
+			<p>Thank You</p>
+		  </body
+		</html>`, user.Username, urlCode, configs.Account.PasswordResetTTL)
+
+	return subject, body
+}
+
+// alertEmail build the subject and body of the email sent when an alert fire or is resolved
+func alertEmail(notification entities.AlertNotification) (subject string, body string) {
+	configs := configs.GetConfig()
+
+	urlCode := fmt.Sprintf("http://%s:%d/alert/%d", configs.Server.Host, configs.Server.Port, notification.IdAlertRule)
+	status := "is firing"
+	if notification.Event.Kind == entities.AlertEventResolved {
+		status = "is resolved"
 	}
+	subject = fmt.Sprintf("[Alert] %s %s", notification.Name, status)
+	body = fmt.Sprintf(`<html>
+		  <head>
+		  </head>
+		  <body>
+			<h3>Dear %s. </h3>
+			<p>Alert <b>%s</b> on sensor %s %s.</p>
+			<p>Value %g %s at %s</p>
+			<p>Click <a href=%s>here</a> to see the alert</p>
+			<p>Thank You</p>
+		  </body
+		</html>`, notification.Username, notification.Name, notification.SensorName, status,
+		notification.Event.Value, notification.Unit, notification.Event.Time.Format(time.RFC3339), urlCode)
 
-	return nil
+	return subject, body
 }
 
-func (u *UserRepository) SendEmailForgotPassword(ctx context.Context, user entities.UserRead, newPassword string) (err error) {
-	subject := "Forgot Password Email"
-	body := fmt.Sprintf(`<html>
+// nodeStatusEmail build the subject and body of the email sent when a node go offline or come back online
+func nodeStatusEmail(notification entities.NodeStatusNotification) (subject string, body string) {
+	configs := configs.GetConfig()
+
+	urlCode := fmt.Sprintf("http://%s:%d/node/%d", configs.Server.Host, configs.Server.Port, notification.IdNode)
+	status := "is offline"
+	if notification.Status != entities.NodeStatusOffline {
+		status = "is back online"
+	}
+	lastSeen := "never"
+	if notification.LastSeen != nil {
+		lastSeen = notification.LastSeen.Format(time.RFC3339)
+	}
+	subject = fmt.Sprintf("[Node] %s %s", notification.Name, status)
+	body = fmt.Sprintf(`<html>
 		  <head>
 		  </head>
 		  <body>
 			<h3>Dear %s. </h3>
-			<p>We have accepted your forget password request. Use this password for log in.</p>
-			<p><h4>%s</h4></p>
+			<p>Node <b>%s</b> at %s %s.</p>
+			<p>Last seen at %s</p>
+			<p>Click <a href=%s>here</a> to see the node</p>
 			<p>Thank You</p>
 		  </body
-		</html>`, user.Username, newPassword)
+		</html>`, notification.Username, notification.Name, notification.Location, status, lastSeen, urlCode)
+
+	return subject, body
+}
+
+func (u *PostgresUserRepository) SendEmail(ctx context.Context, to string, subject string, body string) (err error) {
+	configs := configs.GetConfig()
+
+	mailer := gomail.NewMessage()
+	mailer.SetHeader("From", configs.Mail.SenderName)
+	mailer.SetHeader("To", to)
+	mailer.SetHeader("Subject", subject)
+	mailer.SetBody("text/html", body)
 
-	err = u.SendEmail(ctx, user.Email, subject, body)
+	err = u.mailDialer.DialAndSend(mailer)
 	if err != nil {
 		return err
 	}
@@ -313,12 +393,32 @@ func (u *UserRepository) SendEmailForgotPassword(ctx context.Context, user entit
 	return nil
 }
 
-func (u *UserRepository) SignJWT(ctx context.Context, user entities.UserRead) (token string, err error) {
+func (u *PostgresUserRepository) SendEmailActivation(ctx context.Context, user entities.UserRead) (err error) {
+	subject, body := activationEmail(user)
+	return u.SendEmail(ctx, user.Email, subject, body)
+}
+
+func (u *PostgresUserRepository) SendEmailForgotPassword(ctx context.Context, user entities.UserRead, resetToken string) (err error) {
+	subject, body := forgotPasswordEmail(user, resetToken)
+	return u.SendEmail(ctx, user.Email, subject, body)
+}
+
+func (u *PostgresUserRepository) SendEmailAlert(ctx context.Context, notification entities.AlertNotification) (err error) {
+	subject, body := alertEmail(notification)
+	return u.SendEmail(ctx, notification.Email, subject, body)
+}
+
+func (u *PostgresUserRepository) SendEmailNodeStatus(ctx context.Context, notification entities.NodeStatusNotification) (err error) {
+	subject, body := nodeStatusEmail(notification)
+	return u.SendEmail(ctx, notification.Email, subject, body)
+}
+
+func (u *PostgresUserRepository) SignJWT(ctx context.Context, user entities.UserRead) (token string, err error) {
 	return helper.SignUserToken(user)
 }
 
-func NewUserRepository(mailDialer *gomail.Dialer) (UserRepository, error) {
-	return UserRepository{
+func NewUserRepository(mailDialer *gomail.Dialer) (PostgresUserRepository, error) {
+	return PostgresUserRepository{
 		mailDialer: mailDialer,
 	}, nil
 }
@@ -0,0 +1,247 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// MemoryMail is an email that the memory user repository would have sent
+type MemoryMail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type memoryMailbox struct {
+	mutex sync.Mutex
+	mails []MemoryMail
+}
+
+// MemoryUserRepository is UserRepository on the memory database, the emails are logged and kept
+// in a mailbox instead of being sent
+type MemoryUserRepository struct {
+	mailbox *memoryMailbox
+}
+
+func NewMemoryUserRepository() (MemoryUserRepository, error) {
+	return MemoryUserRepository{
+		mailbox: &memoryMailbox{mails: []MemoryMail{}},
+	}, nil
+}
+
+func (u *MemoryUserRepository) Create(ctx context.Context, tx helper.Querier, payload entities.UserCreate) (user entities.UserRead, err error) {
+	hashedPassword, err := helper.HashPassword(payload.Password)
+	if err != nil {
+		return user, err
+	}
+
+	user = entities.UserRead{
+		Email:    payload.Email,
+		Username: payload.Username,
+		Status:   false,
+		Token:    uuid.New().String(),
+		IsAdmin:  false,
+	}
+	err = memoryWrite(tx, func(data *memoryData) error {
+		for _, stored := range data.users {
+			if stored.Email == user.Email {
+				return memoryUniqueError("user_person", "email", user.Email)
+			}
+			if stored.Username == user.Username {
+				return memoryUniqueError("user_person", "username", user.Username)
+			}
+		}
+
+		user.IdUser = int(data.nextId("user_person"))
+		data.users[user.IdUser] = memoryUser{UserRead: user, Password: hashedPassword}
+		return nil
+	})
+	return user, err
+}
+
+func (u *MemoryUserRepository) GetAll(ctx context.Context, tx helper.Querier) (users []entities.UserRead, err error) {
+	users = []entities.UserRead{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, id := range sortedIds(data.users) {
+			users = append(users, data.users[id].UserRead)
+		}
+		return nil
+	})
+	return users, err
+}
+
+func (u *MemoryUserRepository) GetById(ctx context.Context, tx helper.Querier, id int) (user entities.UserRead, err error) {
+	err = memoryRead(tx, func(data *memoryData) error {
+		stored, ok := data.users[id]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("User with id %d not found", id))
+		}
+		user = stored.UserRead
+		return nil
+	})
+	return user, err
+}
+
+// update run change on the user with the id, notFound is the message when the user doesn't exist
+func (u *MemoryUserRepository) update(tx helper.Querier, id int, notFound string, change func(user *memoryUser)) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		stored, ok := data.users[id]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on update %s with id %d", notFound, id))
+		}
+		change(&stored)
+		data.users[id] = stored
+		return nil
+	})
+}
+
+func (u *MemoryUserRepository) UpdatePassword(ctx context.Context, tx helper.Querier, id int, password string) (err error) {
+	hashPassword, err := helper.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return u.update(tx, id, "user password", func(user *memoryUser) {
+		user.Password = hashPassword
+	})
+}
+
+func (u *MemoryUserRepository) UpdateStatus(ctx context.Context, tx helper.Querier, id int, status bool) (err error) {
+	return u.update(tx, id, "user status", func(user *memoryUser) {
+		user.Status = status
+	})
+}
+
+func (u *MemoryUserRepository) UpdateIsAdmin(ctx context.Context, tx helper.Querier, id int, isAdmin bool) (err error) {
+	return u.update(tx, id, "user admin", func(user *memoryUser) {
+		user.IsAdmin = isAdmin
+	})
+}
+
+func (u *MemoryUserRepository) UpdateRetention(ctx context.Context, tx helper.Querier, id int, retentionDays *int) (err error) {
+	return u.update(tx, id, "user retention", func(user *memoryUser) {
+		user.RetentionDays = retentionDays
+	})
+}
+
+func (u *MemoryUserRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.users[id]; !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+		}
+		data.deleteUser(id)
+		return nil
+	})
+}
+
+// find get the first user matching the condition, notFound is the message when there is none
+func (u *MemoryUserRepository) find(tx helper.Querier, notFound string, condition func(user memoryUser) bool) (user entities.UserRead, err error) {
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, id := range sortedIds(data.users) {
+			if condition(data.users[id]) {
+				user = data.users[id].UserRead
+				return nil
+			}
+		}
+		return fiber.NewError(404, notFound)
+	})
+	return user, err
+}
+
+func (u *MemoryUserRepository) GetByEmail(ctx context.Context, tx helper.Querier, email string) (user entities.UserRead, err error) {
+	return u.find(tx, fmt.Sprintf("User with email %s not found", email), func(user memoryUser) bool {
+		return user.Email == email
+	})
+}
+
+func (u *MemoryUserRepository) GetByUsername(ctx context.Context, tx helper.Querier, username string) (user entities.UserRead, err error) {
+	return u.find(tx, fmt.Sprintf("User with username %s not found", username), func(user memoryUser) bool {
+		return user.Username == username
+	})
+}
+
+func (u *MemoryUserRepository) GetByToken(ctx context.Context, tx helper.Querier, token string) (user entities.UserRead, err error) {
+	return u.find(tx, fmt.Sprintf("User with token %s not found", token), func(user memoryUser) bool {
+		return user.Token == token
+	})
+}
+
+func (u *MemoryUserRepository) MatchPassword(ctx context.Context, tx helper.Querier, user entities.UserRead, password string) (err error) {
+	var userPassword string
+	err = memoryRead(tx, func(data *memoryData) error {
+		stored, ok := data.users[user.IdUser]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("User with id %d not found", user.IdUser))
+		}
+		userPassword = stored.Password
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	match, needRehash, err := helper.CheckPassword(userPassword, password)
+	if err != nil {
+		return err
+	}
+
+	if !match {
+		return fiber.NewError(401, "Wrong password")
+	}
+
+	if needRehash {
+		rehashErr := u.UpdatePassword(ctx, tx, user.IdUser, password)
+		if rehashErr != nil {
+			log.Printf("Failed to rehash password of user %d, %v", user.IdUser, rehashErr)
+		}
+	}
+
+	return nil
+}
+
+func (u *MemoryUserRepository) SendEmail(ctx context.Context, to string, subject string, body string) (err error) {
+	log.Printf("[MAIL] %s to %s", subject, to)
+
+	u.mailbox.mutex.Lock()
+	defer u.mailbox.mutex.Unlock()
+	u.mailbox.mails = append(u.mailbox.mails, MemoryMail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// SentMails return every email sent so far, oldest first
+func (u *MemoryUserRepository) SentMails() []MemoryMail {
+	u.mailbox.mutex.Lock()
+	defer u.mailbox.mutex.Unlock()
+	return append([]MemoryMail{}, u.mailbox.mails...)
+}
+
+func (u *MemoryUserRepository) SendEmailActivation(ctx context.Context, user entities.UserRead) (err error) {
+	subject, body := activationEmail(user)
+	return u.SendEmail(ctx, user.Email, subject, body)
+}
+
+func (u *MemoryUserRepository) SendEmailForgotPassword(ctx context.Context, user entities.UserRead, resetToken string) (err error) {
+	subject, body := forgotPasswordEmail(user, resetToken)
+	return u.SendEmail(ctx, user.Email, subject, body)
+}
+
+func (u *MemoryUserRepository) SendEmailAlert(ctx context.Context, notification entities.AlertNotification) (err error) {
+	subject, body := alertEmail(notification)
+	return u.SendEmail(ctx, notification.Email, subject, body)
+}
+
+func (u *MemoryUserRepository) SendEmailNodeStatus(ctx context.Context, notification entities.NodeStatusNotification) (err error) {
+	subject, body := nodeStatusEmail(notification)
+	return u.SendEmail(ctx, notification.Email, subject, body)
+}
+
+func (u *MemoryUserRepository) SignJWT(ctx context.Context, user entities.UserRead) (token string, err error) {
+	return helper.SignUserToken(user)
+}
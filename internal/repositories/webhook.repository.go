@@ -0,0 +1,259 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+)
+
+// webhookDeliveryLimit is the number of latest delivery shown on the webhook detail
+const webhookDeliveryLimit = 100
+
+type PostgresWebhookRepository struct{}
+
+func NewWebhookRepository() (PostgresWebhookRepository, error) {
+	return PostgresWebhookRepository{}, nil
+}
+
+func (w *PostgresWebhookRepository) webhookField() string {
+	return "id_webhook, id_user, url, event_types, enabled, created_at"
+}
+
+func (w *PostgresWebhookRepository) webhookPointer(webhook *entities.Webhook) []interface{} {
+	return []interface{}{&webhook.IdWebhook, &webhook.IdUser, &webhook.Url, &webhook.EventTypes, &webhook.Enabled, &webhook.CreatedAt}
+}
+
+func (w *PostgresWebhookRepository) webhookDeliveryField() string {
+	return "webhook_delivery.id_webhook_delivery, webhook_delivery.id_webhook, webhook_delivery.event_type, webhook_delivery.status, webhook_delivery.attempt, webhook_delivery.next_attempt_at, webhook_delivery.last_status_code, webhook_delivery.last_error, webhook_delivery.created_at, webhook_delivery.delivered_at"
+}
+
+func (w *PostgresWebhookRepository) webhookDeliveryPointer(delivery *entities.WebhookDelivery) []interface{} {
+	return []interface{}{
+		&delivery.IdWebhookDelivery,
+		&delivery.IdWebhook,
+		&delivery.EventType,
+		&delivery.Status,
+		&delivery.Attempt,
+		&delivery.NextAttemptAt,
+		&delivery.LastStatusCode,
+		&delivery.LastError,
+		&delivery.CreatedAt,
+		&delivery.DeliveredAt,
+	}
+}
+
+func (w *PostgresWebhookRepository) Create(ctx context.Context, tx helper.Querier, idUser int, payload *entities.WebhookCreate) (webhook entities.WebhookCreated, err error) {
+	secret := payload.Secret
+	if secret == "" {
+		secret, err = helper.GenerateSecureToken(32)
+		if err != nil {
+			return webhook, err
+		}
+	}
+
+	sqlStatement := fmt.Sprintf(`
+	INSERT INTO webhook (
+		id_user,
+		url,
+		secret,
+		event_types
+	)
+	VALUES ($1, $2, $3, $4) RETURNING %s`, w.webhookField())
+	err = tx.QueryRow(ctx, sqlStatement, idUser, payload.Url, secret, payload.EventTypes).Scan(w.webhookPointer(&webhook.Webhook)...)
+	if err != nil {
+		return webhook, err
+	}
+
+	webhook.Secret = secret
+	return webhook, nil
+}
+
+func (w *PostgresWebhookRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (webhooks []entities.Webhook, err error) {
+	webhooks = []entities.Webhook{}
+	var rows pgx.Rows
+	if currentUser.IsAdmin {
+		sqlStatement := fmt.Sprintf(`SELECT %s FROM webhook ORDER BY id_webhook`, w.webhookField())
+		rows, err = tx.Query(ctx, sqlStatement)
+	} else {
+		sqlStatement := fmt.Sprintf(`SELECT %s FROM webhook WHERE id_user=$1 ORDER BY id_webhook`, w.webhookField())
+		rows, err = tx.Query(ctx, sqlStatement, currentUser.IdUser)
+	}
+	if err != nil {
+		return webhooks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var webhook entities.Webhook
+		err := rows.Scan(w.webhookPointer(&webhook)...)
+		if err != nil {
+			return webhooks, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return webhooks, err
+	}
+	return webhooks, nil
+}
+
+func (w *PostgresWebhookRepository) GetById(ctx context.Context, tx helper.Querier, id int) (webhook entities.Webhook, err error) {
+	sqlStatement := fmt.Sprintf(`SELECT %s FROM webhook WHERE id_webhook=$1`, w.webhookField())
+	err = tx.QueryRow(ctx, sqlStatement, id).Scan(w.webhookPointer(&webhook)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return webhook, fiber.NewError(404, fmt.Sprintf("Webhook with id %d not found", id))
+		}
+		return webhook, err
+	}
+	return webhook, nil
+}
+
+func (w *PostgresWebhookRepository) Update(ctx context.Context, tx helper.Querier, webhook *entities.Webhook, payload *entities.WebhookUpdate) (err error) {
+	payload.ChangeSettedFieldOnly(webhook)
+
+	sqlStatement := `
+	UPDATE webhook
+	SET url=$1, event_types=$2, enabled=$3
+	WHERE id_webhook=$4`
+	res, err := tx.Exec(ctx, sqlStatement, payload.Url, payload.EventTypes, *payload.Enabled, webhook.IdWebhook)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on update webhook with id %d", webhook.IdWebhook))
+	}
+	return nil
+}
+
+func (w *PostgresWebhookRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	sqlStatement := `DELETE FROM webhook WHERE id_webhook=$1`
+	res, err := tx.Exec(ctx, sqlStatement, id)
+	if err != nil {
+		return err
+	}
+	count := res.RowsAffected()
+	if count == 0 {
+		return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+	}
+	return nil
+}
+
+// GetDeliveries get the latest delivery of the webhook
+func (w *PostgresWebhookRepository) GetDeliveries(ctx context.Context, tx helper.Querier, idWebhook int) (deliveries []entities.WebhookDelivery, err error) {
+	deliveries = []entities.WebhookDelivery{}
+	sqlStatement := fmt.Sprintf(`
+	SELECT %s FROM webhook_delivery
+	WHERE id_webhook=$1
+	ORDER BY id_webhook_delivery DESC
+	LIMIT $2`, w.webhookDeliveryField())
+	rows, err := tx.Query(ctx, sqlStatement, idWebhook, webhookDeliveryLimit)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var delivery entities.WebhookDelivery
+		err := rows.Scan(w.webhookDeliveryPointer(&delivery)...)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return deliveries, err
+	}
+	return deliveries, nil
+}
+
+// Enqueue add a pending delivery for every enabled webhook subscribed to the event whose user is a member
+// of the organization. When idOrganization is nil the event is sent to the webhook of every user, used for
+// shared data like hardware.
+func (w *PostgresWebhookRepository) Enqueue(ctx context.Context, tx helper.Querier, idOrganization *int, eventType string, payload []byte) (count int64, err error) {
+	sqlStatement := `
+	INSERT INTO webhook_delivery (
+		id_webhook,
+		event_type,
+		payload
+	)
+	SELECT id_webhook, $1, $2
+	FROM webhook
+	WHERE enabled AND $1=ANY(event_types) AND ($3::INTEGER IS NULL OR id_user IN (
+		SELECT id_user FROM organization_member WHERE id_organization=$3
+	))`
+	res, err := tx.Exec(ctx, sqlStatement, eventType, string(payload), idOrganization)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// ClaimDue take the pending deliveries that are due and push their next attempt to leaseUntil,
+// so another dispatcher skip them and they are retried if this one crash before finishing
+func (w *PostgresWebhookRepository) ClaimDue(ctx context.Context, tx helper.Querier, limit int, leaseUntil time.Time) (jobs []entities.WebhookDeliveryJob, err error) {
+	jobs = []entities.WebhookDeliveryJob{}
+	sqlStatement := fmt.Sprintf(`
+	UPDATE webhook_delivery
+	SET next_attempt_at=$2
+	FROM webhook
+	WHERE webhook.id_webhook=webhook_delivery.id_webhook AND webhook_delivery.id_webhook_delivery IN (
+		SELECT id_webhook_delivery FROM webhook_delivery
+		WHERE status='pending' AND next_attempt_at<=(NOW() AT TIME ZONE 'utc')
+		ORDER BY next_attempt_at
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING %s, webhook.url, webhook.secret, webhook_delivery.payload`, w.webhookDeliveryField())
+	rows, err := tx.Query(ctx, sqlStatement, limit, leaseUntil)
+	if err != nil {
+		return jobs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var job entities.WebhookDeliveryJob
+		var payload string
+		pointers := append(w.webhookDeliveryPointer(&job.WebhookDelivery), &job.Url, &job.Secret, &payload)
+		err := rows.Scan(pointers...)
+		if err != nil {
+			return jobs, err
+		}
+		job.Payload = []byte(payload)
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return jobs, err
+	}
+	return jobs, nil
+}
+
+func (w *PostgresWebhookRepository) MarkSuccess(ctx context.Context, tx helper.Querier, idWebhookDelivery int64, statusCode int) (err error) {
+	sqlStatement := `
+	UPDATE webhook_delivery
+	SET status='success', attempt=attempt+1, last_status_code=$1, last_error=NULL, delivered_at=(NOW() AT TIME ZONE 'utc')
+	WHERE id_webhook_delivery=$2`
+	_, err = tx.Exec(ctx, sqlStatement, statusCode, idWebhookDelivery)
+	return err
+}
+
+// MarkFailure record a failed attempt, the delivery is retried at nextAttemptAt or marked failed when giveUp is true
+func (w *PostgresWebhookRepository) MarkFailure(ctx context.Context, tx helper.Querier, idWebhookDelivery int64, statusCode *int, lastError string, nextAttemptAt time.Time, giveUp bool) (err error) {
+	status := entities.WebhookDeliveryPending
+	if giveUp {
+		status = entities.WebhookDeliveryFailed
+	}
+
+	sqlStatement := `
+	UPDATE webhook_delivery
+	SET status=$1, attempt=attempt+1, last_status_code=$2, last_error=$3, next_attempt_at=$4
+	WHERE id_webhook_delivery=$5`
+	_, err = tx.Exec(ctx, sqlStatement, status, statusCode, lastError, nextAttemptAt, idWebhookDelivery)
+	return err
+}
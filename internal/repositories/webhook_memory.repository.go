@@ -0,0 +1,229 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dafaath/iot-server/internal/entities"
+	"github.com/dafaath/iot-server/internal/helper"
+	"github.com/gofiber/fiber/v2"
+)
+
+// MemoryWebhookRepository is WebhookRepository on the memory database
+type MemoryWebhookRepository struct{}
+
+func NewMemoryWebhookRepository() (MemoryWebhookRepository, error) {
+	return MemoryWebhookRepository{}, nil
+}
+
+func (w *MemoryWebhookRepository) Create(ctx context.Context, tx helper.Querier, idUser int, payload *entities.WebhookCreate) (webhook entities.WebhookCreated, err error) {
+	secret := payload.Secret
+	if secret == "" {
+		secret, err = helper.GenerateSecureToken(32)
+		if err != nil {
+			return webhook, err
+		}
+	}
+
+	webhook.Webhook = entities.Webhook{
+		IdUser:     idUser,
+		Url:        payload.Url,
+		EventTypes: append([]string{}, payload.EventTypes...),
+		Enabled:    true,
+		CreatedAt:  time.Now().UTC(),
+	}
+	err = memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.users[idUser]; !ok {
+			return memoryForeignKeyError("webhook", "id_user", idUser)
+		}
+
+		webhook.IdWebhook = int(data.nextId("webhook"))
+		data.webhooks[webhook.IdWebhook] = memoryWebhook{Webhook: webhook.Webhook, Secret: secret}
+		return nil
+	})
+	if err != nil {
+		return webhook, err
+	}
+
+	webhook.Secret = secret
+	return webhook, nil
+}
+
+func (w *MemoryWebhookRepository) GetAll(ctx context.Context, tx helper.Querier, currentUser *entities.UserRead) (webhooks []entities.Webhook, err error) {
+	webhooks = []entities.Webhook{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		for _, id := range sortedIds(data.webhooks) {
+			if currentUser.IsAdmin || data.webhooks[id].IdUser == currentUser.IdUser {
+				webhooks = append(webhooks, data.webhooks[id].Webhook)
+			}
+		}
+		return nil
+	})
+	return webhooks, err
+}
+
+func (w *MemoryWebhookRepository) GetById(ctx context.Context, tx helper.Querier, id int) (webhook entities.Webhook, err error) {
+	err = memoryRead(tx, func(data *memoryData) error {
+		stored, ok := data.webhooks[id]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("Webhook with id %d not found", id))
+		}
+		webhook = stored.Webhook
+		return nil
+	})
+	return webhook, err
+}
+
+func (w *MemoryWebhookRepository) Update(ctx context.Context, tx helper.Querier, webhook *entities.Webhook, payload *entities.WebhookUpdate) (err error) {
+	payload.ChangeSettedFieldOnly(webhook)
+
+	return memoryWrite(tx, func(data *memoryData) error {
+		stored, ok := data.webhooks[webhook.IdWebhook]
+		if !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on update webhook with id %d", webhook.IdWebhook))
+		}
+		stored.Url = payload.Url
+		stored.EventTypes = append([]string{}, payload.EventTypes...)
+		stored.Enabled = *payload.Enabled
+		data.webhooks[webhook.IdWebhook] = stored
+		return nil
+	})
+}
+
+func (w *MemoryWebhookRepository) Delete(ctx context.Context, tx helper.Querier, id int) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		if _, ok := data.webhooks[id]; !ok {
+			return fiber.NewError(404, fmt.Sprintf("No row affected on delete with id %d", id))
+		}
+		data.deleteWebhook(id)
+		return nil
+	})
+}
+
+// GetDeliveries get the latest delivery of the webhook
+func (w *MemoryWebhookRepository) GetDeliveries(ctx context.Context, tx helper.Querier, idWebhook int) (deliveries []entities.WebhookDelivery, err error) {
+	deliveries = []entities.WebhookDelivery{}
+	err = memoryRead(tx, func(data *memoryData) error {
+		// Deliveries are appended in id order
+		for i := len(data.webhookDeliveries) - 1; i >= 0 && len(deliveries) < webhookDeliveryLimit; i-- {
+			if data.webhookDeliveries[i].IdWebhook == idWebhook {
+				deliveries = append(deliveries, data.webhookDeliveries[i].WebhookDelivery)
+			}
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+// Enqueue add a pending delivery for every enabled webhook subscribed to the event whose user is a member
+// of the organization. When idOrganization is nil the event is sent to the webhook of every user, used for
+// shared data like hardware.
+func (w *MemoryWebhookRepository) Enqueue(ctx context.Context, tx helper.Querier, idOrganization *int, eventType string, payload []byte) (count int64, err error) {
+	err = memoryWrite(tx, func(data *memoryData) error {
+		now := time.Now().UTC()
+		for _, id := range sortedIds(data.webhooks) {
+			webhook := data.webhooks[id]
+			if !webhook.Enabled || (idOrganization != nil && data.memberRole(*idOrganization, webhook.IdUser) == "") {
+				continue
+			}
+
+			for _, subscribed := range webhook.EventTypes {
+				if subscribed != eventType {
+					continue
+				}
+				data.webhookDeliveries = append(data.webhookDeliveries, memoryWebhookDelivery{
+					WebhookDelivery: entities.WebhookDelivery{
+						IdWebhookDelivery: data.nextId("webhook_delivery"),
+						IdWebhook:         id,
+						EventType:         eventType,
+						Status:            entities.WebhookDeliveryPending,
+						NextAttemptAt:     now,
+						CreatedAt:         now,
+					},
+					Payload: append([]byte{}, payload...),
+				})
+				count++
+				break
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// ClaimDue take the pending deliveries that are due and push their next attempt to leaseUntil,
+// so they are retried if the dispatcher crash before finishing
+func (w *MemoryWebhookRepository) ClaimDue(ctx context.Context, tx helper.Querier, limit int, leaseUntil time.Time) (jobs []entities.WebhookDeliveryJob, err error) {
+	jobs = []entities.WebhookDeliveryJob{}
+	err = memoryWrite(tx, func(data *memoryData) error {
+		now := time.Now().UTC()
+		due := []int{}
+		for i, delivery := range data.webhookDeliveries {
+			if delivery.Status == entities.WebhookDeliveryPending && !delivery.NextAttemptAt.After(now) {
+				due = append(due, i)
+			}
+		}
+		sort.SliceStable(due, func(i, j int) bool {
+			return data.webhookDeliveries[due[i]].NextAttemptAt.Before(data.webhookDeliveries[due[j]].NextAttemptAt)
+		})
+		if len(due) > limit {
+			due = due[:limit]
+		}
+
+		for _, i := range due {
+			delivery := &data.webhookDeliveries[i]
+			delivery.NextAttemptAt = leaseUntil
+			webhook := data.webhooks[delivery.IdWebhook]
+			jobs = append(jobs, entities.WebhookDeliveryJob{
+				WebhookDelivery: delivery.WebhookDelivery,
+				Url:             webhook.Url,
+				Secret:          webhook.Secret,
+				Payload:         append([]byte{}, delivery.Payload...),
+			})
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// updateDelivery run change on the delivery with the id, nothing happen when it doesn't exist
+func (w *MemoryWebhookRepository) updateDelivery(tx helper.Querier, idWebhookDelivery int64, change func(delivery *entities.WebhookDelivery)) (err error) {
+	return memoryWrite(tx, func(data *memoryData) error {
+		for i := range data.webhookDeliveries {
+			if data.webhookDeliveries[i].IdWebhookDelivery == idWebhookDelivery {
+				change(&data.webhookDeliveries[i].WebhookDelivery)
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func (w *MemoryWebhookRepository) MarkSuccess(ctx context.Context, tx helper.Querier, idWebhookDelivery int64, statusCode int) (err error) {
+	return w.updateDelivery(tx, idWebhookDelivery, func(delivery *entities.WebhookDelivery) {
+		now := time.Now().UTC()
+		delivery.Status = entities.WebhookDeliverySuccess
+		delivery.Attempt++
+		delivery.LastStatusCode = &statusCode
+		delivery.LastError = nil
+		delivery.DeliveredAt = &now
+	})
+}
+
+// MarkFailure record a failed attempt, the delivery is retried at nextAttemptAt or marked failed when giveUp is true
+func (w *MemoryWebhookRepository) MarkFailure(ctx context.Context, tx helper.Querier, idWebhookDelivery int64, statusCode *int, lastError string, nextAttemptAt time.Time, giveUp bool) (err error) {
+	status := entities.WebhookDeliveryPending
+	if giveUp {
+		status = entities.WebhookDeliveryFailed
+	}
+
+	return w.updateDelivery(tx, idWebhookDelivery, func(delivery *entities.WebhookDelivery) {
+		delivery.Status = status
+		delivery.Attempt++
+		delivery.LastStatusCode = statusCode
+		delivery.LastError = &lastError
+		delivery.NextAttemptAt = nextAttemptAt
+	})
+}
@@ -1,3 +1,4 @@
 #!/usr/bin/env bash
 
-go run cmd/* --create-db
+go run cmd/* migrate up
+go run cmd/* seed --mock